@@ -38,6 +38,52 @@ type Reader struct {
 
 	RawAccounting bool          // Whether to enable the access needed to reassemble the tar from raw bytes. Some performance/memory hit for this.
 	rawBytes      *bytes.Buffer // last raw bits
+	rawHeader     []byte        // header region of the current entry
+
+	cr            *countingReader // counts the bytes consumed, for the blocks of each entry
+	headerBlocks  int64
+	payloadBlocks int64
+}
+
+// HeaderBlocks returns the number of 512-byte blocks of the current entry's
+// header region: its header, and the extended headers (PAX, GNU long names)
+// and sparse maps that go with it.
+func (tr *Reader) HeaderBlocks() int64 {
+	return tr.headerBlocks
+}
+
+// PayloadBlocks returns the number of 512-byte blocks of the current entry's
+// payload in the archive, including its padding. For sparse files, this is
+// the data stored, rather than the size of the file.
+func (tr *Reader) PayloadBlocks() int64 {
+	return tr.payloadBlocks
+}
+
+// countingReader counts the bytes read (or seeked over) of the archive
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+var errNotSeeker = errors.New("archive/tar: underlying reader can not seek")
+
+// Seek is only for skipping data, relative to the current position
+func (cr *countingReader) Seek(offset int64, whence int) (int64, error) {
+	sr, ok := cr.r.(io.Seeker)
+	if !ok || whence != os.SEEK_CUR {
+		return 0, errNotSeeker
+	}
+	pos, err := sr.Seek(offset, whence)
+	if err == nil {
+		cr.n += offset
+	}
+	return pos, err
 }
 
 type parser struct {
@@ -62,6 +108,23 @@ func (tr *Reader) RawBytes() []byte {
 	return tr.rawBytes.Bytes()
 }
 
+// RawHeader returns the raw bytes of the current entry's header region, as
+// they are in the archive: its header block, and the PAX and GNU extended
+// headers and sparse maps before and after it, without the padding of the
+// entry before it that RawBytes has. It is HeaderBlocks() blocks long, so
+// that writing it out followed by the payload and its padding is the entry
+// byte for byte.
+//
+// Unlike RawBytes, it is not reset by the call, but by the next call to Next.
+//
+// Only when RawAccounting is enabled, otherwise this returns nil
+func (tr *Reader) RawHeader() []byte {
+	if !tr.RawAccounting {
+		return nil
+	}
+	return tr.rawHeader
+}
+
 // A numBytesReader is an io.Reader with a numBytes method, returning the number
 // of bytes remaining in the underlying encoded data.
 type numBytesReader interface {
@@ -107,6 +170,40 @@ type sparseEntry struct {
 	numBytes int64 // Length of the fragment
 }
 
+// SparseEntry is a data fragment of a sparse file, of Length bytes at Offset
+// in the file, as returned by Reader.SparseData
+type SparseEntry struct {
+	Offset int64
+	Length int64
+}
+
+// SparseData returns the data fragments of the current entry, if it is a
+// sparse file (of the old GNU format, or a GNU format in PAX headers), or nil.
+// Only the fragments are in the archive; the rest of the file is holes, that
+// Read returns as zeros.
+func (tr *Reader) SparseData() []SparseEntry {
+	sfr, ok := tr.curr.(*sparseFileReader)
+	if !ok {
+		return nil
+	}
+	data := make([]SparseEntry, len(sfr.sp))
+	for i, s := range sfr.sp {
+		data[i] = SparseEntry{Offset: s.offset, Length: s.numBytes}
+	}
+	return data
+}
+
+// RawReader returns a reader of the current entry's data as it is in the
+// archive, without its padding. For a sparse file, this is its data
+// fragments, rather than the file with its holes that Read returns. Reading
+// from it and Read are not to be mixed for an entry.
+func (tr *Reader) RawReader() io.Reader {
+	if sfr, ok := tr.curr.(*sparseFileReader); ok {
+		return sfr.rfr
+	}
+	return tr
+}
+
 // Keywords for GNU sparse files in a PAX extended header
 const (
 	paxGNUSparseNumBlocks = "GNU.sparse.numblocks"
@@ -132,7 +229,10 @@ const (
 )
 
 // NewReader creates a new Reader reading from r.
-func NewReader(r io.Reader) *Reader { return &Reader{r: r} }
+func NewReader(r io.Reader) *Reader {
+	cr := &countingReader{r: r}
+	return &Reader{r: cr, cr: cr}
+}
 
 // Next advances to the next entry in the tar archive.
 //
@@ -152,6 +252,8 @@ func (tr *Reader) Next() (*Header, error) {
 
 	var hdr *Header
 	var extHdrs map[string]string
+	var start int64 = -1
+	tr.headerBlocks, tr.payloadBlocks = 0, 0
 
 	// Externally, Next iterates through the tar archive as if it is a series of
 	// files. Internally, the tar format often uses fake "files" to add meta
@@ -164,6 +266,9 @@ loop:
 		if tr.err != nil {
 			return nil, tr.err
 		}
+		if start < 0 {
+			start = tr.cr.n
+		}
 
 		hdr = tr.readHeader()
 		if tr.err != nil {
@@ -207,7 +312,23 @@ loop:
 			}
 			continue loop // This is a meta header affecting the next header
 		default:
-			mergePAX(hdr, extHdrs)
+			if tr.err = mergePAX(hdr, extHdrs); tr.err != nil {
+				return nil, tr.err
+			}
+			// A PAX size record, for a size too large for the size field,
+			// is the size of the payload, rather than the field.
+			if _, ok := extHdrs[paxSize]; ok && hdr.Typeflag != TypeGNUSparse {
+				nb := hdr.Size
+				if isHeaderOnlyType(hdr.Typeflag) {
+					nb = 0
+				}
+				if nb < 0 {
+					tr.err = ErrHeader
+					return nil, tr.err
+				}
+				tr.pad = -nb & (blockSize - 1)
+				tr.curr = &regFileReader{r: tr.r, nb: nb}
+			}
 
 			// Check for a PAX format sparse file
 			sp, err := tr.checkForGNUSparsePAXHeaders(hdr, extHdrs)
@@ -226,6 +347,12 @@ loop:
 			break loop // This is a file, so stop
 		}
 	}
+	tr.headerBlocks = (tr.cr.n - start) / blockSize
+	tr.payloadBlocks = (tr.numBytes() + tr.pad) / blockSize
+	if tr.RawAccounting {
+		raw := tr.rawBytes.Bytes()
+		tr.rawHeader = append([]byte(nil), raw[len(raw)-int(tr.cr.n-start):]...)
+	}
 	return hdr, nil
 }
 
@@ -285,7 +412,13 @@ func (tr *Reader) checkForGNUSparsePAXHeaders(hdr *Header, headers map[string]st
 	case "0.0", "0.1":
 		sp, err = readGNUSparseMap0x1(headers)
 	case "1.0":
-		sp, err = readGNUSparseMap1x0(tr.curr)
+		// the map is at the start of the data, but is not of the file, so
+		// it is accounted with the header
+		var r io.Reader = tr.curr
+		if tr.RawAccounting {
+			r = io.TeeReader(tr.curr, tr.rawBytes)
+		}
+		sp, err = readGNUSparseMap1x0(r)
 	}
 	return sp, err
 }
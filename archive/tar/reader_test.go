@@ -1122,3 +1122,231 @@ func TestParseNumeric(t *testing.T) {
 		}
 	}
 }
+
+func TestReaderBlocks(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewWriter(&buf)
+	files := []struct {
+		hdr             Header
+		body            string
+		header, payload int64
+	}{
+		// a PAX header and its records, then the header
+		{Header{Name: "long/" + strings.Repeat("n", 120), Mode: 0644}, strings.Repeat("x", 600), 3, 2},
+		{Header{Name: "short", Mode: 0644}, "x", 1, 1},
+		{Header{Name: "empty", Mode: 0644}, "", 1, 0},
+	}
+	for _, f := range files {
+		f.hdr.Size = int64(len(f.body))
+		if err := tw.WriteHeader(&f.hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, f.body)
+	}
+	tw.Close()
+
+	for _, seekable := range []bool{false, true} {
+		var r io.Reader = bytes.NewReader(buf.Bytes())
+		if !seekable {
+			r = struct{ io.Reader }{r}
+		}
+		tr := NewReader(r)
+		for i, f := range files {
+			if _, err := tr.Next(); err != nil {
+				t.Fatal(err)
+			}
+			if tr.HeaderBlocks() != f.header || tr.PayloadBlocks() != f.payload {
+				t.Errorf("entry %d: expected %d header and %d payload blocks, got %d and %d", i, f.header, f.payload, tr.HeaderBlocks(), tr.PayloadBlocks())
+			}
+		}
+	}
+
+	// the blocks of the entries are all of the archive, but for the end (which
+	// gnu-multi-hdrs.tar does not have)
+	for _, file := range []string{"testdata/gnu.tar", "testdata/pax.tar", "testdata/sparse-formats.tar", "testdata/gnu-multi-hdrs.tar"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := NewReader(bytes.NewReader(data))
+		var blocks int64
+		for {
+			if _, err := tr.Next(); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			blocks += tr.HeaderBlocks() + tr.PayloadBlocks()
+		}
+		if rest := int64(len(data)) - blocks*blockSize; rest < 0 || rest > 20*blockSize || rest%blockSize != 0 {
+			t.Errorf("%s: expected the entries to account for all but the end of the archive, %d bytes are left", file, rest)
+		}
+	}
+}
+
+func TestReaderSparseData(t *testing.T) {
+	f, err := os.Open("testdata/sparse-formats.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr := NewReader(f)
+	tr.RawAccounting = true
+	var sparse int
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		raw := len(tr.RawBytes())
+		data := tr.SparseData()
+		if !strings.HasPrefix(hdr.Name, "sparse-") {
+			if data != nil {
+				t.Errorf("%s: expected no sparse data of a regular file", hdr.Name)
+			}
+			continue
+		}
+		sparse++
+		var n int64
+		for _, d := range data {
+			n += d.Length
+		}
+		if payload := (tr.PayloadBlocks()) * blockSize; n > payload || n <= payload-blockSize {
+			t.Errorf("%s: expected the sparse data to fill %d bytes of payload, got %d", hdr.Name, payload, n)
+		}
+		// the map of the 1.0 format is in the data, but accounted as header
+		// (after the padding of the file before)
+		if header := tr.HeaderBlocks() * blockSize; int64(raw) < header || int64(raw) >= header+blockSize {
+			t.Errorf("%s: expected %d raw bytes of header, got %d", hdr.Name, header, raw)
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sparse != 4 {
+		t.Errorf("expected 4 sparse files, got %d", sparse)
+	}
+}
+
+func TestReaderRawReader(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/sparse-formats.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewReader(bytes.NewReader(data))
+	tr.RawAccounting = true
+	// the raw bytes and the raw data are the archive
+	archive := bytes.NewBuffer(nil)
+	for {
+		hdr, err := tr.Next()
+		archive.Write(tr.RawBytes())
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		expected := hdr.Size
+		if sparse := tr.SparseData(); sparse != nil {
+			expected = 0
+			for _, d := range sparse {
+				expected += d.Length
+			}
+		}
+		n, err := io.Copy(archive, tr.RawReader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != expected {
+			t.Errorf("%s: expected %d bytes of raw data, got %d", hdr.Name, expected, n)
+		}
+	}
+	if rest, err := ioutil.ReadAll(tr.r); err != nil {
+		t.Fatal(err)
+	} else {
+		archive.Write(rest)
+	}
+	if !bytes.Equal(archive.Bytes(), data) {
+		t.Errorf("expected the raw bytes and data to be the %d bytes of the archive, got %d", len(data), archive.Len())
+	}
+}
+
+func TestReaderRawHeader(t *testing.T) {
+	for _, file := range []string{"testdata/gnu.tar", "testdata/pax.tar", "testdata/sparse-formats.tar", "testdata/gnu-multi-hdrs.tar"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := NewReader(bytes.NewReader(data))
+		if _, err := tr.Next(); err != nil || tr.RawHeader() != nil {
+			t.Fatalf("%s: expected no raw header without RawAccounting, got %d bytes and %v", file, len(tr.RawHeader()), err)
+		}
+
+		tr = NewReader(bytes.NewReader(data))
+		tr.RawAccounting = true
+		var offset int64
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			tr.RawBytes()
+			raw := tr.RawHeader()
+			if int64(len(raw)) != tr.HeaderBlocks()*blockSize {
+				t.Errorf("%s: %s: expected %d blocks of raw header, got %d bytes", file, hdr.Name, tr.HeaderBlocks(), len(raw))
+			}
+			if !bytes.Equal(raw, data[offset:offset+int64(len(raw))]) {
+				t.Errorf("%s: %s: expected the raw header as it is in the archive", file, hdr.Name)
+			}
+			offset += (tr.HeaderBlocks() + tr.PayloadBlocks()) * blockSize
+		}
+	}
+}
+
+func TestReaderPAXSize(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewWriter(&buf)
+	tw.PAXRecords = func(hdr *Header, records []PAXRecord) []PAXRecord {
+		return append(records, PAXRecord{Keyword: paxSize, Value: "5"})
+	}
+	if err := tw.WriteHeader(&Header{Name: "file", Mode: 0644, Size: 5, Typeflag: TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(tw, "hello")
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the size field is left 0, as for a size that does not fit it, for the
+	// payload to only be sized by the record
+	archive := buf.Bytes()
+	block := archive[2*blockSize : 3*blockSize]
+	copy(block[124:136], "00000000000\x00")
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, c := range block {
+		sum += int64(c)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	tr := NewReader(bytes.NewReader(archive))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Size != 5 {
+		t.Errorf("expected the size of the record, got %d", hdr.Size)
+	}
+	if payload, err := ioutil.ReadAll(tr); err != nil || string(payload) != "hello" {
+		t.Errorf("expected the payload sized by the record, got %q, %v", payload, err)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected the end of the archive after the payload, got %v", err)
+	}
+}
@@ -23,6 +23,7 @@ var (
 	ErrWriteTooLong    = errors.New("archive/tar: write too long")
 	ErrFieldTooLong    = errors.New("archive/tar: header field too long")
 	ErrWriteAfterClose = errors.New("archive/tar: write after close")
+	ErrRecordSize      = errors.New("archive/tar: record size is not a multiple of 512 bytes")
 	errInvalidHeader   = errors.New("archive/tar: header field too long or contains invalid values")
 )
 
@@ -36,10 +37,72 @@ type Writer struct {
 	nb         int64 // number of unwritten bytes for current file entry
 	pad        int64 // amount of padding to write after current file entry
 	closed     bool
+	written    int64           // number of bytes written to w
 	usedBinary bool            // whether the binary numeric field extension was used
 	preferPax  bool            // use pax header instead of binary numeric header
 	hdrBuff    [blockSize]byte // buffer to use in writeHeader when writing a regular header
 	paxHdrBuff [blockSize]byte // buffer to use in writeHeader when writing a pax header
+
+	// PAXRecords, if set, decides the records of the PAX extended header of
+	// each entry, and their order. It is given the records the Writer needs
+	// for the header (sorted by keyword, and possibly none), and returns the
+	// records to write, or none for no extended header. This is for rewriting
+	// archives byte for byte, from producers that order the records otherwise
+	// or write records for values that fit in the ustar fields. Records that
+	// are needed but dropped are lost.
+	PAXRecords func(hdr *Header, records []PAXRecord) []PAXRecord
+
+	// OmitTrailer, if set, has Close finish the last entry without writing
+	// the two zero blocks that mark the end of the archive, so that the
+	// stream can be continued by another Writer, as when appending to or
+	// concatenating archives.
+	OmitTrailer bool
+
+	// PAXNumbers, if set, has the numbers that do not fit their octal fields,
+	// like the size of a file of 8GiB or more, written as records of the PAX
+	// extended header, as POSIX tar writes them, rather than in the GNU
+	// base-256 encoding.
+	PAXNumbers bool
+
+	// RecordSize, if set, has Close pad the archive with zero blocks to a
+	// multiple of it, as tar(1) writes records of 10240 bytes, so that the
+	// archive ends on that boundary. It must be a multiple of 512.
+	RecordSize int64
+}
+
+// PAXRecord is a record of a PAX extended header
+type PAXRecord struct {
+	Keyword, Value string
+}
+
+// OrderPAXRecords returns a function for Writer.PAXRecords that writes the
+// records with the given keywords first, in that order, and then the rest
+// sorted by keyword.
+func OrderPAXRecords(keywords ...string) func(*Header, []PAXRecord) []PAXRecord {
+	rank := map[string]int{}
+	for i, k := range keywords {
+		rank[k] = i + 1
+	}
+	return func(hdr *Header, records []PAXRecord) []PAXRecord {
+		sort.Stable(byPAXRank{records, rank})
+		return records
+	}
+}
+
+type byPAXRank struct {
+	records []PAXRecord
+	rank    map[string]int
+}
+
+func (r byPAXRank) Len() int      { return len(r.records) }
+func (r byPAXRank) Swap(i, j int) { r.records[i], r.records[j] = r.records[j], r.records[i] }
+func (r byPAXRank) Less(i, j int) bool {
+	ri, rj := r.rank[r.records[i].Keyword], r.rank[r.records[j].Keyword]
+	if ri == 0 || rj == 0 {
+		// keywords that are ranked come before the rest
+		return ri != 0 && rj == 0
+	}
+	return ri < rj
 }
 
 type formatter struct {
@@ -49,8 +112,30 @@ type formatter struct {
 // NewWriter creates a new Writer writing to w.
 func NewWriter(w io.Writer) *Writer { return &Writer{w: w} }
 
-// Flush finishes writing the current file (optional).
+// Flush finishes writing the current file, padding it to a whole block, and
+// then flushes the underlying writer if it has a Flush method (like a
+// bufio.Writer), so that what was written is a whole prefix of the archive.
+// It is optional, as WriteHeader and Close finish the current file too.
 func (tw *Writer) Flush() error {
+	if tw.finishEntry() != nil {
+		return tw.err
+	}
+	if f, ok := tw.w.(interface {
+		Flush() error
+	}); ok {
+		tw.err = f.Flush()
+	}
+	return tw.err
+}
+
+// Offset returns the number of bytes written to the underlying writer, which
+// is where the next header starts once the current file is finished.
+func (tw *Writer) Offset() int64 {
+	return tw.written
+}
+
+// finishEntry writes the padding of the current file
+func (tw *Writer) finishEntry() error {
 	if tw.nb > 0 {
 		tw.err = fmt.Errorf("archive/tar: missed writing %d bytes", tw.nb)
 		return tw.err
@@ -65,6 +150,7 @@ func (tw *Writer) Flush() error {
 		var nw int
 		nw, tw.err = tw.w.Write(zeroBlock[0:nr])
 		n -= int64(nw)
+		tw.written += int64(nw)
 	}
 	tw.nb = 0
 	tw.pad = 0
@@ -144,7 +230,7 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 		return ErrWriteAfterClose
 	}
 	if tw.err == nil {
-		tw.Flush()
+		tw.finishEntry()
 	}
 	if tw.err != nil {
 		return tw.err
@@ -191,7 +277,7 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 		}
 
 		// If it is too long for octal, and PAX is preferred, use a PAX header.
-		if paxKeyword != paxNone && tw.preferPax {
+		if paxKeyword != paxNone && (tw.preferPax || tw.PAXNumbers) {
 			f.formatOctal(b, 0)
 			s := strconv.FormatInt(x, 10)
 			paxHeaders[paxKeyword] = s
@@ -270,18 +356,35 @@ func (tw *Writer) writeHeader(hdr *Header, allowPax bool) error {
 		}
 	}
 
-	if len(paxHeaders) > 0 {
-		if !allowPax {
-			return errInvalidHeader
+	if len(paxHeaders) > 0 && !allowPax {
+		return errInvalidHeader
+	}
+	if allowPax && (len(paxHeaders) > 0 || tw.PAXRecords != nil) {
+		// Keys are sorted before writing to body to allow deterministic output.
+		var keys []string
+		for k := range paxHeaders {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		records := make([]PAXRecord, 0, len(keys))
+		for _, k := range keys {
+			records = append(records, PAXRecord{Keyword: k, Value: paxHeaders[k]})
 		}
-		if err := tw.writePAXHeader(hdr, paxHeaders); err != nil {
-			return err
+		if tw.PAXRecords != nil {
+			records = tw.PAXRecords(hdr, records)
+		}
+		if len(records) > 0 {
+			if err := tw.writePAXHeader(hdr, records); err != nil {
+				return err
+			}
 		}
 	}
 	tw.nb = int64(hdr.Size)
 	tw.pad = (blockSize - (tw.nb % blockSize)) % blockSize
 
-	_, tw.err = tw.w.Write(header)
+	var nw int
+	nw, tw.err = tw.w.Write(header)
+	tw.written += int64(nw)
 	return tw.err
 }
 
@@ -308,7 +411,7 @@ func splitUSTARPath(name string) (prefix, suffix string, ok bool) {
 
 // writePaxHeader writes an extended pax header to the
 // archive.
-func (tw *Writer) writePAXHeader(hdr *Header, paxHeaders map[string]string) error {
+func (tw *Writer) writePAXHeader(hdr *Header, records []PAXRecord) error {
 	// Prepare extended header
 	ext := new(Header)
 	ext.Typeflag = TypeXHeader
@@ -330,15 +433,8 @@ func (tw *Writer) writePAXHeader(hdr *Header, paxHeaders map[string]string) erro
 	// Construct the body
 	var buf bytes.Buffer
 
-	// Keys are sorted before writing to body to allow deterministic output.
-	var keys []string
-	for k := range paxHeaders {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	for _, k := range keys {
-		fmt.Fprint(&buf, formatPAXRecord(k, paxHeaders[k]))
+	for _, r := range records {
+		fmt.Fprint(&buf, formatPAXRecord(r.Keyword, r.Value))
 	}
 
 	ext.Size = int64(len(buf.Bytes()))
@@ -348,7 +444,7 @@ func (tw *Writer) writePAXHeader(hdr *Header, paxHeaders map[string]string) erro
 	if _, err := tw.Write(buf.Bytes()); err != nil {
 		return err
 	}
-	if err := tw.Flush(); err != nil {
+	if err := tw.finishEntry(); err != nil {
 		return err
 	}
 	return nil
@@ -385,6 +481,7 @@ func (tw *Writer) Write(b []byte) (n int, err error) {
 	}
 	n, err = tw.w.Write(b)
 	tw.nb -= int64(n)
+	tw.written += int64(n)
 	if err == nil && overwrite {
 		err = ErrWriteTooLong
 		return
@@ -394,20 +491,36 @@ func (tw *Writer) Write(b []byte) (n int, err error) {
 }
 
 // Close closes the tar archive, flushing any unwritten
-// data to the underlying writer.
+// data to the underlying writer. It writes the end of archive marker, unless
+// OmitTrailer is set, and pads the archive to RecordSize, if set.
 func (tw *Writer) Close() error {
 	if tw.err != nil || tw.closed {
 		return tw.err
 	}
-	tw.Flush()
+	if tw.RecordSize < 0 || tw.RecordSize%blockSize != 0 {
+		tw.err = ErrRecordSize
+		return tw.err
+	}
+	tw.finishEntry()
 	tw.closed = true
 	if tw.err != nil {
 		return tw.err
 	}
 
-	// trailer: two zero blocks
-	for i := 0; i < 2; i++ {
-		_, tw.err = tw.w.Write(zeroBlock)
+	// trailer: two zero blocks, then up to the end of the record
+	var n int64
+	if !tw.OmitTrailer {
+		n = 2 * blockSize
+	}
+	if tw.RecordSize > 0 {
+		if r := (tw.written + n) % tw.RecordSize; r != 0 {
+			n += tw.RecordSize - r
+		}
+	}
+	for ; n > 0; n -= blockSize {
+		var nw int
+		nw, tw.err = tw.w.Write(zeroBlock)
+		tw.written += int64(nw)
 		if tw.err != nil {
 			break
 		}
@@ -720,3 +720,190 @@ func TestFormatNumeric(t *testing.T) {
 		}
 	}
 }
+
+func TestPAXRecordsOrder(t *testing.T) {
+	hdr := &Header{
+		Name:     strings.Repeat("long/", 30) + "name",
+		Mode:     0644,
+		Uname:    strings.Repeat("u", 40),
+		ModTime:  time.Unix(1500000000, 500),
+		Typeflag: TypeReg,
+	}
+
+	var buf bytes.Buffer
+	tw := NewWriter(&buf)
+	tw.PAXRecords = func(hdr *Header, records []PAXRecord) []PAXRecord {
+		// like producers that always record the mtime
+		records = append(records, PAXRecord{"mtime", "1500000000.0000005"})
+		return OrderPAXRecords("uname", "mtime")(hdr, records)
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	indices := []int{
+		bytes.Index(buf.Bytes(), []byte(" uname=")),
+		bytes.Index(buf.Bytes(), []byte(" mtime=")),
+		bytes.Index(buf.Bytes(), []byte(" path=")),
+	}
+	if indices[0] < 0 || !sort.IntsAreSorted(indices) {
+		t.Errorf("expected the records in the order uname, mtime, path, got offsets %v", indices)
+	}
+
+	tr := NewReader(&buf)
+	got, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != hdr.Name || got.Uname != hdr.Uname || !got.ModTime.Equal(hdr.ModTime) {
+		t.Errorf("expected %+v, got %+v", hdr, got)
+	}
+}
+
+func TestPAXRecordsNone(t *testing.T) {
+	hdr := &Header{Name: "short", Mode: 0644, Typeflag: TypeReg, ModTime: time.Unix(1500000000, 0)}
+	var plain, hooked bytes.Buffer
+	for _, buf := range []*bytes.Buffer{&plain, &hooked} {
+		tw := NewWriter(buf)
+		if buf == &hooked {
+			tw.PAXRecords = func(hdr *Header, records []PAXRecord) []PAXRecord { return records }
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Close()
+	}
+	if !bytes.Equal(plain.Bytes(), hooked.Bytes()) {
+		t.Errorf("expected no extended header when there are no records")
+	}
+}
+
+func TestWriterPAXNumbers(t *testing.T) {
+	const size = 8<<30 + 3
+	for _, pax := range []bool{false, true} {
+		var buf bytes.Buffer
+		tw := NewWriter(&buf)
+		tw.PAXNumbers = pax
+		if err := tw.WriteHeader(&Header{Name: "big.db", Mode: 0644, Size: size, Typeflag: TypeReg, ModTime: time.Unix(1500000000, 0)}); err != nil {
+			t.Fatal(err)
+		}
+		// the payload is not written, for the header to be read back
+		raw := buf.Bytes()
+		if record := bytes.Contains(raw, []byte(" size=8589934595\n")); record != pax {
+			t.Errorf("pax %v: expected a PAX size record %v", pax, pax)
+		}
+		block := raw[len(raw)-blockSize:]
+		if binary := block[124]&0x80 != 0; binary == pax {
+			t.Errorf("pax %v: expected the size field in base-256 %v", pax, !pax)
+		}
+		if magic := string(block[257:265]); pax && magic != "ustar\x0000" {
+			t.Errorf("expected a POSIX header with the PAX size record, got magic %q", magic)
+		}
+		hdr, err := NewReader(bytes.NewReader(raw)).Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size != size {
+			t.Errorf("pax %v: expected the size %d read back, got %d", pax, int64(size), hdr.Size)
+		}
+	}
+}
+
+func TestWriterOmitTrailer(t *testing.T) {
+	var buf bytes.Buffer
+	for i, name := range []string{"first", "second"} {
+		tw := NewWriter(&buf)
+		// the first archive is continued by the second
+		tw.OmitTrailer = i == 0
+		if err := tw.WriteHeader(&Header{Name: name, Mode: 0644, Size: 5, Typeflag: TypeReg, ModTime: time.Unix(1500000000, 0)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, "hello"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && tw.Offset() != 2*blockSize {
+			t.Errorf("expected the first archive to end after its entry, at %d, got %d", 2*blockSize, tw.Offset())
+		}
+	}
+	if buf.Len() != 6*blockSize {
+		t.Errorf("expected one trailer, for %d bytes, got %d", 6*blockSize, buf.Len())
+	}
+
+	tr := NewReader(&buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"first", "second"}) {
+		t.Errorf("expected the entries of both archives, got %q", names)
+	}
+}
+
+func TestWriterRecordSize(t *testing.T) {
+	for _, omit := range []bool{false, true} {
+		var buf bytes.Buffer
+		tw := NewWriter(&buf)
+		tw.RecordSize = 10240
+		tw.OmitTrailer = omit
+		if err := tw.WriteHeader(&Header{Name: "file", Mode: 0644, Size: 5, Typeflag: TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, "hello")
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 10240 || tw.Offset() != 10240 {
+			t.Errorf("expected the archive padded to a record, got %d bytes and offset %d", buf.Len(), tw.Offset())
+		}
+	}
+
+	tw := NewWriter(ioutil.Discard)
+	tw.RecordSize = 1000
+	if err := tw.Close(); err != ErrRecordSize {
+		t.Errorf("expected ErrRecordSize, got %v", err)
+	}
+}
+
+type flushRecorder struct {
+	bytes.Buffer
+	flushedAt []int
+}
+
+func (fr *flushRecorder) Flush() error {
+	fr.flushedAt = append(fr.flushedAt, fr.Len())
+	return nil
+}
+
+func TestWriterFlush(t *testing.T) {
+	var fr flushRecorder
+	tw := NewWriter(&fr)
+	if err := tw.WriteHeader(&Header{Name: "file", Mode: 0644, Size: 5, Typeflag: TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(tw, "hello")
+	if err := tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fr.flushedAt, []int{2 * blockSize}) || tw.Offset() != 2*blockSize {
+		t.Errorf("expected the entry padded and flushed at %d, got %v and offset %d", 2*blockSize, fr.flushedAt, tw.Offset())
+	}
+	if err := tw.WriteHeader(&Header{Name: "other", Mode: 0644, Typeflag: TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fr.flushedAt) != 1 {
+		t.Errorf("expected WriteHeader not to flush the underlying writer, got %v", fr.flushedAt)
+	}
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/urfave/cli"
 	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/common"
 	"github.com/vbatts/tar-split/tar/storage"
 )
 
@@ -21,12 +27,42 @@ func CommandAsm(c *cli.Context) {
 	if len(c.String("output")) == 0 {
 		logrus.Fatalf("--output filename must be set ([FILENAME|-])")
 	}
-	if len(c.String("path")) == 0 {
+	if len(c.String("path")) == 0 && len(c.String("thin")) == 0 {
 		logrus.Fatalf("--path must be set")
 	}
 
+	selective := c.IsSet("include") || c.IsSet("exclude")
+	if selective && (c.Bool("verify") || c.Bool("recompress") || c.IsSet("missing") || c.IsSet("uid-map") || c.IsSet("gid-map") || c.IsSet("thin") || c.Bool("check-tree")) {
+		logrus.Fatalf("--include and --exclude are not for --verify, --recompress, --missing, --uid-map, --gid-map, --thin or --check-tree")
+	}
+	// those are assembled without the ids being remapped
+	if (c.IsSet("uid-map") || c.IsSet("gid-map")) && (c.IsSet("thin") || c.IsSet("missing") || c.Bool("check-tree")) {
+		logrus.Fatalf("--uid-map and --gid-map are not for --thin, --missing or --check-tree")
+	}
+	// a thin archive has no payloads to be missing
+	if c.IsSet("missing") && c.IsSet("thin") {
+		logrus.Fatalf("--missing is not for --thin")
+	}
+	// the tree is assembled from --path alone
+	if c.Bool("check-tree") && (c.IsSet("chunk-dir") || c.IsSet("normalize") || c.IsSet("snapshot") || c.IsSet("missing") || c.IsSet("thin")) {
+		logrus.Fatalf("--check-tree is not for --chunk-dir, --normalize, --snapshot, --missing or --thin")
+	}
+
+	// only checking for missing payloads, rather than assembling
+	report := c.String("missing") == "report"
+
+	compression := c.String("compress")
+	if compression == "none" {
+		compression = common.Uncompressed
+	}
+	if compression != common.Uncompressed && (report || c.Bool("recompress")) {
+		logrus.Fatalf("--compress is not for --recompress or --missing report")
+	}
+
 	var outputStream io.Writer
-	if c.String("output") == "-" {
+	if report {
+		outputStream = ioutil.Discard
+	} else if c.String("output") == "-" {
 		outputStream = os.Stdout
 	} else {
 		fh, err := os.Create(c.String("output"))
@@ -36,6 +72,52 @@ func CommandAsm(c *cli.Context) {
 		defer fh.Close()
 		outputStream = fh
 	}
+	if compression != common.Uncompressed {
+		cw, err := common.CompressWriter(outputStream, compression, common.CompressOptions{
+			Level: c.Int("compress-level"),
+			Jobs:  c.Int("compress-jobs"),
+		})
+		if err == common.ErrNoCompressor {
+			logrus.Fatalf("--compress must be gzip, zstd (with the zstd program installed) or none")
+		} else if err != nil {
+			logrus.Fatalf("--compress %s: %v", compression, err)
+		}
+		outputStream = cw
+		// before the output is closed, as the deferred calls run in reverse
+		defer func() {
+			if err := cw.Close(); err != nil {
+				logrus.Fatal(err)
+			}
+		}()
+	}
+	if c.Bool("recompress") {
+		if report {
+			logrus.Fatalf("--recompress is not for --missing report")
+		}
+		src, err := recordedSource(c.String("input"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if src == nil || src.Gzip == nil {
+			logrus.Fatalf("%s has no gzip fingerprint of its source; disassemble with --source and --gzip-fingerprint", c.String("input"))
+		}
+		h := sha256.New()
+		gw, err := asm.NewGzipWriter(io.MultiWriter(outputStream, h), *src.Gzip)
+		if err != nil {
+			logrus.Fatalf("%s: %v", src.Digest, err)
+		}
+		outputStream = gw
+		// before the output is closed, as the deferred calls run in reverse
+		defer func() {
+			if err := gw.Close(); err != nil {
+				logrus.Fatal(err)
+			}
+			if digest := "sha256:" + hex.EncodeToString(h.Sum(nil)); digest != src.Digest {
+				logrus.Fatalf("recompressed to %s, rather than the source %s", digest, src.Digest)
+			}
+			logrus.Infof("recompressed to the source %s", src.Digest)
+		}()
+	}
 
 	// Get the tar metadata reader
 	mf, err := os.Open(c.String("input"))
@@ -49,9 +131,142 @@ func CommandAsm(c *cli.Context) {
 	}
 	defer mfz.Close()
 
-	metaUnpacker := storage.NewJSONUnpacker(mfz)
+	var metaUnpacker storage.Unpacker
+	if c.Bool("untrusted") {
+		metaUnpacker = storage.NewLimitedAutoUnpacker(mfz, storage.DefaultLimits)
+	} else {
+		metaUnpacker = storage.NewAutoUnpacker(mfz)
+	}
+	if len(c.String("source")) > 0 {
+		src, err := digestSourceFile(c.String("source"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		metaUnpacker = asm.NewSourceCheckingUnpacker(metaUnpacker, src)
+	}
+	if c.Bool("verify") {
+		// the entries are checked however the archive is assembled, and the
+		// archive's digest when it is assembled as it was, below
+		metaUnpacker = storage.NewSummaryUnpacker(metaUnpacker)
+	}
+	if len(c.String("thin")) > 0 {
+		mode, err := asm.ParseThinMode(c.String("thin"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := asm.WriteThinTarStream(metaUnpacker, mode, outputStream); err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("created thin %s from %s", c.String("output"), c.String("input"))
+		return
+	}
+	if c.Bool("check-tree") {
+		changes, err := asm.WriteTreeTarStream(c.String("path"), metaUnpacker, outputStream)
+		for _, change := range changes {
+			if change.Kind == asm.Deleted {
+				logrus.Warnf("%q is missing from %s", change.Name, c.String("path"))
+				continue
+			}
+			logrus.Warnf("%q changed on disk: %s", change.Name, strings.Join(change.Fields, ", "))
+		}
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("created %s from %s and %s (%d files changed on disk, but not their payloads)", c.String("output"), c.String("path"), c.String("input"), len(changes))
+		return
+	}
 	// XXX maybe get the absolute path here
-	fileGetter := storage.NewPathFileGetter(c.String("path"))
+	var fileGetter storage.FileGetter = storage.NewPathFileGetter(c.String("path"))
+	if len(c.String("chunk-dir")) > 0 {
+		if fileGetter, err = chunkFileGetter(c.String("chunk-dir"), c.String("input")); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if len(c.String("normalize")) > 0 {
+		if fileGetter, err = storage.NewNormalizingFileGetter(fileGetter, c.String("normalize")); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if len(c.String("snapshot")) > 0 {
+		if fileGetter, err = snapshotFileGetter(c.String("snapshot"), c.String("input"), fileGetter); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if len(c.String("missing")) > 0 && c.String("missing") != "fail" {
+		policyName := c.String("missing")
+		if report {
+			policyName = "zero"
+		}
+		policy, err := asm.ParseMissingPolicy(policyName)
+		if err != nil {
+			logrus.Fatalf("--missing must be one of fail, zero, skip or report")
+		}
+		var list *json.Encoder
+		if len(c.String("missing-list")) > 0 {
+			fh, err := os.Create(c.String("missing-list"))
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			defer fh.Close()
+			list = json.NewEncoder(fh)
+		}
+		var count int
+		err = asm.WriteRecoveredTarStream(fileGetter, metaUnpacker, policy, outputStream, func(entry *storage.Entry, err error) {
+			count++
+			logrus.Warnf("missing payload of %q: %v", entry.GetName(), err)
+			if list != nil {
+				if err := list.Encode(missingPayload{Name: entry.GetName(), Size: entry.Size, Error: err.Error()}); err != nil {
+					logrus.Fatal(err)
+				}
+			}
+		})
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if report {
+			if count > 0 {
+				logrus.Fatalf("%d payloads are missing from %s", count, c.String("path"))
+			}
+			logrus.Infof("no payloads are missing from %s", c.String("path"))
+			return
+		}
+		logrus.Infof("created %s from %s and %s (%d payloads missing, with --missing %s)", c.String("output"), c.String("path"), c.String("input"), count, policyName)
+		return
+	}
+
+	if c.IsSet("uid-map") || c.IsSet("gid-map") {
+		uidMaps := parseIDMaps(c.StringSlice("uid-map"))
+		gidMaps := parseIDMaps(c.StringSlice("gid-map"))
+		if err := asm.WriteRemappedTarStream(fileGetter, metaUnpacker, uidMaps, gidMaps, outputStream); err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("created %s from %s and %s, with remapped ids", c.String("output"), c.String("path"), c.String("input"))
+		return
+	}
+
+	if selective {
+		sel, err := asm.SelectPatterns(c.StringSlice("include"), c.StringSlice("exclude"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		ots := asm.NewSelectedTarStream(fileGetter, metaUnpacker, sel)
+		defer ots.Close()
+		i, err := io.Copy(outputStream, ots)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("created %s from the selected entries of %s and %s (wrote %d bytes)", c.String("output"), c.String("path"), c.String("input"), i)
+		return
+	}
+
+	if c.Bool("verify") {
+		if err := asm.WriteVerifiedTarStream(fileGetter, metaUnpacker, outputStream); err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("created %s from %s and %s, verified against its summary", c.String("output"), c.String("path"), c.String("input"))
+		return
+	}
 
 	ots := asm.NewOutputTarStream(fileGetter, metaUnpacker)
 	defer ots.Close()
@@ -62,3 +277,78 @@ func CommandAsm(c *cli.Context) {
 
 	logrus.Infof("created %s from %s and %s (wrote %d bytes)", c.String("output"), c.String("path"), c.String("input"), i)
 }
+
+func parseIDMaps(mappings []string) []asm.IDMap {
+	var maps []asm.IDMap
+	for _, s := range mappings {
+		m, err := asm.ParseIDMap(s)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		maps = append(maps, m)
+	}
+	return maps
+}
+
+// missingPayload is a line of --missing-list
+type missingPayload struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Error string `json:"error"`
+}
+
+// snapshotFileGetter sources the payloads from the snapshot directory `dir`,
+// falling back to `fg`. The metadata at `input` is read for the sizes and
+// checksums of the payloads.
+func snapshotFileGetter(dir, input string, fg storage.FileGetter) (storage.FileGetter, error) {
+	mf, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer mf.Close()
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		return nil, err
+	}
+	defer mfz.Close()
+	return storage.NewSnapshotFileGetter(dir, storage.NewAutoUnpacker(mfz), fg)
+}
+
+// chunkFileGetter sources the payloads from the chunks stored under `dir`, by
+// those recorded in the metadata at `input`.
+func chunkFileGetter(dir, input string) (storage.FileGetter, error) {
+	mf, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer mf.Close()
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		return nil, err
+	}
+	defer mfz.Close()
+	return storage.NewChunkFileGetter(dir, storage.NewAutoUnpacker(mfz))
+}
+
+// recordedSource returns the source recorded in the metadata at `input`, or
+// nil if there is none. It is the first entry, if any.
+func recordedSource(input string) (*storage.Source, error) {
+	mf, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer mf.Close()
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		return nil, err
+	}
+	defer mfz.Close()
+	entry, err := storage.NewAutoUnpacker(mfz).Next()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return entry.Source, nil
+}
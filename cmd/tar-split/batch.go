@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandBatch(c *cli.Context) {
+	if len(c.String("file")) == 0 {
+		logrus.Fatalf("--file listing the tar archives must be set")
+	}
+	blobs, err := readBatchList(c.String("file"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	dir := c.String("output-dir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logrus.Fatal(err)
+	}
+
+	var jobs []asm.BatchJob
+	seen := map[string]string{}
+	for _, blob := range blobs {
+		blob := blob
+		name := filepath.Base(blob)
+		if prev, ok := seen[name]; ok {
+			logrus.Fatalf("%q and %q would have the same metadata file", prev, blob)
+		}
+		seen[name] = blob
+		jobs = append(jobs, asm.BatchJob{
+			Name: name,
+			Open: func() (io.Reader, storage.Packer, io.Closer, error) {
+				fh, err := os.Open(blob)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				mf, err := os.Create(filepath.Join(dir, name+"-data.json.gz"))
+				if err != nil {
+					fh.Close()
+					return nil, nil, nil, err
+				}
+				mfz := gzip.NewWriter(mf)
+				return fh, storage.NewJSONPacker(mfz), batchCloser{mfz, mf, fh}, nil
+			},
+		})
+	}
+
+	b := asm.Batch{
+		Jobs: c.Int("jobs"),
+		Progress: func(p asm.BatchProgress) {
+			if p.Result.Err != nil {
+				logrus.Errorf("[%d/%d] %s: %v", p.Done, p.Total, seen[p.Result.Name], p.Result.Err)
+				return
+			}
+			logrus.Infof("[%d/%d] %s (read %d bytes in %s)", p.Done, p.Total, seen[p.Result.Name], p.Result.Bytes, p.Result.Duration)
+		},
+	}
+	if len(c.String("path")) > 0 {
+		b.FilePutter = storage.NewPathFilePutter(c.String("path"))
+	}
+	report := b.Run(jobs)
+	failed := report.Failed()
+	logrus.Infof("disassembled %d of %d archives to %s (read %d bytes in %s)", len(jobs)-len(failed), len(jobs), dir, report.Bytes, report.Duration)
+	if len(failed) > 0 {
+		for _, res := range failed {
+			logrus.Errorf("failed %s: %v", seen[res.Name], res.Err)
+		}
+		logrus.Fatalf("%d archives failed", len(failed))
+	}
+}
+
+// readBatchList reads the paths listed one per line, skipping blank lines and
+// '#' comments
+func readBatchList(path string) ([]string, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var paths []string
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, scanner.Err()
+}
+
+// batchCloser closes each in order, returning the first error
+type batchCloser []io.Closer
+
+func (bc batchCloser) Close() error {
+	var err error
+	for _, c := range bc {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"archive/tar"
 	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -19,6 +20,15 @@ func CommandChecksize(c *cli.Context) {
 	if len(c.Args()) == 0 {
 		logrus.Fatalf("please specify tar archives to check ('-' will check stdin)")
 	}
+	// with --json, a report of each archive is written as a line of JSON,
+	// rather than the text
+	asJSON := c.Bool("json")
+	printf := func(format string, a ...interface{}) {
+		if !asJSON {
+			fmt.Printf(format, a...)
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
 	for _, arg := range c.Args() {
 		fh, err := os.Open(arg)
 		if err != nil {
@@ -29,17 +39,17 @@ func CommandChecksize(c *cli.Context) {
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Printf("inspecting %q (size %dk)\n", fh.Name(), fi.Size()/1024)
+		printf("inspecting %q (size %dk)\n", fh.Name(), fi.Size()/1024)
+		archiveSize := fi.Size()
 
-		packFh, err := ioutil.TempFile("", "packed.")
+		packFh, err := newSpillFile(c, "packed.")
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer packFh.Close()
-		if !c.Bool("work") {
-			defer os.Remove(packFh.Name())
-		} else {
-			fmt.Printf(" -- working file preserved: %s\n", packFh.Name())
+		packFh.keep = packFh.keep || c.Bool("work")
+		defer packFh.Cleanup()
+		if packFh.keep {
+			printf(" -- working file preserved: %s\n", packFh.Name())
 		}
 
 		sp := storage.NewJSONPacker(packFh)
@@ -64,7 +74,7 @@ func CommandChecksize(c *cli.Context) {
 				log.Fatal(err)
 			}
 		}
-		fmt.Printf(" -- number of files: %d\n", num)
+		printf(" -- number of files: %d\n", num)
 
 		if err := packFh.Sync(); err != nil {
 			log.Fatal(err)
@@ -74,16 +84,15 @@ func CommandChecksize(c *cli.Context) {
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Printf(" -- size of metadata uncompressed: %dk\n", fi.Size()/1024)
+		printf(" -- size of metadata uncompressed: %dk\n", fi.Size()/1024)
+		metaSize := fi.Size()
 
-		gzPackFh, err := ioutil.TempFile("", "packed.gz.")
+		gzPackFh, err := newSpillFile(c, "packed.gz.")
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer gzPackFh.Close()
-		if !c.Bool("work") {
-			defer os.Remove(gzPackFh.Name())
-		}
+		gzPackFh.keep = gzPackFh.keep || c.Bool("work")
+		defer gzPackFh.Cleanup()
 
 		gzWrtr := gzip.NewWriter(gzPackFh)
 
@@ -104,6 +113,48 @@ func CommandChecksize(c *cli.Context) {
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Printf(" -- size of gzip compressed metadata: %dk\n", fi.Size()/1024)
+		printf(" -- size of gzip compressed metadata: %dk\n", fi.Size()/1024)
+
+		if _, err := packFh.Seek(0, 0); err != nil {
+			log.Fatal(err)
+		}
+		report, err := storage.AnalyzeMetadata(storage.NewJSONUnpacker(packFh))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if asJSON {
+			if err := enc.Encode(checksizeReport{
+				Archive:      arg,
+				Size:         archiveSize,
+				Files:        num,
+				Metadata:     metaSize,
+				MetadataGzip: fi.Size(),
+				Overhead:     report,
+			}); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		for _, o := range []struct {
+			name string
+			eo   storage.EntryOverhead
+		}{{"segment", report.Segments}, {"file", report.Files}} {
+			printf(" -- %s entries: %d, %dk of metadata for %dk of the archive\n", o.name, o.eo.Entries, o.eo.Bytes/1024, o.eo.PayloadBytes/1024)
+		}
+		ss := report.SegmentSizes
+		printf(" -- segment sizes: p50 %d, p90 %d, p99 %d, max %d bytes\n", ss.P50, ss.P90, ss.P99, ss.Max)
+		for _, es := range report.Encodings[1:] {
+			printf(" -- size of metadata as %s: %dk (%+.1f%%)\n", es.Name, es.Bytes/1024, -100*es.Savings)
+		}
 	}
 }
+
+// checksizeReport is a line of 'checksize --json'
+type checksizeReport struct {
+	Archive      string                  `json:"archive"`
+	Size         int64                   `json:"size"`
+	Files        int                     `json:"files"`
+	Metadata     int64                   `json:"metadata"`
+	MetadataGzip int64                   `json:"metadata_gzip"`
+	Overhead     *storage.MetadataReport `json:"overhead"`
+}
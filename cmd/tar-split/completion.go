@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func CommandCompletion(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		logrus.Fatalf("please specify the shell: bash, zsh or fish")
+	}
+	var err error
+	switch c.Args()[0] {
+	case "bash":
+		err = writeBashCompletion(os.Stdout, c.App)
+	case "zsh":
+		err = writeZshCompletion(os.Stdout, c.App)
+	case "fish":
+		err = writeFishCompletion(os.Stdout, c.App)
+	default:
+		logrus.Fatalf("unknown shell %q, rather than bash, zsh or fish", c.Args()[0])
+	}
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// flagInfo is what completion and man pages need of a cli.Flag
+type flagInfo struct {
+	// names, like "debug" and "D"
+	names      []string
+	usage      string
+	takesValue bool
+	value      string // default
+}
+
+func describeFlag(f cli.Flag) flagInfo {
+	info := flagInfo{takesValue: true}
+	for _, name := range strings.Split(f.GetName(), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			info.names = append(info.names, name)
+		}
+	}
+	switch f := f.(type) {
+	case cli.BoolFlag:
+		info.usage, info.takesValue = f.Usage, false
+	case cli.StringFlag:
+		info.usage, info.value = f.Usage, f.Value
+	case cli.IntFlag:
+		info.usage = f.Usage
+		if f.Value != 0 {
+			info.value = fmt.Sprint(f.Value)
+		}
+	case cli.Int64Flag:
+		info.usage = f.Usage
+		if f.Value != 0 {
+			info.value = fmt.Sprint(f.Value)
+		}
+	case cli.StringSliceFlag:
+		info.usage = f.Usage
+	}
+	return info
+}
+
+// dashed returns the flag as it is given on the command line, like "--debug"
+// or "-D"
+func dashed(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+func commandNames(cmd cli.Command) []string {
+	return append([]string{cmd.Name}, cmd.Aliases...)
+}
+
+func flagWords(flags []cli.Flag) string {
+	var words []string
+	for _, f := range flags {
+		for _, name := range describeFlag(f).names {
+			words = append(words, dashed(name))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func writeBashCompletion(w io.Writer, app *cli.App) error {
+	var commands []string
+	for _, cmd := range app.Commands {
+		commands = append(commands, commandNames(cmd)...)
+	}
+	fmt.Fprintf(w, "# bash completion for %s, generated by `%s completion bash`\n", app.Name, app.Name)
+	fmt.Fprintf(w, "_%s() {\n", shellName(app.Name))
+	fmt.Fprintf(w, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" cmd= flags= w\n")
+	fmt.Fprintf(w, "\tfor w in \"${COMP_WORDS[@]:1:COMP_CWORD-1}\"; do\n")
+	fmt.Fprintf(w, "\t\tcase \"$w\" in\n")
+	fmt.Fprintf(w, "\t\t%s) cmd=\"$w\"; break ;;\n", strings.Join(commands, "|"))
+	fmt.Fprintf(w, "\t\tesac\n")
+	fmt.Fprintf(w, "\tdone\n")
+	fmt.Fprintf(w, "\tcase \"$cmd\" in\n")
+	fmt.Fprintf(w, "\t\"\")\n")
+	fmt.Fprintf(w, "\t\tif [[ \"$cur\" != -* ]]; then\n")
+	fmt.Fprintf(w, "\t\t\tCOMPREPLY=( $(compgen -W %q -- \"$cur\") )\n", strings.Join(commands, " "))
+	fmt.Fprintf(w, "\t\t\treturn\n")
+	fmt.Fprintf(w, "\t\tfi\n")
+	fmt.Fprintf(w, "\t\tflags=%q ;;\n", flagWords(app.Flags))
+	for _, cmd := range app.Commands {
+		fmt.Fprintf(w, "\t%s) flags=%q ;;\n", strings.Join(commandNames(cmd), "|"), flagWords(cmd.Flags))
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "\tif [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=( $(compgen -W \"$flags\" -- \"$cur\") )\n")
+	fmt.Fprintf(w, "\telse\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=( $(compgen -f -- \"$cur\") )\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "}\n")
+	_, err := fmt.Fprintf(w, "complete -o filenames -F _%s %s\n", shellName(app.Name), app.Name)
+	return err
+}
+
+// zshEscape escapes `s` for single quotes, and the brackets that _arguments
+// treats specially
+func zshEscape(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`).Replace(s)
+}
+
+func zshFlagSpecs(flags []cli.Flag) []string {
+	var specs []string
+	for _, f := range flags {
+		info := describeFlag(f)
+		for _, name := range info.names {
+			spec := "'" + dashed(name) + "[" + zshEscape(info.usage) + "]"
+			if info.takesValue {
+				spec += ":value:_files"
+			}
+			specs = append(specs, spec+"'")
+		}
+	}
+	return specs
+}
+
+func writeZshCompletion(w io.Writer, app *cli.App) error {
+	name := shellName(app.Name)
+	fmt.Fprintf(w, "#compdef %s\n\n", app.Name)
+	fmt.Fprintf(w, "# zsh completion for %s, generated by `%s completion zsh`\n", app.Name, app.Name)
+	fmt.Fprintf(w, "_%s() {\n", name)
+	fmt.Fprintf(w, "\tlocal -a commands\n")
+	fmt.Fprintf(w, "\tlocal state\n")
+	fmt.Fprintf(w, "\tcommands=(\n")
+	for _, cmd := range app.Commands {
+		for _, n := range commandNames(cmd) {
+			fmt.Fprintf(w, "\t\t'%s:%s'\n", n, zshEscape(cmd.Usage))
+		}
+	}
+	fmt.Fprintf(w, "\t)\n")
+	fmt.Fprintf(w, "\t_arguments -C \\\n")
+	for _, spec := range zshFlagSpecs(app.Flags) {
+		fmt.Fprintf(w, "\t\t%s \\\n", spec)
+	}
+	fmt.Fprintf(w, "\t\t'1: :->command' \\\n")
+	fmt.Fprintf(w, "\t\t'*:: :->args'\n")
+	fmt.Fprintf(w, "\tcase $state in\n")
+	fmt.Fprintf(w, "\tcommand)\n")
+	fmt.Fprintf(w, "\t\t_describe 'command' commands ;;\n")
+	fmt.Fprintf(w, "\targs)\n")
+	fmt.Fprintf(w, "\t\tcase $words[1] in\n")
+	for _, cmd := range app.Commands {
+		fmt.Fprintf(w, "\t\t%s)\n", strings.Join(commandNames(cmd), "|"))
+		fmt.Fprintf(w, "\t\t\t_arguments \\\n")
+		for _, spec := range zshFlagSpecs(cmd.Flags) {
+			fmt.Fprintf(w, "\t\t\t\t%s \\\n", spec)
+		}
+		fmt.Fprintf(w, "\t\t\t\t'*:file:_files' ;;\n")
+	}
+	fmt.Fprintf(w, "\t\tesac ;;\n")
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "}\n\n")
+	_, err := fmt.Fprintf(w, "_%s \"$@\"\n", name)
+	return err
+}
+
+// fishQuote quotes `s` in single quotes for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func writeFishFlags(w io.Writer, prog, condition string, flags []cli.Flag) {
+	for _, f := range flags {
+		info := describeFlag(f)
+		fmt.Fprintf(w, "complete -c %s -n %s", prog, fishQuote(condition))
+		for _, name := range info.names {
+			if len(name) == 1 {
+				fmt.Fprintf(w, " -s %s", name)
+			} else {
+				fmt.Fprintf(w, " -l %s", name)
+			}
+		}
+		if info.takesValue {
+			fmt.Fprintf(w, " -r")
+		}
+		fmt.Fprintf(w, " -d %s\n", fishQuote(info.usage))
+	}
+}
+
+func writeFishCompletion(w io.Writer, app *cli.App) error {
+	fmt.Fprintf(w, "# fish completion for %s, generated by `%s completion fish`\n", app.Name, app.Name)
+	writeFishFlags(w, app.Name, "__fish_use_subcommand", app.Flags)
+	for _, cmd := range app.Commands {
+		for _, n := range commandNames(cmd) {
+			fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -f -a %s -d %s\n", app.Name, fishQuote(n), fishQuote(cmd.Usage))
+		}
+	}
+	for _, cmd := range app.Commands {
+		writeFishFlags(w, app.Name, "__fish_seen_subcommand_from "+strings.Join(commandNames(cmd), " "), cmd.Flags)
+	}
+	return nil
+}
+
+// shellName is `name` as a shell function name, like "tar_split"
+func shellName(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
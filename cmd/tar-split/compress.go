@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"os/exec"
+	"strconv"
+
+	"github.com/vbatts/tar-split/tar/common"
+)
+
+// as for decompression, the standard library has no zstd compression, so it
+// is done by the zstd program, where it is installed
+func init() {
+	if path, err := exec.LookPath(common.Zstd); err == nil {
+		common.RegisterCompressor(common.Zstd, execCompressor(path))
+	}
+}
+
+// execCompressor compresses with the zstd program at `path`, in the level and
+// number of threads of the options
+func execCompressor(path string) common.Compressor {
+	return func(w io.Writer, opts common.CompressOptions) (io.WriteCloser, error) {
+		args := []string{"-c", "-q"}
+		if opts.Level != 0 {
+			args = append(args, "-"+strconv.Itoa(opts.Level))
+		}
+		if opts.Jobs > 1 {
+			args = append(args, "-T"+strconv.Itoa(opts.Jobs))
+		}
+		cmd := exec.Command(path, args...)
+		cmd.Stdout = w
+		in, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &execWriter{WriteCloser: in, cmd: cmd}, nil
+	}
+}
+
+// execWriter is the input of a compressing program, whose Close waits for
+// the program to finish its output, and fails if it does
+type execWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (ew *execWriter) Close() error {
+	if err := ew.WriteCloser.Close(); err != nil {
+		ew.cmd.Wait()
+		return err
+	}
+	return ew.cmd.Wait()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandConcat(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		logrus.Fatalf("please specify the metadata of the archives to join, in order")
+	}
+	paths := c.StringSlice("path")
+	if len(paths) != 1 && len(paths) != len(c.Args()) {
+		logrus.Fatalf("--path must be set once for all the archives, or once for each of the %d", len(c.Args()))
+	}
+
+	var inputs []asm.ConcatInput
+	for i, arg := range c.Args() {
+		mf, err := os.Open(arg)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer mf.Close()
+		mfz, err := gzip.NewReader(mf)
+		if err != nil {
+			logrus.Fatalf("%s: %v", arg, err)
+		}
+		defer mfz.Close()
+		path := paths[0]
+		if len(paths) > 1 {
+			path = paths[i]
+		}
+		inputs = append(inputs, asm.ConcatInput{
+			Unpacker: storage.NewAutoUnpacker(mfz),
+			Getter:   storage.NewPathFileGetter(path),
+		})
+	}
+
+	var outputStream io.Writer
+	if c.String("output") == "-" {
+		outputStream = os.Stdout
+	} else {
+		fh, err := os.Create(c.String("output"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer fh.Close()
+		outputStream = fh
+	}
+
+	ots := asm.NewConcatTarStream(inputs...)
+	defer ots.Close()
+	i, err := io.Copy(outputStream, ots)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("joined %d archives into %s (wrote %d bytes)", len(inputs), c.String("output"), i)
+}
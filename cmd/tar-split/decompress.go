@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/vbatts/tar-split/tar/common"
+)
+
+// the standard library has no xz or zstd decompression, so these are done by
+// the programs of the same name, which fail naming the program where it is
+// not installed
+func init() {
+	for _, compression := range []string{common.Xz, common.Zstd} {
+		path, err := exec.LookPath(compression)
+		if err != nil {
+			common.RegisterDecompressor(compression, missingDecompressor(compression))
+			continue
+		}
+		common.RegisterDecompressor(compression, execDecompressor(path))
+	}
+}
+
+// missingDecompressor fails to decompress, for want of the program `name`
+func missingDecompressor(name string) common.Decompressor {
+	return func(r io.Reader) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("the input is %s compressed, and decompressing it needs the %q program, which is not in $PATH", name, name)
+	}
+}
+
+// execDecompressor decompresses with the program at `path`, like "xz -dc"
+func execDecompressor(path string) common.Decompressor {
+	return func(r io.Reader) (io.ReadCloser, error) {
+		cmd := exec.Command(path, "-dc")
+		cmd.Stdin = r
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &execReader{ReadCloser: out, cmd: cmd}, nil
+	}
+}
+
+// execReader is the output of a decompressing program, that fails if the
+// program does
+type execReader struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	done bool
+	err  error
+}
+
+func (er *execReader) Read(p []byte) (int, error) {
+	n, err := er.ReadCloser.Read(p)
+	if err == io.EOF {
+		if werr := er.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (er *execReader) wait() error {
+	if !er.done {
+		er.done = true
+		er.err = er.cmd.Wait()
+	}
+	return er.err
+}
+
+func (er *execReader) Close() error {
+	er.ReadCloser.Close()
+	if er.cmd.ProcessState == nil && !er.done {
+		er.cmd.Process.Kill()
+	}
+	er.wait()
+	return nil
+}
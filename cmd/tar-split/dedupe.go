@@ -0,0 +1,62 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandDedupe(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		logrus.Fatalf("please specify metadata files to report on (like tar-data.json.gz of each layer)")
+	}
+	var ups []storage.Unpacker
+	for _, arg := range c.Args() {
+		mf, err := os.Open(arg)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer mf.Close()
+		mfz, err := gzip.NewReader(mf)
+		if err != nil {
+			logrus.Fatalf("%s: %v", arg, err)
+		}
+		defer mfz.Close()
+		ups = append(ups, storage.NewAutoUnpacker(mfz))
+	}
+
+	report, err := storage.FindDuplicates(ups...)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if c.Bool("json") {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf(" -- number of file payloads: %d\n", report.Files)
+	fmt.Printf(" -- size of file payloads: %dk\n", report.TotalBytes/1024)
+	fmt.Printf(" -- size of distinct file payloads: %dk\n", report.UniqueBytes/1024)
+	fmt.Printf(" -- potential savings: %dk (%dk of it from hard links within a layer)\n", report.Savings()/1024, report.HardlinkBytes/1024)
+	top := c.Int("top")
+	for i, d := range report.Duplicates {
+		if top > 0 && i >= top {
+			fmt.Printf("[...] %d more duplicated payloads\n", len(report.Duplicates)-top)
+			break
+		}
+		fmt.Println(d)
+		for _, de := range d.Entries {
+			fmt.Printf("\t%s: %s\n", c.Args()[de.Source], de.Name)
+		}
+	}
+	if report.Savings() > 0 {
+		fmt.Println("storing the payloads by their content, rather than by entry name, would keep each of these once")
+	}
+}
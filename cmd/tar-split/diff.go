@@ -0,0 +1,72 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandDiff(c *cli.Context) {
+	if len(c.Args()) != 2 {
+		logrus.Fatalf("please specify the two metadata files to compare")
+	}
+	var unpackers []storage.Unpacker
+	for _, arg := range c.Args() {
+		mf, err := os.Open(arg)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer mf.Close()
+		mfz, err := gzip.NewReader(mf)
+		if err != nil {
+			logrus.Fatalf("%s: %v", arg, err)
+		}
+		defer mfz.Close()
+		unpackers = append(unpackers, storage.NewAutoUnpacker(mfz))
+	}
+
+	report, err := asm.Diff(unpackers[0], unpackers[1])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if c.Bool("json") {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			logrus.Fatal(err)
+		}
+	} else {
+		for _, d := range report.Diffs {
+			var what []string
+			if len(d.Fields) > 0 {
+				what = append(what, strings.Join(d.Fields, ","))
+			}
+			if d.Content {
+				what = append(what, "content")
+			}
+			if d.Header {
+				what = append(what, "raw header")
+			}
+			if d.Moved {
+				what = append(what, "moved")
+			}
+			if len(what) > 0 {
+				fmt.Printf("%s\t%q\t(%s)\n", d.Kind, d.Name, strings.Join(what, "; "))
+			} else {
+				fmt.Printf("%s\t%q\n", d.Kind, d.Name)
+			}
+		}
+		if report.Trailer {
+			fmt.Println("trailer differs")
+		}
+	}
+	if !report.Identical() {
+		logrus.Fatalf("%s and %s differ in %d entries", c.Args()[0], c.Args()[1], len(report.Diffs))
+	}
+	logrus.Infof("%s and %s describe the same archive", c.Args()[0], c.Args()[1])
+}
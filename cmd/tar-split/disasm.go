@@ -1,17 +1,25 @@
 package main
 
 import (
+	"bufio"
 	"compress/gzip"
 	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/urfave/cli"
 	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/common"
 	"github.com/vbatts/tar-split/tar/storage"
 )
 
+// preambleSniffLimit is how far into the input `--preamble auto` looks for
+// the tar data
+const preambleSniffLimit = 1 << 20
+
 func CommandDisasm(c *cli.Context) {
 	if len(c.Args()) != 1 {
 		logrus.Fatalf("please specify tar to be disabled <NAME|->")
@@ -32,6 +40,16 @@ func CommandDisasm(c *cli.Context) {
 		defer fh.Close()
 		inputStream = fh
 	}
+	// a compressed archive, like a layer blob, is disassembled decompressed
+	rc, compression, err := common.DecompressReader(inputStream)
+	if err != nil {
+		logrus.Fatalf("decompressing %s: %v", c.Args()[0], err)
+	}
+	defer rc.Close()
+	if compression != common.Uncompressed {
+		logrus.Debugf("decompressing the %s input", compression)
+	}
+	inputStream = rc
 
 	// Set up the metadata storage
 	mf, err := os.OpenFile(c.String("output"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
@@ -41,11 +59,135 @@ func CommandDisasm(c *cli.Context) {
 	defer mf.Close()
 	mfz := gzip.NewWriter(mf)
 	defer mfz.Close()
-	metaPacker := storage.NewJSONPacker(mfz)
+	var metaPacker storage.Packer
+	switch c.String("format") {
+	case "", "json":
+		if c.Bool("compact") {
+			metaPacker = storage.NewCompactJSONPacker(mfz)
+		} else {
+			metaPacker = storage.NewJSONPacker(mfz)
+		}
+	case "proto":
+		metaPacker = storage.NewProtoPacker(mfz)
+	default:
+		logrus.Fatalf("--format must be json or proto")
+	}
+	if c.IsSet("compress-segments") {
+		if metaPacker, err = storage.NewSegmentEncodingPacker(metaPacker, "gzip", c.Int("compress-segments")); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if len(c.String("legacy-output")) > 0 {
+		lf, err := os.OpenFile(c.String("legacy-output"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer lf.Close()
+		lfz := gzip.NewWriter(lf)
+		defer lfz.Close()
+		metaPacker = storage.NewTeePacker(metaPacker, storage.NewJSONPacker(lfz))
+	}
+	if c.Bool("offsets") {
+		metaPacker = storage.NewOffsetPacker(metaPacker)
+	}
+	if c.Bool("whiteouts") {
+		metaPacker = storage.NewWhiteoutPacker(metaPacker)
+	}
+	if c.Bool("decode-names") {
+		metaPacker = storage.NewNameDecodingPacker(metaPacker)
+	}
+	var summary *storage.SummaryPacker
+	if c.Bool("summary") {
+		summary = storage.NewSummaryPacker(metaPacker)
+		metaPacker = summary
+	}
 
 	// we're passing nil here for the file putter, because the ApplyDiff will
 	// handle the extraction of the archive
-	its, err := asm.NewInputTarStream(inputStream, metaPacker, nil)
+	var filePutter storage.FilePutter
+	if len(c.String("checksum")) > 0 {
+		algorithms := strings.Split(c.String("checksum"), ",")
+		cp, err := storage.NewChecksumPacker(metaPacker, nil, algorithms[0])
+		if err != nil {
+			logrus.Fatalf("--checksum must be one of %s", strings.Join(storage.Checksums(), ", "))
+		}
+		for _, algorithm := range algorithms[1:] {
+			if err := cp.AddChecksum(algorithm); err != nil {
+				logrus.Fatalf("--checksum must be one of %s", strings.Join(storage.Checksums(), ", "))
+			}
+		}
+		if len(c.String("checksum-large")) > 0 {
+			if err := cp.SetLargeChecksum(c.String("checksum-large"), c.Int64("checksum-threshold")); err != nil {
+				logrus.Fatalf("--checksum-large must be one of %s", strings.Join(storage.Checksums(), ", "))
+			}
+		}
+		metaPacker, filePutter = cp, cp
+	} else if len(c.String("checksum-large")) > 0 {
+		logrus.Fatalf("--checksum-large needs --checksum, for the payloads under --checksum-threshold")
+	}
+	if len(c.String("chunk-dir")) > 0 {
+		cp := storage.NewChunkPacker(metaPacker, filePutter, c.String("chunk-dir"))
+		metaPacker, filePutter = cp, cp
+	}
+	if len(c.String("manifest")) > 0 {
+		manifest, err := os.Create(c.String("manifest"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer manifest.Close()
+		mp := storage.NewManifestPacker(metaPacker, filePutter, manifest)
+		metaPacker, filePutter = mp, mp
+	}
+	if len(c.String("source")) > 0 {
+		src, err := digestSourceFile(c.String("source"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if c.Bool("gzip-fingerprint") {
+			if src.Compression != "gzip" {
+				logrus.Fatalf("--gzip-fingerprint needs a gzip --source, not %q", src.Compression)
+			}
+			params, err := fingerprintSourceFile(c.String("source"))
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			if !params.Reproducible {
+				logrus.Warnf("%s was not compressed as compress/gzip does, so it can not be reproduced", c.String("source"))
+			}
+			src.Gzip = &params
+		}
+		if err := asm.AddSource(metaPacker, src); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	var its io.Reader
+	if c.Bool("strict") && (c.Int("jobs") > 1 || len(c.String("preamble")) > 0) {
+		logrus.Fatalf("--strict is not supported with --jobs or --preamble")
+	}
+	switch preamble := c.String("preamble"); preamble {
+	case "":
+		if jobs := c.Int("jobs"); jobs > 1 {
+			its, err = asm.NewParallelInputTarStream(inputStream, metaPacker, filePutter, jobs)
+		} else if c.Bool("strict") {
+			its, err = asm.NewStrictInputTarStream(inputStream, metaPacker, filePutter)
+		} else {
+			its, err = asm.NewInputTarStream(inputStream, metaPacker, filePutter)
+		}
+	case "auto":
+		br := bufio.NewReaderSize(inputStream, preambleSniffLimit+512)
+		off, ferr := asm.FindTarOffset(br, preambleSniffLimit)
+		if ferr != nil {
+			logrus.Fatal(ferr)
+		}
+		logrus.Debugf("found the tar data at offset %d", off)
+		its, err = asm.NewEmbeddedInputTarStream(br, off, metaPacker, filePutter)
+	default:
+		off, perr := strconv.ParseInt(preamble, 10, 64)
+		if perr != nil || off < 0 {
+			logrus.Fatalf("--preamble must be a number of bytes, or 'auto'")
+		}
+		its, err = asm.NewEmbeddedInputTarStream(inputStream, off, metaPacker, filePutter)
+	}
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -55,9 +197,38 @@ func CommandDisasm(c *cli.Context) {
 	} else {
 		out = os.Stdout
 	}
+	if summary != nil {
+		out = io.MultiWriter(out, summary)
+	}
 	i, err := io.Copy(out, its)
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	if summary != nil {
+		if err := summary.Close(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
 	logrus.Infof("created %s from %s (read %d bytes)", c.String("output"), c.Args()[0], i)
 }
+
+// digestSourceFile returns the identity of the blob at `path`, for --source
+func digestSourceFile(path string) (storage.Source, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return storage.Source{}, err
+	}
+	defer fh.Close()
+	return asm.DigestSource(fh)
+}
+
+// fingerprintSourceFile returns how the gzip blob at `path` was compressed,
+// for --gzip-fingerprint
+func fingerprintSourceFile(path string) (storage.GzipParams, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return storage.GzipParams{}, err
+	}
+	defer fh.Close()
+	return asm.FingerprintGzip(fh)
+}
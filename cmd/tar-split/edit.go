@@ -0,0 +1,84 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandEdit(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		logrus.Warnf("%d additional arguments passed are ignored", len(c.Args()))
+	}
+	for _, flag := range []string{"input", "path", "edits", "output", "output-metadata"} {
+		if len(c.String(flag)) == 0 {
+			logrus.Fatalf("--%s must be set", flag)
+		}
+	}
+
+	ef, err := os.Open(c.String("edits"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	edits, err := asm.ParseEdits(ef)
+	ef.Close()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	mf, err := os.Open(c.String("input"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mf.Close()
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mfz.Close()
+
+	var out io.Writer
+	if c.String("output") == "-" {
+		out = os.Stdout
+	} else {
+		fh, err := os.Create(c.String("output"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer fh.Close()
+		out = fh
+	}
+	h := sha256.New()
+
+	nf, err := os.OpenFile(c.String("output-metadata"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer nf.Close()
+	nfz := gzip.NewWriter(nf)
+
+	// the payloads are only needed again if the new archive is not extracted
+	filePutter := storage.NewDiscardFilePutter()
+	if len(c.String("output-path")) > 0 {
+		filePutter = storage.NewPathFilePutter(c.String("output-path"))
+	}
+	changes, err := asm.EditTarStream(storage.NewPathFileGetter(c.String("path")), storage.NewAutoUnpacker(mfz), edits, storage.NewJSONPacker(nfz), filePutter, io.MultiWriter(out, h))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := nfz.Close(); err != nil {
+		logrus.Fatal(err)
+	}
+	for _, change := range changes {
+		fmt.Fprintf(os.Stderr, "%s\t%s\n", change.Kind, change.Name)
+	}
+	logrus.Infof("created %s (sha256:%s) and %s, with %d entries edited", c.String("output"), hex.EncodeToString(h.Sum(nil)), c.String("output-metadata"), len(changes))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/common"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandExtract(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		logrus.Fatalf("please specify tar to be extracted <NAME|->")
+	}
+	if len(c.String("output")) == 0 {
+		logrus.Fatalf("--output filename must be set")
+	}
+	if len(c.String("dir")) == 0 {
+		logrus.Fatalf("--dir to extract to must be set")
+	}
+	if err := os.MkdirAll(c.String("dir"), 0755); err != nil {
+		logrus.Fatal(err)
+	}
+
+	var inputStream io.Reader
+	if c.Args()[0] == "-" {
+		inputStream = os.Stdin
+	} else {
+		fh, err := os.Open(c.Args()[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer fh.Close()
+		inputStream = fh
+	}
+	rc, _, err := common.DecompressReader(inputStream)
+	if err != nil {
+		logrus.Fatalf("decompressing %s: %v", c.Args()[0], err)
+	}
+	defer rc.Close()
+
+	mf, err := os.OpenFile(c.String("output"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mf.Close()
+	mfz := gzip.NewWriter(mf)
+	defer mfz.Close()
+	var metaPacker storage.Packer
+	switch c.String("format") {
+	case "", "json":
+		metaPacker = storage.NewJSONPacker(mfz)
+	case "proto":
+		metaPacker = storage.NewProtoPacker(mfz)
+	default:
+		logrus.Fatalf("--format must be json or proto")
+	}
+	var filePutter storage.FilePutter
+	if len(c.String("checksum")) > 0 {
+		cp, err := storage.NewChecksumPacker(metaPacker, nil, c.String("checksum"))
+		if err != nil {
+			logrus.Fatalf("--checksum must be one of %s", strings.Join(storage.Checksums(), ", "))
+		}
+		metaPacker, filePutter = cp, cp
+	}
+
+	its, err := asm.NewExtractingInputTarStream(rc, metaPacker, filePutter, c.String("dir"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	i, err := io.Copy(ioutil.Discard, its)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("extracted %s to %s, and created %s (read %d bytes)", c.Args()[0], c.String("dir"), c.String("output"), i)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/common"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandFetch(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		logrus.Warnf("%d additional arguments passed are ignored", len(c.Args()))
+	}
+	if len(c.String("input")) == 0 {
+		logrus.Fatalf("--input filename must be set")
+	}
+	if (len(c.String("url")) == 0) == (len(c.String("ref")) == 0) {
+		logrus.Fatalf("one of --url or --ref must be set")
+	}
+
+	mf, err := os.Open(c.String("input"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	// read twice, to index the payloads of the blob and then to assemble
+	meta, err := ioutil.ReadAll(mfz)
+	mf.Close()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	blobURL, client := c.String("url"), http.DefaultClient
+	if len(c.String("ref")) > 0 {
+		var host string
+		if blobURL, host, err = parseBlobReference(c.String("ref")); err != nil {
+			logrus.Fatal(err)
+		}
+		client = &http.Client{Transport: &registryTransport{host: host}}
+	}
+	blob, cleanup, err := openBlob(c, client, blobURL)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer cleanup()
+	fileGetter, err := storage.NewTarFileGetter(blob, storage.NewAutoUnpacker(bytes.NewReader(meta)))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(c.String("path")) > 0 {
+		fileGetter = storage.NewFallbackFileGetter(storage.NewPathFileGetter(c.String("path")), fileGetter)
+	}
+	metaUnpacker := storage.NewAutoUnpacker(bytes.NewReader(meta))
+
+	if len(c.String("extract")) > 0 {
+		if err := asm.ExtractTarStream(fileGetter, metaUnpacker, c.String("extract")); err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("extracted %s from %s and %s", c.String("extract"), blobURL, c.String("input"))
+		return
+	}
+
+	var out io.Writer = os.Stdout
+	if c.String("output") != "-" {
+		fh, err := os.Create(c.String("output"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer fh.Close()
+		out = fh
+	}
+	h := sha256.New()
+	if err := asm.WriteOutputTarStream(fileGetter, metaUnpacker, io.MultiWriter(out, h)); err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("created %s (sha256:%s) from %s and %s", c.String("output"), hex.EncodeToString(h.Sum(nil)), blobURL, c.String("input"))
+}
+
+// openBlob returns the uncompressed archive at `blobURL`. It is read by range
+// requests if it can be, and otherwise fetched whole (and decompressed) into
+// a temporary file in --tmpdir, which cleanup removes.
+func openBlob(c *cli.Context, client *http.Client, blobURL string) (io.ReaderAt, func(), error) {
+	hra, err := storage.NewHTTPReaderAt(client, blobURL)
+	if err != nil && err != storage.ErrNoRanges {
+		return nil, nil, err
+	}
+	if err == nil {
+		magic := make([]byte, 8)
+		n, err := hra.ReadAt(magic, 0)
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		compression := common.DetectCompression(magic[:n])
+		if compression == common.Uncompressed {
+			return hra, func() {}, nil
+		}
+		logrus.Infof("%s is %s compressed, so it is fetched whole", blobURL, compression)
+	} else {
+		logrus.Infof("%s is not served by range, so it is fetched whole", blobURL)
+	}
+
+	resp, err := client.Get(blobURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("GET %s: %s", blobURL, resp.Status)
+	}
+	r, _, err := common.DecompressReader(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	fh, err := newSpillFile(c, "tar-split-fetch-")
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.Copy(fh, r); err != nil {
+		fh.Cleanup()
+		return nil, nil, err
+	}
+	return fh, fh.Cleanup, nil
+}
+
+// parseBlobReference returns the URL of the blob of an OCI reference like
+// "registry.example.com/repo/name@sha256:...", and the host of its registry.
+// References without a registry are of Docker Hub, as with docker pull.
+func parseBlobReference(ref string) (string, string, error) {
+	i := strings.Index(ref, "@")
+	if i < 0 || !strings.Contains(ref[i+1:], ":") {
+		return "", "", fmt.Errorf("invalid reference %q, rather than REPOSITORY@DIGEST", ref)
+	}
+	repo, digest := ref[:i], ref[i+1:]
+	host := "registry-1.docker.io"
+	if j := strings.Index(repo, "/"); j > 0 && (strings.ContainsAny(repo[:j], ".:") || repo[:j] == "localhost") {
+		host, repo = repo[:j], repo[j+1:]
+	} else if strings.HasPrefix(repo, "docker.io/") {
+		repo = strings.TrimPrefix(repo, "docker.io/")
+	}
+	if host == "registry-1.docker.io" && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return fmt.Sprintf("https://%s/v2/%s/blobs/%s", host, repo, digest), host, nil
+}
+
+// registryTransport requests the anonymous bearer tokens that registries
+// challenge for, and sends them to the registry `host` only (not to the blob
+// storage it redirects to)
+type registryTransport struct {
+	host string
+
+	mu    sync.Mutex
+	token string
+}
+
+func (rt *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != rt.host {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	rt.mu.Lock()
+	token := rt.token
+	rt.mu.Unlock()
+	resp, err := http.DefaultTransport.RoundTrip(withToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if token, err = fetchToken(parseChallenge(challenge[len("Bearer "):])); err != nil {
+		return nil, err
+	}
+	rt.mu.Lock()
+	rt.token = token
+	rt.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(withToken(req, token))
+}
+
+func withToken(req *http.Request, token string) *http.Request {
+	if token == "" {
+		return req
+	}
+	r := *req
+	r.Header = http.Header{}
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return &r
+}
+
+// parseChallenge parses the params of a challenge, like
+// `realm="https://auth.docker.io/token",service="registry.docker.io"`
+func parseChallenge(s string) map[string]string {
+	params := map[string]string{}
+	for len(s) > 0 {
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, s = s[1:end+1], s[end+2:]
+		} else if end := strings.Index(s, ","); end >= 0 {
+			value, s = s[:end], s[end:]
+		} else {
+			value, s = s, ""
+		}
+		params[key] = value
+		s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+	}
+	return params
+}
+
+func fetchToken(params map[string]string) (string, error) {
+	if params["realm"] == "" {
+		return "", fmt.Errorf("registry challenged without a realm")
+	}
+	q := url.Values{}
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			q.Set(k, params[k])
+		}
+	}
+	resp, err := http.Get(params["realm"] + "?" + q.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", params["realm"], resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
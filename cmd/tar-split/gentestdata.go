@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/gen"
+)
+
+func CommandGenTestdata(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		logrus.Warnf("%d additional arguments passed are ignored", len(c.Args()))
+	}
+	dir := c.String("output-dir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logrus.Fatal(err)
+	}
+	for _, a := range gen.Archives() {
+		name := filepath.Join(dir, a.Name+".tar")
+		if err := ioutil.WriteFile(name, a.Data, 0644); err != nil {
+			logrus.Fatal(err)
+		}
+		fmt.Printf("%s: %s\n", name, a.Description)
+	}
+}
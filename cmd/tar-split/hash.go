@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandHash(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		logrus.Fatalf("please specify files to hash ('-' will hash stdin)")
+	}
+	for _, arg := range c.Args() {
+		var r io.Reader
+		if arg == "-" {
+			r = os.Stdin
+		} else {
+			fh, err := os.Open(arg)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			defer fh.Close()
+			r = fh
+		}
+
+		var h hash.Hash
+		switch c.String("digest") {
+		case "":
+		case "sha256":
+			h = sha256.New()
+		case "sha512":
+			h = sha512.New()
+		default:
+			logrus.Fatalf("unknown --digest %q, expected sha256 or sha512", c.String("digest"))
+		}
+		if h != nil {
+			r = io.TeeReader(r, h)
+		}
+
+		// the same checksum as is recorded for a payload while disassembling
+		pd := storage.NewPayloadDigester()
+		if _, err := io.Copy(pd, r); err != nil {
+			logrus.Fatal(err)
+		}
+		size, sum := pd.Size(), pd.Checksum()
+		// the metadata has the checksum base64 encoded, as "payload"
+		fmt.Printf("%s size=%d crc64=%s payload=%s", arg, size, hex.EncodeToString(sum), base64.StdEncoding.EncodeToString(sum))
+		if h != nil {
+			fmt.Printf(" digest=%s:%s", c.String("digest"), hex.EncodeToString(h.Sum(nil)))
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+)
+
+func CommandInfo(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		logrus.Fatalf("please specify tar archives to inspect ('-' will inspect stdin)")
+	}
+	for _, arg := range c.Args() {
+		var r io.Reader
+		if arg == "-" {
+			r = os.Stdin
+		} else {
+			fh, err := os.Open(arg)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			defer fh.Close()
+			r = fh
+		}
+		info, err := asm.InspectTarStream(r)
+		if err != nil {
+			logrus.Fatalf("%s: %v", arg, err)
+		}
+		if c.Bool("json") {
+			if err := json.NewEncoder(os.Stdout).Encode(info); err != nil {
+				logrus.Fatal(err)
+			}
+			continue
+		}
+
+		fmt.Printf("inspecting %q (size %dk)\n", arg, info.Size/1024)
+		fmt.Printf(" -- number of entries: %d (%d with payloads)\n", info.Files, info.Payloads)
+		fmt.Printf(" -- size of file payloads: %dk\n", info.PayloadBytes/1024)
+		fmt.Printf(" -- number of raw segments: %d (%dk)\n", info.Segments, info.SegmentBytes/1024)
+		var types []string
+		for t := range info.Types {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			fmt.Printf(" -- %s: %d\n", t, info.Types[t])
+		}
+		fmt.Printf(" -- entries with PAX headers: %d\n", info.PAXHeaders)
+		if info.TrailerBytes != 1024 {
+			fmt.Printf(" -- %d bytes after the last entry, rather than the usual 1024 of the end of archive marker\n", info.TrailerBytes)
+		}
+		printNames(" -- sparse files (not assembled byte for byte): ", info.Sparse, c.Int("top"))
+		printNames(" -- long names or link targets: ", info.LongNames, c.Int("top"))
+		printNames(" -- names that are not UTF-8 (recorded as raw bytes): ", info.InvalidUTF8, c.Int("top"))
+	}
+}
+
+// printNames prints the count of `names`, and up to `top` of them (0 for all)
+func printNames(label string, names []string, top int) {
+	if len(names) == 0 {
+		return
+	}
+	fmt.Printf("%s%d\n", label, len(names))
+	for i, name := range names {
+		if top > 0 && i >= top {
+			fmt.Printf("\t[...] %d more\n", len(names)-top)
+			break
+		}
+		fmt.Printf("\t%q\n", name)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// lsEntry is a file listed by `ls`, as a line of its --json output and the
+// value of its --format template
+type lsEntry struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Mode     int64     `json:"mode"`
+	ModTime  time.Time `json:"modtime"`
+	Linkname string    `json:"linkname,omitempty"`
+	Crc64    string    `json:"crc64,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+}
+
+func CommandLs(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		logrus.Fatalf("please specify the metadata file to list")
+	}
+	var tmpl *template.Template
+	if len(c.String("format")) > 0 {
+		var err error
+		if tmpl, err = template.New("format").Parse(c.String("format") + "\n"); err != nil {
+			logrus.Fatalf("--format: %v", err)
+		}
+	}
+	mf, err := os.Open(c.Args()[0])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mf.Close()
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mfz.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	hr := asm.NewHeaderReader(storage.NewAutoUnpacker(mfz))
+	var count int
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			logrus.Fatal(err)
+		}
+		le := lsEntry{
+			Name:     hdr.Name,
+			Type:     asm.TypeName(hdr.Typeflag),
+			Size:     hdr.Size,
+			Mode:     hdr.Mode,
+			ModTime:  hdr.ModTime,
+			Linkname: hdr.Linkname,
+			Checksum: entry.Checksum,
+		}
+		if entry.Size > 0 {
+			le.Crc64 = hex.EncodeToString(entry.Payload)
+		}
+		switch {
+		case c.Bool("json"):
+			err = enc.Encode(le)
+		case tmpl != nil:
+			err = tmpl.Execute(os.Stdout, le)
+		default:
+			sum := le.Checksum
+			if sum == "" && le.Crc64 != "" {
+				sum = "crc64:" + le.Crc64
+			}
+			if sum == "" {
+				sum = "-"
+			}
+			name := fmt.Sprintf("%q", le.Name)
+			if le.Linkname != "" {
+				name += fmt.Sprintf(" -> %q", le.Linkname)
+			}
+			_, err = fmt.Printf("%s\t%d\t%s\t%s\n", le.Type, le.Size, sum, name)
+		}
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		count++
+	}
+	logrus.Infof("listed %d entries of %s", count, c.Args()[0])
+}
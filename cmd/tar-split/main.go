@@ -29,6 +29,19 @@ func main() {
 			Usage: "debug output",
 			// defaults to false
 		},
+		cli.StringFlag{
+			Name:   "tmpdir",
+			Usage:  "directory for the temporary files of commands that spill to disk (defaults to $TMPDIR, or /tmp)",
+			EnvVar: "TAR_SPLIT_TMPDIR",
+		},
+		cli.Int64Flag{
+			Name:  "tmp-limit",
+			Usage: "fail rather than write more than this many bytes to a temporary file (0 for no limit)",
+		},
+		cli.BoolFlag{
+			Name:  "keep-tmp",
+			Usage: "do not remove temporary files, e.g. to inspect them",
+		},
 	}
 	app.Commands = []cli.Command{
 		{
@@ -46,6 +59,81 @@ func main() {
 					Name:  "no-stdout",
 					Usage: "do not throughput the stream to STDOUT",
 				},
+				cli.StringFlag{
+					Name:  "manifest",
+					Usage: "also write a listing of the files, with the size and sha256 digest of each, to this file",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "json",
+					Usage: "format of the metadata: json, or proto for the much smaller protobuf encoding",
+				},
+				cli.StringFlag{
+					Name:  "checksum",
+					Usage: "also record a checksum of each file payload in this algorithm (sha256, sha512 or blake3), for assembly to verify; a comma-separated list, like sha256,blake3, records one of each",
+				},
+				cli.StringFlag{
+					Name:  "checksum-large",
+					Usage: "record the checksums of the payloads over --checksum-threshold in this algorithm instead, like blake3",
+				},
+				cli.Int64Flag{
+					Name:  "checksum-threshold",
+					Value: 16 << 20,
+					Usage: "size in bytes over which a payload is checksummed with --checksum-large",
+				},
+				cli.StringFlag{
+					Name:  "chunk-dir",
+					Usage: "store the file payloads to this directory as content-defined chunks, recorded in the metadata, for 'asm --chunk-dir'",
+				},
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Value: 1,
+					Usage: "number of file payloads to checksum and store at once, while the archive is read on",
+				},
+				cli.BoolFlag{
+					Name:  "strict",
+					Usage: "fail on an archive that is cut short, even between its entries, rather than disassembling what there is",
+				},
+				cli.BoolFlag{
+					Name:  "offsets",
+					Usage: "also record the offset of each entry in the archive, for mapping files back to their place in it",
+				},
+				cli.BoolFlag{
+					Name:  "whiteouts",
+					Usage: "annotate the whiteout entries of a container image layer with what they delete",
+				},
+				cli.BoolFlag{
+					Name:  "decode-names",
+					Usage: "also pack the names that are not UTF-8 decoded from their likely charset, for display",
+				},
+				cli.BoolFlag{
+					Name:  "compact",
+					Usage: "pack repeated padding as references, for smaller metadata (not readable by older versions of tar-split)",
+				},
+				cli.IntFlag{
+					Name:  "compress-segments",
+					Usage: "gzip each raw segment larger than this many bytes within the metadata (not readable by older versions of tar-split)",
+				},
+				cli.StringFlag{
+					Name:  "legacy-output",
+					Usage: "also write the metadata without --compact or --compress-segments to this file, in the same pass",
+				},
+				cli.StringFlag{
+					Name:  "preamble",
+					Usage: "number of bytes before the tar data in the input (like the header of a wrapping format), or 'auto' to find it",
+				},
+				cli.StringFlag{
+					Name:  "source",
+					Usage: "record the digest of this blob (like the compressed layer the input was decompressed from) in the metadata",
+				},
+				cli.BoolFlag{
+					Name:  "gzip-fingerprint",
+					Usage: "also record how the gzip --source was compressed, for 'asm --recompress' to reproduce it",
+				},
+				cli.BoolFlag{
+					Name:  "summary",
+					Usage: "end the metadata with a summary of its entries and the archive's digest, for 'asm --verify'",
+				},
 			},
 		},
 		{
@@ -69,6 +157,382 @@ func main() {
 					Value: "",
 					Usage: "relative path of extracted tar",
 				},
+				cli.StringFlag{
+					Name:  "thin",
+					Usage: "leave out file payloads, either zero filled ('zero') or skipped ('skip'); no --path is needed",
+				},
+				cli.StringSliceFlag{
+					Name:  "uid-map",
+					Usage: "shift uids by a mapping of containerID:hostID:size (repeatable)",
+				},
+				cli.StringSliceFlag{
+					Name:  "gid-map",
+					Usage: "shift gids by a mapping of containerID:hostID:size (repeatable)",
+				},
+				cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "assemble only the entries matching this pattern, or under a directory matching it (repeatable)",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "leave out the entries matching this pattern, or under a directory matching it (repeatable)",
+				},
+				cli.StringFlag{
+					Name:  "normalize",
+					Usage: "find the files in --path by their names in this Unicode normalization form (NFC or NFD)",
+				},
+				cli.StringFlag{
+					Name:  "snapshot",
+					Usage: "mounted snapshot directory of a sibling layer, to take the payloads it has from rather than --path",
+				},
+				cli.StringFlag{
+					Name:  "chunk-dir",
+					Usage: "directory of the chunks stored with 'disasm --chunk-dir', to take the payloads from rather than --path",
+				},
+				cli.BoolFlag{
+					Name:  "check-tree",
+					Usage: "check the files in --path against the metadata first, failing if any payload changed, and reporting changes of mode, time or xattrs",
+				},
+				cli.StringFlag{
+					Name:  "missing",
+					Value: "fail",
+					Usage: "when files are missing from --path: fail, zero fill them, skip their entries, or only report them",
+				},
+				cli.StringFlag{
+					Name:  "missing-list",
+					Usage: "write the entries whose files are missing to this file, a JSON object per line",
+				},
+				cli.StringFlag{
+					Name:  "source",
+					Usage: "fail unless this blob is the one recorded in the metadata with 'disasm --source'",
+				},
+				cli.BoolFlag{
+					Name:  "recompress",
+					Usage: "compress the output to the blob recorded with 'disasm --gzip-fingerprint', failing unless it is the same",
+				},
+				cli.StringFlag{
+					Name:  "compress",
+					Value: "none",
+					Usage: "compress the output: gzip, zstd (with the zstd program installed) or none",
+				},
+				cli.IntFlag{
+					Name:  "compress-level",
+					Usage: "level of --compress, like 1 to 9 for gzip or 1 to 19 for zstd (default of each, if unset)",
+				},
+				cli.IntFlag{
+					Name:  "compress-jobs",
+					Value: 1,
+					Usage: "number of blocks of the output to --compress at once",
+				},
+				cli.BoolFlag{
+					Name:  "verify",
+					Usage: "fail if the metadata does not match the summary written with 'disasm --summary', or has none",
+				},
+				cli.BoolFlag{
+					Name:  "untrusted",
+					Usage: "bound the memory taken by each entry of the metadata, failing on those too large, for metadata from an untrusted source",
+				},
+			},
+		},
+		{
+			Name:   "batch",
+			Usage:  "disassemble many tar archives in parallel, like the layers of an image",
+			Action: CommandBatch,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "file listing the tar archives, one per line",
+				},
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Value: 4,
+					Usage: "number of archives to disassemble at once",
+				},
+				cli.StringFlag{
+					Name:  "output-dir",
+					Value: ".",
+					Usage: "directory for the metadata of each archive (<name>-data.json.gz)",
+				},
+				cli.StringFlag{
+					Name:  "path",
+					Usage: "directory to store the file payloads of all the archives in, under the name of each archive",
+				},
+			},
+		},
+		{
+			Name:   "split",
+			Usage:  "split a tar archive into size bounded parts, each with its own metadata",
+			Action: CommandSplit,
+			Flags: []cli.Flag{
+				cli.Int64Flag{
+					Name:  "limit",
+					Usage: "maximum size of each part, in bytes",
+				},
+				cli.BoolFlag{
+					Name:  "chunk",
+					Usage: "allow entries larger than the limit to be cut into raw chunks",
+				},
+				cli.StringFlag{
+					Name:  "output-dir",
+					Value: ".",
+					Usage: "directory for the parts, their metadata and the manifest",
+				},
+			},
+		},
+		{
+			Name:   "join",
+			Usage:  "join the parts of a split tar archive back into the original",
+			Action: CommandJoin,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "manifest",
+					Value: "manifest.json",
+					Usage: "manifest of the split archive, next to its parts",
+				},
+				cli.StringFlag{
+					Name:  "output",
+					Value: "-",
+					Usage: "joined tar archive",
+				},
+			},
+		},
+		{
+			Name:      "extract",
+			Usage:     "extract the input tar stream to a directory, while disassembling it",
+			Action:    CommandExtract,
+			ArgsUsage: "<NAME|->",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output",
+					Value: "tar-data.json.gz",
+					Usage: "output of disassembled tar stream",
+				},
+				cli.StringFlag{
+					Name:  "dir",
+					Usage: "directory to extract to, and to assemble from again with 'asm --path'",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "json",
+					Usage: "format of the metadata: json, or proto for the much smaller protobuf encoding",
+				},
+				cli.StringFlag{
+					Name:  "checksum",
+					Usage: "also record a checksum of each file payload in this algorithm (sha256, sha512 or blake3), for assembly to verify",
+				},
+			},
+		},
+		{
+			Name:      "concat",
+			Usage:     "join several disassembled archives into one tar archive",
+			Action:    CommandConcat,
+			ArgsUsage: "<tar-data.json.gz>...",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "path",
+					Usage: "relative path of extracted tar, once for all the archives or once for each (repeatable)",
+				},
+				cli.StringFlag{
+					Name:  "output",
+					Value: "-",
+					Usage: "joined tar archive",
+				},
+			},
+		},
+		{
+			Name:   "migrate",
+			Usage:  "upgrade existing metadata to fill in newer fields",
+			Action: CommandMigrate,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "input",
+					Value: "tar-data.json.gz",
+					Usage: "metadata to migrate",
+				},
+				cli.StringFlag{
+					Name:  "output",
+					Usage: "migrated metadata",
+				},
+				cli.StringFlag{
+					Name:  "path",
+					Usage: "relative path of extracted tar, for migrations that read the file payloads",
+				},
+				cli.StringSliceFlag{
+					Name:  "apply",
+					Usage: "migration to apply (repeatable): inline-special, digest-sha256, digest-sha512",
+				},
+			},
+		},
+		{
+			Name:   "gen-testdata",
+			Usage:  "write archives that exercise the edge cases of the tar format, to validate round trips",
+			Action: CommandGenTestdata,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output-dir",
+					Value: ".",
+					Usage: "directory for the archives",
+				},
+			},
+		},
+		{
+			Name:   "dedupe",
+			Usage:  "report the file payloads duplicated across metadata files, and what storing them once would save",
+			Action: CommandDedupe,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "output the report as JSON",
+				},
+				cli.IntFlag{
+					Name:  "top",
+					Value: 20,
+					Usage: "number of duplicated payloads to list (0 for all)",
+				},
+			},
+		},
+		{
+			Name:   "hash",
+			Usage:  "compute the checksums of files that the metadata records for their payloads",
+			Action: CommandHash,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "digest",
+					Usage: "also compute a digest, like the ones added by `migrate --apply digest-sha256` (sha256 or sha512)",
+				},
+			},
+		},
+		{
+			Name:   "fetch",
+			Usage:  "assemble a tar archive from its metadata and the original blob where it is published, fetching only the payloads needed",
+			Action: CommandFetch,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "input",
+					Value: "tar-data.json.gz",
+					Usage: "input of the tar metadata",
+				},
+				cli.StringFlag{
+					Name:  "url",
+					Usage: "URL of the original blob",
+				},
+				cli.StringFlag{
+					Name:  "ref",
+					Usage: "OCI reference of the original blob, like registry.example.com/repo@sha256:...",
+				},
+				cli.StringFlag{
+					Name:  "path",
+					Usage: "relative path of extracted tar, to take the payloads it has from rather than the blob",
+				},
+				cli.StringFlag{
+					Name:  "output",
+					Value: "-",
+					Usage: "reassembled tar archive",
+				},
+				cli.StringFlag{
+					Name:  "extract",
+					Usage: "directory to extract the archive to, rather than writing it to --output",
+				},
+			},
+		},
+		{
+			Name:   "edit",
+			Usage:  "apply a spec of edits (rename, delete, chmod, chown, mtime) to a disassembled archive, producing a new archive and metadata",
+			Action: CommandEdit,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "input",
+					Value: "tar-data.json.gz",
+					Usage: "metadata of the archive to edit",
+				},
+				cli.StringFlag{
+					Name:  "path",
+					Usage: "relative path of extracted tar",
+				},
+				cli.StringFlag{
+					Name:  "edits",
+					Usage: "file of the edits to apply, one per line",
+				},
+				cli.StringFlag{
+					Name:  "output",
+					Value: "-",
+					Usage: "edited tar archive",
+				},
+				cli.StringFlag{
+					Name:  "output-metadata",
+					Usage: "metadata of the edited archive",
+				},
+				cli.StringFlag{
+					Name:  "output-path",
+					Usage: "directory to store the file payloads of the edited archive in, under their new names",
+				},
+			},
+		},
+		{
+			Name:   "info",
+			Usage:  "preview what disassembly would record of tar archives, without storing anything",
+			Action: CommandInfo,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "output the layout of each archive as JSON",
+				},
+				cli.IntFlag{
+					Name:  "top",
+					Value: 20,
+					Usage: "number of names to list of each unusual construct (0 for all)",
+				},
+			},
+		},
+		{
+			Name:   "verify",
+			Usage:  "verify a tar archive against its metadata, reporting the first entry that differs",
+			Action: CommandVerify,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "input",
+					Usage: "file to read the metadata of the archive from",
+				},
+			},
+		},
+		{
+			Name:      "diff",
+			Usage:     "compare the metadata of two tar archives, reporting the entries added, removed or changed",
+			ArgsUsage: "<tar-data.json.gz> <tar-data.json.gz>",
+			Action:    CommandDiff,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "output the report as JSON",
+				},
+			},
+		},
+		{
+			Name:      "ls",
+			Usage:     "list the entries of a tar archive from its metadata alone, with their types, sizes and checksums",
+			ArgsUsage: "<tar-data.json.gz>",
+			Action:    CommandLs,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "output each entry as a line of JSON",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "output each entry with this Go template, like '{{.Name}} {{.Size}}' (the fields are Name, Type, Size, Mode, ModTime, Linkname, Crc64 and Checksum)",
+				},
+			},
+		},
+		{
+			Name:      "toc",
+			Usage:     "export the eStargz TOC (or zstd:chunked manifest) of a tar archive from its metadata",
+			ArgsUsage: "<tar-data.json.gz>",
+			Action:    CommandTOC,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output",
+					Usage: "file to write the TOC to, rather than stdout",
+				},
 			},
 		},
 		{
@@ -81,6 +545,28 @@ func main() {
 					Usage: "do not delete the working directory",
 					// defaults to false
 				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "write a report of each archive as a line of JSON, with the metadata by type of entry, the sizes of the segments, and the size in each encoding",
+				},
+			},
+		},
+		{
+			Name:      "completion",
+			Usage:     "output the shell completion script for bash, zsh or fish",
+			ArgsUsage: "bash|zsh|fish",
+			Action:    CommandCompletion,
+		},
+		{
+			Name:   "man",
+			Usage:  "write man pages of tar-split and each of its commands",
+			Action: CommandMan,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output-dir",
+					Value: ".",
+					Usage: "directory for the man pages",
+				},
 			},
 		},
 	}
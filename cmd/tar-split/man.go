@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func CommandMan(c *cli.Context) {
+	dir := c.String("output-dir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logrus.Fatal(err)
+	}
+	pages := map[string]func(io.Writer) error{
+		c.App.Name + ".1": func(w io.Writer) error { return writeAppManPage(w, c.App) },
+	}
+	for _, cmd := range c.App.Commands {
+		cmd := cmd
+		pages[c.App.Name+"-"+cmd.Name+".1"] = func(w io.Writer) error { return writeCommandManPage(w, c.App, cmd) }
+	}
+	for name, write := range pages {
+		fh, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := write(fh); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := fh.Close(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	logrus.Infof("wrote %d man pages to %s", len(pages), dir)
+}
+
+// roff escapes `s` for the text of a man page
+func roff(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+func writeManHeader(w io.Writer, app *cli.App, title, usage string) {
+	fmt.Fprintf(w, ".TH %s 1 \"\" \"%s %s\" \"User Commands\"\n", strings.ToUpper(title), app.Name, app.Version)
+	fmt.Fprintf(w, ".SH NAME\n%s \\- %s\n", roff(title), roff(usage))
+}
+
+func writeManFlags(w io.Writer, section string, flags []cli.Flag) {
+	if len(flags) == 0 {
+		return
+	}
+	fmt.Fprintf(w, ".SH %s\n", section)
+	for _, f := range flags {
+		info := describeFlag(f)
+		var names []string
+		for _, name := range info.names {
+			names = append(names, `\fB`+roff(dashed(name))+`\fR`)
+		}
+		fmt.Fprintf(w, ".TP\n%s", strings.Join(names, ", "))
+		if info.takesValue {
+			fmt.Fprintf(w, ` \fIvalue\fR`)
+		}
+		fmt.Fprintf(w, "\n%s", roff(info.usage))
+		if info.value != "" {
+			fmt.Fprintf(w, " (default: %s)", roff(info.value))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+func writeAppManPage(w io.Writer, app *cli.App) error {
+	writeManHeader(w, app, app.Name, app.Usage)
+	fmt.Fprintf(w, ".SH SYNOPSIS\n.B %s\n[\\fIglobal options\\fR] \\fIcommand\\fR [\\fIcommand options\\fR] [\\fIarguments...\\fR]\n", roff(app.Name))
+	writeManFlags(w, "GLOBAL OPTIONS", app.Flags)
+	fmt.Fprintf(w, ".SH COMMANDS\n")
+	var seeAlso []string
+	for _, cmd := range app.Commands {
+		fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roff(strings.Join(commandNames(cmd), ", ")), roff(cmd.Usage))
+		seeAlso = append(seeAlso, `\fB`+roff(app.Name+"-"+cmd.Name)+`\fR(1)`)
+	}
+	_, err := fmt.Fprintf(w, ".SH SEE ALSO\n%s\n", strings.Join(seeAlso, ", "))
+	return err
+}
+
+func writeCommandManPage(w io.Writer, app *cli.App, cmd cli.Command) error {
+	writeManHeader(w, app, app.Name+"-"+cmd.Name, cmd.Usage)
+	fmt.Fprintf(w, ".SH SYNOPSIS\n.B %s %s\n[\\fIoptions\\fR] [\\fIarguments...\\fR]\n", roff(app.Name), roff(cmd.Name))
+	if len(cmd.Aliases) > 0 {
+		fmt.Fprintf(w, ".SH ALIASES\n%s\n", roff(strings.Join(cmd.Aliases, ", ")))
+	}
+	if cmd.Description != "" {
+		fmt.Fprintf(w, ".SH DESCRIPTION\n%s\n", roff(cmd.Description))
+	}
+	writeManFlags(w, "OPTIONS", cmd.Flags)
+	_, err := fmt.Fprintf(w, ".SH SEE ALSO\n\\fB%s\\fR(1)\n", roff(app.Name))
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"compress/gzip"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandMigrate(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		logrus.Warnf("%d additional arguments passed are ignored", len(c.Args()))
+	}
+	if len(c.String("input")) == 0 {
+		logrus.Fatalf("--input filename must be set")
+	}
+	if len(c.String("output")) == 0 {
+		logrus.Fatalf("--output filename must be set")
+	}
+	if len(c.StringSlice("apply")) == 0 {
+		logrus.Fatalf("--apply must be set to one or more of: %s", strings.Join(asm.MigrationNames(), ", "))
+	}
+	var migrations []asm.Migration
+	for _, name := range c.StringSlice("apply") {
+		m, ok := asm.LookupMigration(name)
+		if !ok {
+			logrus.Fatalf("unknown migration %q, expected one of: %s", name, strings.Join(asm.MigrationNames(), ", "))
+		}
+		migrations = append(migrations, m)
+	}
+
+	mf, err := os.Open(c.String("input"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mf.Close()
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mfz.Close()
+
+	fh, err := os.Create(c.String("output"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer fh.Close()
+	fhz := gzip.NewWriter(fh)
+
+	var fileGetter storage.FileGetter
+	if len(c.String("path")) > 0 {
+		fileGetter = storage.NewPathFileGetter(c.String("path"))
+	}
+	if err := asm.MigrateMetadata(storage.NewAutoUnpacker(mfz), storage.NewJSONPacker(fhz), fileGetter, migrations...); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := fhz.Close(); err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("migrated %s to %s (applied %s)", c.String("input"), c.String("output"), strings.Join(c.StringSlice("apply"), ", "))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+const splitManifestName = "manifest.json"
+
+func splitPartName(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("part-%04d.tar", n))
+}
+
+func CommandSplit(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		logrus.Fatalf("please specify tar to be split <NAME|->")
+	}
+	if c.Int64("limit") <= 0 {
+		logrus.Fatalf("--limit must be set")
+	}
+	dir := c.String("output-dir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logrus.Fatal(err)
+	}
+
+	var inputStream io.Reader
+	if c.Args()[0] == "-" {
+		inputStream = os.Stdin
+	} else {
+		fh, err := os.Open(c.Args()[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer fh.Close()
+		inputStream = fh
+	}
+
+	var closers []io.Closer
+	defer func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].Close()
+		}
+	}()
+	opts := asm.SplitOptions{
+		Limit:    c.Int64("limit"),
+		Chunking: c.Bool("chunk"),
+	}
+	m, err := asm.SplitTarStream(inputStream, opts, func(n int) (asm.SplitPart, error) {
+		fh, err := os.Create(splitPartName(dir, n))
+		if err != nil {
+			return asm.SplitPart{}, err
+		}
+		mf, err := os.Create(splitPartName(dir, n) + "-data.json.gz")
+		if err != nil {
+			fh.Close()
+			return asm.SplitPart{}, err
+		}
+		mfz := gzip.NewWriter(mf)
+		closers = append(closers, fh, mf, mfz)
+		return asm.SplitPart{W: fh, Packer: storage.NewJSONPacker(mfz)}, nil
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	mfh, err := os.Create(filepath.Join(dir, splitManifestName))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mfh.Close()
+	if err := json.NewEncoder(mfh).Encode(m); err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("split %s into %d parts in %s", c.Args()[0], len(m.Parts), dir)
+}
+
+func CommandJoin(c *cli.Context) {
+	if len(c.String("manifest")) == 0 {
+		logrus.Fatalf("--manifest must be set")
+	}
+	mfh, err := os.Open(c.String("manifest"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mfh.Close()
+	var m asm.SplitManifest
+	if err := json.NewDecoder(mfh).Decode(&m); err != nil {
+		logrus.Fatal(err)
+	}
+
+	var outputStream io.Writer
+	if c.String("output") == "-" {
+		outputStream = os.Stdout
+	} else {
+		fh, err := os.Create(c.String("output"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer fh.Close()
+		outputStream = fh
+	}
+
+	dir := filepath.Dir(c.String("manifest"))
+	err = asm.JoinTarStream(outputStream, &m, func(n int) (io.ReadCloser, error) {
+		return os.Open(splitPartName(dir, n))
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("joined %d parts into %s (wrote %d bytes)", len(m.Parts), c.String("output"), m.Size)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// spillFile is a temporary file of a command, in --tmpdir, that fails with a
+// clear error once more than --tmp-limit bytes are written to it
+type spillFile struct {
+	*os.File
+	limit   int64
+	written int64
+	keep    bool
+}
+
+func newSpillFile(c *cli.Context, prefix string) (*spillFile, error) {
+	fh, err := ioutil.TempFile(c.GlobalString("tmpdir"), prefix)
+	if err != nil {
+		return nil, fmt.Errorf("creating a temporary file (see --tmpdir): %v", err)
+	}
+	return &spillFile{
+		File:  fh,
+		limit: c.GlobalInt64("tmp-limit"),
+		keep:  c.GlobalBool("keep-tmp"),
+	}, nil
+}
+
+func (sf *spillFile) Write(p []byte) (int, error) {
+	if sf.limit > 0 && sf.written+int64(len(p)) > sf.limit {
+		return 0, fmt.Errorf("%s: more than the --tmp-limit of %d bytes would be written", sf.Name(), sf.limit)
+	}
+	n, err := sf.File.Write(p)
+	sf.written += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("writing to a temporary file (see --tmpdir): %v", err)
+	}
+	return n, nil
+}
+
+// ReadFrom is only so that io.Copy goes through Write, and the limit
+func (sf *spillFile) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{sf}, r)
+}
+
+// Cleanup closes the file, and removes it unless --keep-tmp is set
+func (sf *spillFile) Cleanup() {
+	sf.Close()
+	if sf.keep {
+		logrus.Infof("kept temporary file %s", sf.Name())
+		return
+	}
+	if err := os.Remove(sf.Name()); err != nil {
+		logrus.Warnf("removing temporary file: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandTOC(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		logrus.Fatalf("please specify the metadata file to export the TOC of")
+	}
+	mf, err := os.Open(c.Args()[0])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mf.Close()
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mfz.Close()
+
+	toc, err := asm.NewTOC(storage.NewAutoUnpacker(mfz))
+	if err == storage.ErrNoOffsets {
+		logrus.Fatalf("%s has no offsets; disassemble the archive with --offsets", c.Args()[0])
+	} else if err != nil {
+		logrus.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if len(c.String("output")) > 0 {
+		fh, err := os.Create(c.String("output"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer fh.Close()
+		w = fh
+	}
+	if err := json.NewEncoder(w).Encode(toc); err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("exported the TOC of %d entries from %s", len(toc.Entries), c.Args()[0])
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func CommandVerify(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		logrus.Fatalf("please specify the tar archive to verify <NAME|->")
+	}
+	if len(c.String("input")) == 0 {
+		logrus.Fatalf("--input filename must be set")
+	}
+
+	var archive io.Reader
+	if c.Args()[0] == "-" {
+		archive = os.Stdin
+	} else {
+		fh, err := os.Open(c.Args()[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer fh.Close()
+		archive = fh
+	}
+
+	mf, err := os.Open(c.String("input"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mf.Close()
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer mfz.Close()
+
+	if err := asm.VerifyTarStream(archive, storage.NewAutoUnpacker(mfz)); err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("%s matches %s", c.Args()[0], c.String("input"))
+}
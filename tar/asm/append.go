@@ -0,0 +1,87 @@
+package asm
+
+import (
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// NewAppendTarStream appends the entries of the tar archive read from `r` to
+// an existing archive, whose metadata is read from `up`.
+//
+// The existing metadata is packed to `p`, less the end of archive marker, and
+// then the new entries are packed after it, with their payloads stored to
+// `fp`, as with NewInputTarStream.
+//
+// The original archive is not rewritten. Instead, the returned offset is
+// where the original archive is to be truncated, and the returned Reader
+// provides the bytes to write from there on: the new entries and a new end of
+// archive marker. Only once the Reader has been read to the end is the
+// metadata in `p` complete.
+func NewAppendTarStream(up storage.Unpacker, r io.Reader, p storage.Packer, fp storage.FilePutter) (int64, io.Reader, error) {
+	rec := &recordingUnpacker{up: up}
+	hr := NewHeaderReader(rec)
+	var offset int64
+	for {
+		_, _, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, nil, err
+		}
+		for _, entry := range rec.entries {
+			if entry.Type == storage.SourceType {
+				// the archive appended to is no longer the source blob
+				continue
+			}
+			if _, err := p.AddEntry(*entry); err != nil {
+				return 0, nil, err
+			}
+			if entry.Type == storage.SegmentType {
+				offset += int64(len(entry.Payload))
+			} else {
+				offset += entry.DataSize()
+			}
+		}
+		rec.entries = nil
+	}
+
+	// the segments after the last file are its padding, and then the end of
+	// archive marker that is dropped.
+	var trailing []byte
+	for _, entry := range rec.entries {
+		if entry.Type == storage.SegmentType {
+			trailing = append(trailing, entry.Payload...)
+		}
+	}
+	if keep := trailing[:len(trailing)-len(hr.Trailer())]; len(keep) > 0 {
+		if _, err := p.AddEntry(storage.Entry{
+			Type:    storage.SegmentType,
+			Payload: keep,
+		}); err != nil {
+			return 0, nil, err
+		}
+		offset += int64(len(keep))
+	}
+
+	its, err := NewInputTarStream(r, p, fp)
+	if err != nil {
+		return 0, nil, err
+	}
+	return offset, its, nil
+}
+
+// recordingUnpacker keeps the entries read through it
+type recordingUnpacker struct {
+	up      storage.Unpacker
+	entries []*storage.Entry
+}
+
+func (ru *recordingUnpacker) Next() (*storage.Entry, error) {
+	entry, err := ru.up.Next()
+	if err == nil {
+		ru.entries = append(ru.entries, entry)
+	}
+	return entry, err
+}
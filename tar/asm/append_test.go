@@ -0,0 +1,59 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestAppendTarStream(t *testing.T) {
+	original := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, original)
+
+	more := []testFile{
+		{hdr: tar.Header{Name: "appended.txt", Typeflag: tar.TypeReg, Mode: 0644}, body: "appended"},
+		{hdr: tar.Header{Name: "appended/", Typeflag: tar.TypeDir, Mode: 0755}},
+	}
+	newMeta := bytes.NewBuffer(nil)
+	offset, rdr, err := NewAppendTarStream(storage.NewJSONUnpacker(bytes.NewReader(meta)), bytes.NewReader(buildTar(t, more)), storage.NewJSONPacker(newMeta), fgp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(original[offset:], make([]byte, len(original)-int(offset))) {
+		t.Errorf("expected only the end of archive marker after offset %d", offset)
+	}
+	appended := append(append([]byte{}, original[:offset]...), tail...)
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(appended))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if len(names) != len(testFiles)+len(more) || names[len(names)-2] != "appended.txt" {
+		t.Errorf("unexpected entries of the appended archive %v", names)
+	}
+
+	rc := NewOutputTarStream(fgp, storage.NewJSONUnpacker(newMeta))
+	reassembled, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reassembled, appended) {
+		t.Errorf("expected the updated metadata to reassemble the appended archive")
+	}
+}
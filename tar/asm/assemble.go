@@ -2,12 +2,17 @@ package asm
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"hash/crc64"
 	"io"
-	"sync"
+	"io/ioutil"
+	"strings"
 
+	"github.com/vbatts/tar-split/tar/common"
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
 	"github.com/vbatts/tar-split/tar/storage"
 )
 
@@ -18,21 +23,85 @@ import (
 // and a storage.Unpacker, which has access to the rawbytes and file order
 // metadata. With the combination of these two items, a precise assembled Tar
 // archive is possible.
+//
+// The stream is also an io.WriterTo, so io.Copy writes the archive directly
+// to its destination, as WriteOutputTarStream does, rather than through a pipe.
+// It is only assembled through a pipe once it is Read.
 func NewOutputTarStream(fg storage.FileGetter, up storage.Unpacker) io.ReadCloser {
 	// ... Since these are interfaces, this is possible, so let's not have a nil pointer
 	if fg == nil || up == nil {
 		return nil
 	}
-	pr, pw := io.Pipe()
-	go func() {
-		err := WriteOutputTarStream(fg, up, pw)
-		if err != nil {
-			pw.CloseWithError(err)
-		} else {
-			pw.Close()
+	return &outputTarStream{fg: fg, up: up}
+}
+
+// outputTarStream assembles the archive on the first Read or WriteTo
+type outputTarStream struct {
+	fg storage.FileGetter
+	up storage.Unpacker
+	pr *io.PipeReader
+	// done is set once it is written by WriteTo, or closed before it started,
+	// with the error to return from then on, io.EOF if it was written whole
+	done bool
+	err  error
+}
+
+func (ots *outputTarStream) Read(p []byte) (int, error) {
+	if ots.pr == nil {
+		if ots.done {
+			return 0, ots.err
 		}
-	}()
-	return pr
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(WriteOutputTarStream(ots.fg, ots.up, pw))
+		}()
+		ots.pr = pr
+	}
+	return ots.pr.Read(p)
+}
+
+// WriteTo writes the archive to `w`, or what is left of it to be read
+func (ots *outputTarStream) WriteTo(w io.Writer) (int64, error) {
+	if ots.pr != nil {
+		return io.Copy(w, ots.pr)
+	}
+	if ots.done {
+		if ots.err == io.EOF {
+			return 0, nil
+		}
+		return 0, ots.err
+	}
+	cw := &countingWriter{w: w}
+	err := WriteOutputTarStream(ots.fg, ots.up, cw)
+	// the archive can not be written again, so a failure is returned by
+	// every Read and WriteTo after it, rather than a clean io.EOF
+	ots.done, ots.err = true, err
+	if err == nil {
+		ots.err = io.EOF
+	}
+	return cw.n, err
+}
+
+func (ots *outputTarStream) Close() error {
+	if ots.pr != nil {
+		return ots.pr.Close()
+	}
+	if !ots.done {
+		ots.done, ots.err = true, io.ErrClosedPipe
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
 }
 
 // WriteOutputTarStream writes assembled tar archive to a writer.
@@ -62,41 +131,343 @@ func WriteOutputTarStream(fg storage.FileGetter, up storage.Unpacker, w io.Write
 			if entry.Size == 0 {
 				continue
 			}
-			fh, err := fg.Get(entry.GetName())
+			if entry.Special != "" {
+				if err := writeInline(w, entry); err != nil {
+					return err
+				}
+				continue
+			}
+			fh, err := getPayload(fg, entry)
 			if err != nil {
 				return err
 			}
+			var rdr io.Reader = fh
+			if entry.Partial {
+				if rdr, err = partReader(fh, entry); err != nil {
+					fh.Close()
+					return err
+				}
+			}
 			if crcHash == nil {
 				crcHash = crc64.New(storage.CRCTable)
 				crcSum = make([]byte, 8)
 				multiWriter = io.MultiWriter(w, crcHash)
-				copyBuffer = byteBufferPool.Get().([]byte)
-				defer byteBufferPool.Put(copyBuffer)
+				buf := bufpool.Get()
+				defer bufpool.Put(buf)
+				copyBuffer = *buf
 			} else {
 				crcHash.Reset()
 			}
 
-			if _, err := copyWithBuffer(multiWriter, fh, copyBuffer); err != nil {
+			dst := multiWriter
+			var sums io.Writer = crcHash
+			hw, checksumsOK, err := newChecksumsVerifier(entry)
+			if err != nil {
+				fh.Close()
+				return err
+			}
+			if hw != nil {
+				dst = io.MultiWriter(multiWriter, hw)
+				sums = io.MultiWriter(crcHash, hw)
+			}
+
+			if len(entry.Sparse) > 0 && !entry.SparsePacked {
+				err = copySparseData(w, sums, rdr, entry.Sparse, copyBuffer)
+			} else {
+				_, err = copyWithBuffer(dst, rdr, copyBuffer)
+			}
+			if err != nil {
 				fh.Close()
 				return err
 			}
 
-			if !bytes.Equal(crcHash.Sum(crcSum[:0]), entry.Payload) {
-				// I would rather this be a comparable ErrInvalidChecksum or such,
-				// but since it's coming through the PipeReader, the context of
-				// _which_ file would be lost...
+			if sum := crcHash.Sum(crcSum[:0]); !bytes.Equal(sum, entry.Payload) {
 				fh.Close()
-				return fmt.Errorf("file integrity checksum failed for %q", entry.GetName())
+				return newCRCError(entry, sum)
+			}
+			if checksumsOK != nil {
+				if err := checksumsOK(); err != nil {
+					fh.Close()
+					return err
+				}
 			}
 			fh.Close()
 		}
 	}
 }
 
-var byteBufferPool = &sync.Pool{
-	New: func() interface{} {
-		return make([]byte, 32*1024)
-	},
+// WriteCompressedTarStream is like WriteOutputTarStream, with the archive
+// compressed to `w` in `compression`, like "gzip" or "zstd" (see
+// common.CompressWriter), rather than piped through a compressor after it.
+// The compressed stream is finished before it returns, without closing `w`.
+func WriteCompressedTarStream(fg storage.FileGetter, up storage.Unpacker, w io.Writer, compression string, opts common.CompressOptions) error {
+	cw, err := common.CompressWriter(w, compression, opts)
+	if err != nil {
+		return err
+	}
+	if err := WriteOutputTarStream(fg, up, cw); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// WriteVerifiedTarStream is like WriteOutputTarStream, for metadata packed
+// with a storage.SummaryPacker. Once the archive is written, it fails if the
+// metadata does not match its summary (or has none), or if the archive does
+// not match the digest in the summary. As the archive is already written by
+// then, it is for detecting metadata that is truncated or tampered with,
+// rather than preventing its output. `up` may already be a
+// storage.SummaryUnpacker.
+func WriteVerifiedTarStream(fg storage.FileGetter, up storage.Unpacker, w io.Writer) error {
+	su, ok := up.(*storage.SummaryUnpacker)
+	if !ok {
+		su = storage.NewSummaryUnpacker(up)
+	}
+	h := sha256.New()
+	if err := WriteOutputTarStream(fg, su, io.MultiWriter(w, h)); err != nil {
+		return err
+	}
+	// the SummaryUnpacker has failed, if there is no summary
+	summary := su.Summary()
+	if summary.Digest != "" && summary.Digest != "sha256:"+hex.EncodeToString(h.Sum(nil)) {
+		return storage.ErrSummaryMismatch
+	}
+	return nil
+}
+
+// writeEntryPayload writes the payload of the FileType `entry` to `w`, from `fg`
+// or inline, verifying its checksum. Unlike WriteOutputTarStream, the padding
+// that follows the payload is written too, as zeros, for when the segments
+// holding it are not.
+func writeEntryPayload(w io.Writer, fg storage.FileGetter, entry *storage.Entry) error {
+	if err := writeEntryData(w, fg, entry); err != nil {
+		return err
+	}
+	_, err := w.Write(zeros[:-entry.DataSize()&(blockSize-1)])
+	return err
+}
+
+// writeEntryData is writeEntryPayload without the padding, for when it is
+// written from the segments, as by HeaderReader.Padding
+func writeEntryData(w io.Writer, fg storage.FileGetter, entry *storage.Entry) error {
+	if entry.Size == 0 {
+		return nil
+	}
+	if entry.Special != "" {
+		if err := writeInline(w, entry); err != nil {
+			return err
+		}
+	} else {
+		fh, err := getPayload(fg, entry)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		var rdr io.Reader = fh
+		if entry.Partial {
+			if rdr, err = partReader(fh, entry); err != nil {
+				return err
+			}
+		}
+		verifier, verify, err := newPayloadVerifier(entry)
+		if err != nil {
+			return err
+		}
+		buf := bufpool.Get()
+		defer bufpool.Put(buf)
+		if len(entry.Sparse) > 0 && !entry.SparsePacked {
+			err = copySparseData(w, verifier, rdr, entry.Sparse, *buf)
+		} else {
+			_, err = copyWithBuffer(io.MultiWriter(w, verifier), rdr, *buf)
+		}
+		if err != nil {
+			return err
+		}
+		if err := verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPayload gets the payload of `entry` from `fg`, by the digests of its
+// checksums first, if `fg` is a storage.DigestFileGetter, and then by its name.
+// The payloads of partial and sparse files are only got by name, as their
+// checksums are not of what is stored.
+func getPayload(fg storage.FileGetter, entry *storage.Entry) (io.ReadCloser, error) {
+	if dfg, ok := fg.(storage.DigestFileGetter); ok && !entry.Partial && len(entry.Sparse) == 0 {
+		for _, checksum := range entry.AllChecksums() {
+			i := strings.Index(checksum, ":")
+			if i < 0 {
+				continue
+			}
+			rc, err := dfg.GetByDigest(checksum[:i], checksum[i+1:])
+			if err == nil {
+				return rc, nil
+			}
+			if err != storage.ErrNoSuchFile {
+				return nil, err
+			}
+		}
+	}
+	return fg.Get(entry.GetName())
+}
+
+// copySparseData writes only the data of the sparse file `r` (holes and all)
+// in the extents to `w`, as it is in the archive, and all of `r` to `sums`
+func copySparseData(w, sums io.Writer, r io.Reader, extents []storage.SparseExtent, buf []byte) error {
+	tr := io.TeeReader(r, sums)
+	if _, err := copyWithBuffer(w, storage.NewSparseDataReader(tr, extents), buf); err != nil {
+		return err
+	}
+	// the hole after the last extent
+	_, err := copyWithBuffer(ioutil.Discard, tr, buf)
+	return err
+}
+
+// newPayloadVerifier returns the writer to copy the payload of `entry` to,
+// and the function that checks its crc64, and its checksums if it has any,
+// once it is copied
+func newPayloadVerifier(entry *storage.Entry) (io.Writer, func() error, error) {
+	crcHash := crc64.New(storage.CRCTable)
+	var w io.Writer = crcHash
+	hw, checksumsOK, err := newChecksumsVerifier(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hw != nil {
+		w = io.MultiWriter(crcHash, hw)
+	}
+	return w, func() error {
+		if sum := crcHash.Sum(nil); !bytes.Equal(sum, entry.Payload) {
+			return newCRCError(entry, sum)
+		}
+		if checksumsOK != nil {
+			return checksumsOK()
+		}
+		return nil
+	}, nil
+}
+
+// checkInline checks the inline payload of a special entry, like a GNU
+// dumpdir, as the payloads got from a FileGetter are checked: that it is the
+// size of the entry, and matches its checksums
+func checkInline(entry *storage.Entry) error {
+	if int64(len(entry.Inline)) != entry.Size {
+		return fmt.Errorf("asm: inline payload of %q (entry %d) is %d bytes, rather than its size of %d", entry.GetName(), entry.Position, len(entry.Inline), entry.Size)
+	}
+	verifier, verify, err := newPayloadVerifier(entry)
+	if err != nil {
+		return err
+	}
+	verifier.Write(entry.Inline)
+	return verify()
+}
+
+// writeInline writes the inline payload of a special entry, once it is
+// checked by checkInline
+func writeInline(w io.Writer, entry *storage.Entry) error {
+	if err := checkInline(entry); err != nil {
+		return err
+	}
+	_, err := w.Write(entry.Inline)
+	return err
+}
+
+// newChecksumsVerifier returns the writer to hash the payload of `entry` in
+// the algorithms of its Checksum and Checksums, and the function that checks
+// them once it is written, or a nil writer if it has none
+func newChecksumsVerifier(entry *storage.Entry) (io.Writer, func() error, error) {
+	checksums := entry.AllChecksums()
+	if len(checksums) == 0 {
+		return nil, nil, nil
+	}
+	hashes := make([]hash.Hash, len(checksums))
+	oks := make([]func() bool, len(checksums))
+	writers := make([]io.Writer, len(checksums))
+	for i, checksum := range checksums {
+		h, ok, err := storage.NewChecksumVerifier(checksum)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%q: %v", entry.GetName(), err)
+		}
+		hashes[i], oks[i], writers[i] = h, ok, h
+	}
+	return io.MultiWriter(writers...), func() error {
+		for i, ok := range oks {
+			if !ok() {
+				return newDigestError(entry, checksums[i], hashes[i])
+			}
+		}
+		return nil
+	}, nil
+}
+
+// ChecksumError is a file payload, as read from the FileGetter on assembly,
+// that does not match the checksum recorded for it. It is returned as is
+// through the readers of NewOutputTarStream and the like, so callers can tell
+// which payload is corrupt.
+type ChecksumError struct {
+	// Position of the entry in the metadata
+	Position int
+	// Name of the entry
+	Name string
+	// Expected and Actual are the checksums of the payload, like
+	// "crc64:<hex>" for the crc64 of the Payload, or the entry's Checksum
+	// (like "sha256:<hex>") when only that does not match
+	Expected, Actual string
+}
+
+func (ce *ChecksumError) Error() string {
+	return fmt.Sprintf("file integrity checksum failed for %q (entry %d): %s, rather than %s", ce.Name, ce.Position, ce.Actual, ce.Expected)
+}
+
+// newCRCError is the ChecksumError of a payload of `entry` whose crc64 is
+// `sum`
+func newCRCError(entry *storage.Entry, sum []byte) *ChecksumError {
+	return &ChecksumError{
+		Position: entry.Position,
+		Name:     entry.GetName(),
+		Expected: "crc64:" + hex.EncodeToString(entry.Payload),
+		Actual:   "crc64:" + hex.EncodeToString(sum),
+	}
+}
+
+// newDigestError is the ChecksumError of a payload of `entry` that does not
+// match its `checksum`, as hashed by `h`
+func newDigestError(entry *storage.Entry, checksum string, h hash.Hash) *ChecksumError {
+	algorithm := checksum[:strings.Index(checksum, ":")]
+	return &ChecksumError{
+		Position: entry.Position,
+		Name:     entry.GetName(),
+		Expected: checksum,
+		Actual:   algorithm + ":" + hex.EncodeToString(h.Sum(nil)),
+	}
+}
+
+// partReader returns the part of the file payload `r` for a Partial entry
+func partReader(r io.Reader, entry *storage.Entry) (io.Reader, error) {
+	if s, ok := r.(io.Seeker); ok {
+		if _, err := s.Seek(entry.PartOffset, 0); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.CopyN(ioutil.Discard, r, entry.PartOffset); err != nil {
+		return nil, fmt.Errorf("asm: part of %q at offset %d: %v", entry.GetName(), entry.PartOffset, err)
+	}
+	return io.LimitReader(r, entry.Size), nil
+}
+
+// zeros is read from for the padding of payloads, and the payloads filled in
+// with zeros, and is not to be written to
+var zeros = make([]byte, 32*1024)
+
+// SetCopyBufferSize sets the size of the buffers that file payloads are
+// copied through, on assembly, disassembly and extraction, and by the
+// FilePutters of storage, from the default of 32 KiB. The buffers are pooled,
+// so that layers of many small files do not allocate one for each file.
+// Bigger buffers may be faster for payloads stored on remote or slow storage.
+func SetCopyBufferSize(size int) {
+	bufpool.SetSize(size)
 }
 
 // copyWithBuffer is taken from stdlib io.Copy implementation
@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash/crc64"
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/common"
 	"github.com/vbatts/tar-split/tar/storage"
 )
 
@@ -206,6 +213,63 @@ func TestTarStream(t *testing.T) {
 	}
 }
 
+func TestWriteVerifiedTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta := bytes.NewBuffer(nil)
+	sp := storage.NewSummaryPacker(storage.NewJSONPacker(meta))
+	fgp := storage.NewBufferFileGetPutter()
+	its, err := NewInputTarStream(bytes.NewReader(archive), sp, fgp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(sp, its); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	if err := WriteVerifiedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Error("expected the archive assembled as it was")
+	}
+
+	// a payload that differs, with its checksum to match
+	name, body := testFiles[1].hdr.Name, bytes.ToUpper([]byte(testFiles[1].body))
+	fgp.Put(name, bytes.NewReader(body))
+	tampered := bytes.NewBuffer(nil)
+	tp := storage.NewJSONPacker(tampered)
+	up := storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes()))
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if entry.Type == storage.FileType && entry.GetName() == name {
+			crcHash := crc64.New(storage.CRCTable)
+			crcHash.Write(body)
+			entry.Payload = crcHash.Sum(nil)
+		}
+		tp.AddEntry(*entry)
+	}
+	err = WriteVerifiedTarStream(fgp, storage.NewJSONUnpacker(tampered), ioutil.Discard)
+	if err != storage.ErrSummaryMismatch {
+		t.Errorf("expected ErrSummaryMismatch, got %v", err)
+	}
+
+	plain, plainFgp := disassemble(t, archive)
+	err = WriteVerifiedTarStream(plainFgp, storage.NewJSONUnpacker(bytes.NewReader(plain)), ioutil.Discard)
+	if err != storage.ErrNoSummary {
+		t.Errorf("expected ErrNoSummary, got %v", err)
+	}
+}
+
 func BenchmarkAsm(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		for _, tc := range testCases {
@@ -254,3 +318,726 @@ func BenchmarkAsm(b *testing.B) {
 		}
 	}
 }
+
+func TestTarStreamChecksum(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta := bytes.NewBuffer(nil)
+	fgp := storage.NewBufferFileGetPutter()
+	cp, err := storage.NewChecksumPacker(storage.NewJSONPacker(meta), fgp, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	its, err := NewInputTarStream(bytes.NewReader(archive), cp, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Error("expected the archive assembled as it was")
+	}
+
+	// a crc64 that matches is not enough, with a checksum that does not
+	name := testFiles[1].hdr.Name
+	tampered := bytes.NewBuffer(nil)
+	tp := storage.NewJSONPacker(tampered)
+	up := storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes()))
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if entry.Type == storage.FileType && entry.GetName() == name {
+			if entry.Checksum == "" {
+				t.Fatalf("expected a checksum for %s", name)
+			}
+			entry.Checksum = "sha256:" + strings.Repeat("0", 64)
+		}
+		tp.AddEntry(*entry)
+	}
+	err = WriteOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(tampered.Bytes())), ioutil.Discard)
+	sum := sha256.Sum256([]byte(testFiles[1].body))
+	if ce, ok := err.(*ChecksumError); !ok {
+		t.Errorf("expected the checksum to fail with a *ChecksumError, got %v", err)
+	} else if ce.Name != name || ce.Expected != "sha256:"+strings.Repeat("0", 64) || ce.Actual != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected %#v", ce)
+	}
+	dir, err := ioutil.TempDir("", "checksum-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ExtractTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(tampered.Bytes())), dir); err == nil {
+		t.Error("expected the checksum to fail on extraction")
+	}
+}
+
+func TestTarStreamByDigest(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	root, err := ioutil.TempDir("", "digest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	meta := bytes.NewBuffer(nil)
+	cp, err := storage.NewChecksumPacker(storage.NewJSONPacker(meta), storage.NewCASFilePutter(root), "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	its, err := NewInputTarStream(bytes.NewReader(archive), cp, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err != nil {
+		t.Fatal(err)
+	}
+
+	// with no manifest, the store has the payloads by digest only, behind a
+	// getter by name that has none
+	byName := storage.NewBufferFileGetPutter()
+	mfg := storage.NewMultiFileGetter(byName, storage.NewCASFileGetter(root, nil))
+	out := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(mfg, storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Error("expected the archive assembled as it was")
+	}
+	if stats := mfg.Stats(); stats[0] != (storage.GetterStats{}) || stats[1].Hits == 0 || stats[1].Misses != 0 {
+		t.Errorf("expected the payloads got by digest only, got %+v", stats)
+	}
+
+	// without checksums, they are got by name
+	meta.Reset()
+	its, err = NewInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(meta), byName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := WriteOutputTarStream(mfg, storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Error("expected the archive assembled as it was, by name")
+	}
+}
+
+func TestTarStreamCorruptPayload(t *testing.T) {
+	meta, fgp := disassemble(t, buildTar(t, testFiles))
+	name := testFiles[1].hdr.Name
+	if _, _, err := fgp.Put(name, strings.NewReader("imma derp til I hurr")); err != nil {
+		t.Fatal(err)
+	}
+	up := storage.NewJSONUnpacker(bytes.NewReader(meta))
+	var position int
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.GetName() == name {
+			position = entry.Position
+			break
+		}
+	}
+
+	ots := NewOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)))
+	defer ots.Close()
+	_, err := io.Copy(ioutil.Discard, ots)
+	ce, ok := err.(*ChecksumError)
+	if !ok {
+		t.Fatalf("expected a *ChecksumError through the stream, got %v", err)
+	}
+	if ce.Name != name || ce.Position != position {
+		t.Errorf("expected entry %d (%q), got %d (%q)", position, name, ce.Position, ce.Name)
+	}
+	if !strings.HasPrefix(ce.Expected, "crc64:") || !strings.HasPrefix(ce.Actual, "crc64:") || ce.Expected == ce.Actual {
+		t.Errorf("unexpected checksums %q and %q", ce.Expected, ce.Actual)
+	}
+}
+
+func TestTarStreamChecksums(t *testing.T) {
+	meta, fgp := disassemble(t, buildTar(t, testFiles))
+	name := testFiles[1].hdr.Name
+	sum := sha256.Sum256([]byte(testFiles[1].body))
+	wrong := "sha512:" + strings.Repeat("00", 64)
+
+	// record a matching sha256 and a mismatching sha512 for the payload
+	up := storage.NewJSONUnpacker(bytes.NewReader(meta))
+	buf := bytes.NewBuffer(nil)
+	p := storage.NewJSONPacker(buf)
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if entry.GetName() == name {
+			entry.Checksums = []string{"sha256:" + hex.EncodeToString(sum[:]), wrong}
+		}
+		if _, err := p.AddEntry(*entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ots := NewOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(buf.Bytes())))
+	defer ots.Close()
+	_, err := io.Copy(ioutil.Discard, ots)
+	ce, ok := err.(*ChecksumError)
+	if !ok {
+		t.Fatalf("expected a *ChecksumError through the stream, got %v", err)
+	}
+	if ce.Name != name || ce.Expected != wrong || !strings.HasPrefix(ce.Actual, "sha512:") {
+		t.Errorf("expected the sha512 of %q to fail, got %v", name, ce)
+	}
+}
+
+func TestWriteCompressedTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+	buf := bytes.NewBuffer(nil)
+	opts := common.CompressOptions{Level: gzip.BestCompression, Jobs: 2}
+	if err := WriteCompressedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), buf, common.Gzip, opts); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, archive) {
+		t.Errorf("expected the archive back from the gzip, got %d bytes rather than %d", len(got), len(archive))
+	}
+
+	if err := WriteCompressedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), buf, "lz4", opts); err != common.ErrNoCompressor {
+		t.Errorf("expected common.ErrNoCompressor, got %v", err)
+	}
+}
+
+func TestOutputTarStreamWriteTo(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+	newStream := func() io.ReadCloser {
+		return NewOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)))
+	}
+
+	// written directly, rather than through the pipe
+	ots := newStream()
+	wt, ok := ots.(io.WriterTo)
+	if !ok {
+		t.Fatal("expected the stream to be an io.WriterTo")
+	}
+	buf := bytes.NewBuffer(nil)
+	n, err := wt.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(archive)) || !bytes.Equal(buf.Bytes(), archive) {
+		t.Errorf("expected the %d bytes of the archive, got %d", len(archive), n)
+	}
+	if n, err := ots.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("expected io.EOF once written, got %d, %v", n, err)
+	}
+	ots.Close()
+
+	// the rest of it, after some is read
+	ots = newStream()
+	head := make([]byte, 100)
+	if _, err := io.ReadFull(ots, head); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if _, err := io.Copy(buf, ots); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(head, buf.Bytes()...), archive) {
+		t.Error("expected the rest of the archive to be written after what was read")
+	}
+	ots.Close()
+
+	// closed before it is read
+	ots = newStream()
+	ots.Close()
+	if _, err := ots.Read(make([]byte, 1)); err != io.ErrClosedPipe {
+		t.Errorf("expected io.ErrClosedPipe once closed, got %v", err)
+	}
+
+	// a failed write is not taken for the end of the archive after it
+	ots = NewOutputTarStream(storage.NewBufferFileGetPutter(), storage.NewJSONUnpacker(bytes.NewReader(meta)))
+	_, err = ots.(io.WriterTo).WriteTo(ioutil.Discard)
+	if err == nil {
+		t.Fatal("expected the payloads to be missing")
+	}
+	if _, rerr := ots.Read(make([]byte, 1)); rerr != err {
+		t.Errorf("expected Read to fail with %v after WriteTo, got %v", err, rerr)
+	}
+	if _, werr := ots.(io.WriterTo).WriteTo(ioutil.Discard); werr != err {
+		t.Errorf("expected WriteTo to fail with %v again, got %v", err, werr)
+	}
+}
+
+func TestTarStreamSparse(t *testing.T) {
+	archive, err := ioutil.ReadFile("../../archive/tar/testdata/sparse-formats.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, holeless := range []bool{false, true} {
+		meta := bytes.NewBuffer(nil)
+		fgp := storage.NewBufferFileGetPutter()
+		var fp storage.FilePutter = fgp
+		if holeless {
+			fp = storage.NewHolelessFilePutter(fgp)
+		}
+		its, err := NewInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(meta), fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, its); err != nil {
+			t.Fatal(err)
+		}
+
+		var sparse int
+		up := storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes()))
+		for {
+			entry, err := up.Next()
+			if err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			if len(entry.Sparse) > 0 {
+				sparse++
+				if entry.SparsePacked != holeless {
+					t.Errorf("%s: expected SparsePacked %v", entry.GetName(), holeless)
+				}
+				if stored := readAllPayload(t, fgp, entry.GetName()); int64(len(stored)) != map[bool]int64{false: entry.Size, true: entry.DataSize()}[holeless] {
+					t.Errorf("%s: unexpected %d bytes stored", entry.GetName(), len(stored))
+				}
+			}
+		}
+		if sparse != 4 {
+			t.Errorf("expected 4 sparse files, got %d", sparse)
+		}
+
+		out := bytes.NewBuffer(nil)
+		if err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), archive) {
+			t.Errorf("holeless %v: expected the archive assembled as it was", holeless)
+		}
+
+		dir, err := ioutil.TempDir("", "sparse-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ExtractTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), dir); err != nil {
+			t.Fatal(err)
+		}
+		extracted, err := ioutil.ReadFile(dir + "/sparse-posix-1.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(extracted) != 200 || !bytes.HasPrefix(extracted, []byte("\x00G\x00o\x00G")) {
+			t.Errorf("holeless %v: expected the sparse file extracted with its holes, got %q", holeless, extracted)
+		}
+	}
+}
+
+func readAllPayload(t *testing.T, fg storage.FileGetter, name string) []byte {
+	rc, err := fg.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// bigRealSize is the size of the sparse files of bigSparseTar, beyond the
+// 8GiB of an octal size field
+const bigRealSize = 8<<30 + blockSize
+
+// bigSparseTar returns an archive of two sparse files of bigRealSize, with
+// "hello" at their start and "world" at their end: one in the PAX 1.0 format,
+// with a realsize record, and one in the old GNU format, with the realsize
+// and the offset of its last extent in base-256
+func bigSparseTar(t testing.TB) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	tw.OmitTrailer = true
+	tw.PAXRecords = func(hdr *tar.Header, records []tar.PAXRecord) []tar.PAXRecord {
+		return append(records,
+			tar.PAXRecord{Keyword: "GNU.sparse.major", Value: "1"},
+			tar.PAXRecord{Keyword: "GNU.sparse.minor", Value: "0"},
+			tar.PAXRecord{Keyword: "GNU.sparse.name", Value: "big-pax.db"},
+			tar.PAXRecord{Keyword: "GNU.sparse.realsize", Value: fmt.Sprint(int64(bigRealSize))},
+		)
+	}
+	sparseMap := fmt.Sprintf("2\n0\n5\n%d\n5\n", int64(bigRealSize-5))
+	sparseMap += strings.Repeat("\x00", blockSize-len(sparseMap))
+	data := sparseMap + "helloworld"
+	if err := tw.WriteHeader(&tar.Header{Name: "GNUSparseFile.0/big-pax.db", Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg, ModTime: time.Unix(1500000000, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(tw, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	block := make([]byte, blockSize)
+	copy(block, "big-gnu.db")
+	copy(block[100:], "0000644\x00")
+	copy(block[108:], "0000000\x00")
+	copy(block[116:], "0000000\x00")
+	formatNumeric(block[124:136], 10)
+	formatNumeric(block[136:148], 1500000000)
+	block[156] = 'S'
+	copy(block[257:], "ustar  \x00")
+	formatNumeric(block[386:398], 0)
+	formatNumeric(block[398:410], 5)
+	formatNumeric(block[410:422], bigRealSize-5)
+	formatNumeric(block[422:434], 5)
+	formatNumeric(block[483:495], bigRealSize)
+	if block[410]&0x80 == 0 || block[483]&0x80 == 0 {
+		t.Fatal("expected the offset and realsize in base-256")
+	}
+	setChecksum(block)
+	buf.Write(block)
+	buf.WriteString("helloworld")
+	buf.Write(make([]byte, blockSize-10+2*blockSize))
+	return buf.Bytes()
+}
+
+func TestTarStreamBigSparse(t *testing.T) {
+	archive := bigSparseTar(t)
+	meta := bytes.NewBuffer(nil)
+	fgp := storage.NewBufferFileGetPutter()
+	its, err := NewInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(meta), storage.NewHolelessFilePutter(fgp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err != nil {
+		t.Fatal(err)
+	}
+
+	hr := NewHeaderReader(storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())))
+	var names []string
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Size != bigRealSize || entry.Size != bigRealSize {
+			t.Errorf("%s: expected the size %d, got %d in the header and %d in the entry", hdr.Name, int64(bigRealSize), hdr.Size, entry.Size)
+		}
+		if entry.DataSize() != 10 || !entry.SparsePacked {
+			t.Errorf("%s: expected only the 10 bytes of data stored, got %d", hdr.Name, entry.DataSize())
+		}
+		if stored := readAllPayload(t, fgp, hdr.Name); string(stored) != "helloworld" {
+			t.Errorf("%s: unexpected payload %q", hdr.Name, stored)
+		}
+	}
+	if strings.Join(names, ",") != "big-pax.db,big-gnu.db" {
+		t.Errorf("unexpected entries %q", names)
+	}
+
+	out := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Error("expected the archive assembled as it was")
+	}
+}
+
+// zeroReader reads endless zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// zeroFileGetter gets a payload of zeros of each size
+type zeroFileGetter map[string]int64
+
+func (zfg zeroFileGetter) Get(name string) (io.ReadCloser, error) {
+	size, ok := zfg[name]
+	if !ok {
+		return nil, storage.ErrNoSuchFile
+	}
+	return ioutil.NopCloser(io.LimitReader(zeroReader{}, size)), nil
+}
+
+// compareWriter fails a Write that differs from what is read of `r`
+type compareWriter struct {
+	r   io.Reader
+	buf []byte
+	n   int64
+}
+
+func (cw *compareWriter) Write(p []byte) (int, error) {
+	if len(cw.buf) < len(p) {
+		cw.buf = make([]byte, len(p))
+	}
+	if _, err := io.ReadFull(cw.r, cw.buf[:len(p)]); err != nil || !bytes.Equal(p, cw.buf[:len(p)]) {
+		return 0, fmt.Errorf("differs from the archive after offset %d", cw.n)
+	}
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// TestTarStreamBig streams 16GiB of payloads, which takes long enough (and
+// far longer with -race) to only be run with TAR_SPLIT_TEST_BIG=1 set
+func TestTarStreamBig(t *testing.T) {
+	if testing.Short() || os.Getenv("TAR_SPLIT_TEST_BIG") != "1" {
+		t.Skip("streams 16GiB of payloads, set TAR_SPLIT_TEST_BIG=1 to run it")
+	}
+	const size = 8<<30 + 1
+	// a file whose size is in base-256, and one whose size is a PAX record
+	headers := bytes.NewBuffer(nil)
+	for _, pax := range []bool{false, true} {
+		tw := tar.NewWriter(headers)
+		tw.PAXNumbers = pax
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("big-%v.db", pax), Mode: 0644, Size: size, Typeflag: tar.TypeReg, ModTime: time.Unix(1500000000, 0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw := headers.Bytes()
+	pax := bytes.Index(raw, []byte("PaxHeaders"))
+	if pax < 0 || raw[124]&0x80 == 0 {
+		t.Fatal("expected a header with a base-256 size, and one with a PAX size record")
+	}
+	pax -= pax % blockSize
+	pad := make([]byte, -size&(blockSize-1))
+	archive := func() io.Reader {
+		return io.MultiReader(
+			bytes.NewReader(raw[:pax]), io.LimitReader(zeroReader{}, size), bytes.NewReader(pad),
+			bytes.NewReader(raw[pax:]), io.LimitReader(zeroReader{}, size), bytes.NewReader(pad),
+			bytes.NewReader(make([]byte, 2*blockSize)),
+		)
+	}
+
+	meta := bytes.NewBuffer(nil)
+	its, err := NewInputTarStream(archive(), storage.NewJSONPacker(meta), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err != nil {
+		t.Fatal(err)
+	}
+	fg := zeroFileGetter{}
+	up := storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes()))
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if entry.Type == storage.FileType {
+			if entry.Size != size {
+				t.Errorf("%s: expected the size %d, got %d", entry.GetName(), int64(size), entry.Size)
+			}
+			fg[entry.GetName()] = entry.Size
+		}
+	}
+	if len(fg) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(fg))
+	}
+
+	cw := &compareWriter{r: archive()}
+	if err := WriteOutputTarStream(fg, storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), cw); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := io.Copy(ioutil.Discard, cw.r); n != 0 {
+		t.Errorf("expected the whole archive assembled, %d bytes are missing", n)
+	}
+}
+
+func TestInputTarStreamCallback(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	expected, _ := disassemble(t, archive)
+
+	var (
+		modes  []int64
+		bodies []string
+	)
+	fn := func(hdr *tar.Header, entry storage.Entry, payload io.Reader) error {
+		if entry.GetName() != hdr.Name || entry.Size != hdr.Size {
+			t.Errorf("expected the entry of %q, got %+v", hdr.Name, entry)
+		}
+		modes = append(modes, hdr.Mode)
+		// only part of a payload may be read
+		p := make([]byte, 11)
+		n, _ := io.ReadFull(payload, p)
+		bodies = append(bodies, string(p[:n]))
+		return nil
+	}
+	meta := bytes.NewBuffer(nil)
+	fgp := storage.NewBufferFileGetPutter()
+	its, err := NewInputTarStreamCallback(bytes.NewReader(archive), storage.NewJSONPacker(meta), fgp, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(meta.Bytes(), expected) {
+		t.Error("expected the same metadata as NewInputTarStream")
+	}
+	if len(modes) != len(testFiles) {
+		t.Fatalf("expected a call for each of %d files, got %d", len(testFiles), len(modes))
+	}
+	for i, f := range testFiles {
+		body := f.body
+		if len(body) > 11 {
+			body = body[:11]
+		}
+		if modes[i] != f.hdr.Mode || bodies[i] != body {
+			t.Errorf("%q: expected mode %o and %q, got %o and %q", f.hdr.Name, f.hdr.Mode, body, modes[i], bodies[i])
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(meta), buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), archive) {
+		t.Error("expected the whole payloads stored")
+	}
+
+	errStop := errors.New("stop")
+	its, err = NewInputTarStreamCallback(bytes.NewReader(archive), storage.NewJSONPacker(ioutil.Discard), nil, func(hdr *tar.Header, entry storage.Entry, payload io.Reader) error {
+		if hdr.Name == "dir/hurr.txt" {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err != errStop {
+		t.Errorf("expected the error of the callback, got %v", err)
+	}
+}
+
+func TestStrictInputTarStream(t *testing.T) {
+	archive := buildTar(t, []testFile{
+		{hdr: tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644}, body: strings.Repeat("a", 700)},
+		{hdr: tar.Header{Name: "b", Typeflag: tar.TypeReg, Mode: 0644}, body: strings.Repeat("b", 700)},
+	})
+	for _, tc := range []struct {
+		cut  int
+		last string
+	}{
+		{0, ""},
+		{100, ""},
+		{800, ""},
+		{3 * blockSize, "a"},
+		{3*blockSize + 700, "a"},
+		{6 * blockSize, "b"},
+		{7 * blockSize, "b"},
+		{len(archive) - 1, "b"},
+	} {
+		rdr, err := NewStrictInputTarStream(bytes.NewReader(archive[:tc.cut]), storage.NewJSONPacker(ioutil.Discard), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(ioutil.Discard, rdr)
+		te, ok := err.(*TruncatedArchiveError)
+		if !ok {
+			t.Errorf("cut at %d: expected a *TruncatedArchiveError, got %v", tc.cut, err)
+			continue
+		}
+		if te.LastEntry != tc.last || te.Offset != int64(tc.cut) {
+			t.Errorf("cut at %d: expected truncation after %q at %d, got %v", tc.cut, tc.last, tc.cut, te)
+		}
+	}
+
+	rdr, err := NewStrictInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(ioutil.Discard), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+		t.Errorf("expected the whole archive disassembled, got %v", err)
+	}
+}
+
+// smallFilesTar is an archive of many small files, like the layers that
+// assembly and disassembly are to not allocate for each file of
+func smallFilesTar(b *testing.B) []byte {
+	files := make([]testFile, 10000)
+	for i := range files {
+		files[i] = testFile{hdr: tar.Header{Name: fmt.Sprintf("dir/file%d", i), Typeflag: tar.TypeReg, Mode: 0644}, body: "a small file"}
+	}
+	return buildTar(b, files)
+}
+
+func BenchmarkDisassembleSmallFiles(b *testing.B) {
+	archive := smallFilesTar(b)
+	b.SetBytes(int64(len(archive)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rdr, err := NewInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(ioutil.Discard), nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAssembleSmallFiles(b *testing.B) {
+	archive := smallFilesTar(b)
+	meta := bytes.NewBuffer(nil)
+	fgp := storage.NewBufferFileGetPutter()
+	rdr, err := NewInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(meta), fgp)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(archive)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := io.Copy(ioutil.Discard, NewOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package asm
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// BatchJob is an archive for a Batch to disassemble
+type BatchJob struct {
+	// Name identifies the archive in the progress and results, like the path
+	// of a layer. Its payloads are stored as Name + "/" + the entry's name.
+	Name string
+	// Open returns the archive, and the Packer for its metadata. The Closer is
+	// called once the archive is disassembled (or failed), to release both.
+	Open func() (io.Reader, storage.Packer, io.Closer, error)
+}
+
+// BatchResult is the outcome of one BatchJob
+type BatchResult struct {
+	Name string
+	// Bytes of the archive that were read
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+}
+
+// BatchProgress is passed to Batch.Progress as each job is done
+type BatchProgress struct {
+	// Done is the number of jobs done so far, of the Total
+	Done, Total int
+	// Bytes read of all the jobs done so far
+	Bytes  int64
+	Result BatchResult
+}
+
+// BatchReport summarizes a Batch run
+type BatchReport struct {
+	// Results of each job, in the order the jobs were given
+	Results  []BatchResult
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Failed returns the results of the jobs that failed
+func (r BatchReport) Failed() []BatchResult {
+	var failed []BatchResult
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Batch disassembles many archives in parallel, like the layers of an image,
+// storing their payloads to a shared store. A job that fails does not stop the
+// others; each job's error is kept in its result.
+type Batch struct {
+	// Jobs is how many archives are disassembled at once (at least 1)
+	Jobs int
+	// FilePutter is the store shared by all of the jobs, so it must be safe
+	// for concurrent use (see storage.NewPathFilePutter). Payloads are
+	// discarded if it is nil.
+	FilePutter storage.FilePutter
+	// Progress, if set, is called as each job is done. Calls are not
+	// concurrent.
+	Progress func(BatchProgress)
+}
+
+// Run disassembles the archives of the jobs, and returns once all are done
+func (b *Batch) Run(jobs []BatchJob) BatchReport {
+	start := time.Now()
+	report := BatchReport{Results: make([]BatchResult, len(jobs))}
+	workers := b.Jobs
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		progress = BatchProgress{Total: len(jobs)}
+		queue    = make(chan int)
+	)
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				res := b.run(jobs[i])
+				mu.Lock()
+				report.Results[i] = res
+				progress.Done++
+				progress.Bytes += res.Bytes
+				progress.Result = res
+				if b.Progress != nil {
+					b.Progress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	report.Bytes = progress.Bytes
+	report.Duration = time.Since(start)
+	return report
+}
+
+func (b *Batch) run(job BatchJob) BatchResult {
+	start := time.Now()
+	res := BatchResult{Name: job.Name}
+	r, p, c, err := job.Open()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	var fp storage.FilePutter
+	if b.FilePutter != nil {
+		fp = prefixFilePutter{fp: b.FilePutter, prefix: job.Name + "/"}
+	}
+	its, err := NewInputTarStream(r, p, fp)
+	if err == nil {
+		res.Bytes, err = io.Copy(ioutil.Discard, its)
+	}
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	res.Err = err
+	res.Duration = time.Since(start)
+	return res
+}
+
+// prefixFilePutter stores each payload with a prefix on its name
+type prefixFilePutter struct {
+	fp     storage.FilePutter
+	prefix string
+}
+
+func (pfp prefixFilePutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	return pfp.fp.Put(pfp.prefix+name, r)
+}
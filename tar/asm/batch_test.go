@@ -0,0 +1,97 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// lockedFileGetPutter makes a FileGetPutter safe for concurrent use
+type lockedFileGetPutter struct {
+	mu  sync.Mutex
+	fgp storage.FileGetPutter
+}
+
+func (l *lockedFileGetPutter) Get(name string) (io.ReadCloser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fgp.Get(name)
+}
+
+func (l *lockedFileGetPutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fgp.Put(name, r)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func TestBatch(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	errBroken := errors.New("broken blob")
+	store := &lockedFileGetPutter{fgp: storage.NewBufferFileGetPutter()}
+	metas := make([]*bytes.Buffer, 5)
+	var jobs []BatchJob
+	for i := range metas {
+		i := i
+		metas[i] = bytes.NewBuffer(nil)
+		jobs = append(jobs, BatchJob{
+			Name: fmt.Sprintf("layer%d", i),
+			Open: func() (io.Reader, storage.Packer, io.Closer, error) {
+				if i == 3 {
+					return nil, nil, nil, errBroken
+				}
+				return bytes.NewReader(archive), storage.NewJSONPacker(metas[i]), nopCloser{}, nil
+			},
+		})
+	}
+
+	var calls int
+	b := Batch{Jobs: 3, FilePutter: store, Progress: func(p BatchProgress) {
+		calls++
+		if p.Total != len(jobs) || p.Done != calls {
+			t.Errorf("unexpected progress %+v", p)
+		}
+	}}
+	report := b.Run(jobs)
+	if calls != len(jobs) {
+		t.Errorf("expected progress for each of the %d jobs, got %d", len(jobs), calls)
+	}
+	if failed := report.Failed(); len(failed) != 1 || failed[0].Name != "layer3" || failed[0].Err != errBroken {
+		t.Errorf("expected only layer3 to fail, got %v", failed)
+	}
+	if report.Bytes != 4*int64(len(archive)) {
+		t.Errorf("expected %d bytes read, got %d", 4*len(archive), report.Bytes)
+	}
+
+	// each layer assembles from its part of the shared store
+	for i, meta := range metas {
+		if i == 3 {
+			continue
+		}
+		fg := prefixFileGetter{fg: store, prefix: jobs[i].Name + "/"}
+		buf := bytes.NewBuffer(nil)
+		if err := WriteOutputTarStream(fg, storage.NewJSONUnpacker(meta), buf); err != nil {
+			t.Fatalf("%s: %v", jobs[i].Name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), archive) {
+			t.Errorf("%s: expected the reassembled archive to match", jobs[i].Name)
+		}
+	}
+}
+
+type prefixFileGetter struct {
+	fg     storage.FileGetter
+	prefix string
+}
+
+func (pfg prefixFileGetter) Get(name string) (io.ReadCloser, error) {
+	return pfg.fg.Get(pfg.prefix + name)
+}
@@ -0,0 +1,274 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// CompareReport is the result of Compare
+type CompareReport struct {
+	// FirstDiff is the offset of the first byte at which the archives
+	// differ, or -1 if they are identical
+	FirstDiff int64
+	// SizeA and SizeB are the sizes of the archives
+	SizeA, SizeB int64
+	// Diffs are the entries that differ, in the order of archive `a`, then
+	// those only in archive `b`
+	Diffs []EntryDiff
+}
+
+// Identical is whether the archives are byte for byte the same
+func (r CompareReport) Identical() bool {
+	return r.FirstDiff < 0
+}
+
+// EntryDiff is an entry that differs between two archives. Entries are
+// matched by name.
+type EntryDiff struct {
+	Name string
+	// Kind is Added or Deleted for an entry only in archive `b` or `a`, and
+	// otherwise Modified
+	Kind ChangeKind
+	// Fields are the names of the tar.Header fields that differ
+	Fields []string
+	// Content is whether the payloads differ
+	Content bool
+	// Header is whether the raw bytes of the headers differ, e.g. in the order
+	// of PAX records, even where Fields are the same. It is only reported by
+	// Diff.
+	Header bool
+	// Moved is whether the entry is in a different position, relative to
+	// the entries that are in both archives
+	Moved bool
+	// IndexA and IndexB are the positions of the entry in each archive, or
+	// -1 if it is not in that archive
+	IndexA, IndexB int
+}
+
+// Compare reads the tar archives `a` and `b` side by side, and reports the
+// first byte at which they differ, and how their entries differ in header
+// fields, content and ordering. It is for debugging why two builds of the
+// "same" archive are not identical.
+func Compare(a, b io.Reader) (CompareReport, error) {
+	report := CompareReport{FirstDiff: -1}
+	prA, pwA := io.Pipe()
+	prB, pwB := io.Pipe()
+	doneA := make(chan compareResult, 1)
+	doneB := make(chan compareResult, 1)
+	go summarizeEntries(prA, doneA)
+	go summarizeEntries(prB, doneB)
+
+	err := compareBytes(a, b, pwA, pwB, &report)
+	pwA.CloseWithError(err)
+	pwB.CloseWithError(err)
+	resA, resB := <-doneA, <-doneB
+	if err != nil {
+		return report, err
+	}
+	if resA.err != nil {
+		return report, fmt.Errorf("asm: archive a: %v", resA.err)
+	}
+	if resB.err != nil {
+		return report, fmt.Errorf("asm: archive b: %v", resB.err)
+	}
+	report.Diffs = diffEntries(resA.entries, resB.entries)
+	return report, nil
+}
+
+// compareBytes reads both archives in step, finding the first differing
+// byte, and passes them along to be parsed.
+func compareBytes(a, b io.Reader, wa, wb io.Writer, report *CompareReport) error {
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	var eofA, eofB bool
+	for !eofA || !eofB {
+		var na, nb int
+		var err error
+		if !eofA {
+			na, err = io.ReadFull(a, bufA)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eofA = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if !eofB {
+			nb, err = io.ReadFull(b, bufB)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eofB = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if report.FirstDiff < 0 {
+			if i := firstDiff(bufA[:na], bufB[:nb]); i >= 0 {
+				report.FirstDiff = report.SizeA + int64(i)
+			}
+		}
+		report.SizeA += int64(na)
+		report.SizeB += int64(nb)
+		if _, err := wa.Write(bufA[:na]); err != nil {
+			return err
+		}
+		if _, err := wb.Write(bufB[:nb]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstDiff returns the index of the first differing byte, or -1 if `a` and
+// `b` are equal
+func firstDiff(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return len(a)
+		}
+		return len(b)
+	}
+	return -1
+}
+
+type entrySummary struct {
+	hdr *tar.Header
+	crc []byte
+	// checksum and raw are known for the entries of metadata, and then
+	// compared too
+	checksum string
+	raw      []byte
+}
+
+// sameContent is whether the summaries are of the same payload
+func sameContent(a, b entrySummary) bool {
+	if a.checksum != "" && b.checksum != "" && a.checksum != b.checksum {
+		return false
+	}
+	return bytes.Equal(a.crc, b.crc)
+}
+
+type compareResult struct {
+	entries []entrySummary
+	err     error
+}
+
+func summarizeEntries(r io.Reader, done chan<- compareResult) {
+	var res compareResult
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err != io.EOF {
+				res.err = err
+			}
+			break
+		}
+		crcHash := crc64.New(storage.CRCTable)
+		if _, err := io.Copy(crcHash, tr); err != nil {
+			res.err = err
+			break
+		}
+		res.entries = append(res.entries, entrySummary{hdr: hdr, crc: crcHash.Sum(nil)})
+	}
+	// the rest of the stream is still compared
+	io.Copy(ioutil.Discard, r)
+	done <- res
+}
+
+func diffEntries(a, b []entrySummary) []EntryDiff {
+	// names may repeat within an archive, so the nth of each are matched
+	key := func(entries []entrySummary) ([]string, map[string]int) {
+		seen := map[string]int{}
+		keys := make([]string, len(entries))
+		index := map[string]int{}
+		for i, e := range entries {
+			keys[i] = fmt.Sprintf("%s\x00%d", e.hdr.Name, seen[e.hdr.Name])
+			seen[e.hdr.Name]++
+			index[keys[i]] = i
+		}
+		return keys, index
+	}
+	keysA, indexA := key(a)
+	keysB, indexB := key(b)
+
+	// the rank of each entry among those in both archives
+	rank := func(keys []string, other map[string]int) map[string]int {
+		ranks := map[string]int{}
+		for _, k := range keys {
+			if _, ok := other[k]; ok {
+				ranks[k] = len(ranks)
+			}
+		}
+		return ranks
+	}
+	rankA, rankB := rank(keysA, indexB), rank(keysB, indexA)
+
+	var diffs []EntryDiff
+	for i, k := range keysA {
+		j, ok := indexB[k]
+		if !ok {
+			diffs = append(diffs, EntryDiff{Name: a[i].hdr.Name, Kind: Deleted, IndexA: i, IndexB: -1})
+			continue
+		}
+		d := EntryDiff{
+			Name:    a[i].hdr.Name,
+			Kind:    Modified,
+			Fields:  diffHeaders(a[i].hdr, b[j].hdr),
+			Content: !sameContent(a[i], b[j]),
+			Header:  a[i].raw != nil && b[j].raw != nil && !bytes.Equal(a[i].raw, b[j].raw),
+			Moved:   rankA[k] != rankB[k],
+			IndexA:  i,
+			IndexB:  j,
+		}
+		if len(d.Fields) > 0 || d.Content || d.Header || d.Moved {
+			diffs = append(diffs, d)
+		}
+	}
+	for j, k := range keysB {
+		if _, ok := indexA[k]; !ok {
+			diffs = append(diffs, EntryDiff{Name: b[j].hdr.Name, Kind: Added, IndexA: -1, IndexB: j})
+		}
+	}
+	return diffs
+}
+
+// diffHeaders returns the names of the fields that differ between the headers
+func diffHeaders(a, b *tar.Header) []string {
+	var fields []string
+	check := func(name string, same bool) {
+		if !same {
+			fields = append(fields, name)
+		}
+	}
+	check("Mode", a.Mode == b.Mode)
+	check("Uid", a.Uid == b.Uid)
+	check("Gid", a.Gid == b.Gid)
+	check("Size", a.Size == b.Size)
+	check("ModTime", a.ModTime.Equal(b.ModTime))
+	check("Typeflag", a.Typeflag == b.Typeflag)
+	check("Linkname", a.Linkname == b.Linkname)
+	check("Uname", a.Uname == b.Uname)
+	check("Gname", a.Gname == b.Gname)
+	check("Devmajor", a.Devmajor == b.Devmajor)
+	check("Devminor", a.Devminor == b.Devminor)
+	check("AccessTime", a.AccessTime.Equal(b.AccessTime))
+	check("ChangeTime", a.ChangeTime.Equal(b.ChangeTime))
+	sameXattrs := len(a.Xattrs) == len(b.Xattrs)
+	for k, v := range a.Xattrs {
+		if bv, ok := b.Xattrs[k]; !ok || bv != v {
+			sameXattrs = false
+		}
+	}
+	check("Xattrs", sameXattrs)
+	return fields
+}
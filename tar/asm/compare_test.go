@@ -0,0 +1,78 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+)
+
+func TestCompareIdentical(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	report, err := Compare(bytes.NewReader(archive), bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Identical() || len(report.Diffs) != 0 {
+		t.Errorf("expected identical archives, got %+v", report)
+	}
+	if report.SizeA != int64(len(archive)) || report.SizeB != int64(len(archive)) {
+		t.Errorf("expected sizes of %d, got %d and %d", len(archive), report.SizeA, report.SizeB)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a := []testFile{
+		{hdr: tar.Header{Name: "one", Typeflag: tar.TypeReg, Mode: 0644}, body: "one"},
+		{hdr: tar.Header{Name: "two", Typeflag: tar.TypeReg, Mode: 0644}, body: "two"},
+		{hdr: tar.Header{Name: "three", Typeflag: tar.TypeReg, Mode: 0644}, body: "three"},
+		{hdr: tar.Header{Name: "gone", Typeflag: tar.TypeReg, Mode: 0644}, body: "gone"},
+	}
+	b := []testFile{
+		{hdr: tar.Header{Name: "one", Typeflag: tar.TypeReg, Mode: 0644}, body: "one"},
+		{hdr: tar.Header{Name: "three", Typeflag: tar.TypeReg, Mode: 0600, Uid: 1000}, body: "three"},
+		{hdr: tar.Header{Name: "two", Typeflag: tar.TypeReg, Mode: 0644}, body: "TWO"},
+		{hdr: tar.Header{Name: "new", Typeflag: tar.TypeReg, Mode: 0644}, body: "new"},
+	}
+	archiveA, archiveB := buildTar(t, a), buildTar(t, b)
+	report, err := Compare(bytes.NewReader(archiveA), bytes.NewReader(archiveB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// past the first entry, "two" and "three" differ from their second letter
+	if report.FirstDiff != blockSize*2+1 {
+		t.Errorf("expected the first difference at %d, got %d", blockSize*2+1, report.FirstDiff)
+	}
+
+	expected := []EntryDiff{
+		{Name: "two", Kind: Modified, Content: true, Moved: true, IndexA: 1, IndexB: 2},
+		{Name: "three", Kind: Modified, Fields: []string{"Mode", "Uid"}, Moved: true, IndexA: 2, IndexB: 1},
+		{Name: "gone", Kind: Deleted, IndexA: 3, IndexB: -1},
+		{Name: "new", Kind: Added, IndexA: -1, IndexB: 3},
+	}
+	if len(report.Diffs) != len(expected) {
+		t.Fatalf("expected %d diffs, got %+v", len(expected), report.Diffs)
+	}
+	for i, d := range report.Diffs {
+		e := expected[i]
+		if d.Name != e.Name || d.Kind != e.Kind || d.Content != e.Content || d.Moved != e.Moved ||
+			d.IndexA != e.IndexA || d.IndexB != e.IndexB || strings.Join(d.Fields, ",") != strings.Join(e.Fields, ",") {
+			t.Errorf("expected %+v, got %+v", e, d)
+		}
+	}
+}
+
+func TestCompareTruncated(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	report, err := Compare(bytes.NewReader(archive), bytes.NewReader(archive[:len(archive)-blockSize]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FirstDiff != int64(len(archive)-blockSize) {
+		t.Errorf("expected the first difference at %d, got %d", len(archive)-blockSize, report.FirstDiff)
+	}
+	if len(report.Diffs) != 0 {
+		t.Errorf("expected no entry differences, got %+v", report.Diffs)
+	}
+}
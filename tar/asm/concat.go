@@ -0,0 +1,100 @@
+package asm
+
+import (
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ConcatInput is one of the archives joined by Concatenate
+type ConcatInput struct {
+	// Unpacker reads the archive's metadata
+	Unpacker storage.Unpacker
+	// Getter provides the archive's file payloads
+	Getter storage.FileGetter
+}
+
+// NewConcatTarStream returns an io.ReadCloser of the archives of `inputs`,
+// joined into one, as written by Concatenate.
+func NewConcatTarStream(inputs ...ConcatInput) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(Concatenate(pw, inputs...))
+	}()
+	return pr
+}
+
+// Concatenate writes the archives of `inputs` to `w`, in order, joined into
+// a single tar archive.
+//
+// Each archive is assembled as with WriteOutputTarStream, except that
+// whatever follows the padding of the last file of all but the final archive
+// is dropped. That is the end of archive marker, and any blocks padding it
+// to a record size, which would otherwise end the joined archive early for
+// most readers. The final archive is assembled with its trailer as it was.
+func Concatenate(w io.Writer, inputs ...ConcatInput) error {
+	for i, in := range inputs {
+		up := in.Unpacker
+		if i < len(inputs)-1 {
+			up = &trailerDroppingUnpacker{up: in.Unpacker}
+		}
+		if err := WriteOutputTarStream(in.Getter, up, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trailerDroppingUnpacker holds back the segments following each file, until
+// the next file is read. At the end of the metadata, only the padding of the
+// last file is passed on from them.
+type trailerDroppingUnpacker struct {
+	up      storage.Unpacker
+	pad     int64
+	held    []*storage.Entry
+	pending []*storage.Entry
+}
+
+func (tu *trailerDroppingUnpacker) Next() (*storage.Entry, error) {
+	for len(tu.pending) == 0 {
+		entry, err := tu.up.Next()
+		if err == io.EOF {
+			return tu.finish()
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch entry.Type {
+		case storage.FileType:
+			tu.pending = append(tu.held, entry)
+			tu.held = nil
+			tu.pad = -entry.DataSize() & (blockSize - 1)
+		case storage.SourceType:
+			// the joined archive is not the source blob of any of its parts
+		default:
+			tu.held = append(tu.held, entry)
+		}
+	}
+	entry := tu.pending[0]
+	tu.pending = tu.pending[1:]
+	return entry, nil
+}
+
+// finish returns the padding of the last file, before io.EOF
+func (tu *trailerDroppingUnpacker) finish() (*storage.Entry, error) {
+	var trailing []byte
+	for _, entry := range tu.held {
+		if entry.Type == storage.SegmentType {
+			trailing = append(trailing, entry.Payload...)
+		}
+	}
+	tu.held = nil
+	if int64(len(trailing)) > tu.pad {
+		trailing = trailing[:tu.pad]
+	}
+	tu.pad = 0
+	if len(trailing) == 0 {
+		return nil, io.EOF
+	}
+	return &storage.Entry{Type: storage.SegmentType, Payload: trailing}, nil
+}
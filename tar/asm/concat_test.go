@@ -0,0 +1,58 @@
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestConcatenate(t *testing.T) {
+	more := []testFile{
+		{hdr: tar.Header{Name: "more/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "more/odd.txt", Typeflag: tar.TypeReg, Mode: 0644}, body: "an odd number of bytes"},
+	}
+	first := buildTar(t, testFiles)
+	// padded to a record size, as by many tar writers
+	second := append(buildTar(t, more), make([]byte, 10240-blockSize*4)...)
+	empty := buildTar(t, nil)
+	last := buildTar(t, testFiles[1:2])
+
+	var inputs []ConcatInput
+	for _, archive := range [][]byte{first, empty, second, last} {
+		meta, fgp := disassemble(t, archive)
+		inputs = append(inputs, ConcatInput{
+			Unpacker: storage.NewJSONUnpacker(bytes.NewReader(meta)),
+			Getter:   fgp,
+		})
+	}
+
+	rc := NewConcatTarStream(inputs...)
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []testFile
+	all = append(all, testFiles...)
+	all = append(all, more...)
+	all = append(all, testFiles[1:2]...)
+	if expected := buildTar(t, all); !bytes.Equal(got, expected) {
+		t.Errorf("joined archive is %d bytes, rather than the %d bytes of one archive of all the files", len(got), len(expected))
+	}
+}
+
+func TestConcatenateKeepsLastTrailer(t *testing.T) {
+	archive := append(buildTar(t, testFiles), make([]byte, blockSize*3)...)
+	meta, fgp := disassemble(t, archive)
+	buf := bytes.NewBuffer(nil)
+	err := Concatenate(buf, ConcatInput{Unpacker: storage.NewJSONUnpacker(bytes.NewReader(meta)), Getter: fgp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), archive) {
+		t.Errorf("a single archive is not assembled as it was")
+	}
+}
@@ -0,0 +1,96 @@
+//go:build go1.7
+// +build go1.7
+
+package asm
+
+import (
+	"context"
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// NewInputTarStreamContext is like NewInputTarStream, but stops disassembling
+// once `ctx` is done, and the returned Reader then fails with ctx.Err(). A
+// Read that is blocked on `r` itself is not interrupted.
+func NewInputTarStreamContext(ctx context.Context, r io.Reader, p storage.Packer, fp storage.FilePutter) (io.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pR, pW := io.Pipe()
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	finished := make(chan struct{})
+	go func() {
+		disassembleTo(&contextReader{ctx: ctx, r: r}, p, fp, disassembleOptions{}, pW)
+		close(finished)
+	}()
+	go closeOnDone(ctx, finished, pR, pW)
+	return &contextReader{ctx: ctx, r: pR}, nil
+}
+
+// NewOutputTarStreamContext is like NewOutputTarStream, but stops assembling
+// once `ctx` is done, and the returned ReadCloser then fails with ctx.Err().
+func NewOutputTarStreamContext(ctx context.Context, fg storage.FileGetter, up storage.Unpacker) io.ReadCloser {
+	if fg == nil || up == nil {
+		return nil
+	}
+	pR, pW := io.Pipe()
+	finished := make(chan struct{})
+	go func() {
+		pW.CloseWithError(WriteOutputTarStream(fg, up, &contextWriter{ctx: ctx, w: pW}))
+		close(finished)
+	}()
+	go closeOnDone(ctx, finished, pR, pW)
+	return &contextReadCloser{contextReader{ctx: ctx, r: pR}, pR}
+}
+
+// closeOnDone closes both ends of the pipe with ctx.Err() once `ctx` is done,
+// so that neither the stream nor its reader is left blocked on the other,
+// unless the stream is finished first
+func closeOnDone(ctx context.Context, finished chan struct{}, pR *io.PipeReader, pW *io.PipeWriter) {
+	select {
+	case <-ctx.Done():
+		pW.CloseWithError(ctx.Err())
+		pR.CloseWithError(ctx.Err())
+	case <-finished:
+	}
+}
+
+// contextReader fails with ctx.Err() once `ctx` is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := cr.r.Read(p)
+	if err != nil && err != io.EOF {
+		if cerr := cr.ctx.Err(); cerr != nil {
+			return n, cerr
+		}
+	}
+	return n, err
+}
+
+type contextReadCloser struct {
+	contextReader
+	io.Closer
+}
+
+// contextWriter fails with ctx.Err() once `ctx` is done
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
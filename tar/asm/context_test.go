@@ -0,0 +1,74 @@
+//go:build go1.7
+// +build go1.7
+
+package asm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestInputTarStreamContext(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta := bytes.NewBuffer(nil)
+	its, err := NewInputTarStreamContext(context.Background(), bytes.NewReader(archive), storage.NewJSONPacker(meta), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(its)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, archive) {
+		t.Error("expected the archive passed through")
+	}
+
+	// cancelled with the stream half read, and no more read of it
+	ctx, cancel := context.WithCancel(context.Background())
+	its, err = NewInputTarStreamContext(ctx, bytes.NewReader(archive), storage.NewJSONPacker(ioutil.Discard), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(its, make([]byte, 512)); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := ioutil.ReadAll(its); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err := NewInputTarStreamContext(ctx, bytes.NewReader(archive), storage.NewJSONPacker(ioutil.Discard), nil); err != context.Canceled {
+		t.Errorf("expected context.Canceled for a done context, got %v", err)
+	}
+}
+
+func TestOutputTarStreamContext(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+	ots := NewOutputTarStreamContext(context.Background(), fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)))
+	out, err := ioutil.ReadAll(ots)
+	ots.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, archive) {
+		t.Error("expected the archive assembled as it was")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ots = NewOutputTarStreamContext(ctx, fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)))
+	defer ots.Close()
+	if _, err := io.ReadFull(ots, make([]byte, 512)); err != nil {
+		t.Fatal(err)
+	}
+	<-ctx.Done()
+	if _, err := ioutil.ReadAll(ots); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
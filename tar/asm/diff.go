@@ -0,0 +1,70 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// DiffReport is the result of Diff
+type DiffReport struct {
+	// Diffs are the entries that differ, in the order of metadata `a`, then
+	// those only in metadata `b`
+	Diffs []EntryDiff
+	// Trailer is whether the raw bytes after the last payload, like the end
+	// of archive marker, differ
+	Trailer bool
+}
+
+// Identical is whether the metadata describe the same archive
+func (r DiffReport) Identical() bool {
+	return len(r.Diffs) == 0 && !r.Trailer
+}
+
+// Diff compares the packed metadata of two archives, reporting the entries
+// that were added, removed, or changed in their headers, raw header bytes,
+// payloads (by size and checksums) or order, as Compare does for the archives
+// themselves. Neither the archives nor their payloads are needed, so it is for
+// auditing why the digest of a rebuilt layer changed.
+func Diff(a, b storage.Unpacker) (DiffReport, error) {
+	var report DiffReport
+	entriesA, trailerA, err := summarizeMetadata(a)
+	if err != nil {
+		return report, fmt.Errorf("asm: metadata a: %v", err)
+	}
+	entriesB, trailerB, err := summarizeMetadata(b)
+	if err != nil {
+		return report, fmt.Errorf("asm: metadata b: %v", err)
+	}
+	report.Diffs = diffEntries(entriesA, entriesB)
+	report.Trailer = !bytes.Equal(trailerA, trailerB)
+	return report, nil
+}
+
+// summarizeMetadata returns the summary of each file entry of `up`, with its
+// header parsed from the raw segments, and the trailer of the archive
+func summarizeMetadata(up storage.Unpacker) ([]entrySummary, []byte, error) {
+	var entries []entrySummary
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return entries, hr.Trailer(), nil
+			}
+			return nil, nil, err
+		}
+		crc := entry.Payload
+		if entry.Special != "" {
+			crc = entry.Inline
+		}
+		entries = append(entries, entrySummary{
+			hdr:      hdr,
+			crc:      crc,
+			checksum: entry.Checksum,
+			raw:      hr.RawHeader(),
+		})
+	}
+}
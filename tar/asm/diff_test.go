@@ -0,0 +1,86 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func diffArchives(t *testing.T, archiveA, archiveB []byte) DiffReport {
+	metaA, _ := disassemble(t, archiveA)
+	metaB, _ := disassemble(t, archiveB)
+	report, err := Diff(storage.NewJSONUnpacker(bytes.NewReader(metaA)), storage.NewJSONUnpacker(bytes.NewReader(metaB)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestDiff(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	if report := diffArchives(t, archive, archive); !report.Identical() {
+		t.Errorf("expected identical metadata, got %+v", report)
+	}
+
+	a := []testFile{
+		{hdr: tar.Header{Name: "one", Typeflag: tar.TypeReg, Mode: 0644}, body: "one"},
+		{hdr: tar.Header{Name: "two", Typeflag: tar.TypeReg, Mode: 0644}, body: "two"},
+		{hdr: tar.Header{Name: "three", Typeflag: tar.TypeReg, Mode: 0644}, body: "three"},
+		{hdr: tar.Header{Name: "gone", Typeflag: tar.TypeReg, Mode: 0644}, body: "gone"},
+	}
+	b := []testFile{
+		{hdr: tar.Header{Name: "one", Typeflag: tar.TypeReg, Mode: 0644}, body: "one"},
+		{hdr: tar.Header{Name: "three", Typeflag: tar.TypeReg, Mode: 0600, Uid: 1000}, body: "three"},
+		{hdr: tar.Header{Name: "two", Typeflag: tar.TypeReg, Mode: 0644}, body: "TWO"},
+		{hdr: tar.Header{Name: "new", Typeflag: tar.TypeReg, Mode: 0644}, body: "new"},
+	}
+	report := diffArchives(t, buildTar(t, a), buildTar(t, b))
+	expected := []EntryDiff{
+		{Name: "two", Kind: Modified, Content: true, Moved: true, IndexA: 1, IndexB: 2},
+		{Name: "three", Kind: Modified, Fields: []string{"Mode", "Uid"}, Header: true, Moved: true, IndexA: 2, IndexB: 1},
+		{Name: "gone", Kind: Deleted, IndexA: 3, IndexB: -1},
+		{Name: "new", Kind: Added, IndexA: -1, IndexB: 3},
+	}
+	if len(report.Diffs) != len(expected) {
+		t.Fatalf("expected %d diffs, got %+v", len(expected), report.Diffs)
+	}
+	for i, d := range report.Diffs {
+		e := expected[i]
+		if d.Name != e.Name || d.Kind != e.Kind || d.Content != e.Content || d.Header != e.Header || d.Moved != e.Moved ||
+			d.IndexA != e.IndexA || d.IndexB != e.IndexB || strings.Join(d.Fields, ",") != strings.Join(e.Fields, ",") {
+			t.Errorf("expected %+v, got %+v", e, d)
+		}
+	}
+	if report.Trailer {
+		t.Error("expected the same trailer")
+	}
+}
+
+func TestDiffRawHeader(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	// the same mode, with one less leading zero and then a space
+	changed := append([]byte(nil), archive...)
+	copy(changed[100:107], string(archive[101:107])+" ")
+	var sum int64
+	copy(changed[148:156], "        ")
+	for _, c := range changed[:blockSize] {
+		sum += int64(c)
+	}
+	copy(changed[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	changed = append(changed, make([]byte, blockSize)...)
+
+	report := diffArchives(t, archive, changed)
+	if len(report.Diffs) != 1 {
+		t.Fatalf("expected the one entry to differ, got %+v", report.Diffs)
+	}
+	if d := report.Diffs[0]; !d.Header || len(d.Fields) != 0 || d.Content || d.Moved {
+		t.Errorf("expected only the raw header to differ, got %+v", d)
+	}
+	if !report.Trailer {
+		t.Error("expected the longer trailer to differ")
+	}
+}
@@ -1,6 +1,8 @@
 package asm
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 
@@ -36,106 +38,318 @@ func NewInputTarStream(r io.Reader, p storage.Packer, fp storage.FilePutter) (io
 	// the end, we want to be the one reading the padding, even if the user's
 	// `archive/tar` doesn't care.
 	pR, pW := io.Pipe()
-	outputRdr := io.TeeReader(r, pW)
 
 	// we need a putter that will generate the crc64 sums of file payloads
 	if fp == nil {
 		fp = storage.NewDiscardFilePutter()
 	}
 
-	go func() {
-		tr := tar.NewReader(outputRdr)
-		tr.RawAccounting = true
-		for {
-			hdr, err := tr.Next()
-			if err != nil {
-				if err != io.EOF {
-					pW.CloseWithError(err)
-					return
-				}
-				// even when an EOF is reached, there is often 1024 null bytes on
-				// the end of an archive. Collect them too.
-				if b := tr.RawBytes(); len(b) > 0 {
-					_, err := p.AddEntry(storage.Entry{
-						Type:    storage.SegmentType,
-						Payload: b,
-					})
-					if err != nil {
-						pW.CloseWithError(err)
-						return
-					}
-				}
-				break // not return. We need the end of the reader.
+	go disassembleTo(r, p, fp, disassembleOptions{}, pW)
+	return pR, nil
+}
+
+// TruncatedArchiveError is the error of NewStrictInputTarStream for an archive
+// that ends in the middle of an entry, or before its end of archive marker
+type TruncatedArchiveError struct {
+	// LastEntry is the name of the last file read whole, or "" if there is
+	// none
+	LastEntry string
+	// Offset is where the archive ends
+	Offset int64
+}
+
+func (te *TruncatedArchiveError) Error() string {
+	if te.LastEntry == "" {
+		return fmt.Sprintf("asm: archive truncated at offset %d, before its first file", te.Offset)
+	}
+	return fmt.Sprintf("asm: archive truncated at offset %d, after %q", te.Offset, te.LastEntry)
+}
+
+// NewStrictInputTarStream is like NewInputTarStream, but an archive that is
+// cut short fails with a *TruncatedArchiveError. Without it, an archive that
+// ends between two entries, without the two zero blocks of its end of archive
+// marker (as one whose download was cut short might), is disassembled as if
+// it was whole, and one that ends in the middle of an entry fails with
+// io.ErrUnexpectedEOF.
+func NewStrictInputTarStream(r io.Reader, p storage.Packer, fp storage.FilePutter) (io.Reader, error) {
+	pR, pW := io.Pipe()
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	go disassembleTo(r, p, fp, disassembleOptions{strict: true}, pW)
+	return pR, nil
+}
+
+// EntryCallback is called by NewInputTarStreamCallback for each file of the
+// archive, with its header, its entry, and its payload to read as it is
+// stored. The entry is as it is packed, but for the checksum of its payload,
+// which is only known once the payload is stored. What is not read of the
+// payload is discarded once the callback returns, and the payload is not to
+// be read after. An error returned stops the disassembly with it.
+type EntryCallback func(hdr *tar.Header, entry storage.Entry, payload io.Reader) error
+
+// NewInputTarStreamCallback is like NewInputTarStream, but calls `fn` for each
+// file as it is disassembled, e.g. to collect the modes and xattrs of the
+// files without reading the archive again.
+func NewInputTarStreamCallback(r io.Reader, p storage.Packer, fp storage.FilePutter, fn EntryCallback) (io.Reader, error) {
+	pR, pW := io.Pipe()
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	go disassembleTo(r, p, fp, disassembleOptions{fn: fn}, pW)
+	return pR, nil
+}
+
+// disassembleOptions are the ways that disassembleTo differs from
+// NewInputTarStream
+type disassembleOptions struct {
+	// fn is called for each file, if not nil
+	fn EntryCallback
+	// strict is for a truncated archive to be a *TruncatedArchiveError
+	strict bool
+	// from is the Checkpoint that the archive is resumed from, if not nil,
+	// with its entries already packed
+	from *Checkpoint
+	// checkpoint is called after each file, if not nil
+	checkpoint CheckpointFunc
+}
+
+// disassembleTo disassembles the tar archive `r`, writing it on to `pW` as it
+// is read, and closes `pW` with the error, if any.
+func disassembleTo(r io.Reader, p storage.Packer, fp storage.FilePutter, opts disassembleOptions, pW *io.PipeWriter) {
+	fn, strict := opts.fn, opts.strict
+	cr := &countingReader{r: r}
+	var last string
+	var entries int
+	// pending is the padding of the last file before a Checkpoint, that is
+	// the start of the next segment, as it would have been read with it
+	var pending []byte
+	if opts.from != nil {
+		cr.n, last, entries = opts.from.Offset, opts.from.LastEntry, opts.from.Entries
+	}
+	// closeWithError closes `pW` with `err`, as a *TruncatedArchiveError if
+	// that is what it is
+	closeWithError := func(err error) {
+		if strict && err == io.ErrUnexpectedEOF {
+			err = &TruncatedArchiveError{LastEntry: last, Offset: cr.n}
+		}
+		pW.CloseWithError(err)
+	}
+	outputRdr := io.TeeReader(cr, pW)
+	if opts.from != nil {
+		pending = make([]byte, checkpointPadding(cr.n))
+		n, err := io.ReadFull(outputRdr, pending)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			closeWithError(err)
+			return
+		}
+		pending = pending[:n]
+	}
+	tr := tar.NewReader(outputRdr)
+	tr.RawAccounting = true
+	// rawBytes are the raw bytes of `tr` since they were last taken, after
+	// what is pending
+	rawBytes := func() []byte {
+		b := tr.RawBytes()
+		if len(pending) > 0 {
+			b, pending = append(pending, b...), nil
+		}
+		return b
+	}
+	addEntry := func(e storage.Entry) error {
+		_, err := p.AddEntry(e)
+		if err == nil {
+			entries++
+		}
+		return err
+	}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err != io.EOF {
+				closeWithError(err)
+				return
 			}
-			if hdr == nil {
-				break // not return. We need the end of the reader.
+			// even when an EOF is reached, there is often 1024 null bytes on
+			// the end of an archive. Collect them too.
+			b := rawBytes()
+			// the end of archive marker is two zero blocks
+			if strict && len(b) < 2*blockSize {
+				closeWithError(io.ErrUnexpectedEOF)
+				return
 			}
-
-			if b := tr.RawBytes(); len(b) > 0 {
-				_, err := p.AddEntry(storage.Entry{
+			if len(b) > 0 {
+				err := addEntry(storage.Entry{
 					Type:    storage.SegmentType,
 					Payload: b,
 				})
 				if err != nil {
-					pW.CloseWithError(err)
-					return
-				}
-			}
-
-			var csum []byte
-			if hdr.Size > 0 {
-				var err error
-				_, csum, err = fp.Put(hdr.Name, tr)
-				if err != nil {
-					pW.CloseWithError(err)
+					closeWithError(err)
 					return
 				}
 			}
+			break // not return. We need the end of the reader.
+		}
+		if hdr == nil {
+			break // not return. We need the end of the reader.
+		}
 
-			entry := storage.Entry{
-				Type:    storage.FileType,
-				Size:    hdr.Size,
-				Payload: csum,
-			}
-			// For proper marshalling of non-utf8 characters
-			entry.SetName(hdr.Name)
-
-			// File entries added, regardless of size
-			_, err = p.AddEntry(entry)
+		if b := rawBytes(); len(b) > 0 {
+			err := addEntry(storage.Entry{
+				Type:    storage.SegmentType,
+				Payload: b,
+			})
 			if err != nil {
-				pW.CloseWithError(err)
+				closeWithError(err)
 				return
 			}
+		}
 
-			if b := tr.RawBytes(); len(b) > 0 {
-				_, err = p.AddEntry(storage.Entry{
-					Type:    storage.SegmentType,
-					Payload: b,
-				})
-				if err != nil {
-					pW.CloseWithError(err)
+		entry := storage.Entry{
+			Type: storage.FileType,
+			Size: hdr.Size,
+		}
+		// For proper marshalling of non-utf8 characters
+		entry.SetName(hdr.Name)
+		setLink(&entry, hdr)
+		if special := specialKind(hdr.Typeflag); special != "" && hdr.Size > 0 {
+			if err := setInline(&entry, special, tr); err != nil {
+				closeWithError(err)
+				return
+			}
+			if fn != nil {
+				if err := fn(hdr, entry, bytes.NewReader(entry.Inline)); err != nil {
+					closeWithError(err)
 					return
 				}
 			}
+		} else if hdr.Size > 0 {
+			setSparse(&entry, tr)
+			if err := putPayloadCallback(fp, hdr, tr, &entry, fn); err != nil {
+				closeWithError(err)
+				return
+			}
+		} else if fn != nil {
+			if err := fn(hdr, entry, bytes.NewReader(nil)); err != nil {
+				closeWithError(err)
+				return
+			}
 		}
 
-		// it is allowable, and not uncommon that there is further padding on the
-		// end of an archive, apart from the expected 1024 null bytes.
-		remainder, err := ioutil.ReadAll(outputRdr)
-		if err != nil && err != io.EOF {
-			pW.CloseWithError(err)
+		// File entries added, regardless of size
+		if err := addEntry(entry); err != nil {
+			closeWithError(err)
 			return
 		}
-		_, err = p.AddEntry(storage.Entry{
-			Type:    storage.SegmentType,
-			Payload: remainder,
-		})
-		if err != nil {
-			pW.CloseWithError(err)
-			return
+		last = hdr.Name
+
+		if b := tr.RawBytes(); len(b) > 0 {
+			err = addEntry(storage.Entry{
+				Type:    storage.SegmentType,
+				Payload: b,
+			})
+			if err != nil {
+				closeWithError(err)
+				return
+			}
+		}
+		if opts.checkpoint != nil {
+			if err := opts.checkpoint(Checkpoint{Offset: cr.n, Entries: entries, LastEntry: last}); err != nil {
+				closeWithError(err)
+				return
+			}
 		}
-		pW.Close()
-	}()
+	}
 
-	return pR, nil
+	// it is allowable, and not uncommon that there is further padding on the
+	// end of an archive, apart from the expected 1024 null bytes.
+	remainder, err := ioutil.ReadAll(outputRdr)
+	if err != nil && err != io.EOF {
+		closeWithError(err)
+		return
+	}
+	err = addEntry(storage.Entry{
+		Type:    storage.SegmentType,
+		Payload: remainder,
+	})
+	if err != nil {
+		closeWithError(err)
+		return
+	}
+	pW.Close()
+}
+
+// setSparse sets the Sparse extents of `entry`, if the current file of `tr`
+// is a sparse file. A sparse file of only holes gets an empty extent at its
+// end, to still be told apart.
+func setSparse(entry *storage.Entry, tr *tar.Reader) {
+	data := tr.SparseData()
+	if data == nil || entry.Size == 0 {
+		return
+	}
+	entry.Sparse = make([]storage.SparseExtent, 0, len(data))
+	for _, d := range data {
+		entry.Sparse = append(entry.Sparse, storage.SparseExtent{Offset: d.Offset, Length: d.Length})
+	}
+	if len(entry.Sparse) == 0 {
+		entry.Sparse = append(entry.Sparse, storage.SparseExtent{Offset: entry.Size})
+	}
+}
+
+// putPayloadCallback is putPayload, with the payload passed to `fn` too, if
+// not nil, as it is stored
+func putPayloadCallback(fp storage.FilePutter, hdr *tar.Header, r io.Reader, entry *storage.Entry, fn EntryCallback) error {
+	if fn == nil {
+		return putPayload(fp, hdr.Name, r, entry)
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func(e storage.Entry) {
+		err := fn(hdr, e, pr)
+		// the rest is discarded, for the payload to be stored
+		io.Copy(ioutil.Discard, pr)
+		done <- err
+	}(*entry)
+	err := putPayload(fp, hdr.Name, io.TeeReader(r, pw), entry)
+	pw.CloseWithError(err)
+	if cerr := <-done; err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// putPayload stores the payload `r` of `entry` to `fp`, and sets its checksum.
+// A sparse file is stored without its holes if `fp` is a
+// storage.SparseFilePutter.
+func putPayload(fp storage.FilePutter, name string, r io.Reader, entry *storage.Entry) error {
+	var err error
+	if sfp, ok := fp.(storage.SparseFilePutter); ok && len(entry.Sparse) > 0 {
+		_, entry.Payload, err = sfp.PutSparse(name, r, entry.Sparse)
+		entry.SparsePacked = true
+	} else {
+		_, entry.Payload, err = fp.Put(name, r)
+	}
+	return err
+}
+
+// setLink records the kind and target of a link in its entry
+func setLink(entry *storage.Entry, hdr *tar.Header) {
+	switch hdr.Typeflag {
+	case tar.TypeLink:
+		entry.SetLink(storage.HardLink, hdr.Linkname)
+	case tar.TypeSymlink:
+		entry.SetLink(storage.SymLink, hdr.Linkname)
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
 }
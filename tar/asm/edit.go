@@ -0,0 +1,266 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// EditOp is the operation of an Edit
+type EditOp int
+
+const (
+	// EditRename renames the entry From, and the entries under it, to To
+	EditRename EditOp = 1 + iota
+	// EditDelete leaves out the entries matching Pattern, and the entries
+	// under them
+	EditDelete
+	// EditChmod sets the permission bits of the entries matching Pattern
+	EditChmod
+	// EditChown sets the owner of the entries matching Pattern
+	EditChown
+	// EditMtime sets the modification time of the entries matching Pattern
+	EditMtime
+)
+
+func (op EditOp) String() string {
+	switch op {
+	case EditRename:
+		return "rename"
+	case EditDelete:
+		return "delete"
+	case EditChmod:
+		return "chmod"
+	case EditChown:
+		return "chown"
+	case EditMtime:
+		return "mtime"
+	}
+	return "unknown"
+}
+
+// Edit is a transformation of the entries of an archive, for EditTarStream
+type Edit struct {
+	Op EditOp
+	// Pattern is matched against the entry names, as with path.Match, for all
+	// but EditRename
+	Pattern string
+	// From and To are the names of EditRename
+	From, To string
+	// Mode is the permission bits of EditChmod
+	Mode int64
+	// Uid and Gid are of EditChown, or -1 to leave one as it is
+	Uid, Gid int
+	// ModTime is of EditMtime
+	ModTime time.Time
+}
+
+// ParseEdits reads a spec of edits, one per line. Blank lines and those
+// starting with '#' are ignored. Names can not have spaces.
+//
+//	rename OLD NEW
+//	delete PATTERN
+//	chmod MODE PATTERN     (MODE in octal, like 0644)
+//	chown UID:GID PATTERN  (either may be left empty, like 0: or :0)
+//	mtime TIME PATTERN     (TIME in RFC 3339, or seconds since the epoch)
+func ParseEdits(r io.Reader) ([]Edit, error) {
+	var edits []Edit
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e, err := parseEdit(strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("asm: line %d of the edits: %v", n, err)
+		}
+		edits = append(edits, e)
+	}
+	return edits, scanner.Err()
+}
+
+func parseEdit(fields []string) (Edit, error) {
+	args := map[string]int{"rename": 2, "delete": 1, "chmod": 2, "chown": 2, "mtime": 2}
+	want, ok := args[fields[0]]
+	if !ok {
+		return Edit{}, fmt.Errorf("unknown edit %q", fields[0])
+	}
+	if len(fields) != want+1 {
+		return Edit{}, fmt.Errorf("%s takes %d arguments, got %d", fields[0], want, len(fields)-1)
+	}
+	switch fields[0] {
+	case "rename":
+		return Edit{Op: EditRename, From: cleanEntryName(fields[1]), To: cleanEntryName(fields[2])}, nil
+	case "delete":
+		return Edit{Op: EditDelete, Pattern: cleanEntryName(fields[1])}, nil
+	case "chmod":
+		mode, err := strconv.ParseInt(fields[1], 8, 64)
+		if err != nil || mode < 0 || mode > 07777 {
+			return Edit{}, fmt.Errorf("invalid mode %q", fields[1])
+		}
+		return Edit{Op: EditChmod, Mode: mode, Pattern: cleanEntryName(fields[2])}, nil
+	case "chown":
+		ids := strings.SplitN(fields[1], ":", 2)
+		if len(ids) != 2 {
+			return Edit{}, fmt.Errorf("invalid owner %q, rather than UID:GID", fields[1])
+		}
+		e := Edit{Op: EditChown, Uid: -1, Gid: -1, Pattern: cleanEntryName(fields[2])}
+		for i, dst := range []*int{&e.Uid, &e.Gid} {
+			if ids[i] == "" {
+				continue
+			}
+			id, err := strconv.Atoi(ids[i])
+			if err != nil || id < 0 {
+				return Edit{}, fmt.Errorf("invalid owner %q, rather than UID:GID", fields[1])
+			}
+			*dst = id
+		}
+		return e, nil
+	default: // mtime
+		t, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			secs, serr := strconv.ParseInt(fields[1], 10, 64)
+			if serr != nil {
+				return Edit{}, fmt.Errorf("invalid time %q", fields[1])
+			}
+			t = time.Unix(secs, 0)
+		}
+		return Edit{Op: EditMtime, ModTime: t, Pattern: cleanEntryName(fields[2])}, nil
+	}
+}
+
+// cleanEntryName is `name` without leading "./" or "/", or a trailing "/", to
+// match names however they are written in the archive
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// matchUnder is whether `name`, or one of the directories it is under,
+// matches `pattern`
+func matchUnder(pattern, name string) bool {
+	for name != "." && name != "/" && name != "" {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		name = path.Dir(name)
+	}
+	return false
+}
+
+// EditTarStream writes to `w` the archive of the metadata read from `up` and
+// the payloads of `fg`, with the edits applied to each entry in order (so a
+// later edit matches the name given by an earlier rename). The new archive's
+// metadata is packed to `p`, and its file payloads stored to `fp`, as with
+// NewInputTarStream.
+//
+// Entries that no edit changes keep their raw headers as they were, so only
+// the edited ones differ from the original archive. Hard links follow the
+// renames of their targets, and a hard link to a deleted entry fails, as it
+// could not be extracted. The entries edited are returned, by their new name.
+func EditTarStream(fg storage.FileGetter, up storage.Unpacker, edits []Edit, p storage.Packer, fp storage.FilePutter, w io.Writer) ([]Change, error) {
+	pR, pW := io.Pipe()
+	type result struct {
+		changes []Change
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		changes, err := writeEditedTar(fg, up, edits, pW)
+		pW.CloseWithError(err)
+		done <- result{changes, err}
+	}()
+
+	its, err := NewInputTarStream(pR, p, fp)
+	if err == nil {
+		_, err = io.Copy(w, its)
+	}
+	if err != nil {
+		pR.CloseWithError(err)
+		<-done
+		return nil, err
+	}
+	res := <-done
+	return res.changes, res.err
+}
+
+func writeEditedTar(fg storage.FileGetter, up storage.Unpacker, edits []Edit, w io.Writer) ([]Change, error) {
+	return NewRewriter(EditRewrite(edits)).WriteTarStream(fg, up, w)
+}
+
+// applyEdits applies the edits to `hdr`, returning whether it changed, and
+// whether it is deleted
+func applyEdits(hdr *tar.Header, edits []Edit) (bool, bool) {
+	var changed bool
+	for _, e := range edits {
+		name := cleanEntryName(hdr.Name)
+		if e.Op == EditRename {
+			if renamed, ok := rename(hdr.Name, e.From, e.To); ok {
+				hdr.Name, changed = renamed, true
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				if renamed, ok := rename(hdr.Linkname, e.From, e.To); ok {
+					hdr.Linkname, changed = renamed, true
+				}
+			}
+			continue
+		}
+		if e.Op == EditDelete {
+			if matchUnder(e.Pattern, name) {
+				return changed, true
+			}
+			continue
+		}
+		if ok, _ := path.Match(e.Pattern, name); !ok {
+			continue
+		}
+		switch e.Op {
+		case EditChmod:
+			if hdr.Mode&07777 != e.Mode {
+				hdr.Mode, changed = hdr.Mode&^07777|e.Mode, true
+			}
+		case EditChown:
+			// the names would take precedence over the ids on extraction
+			if e.Uid >= 0 && hdr.Uid != e.Uid {
+				hdr.Uid, hdr.Uname, changed = e.Uid, "", true
+			}
+			if e.Gid >= 0 && hdr.Gid != e.Gid {
+				hdr.Gid, hdr.Gname, changed = e.Gid, "", true
+			}
+		case EditMtime:
+			if !hdr.ModTime.Equal(e.ModTime) {
+				hdr.ModTime, changed = e.ModTime, true
+			}
+		}
+	}
+	return changed, false
+}
+
+// rename returns `name` with its prefix `from` replaced by `to`, if it is
+// `from` or under it. The form of the name (like a trailing "/") is kept.
+func rename(name, from, to string) (string, bool) {
+	clean := cleanEntryName(name)
+	var renamed string
+	switch {
+	case clean == from:
+		renamed = to
+	case strings.HasPrefix(clean, from+"/"):
+		renamed = to + clean[len(from):]
+	default:
+		return name, false
+	}
+	if strings.HasPrefix(name, "./") {
+		renamed = "./" + renamed
+	}
+	if strings.HasSuffix(name, "/") {
+		renamed += "/"
+	}
+	return renamed, true
+}
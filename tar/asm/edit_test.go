@@ -0,0 +1,120 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestEditTarStream(t *testing.T) {
+	files := []testFile{
+		{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0600, Uid: 5, Uname: "someone"}, body: "root:x:0:0"},
+		{hdr: tar.Header{Name: "etc/link", Typeflag: tar.TypeLink, Linkname: "etc/passwd", Mode: 0600}},
+		{hdr: tar.Header{Name: "var/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "var/cache/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "var/cache/junk", Typeflag: tar.TypeReg, Mode: 0644}, body: "junk"},
+		{hdr: tar.Header{Name: "var/keep", Typeflag: tar.TypeReg, Mode: 0644}, body: "keep"},
+	}
+	meta, fgp := disassemble(t, buildTar(t, files))
+
+	edits, err := ParseEdits(strings.NewReader(`
+# an example
+rename etc/passwd etc/passwd.orig
+delete var/cache
+chmod 0640 etc/passwd.orig
+chown 0: etc/*
+mtime 2020-01-02T03:04:05Z var/keep
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	newMeta := bytes.NewBuffer(nil)
+	newFgp := storage.NewBufferFileGetPutter()
+	changes, err := EditTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), edits, storage.NewJSONPacker(newMeta), newFgp, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Name: "etc/passwd.orig", Kind: Modified},
+		{Name: "etc/link", Kind: Modified},
+		{Name: "var/cache/", Kind: Deleted},
+		{Name: "var/cache/junk", Kind: Deleted},
+		{Name: "var/keep", Kind: Modified},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("change %d: expected %v, got %v", i, expected[i], changes[i])
+		}
+	}
+
+	tr := tar.NewReader(bytes.NewReader(out.Bytes()))
+	hdrs := map[string]*tar.Header{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdrs[hdr.Name] = hdr
+	}
+	if len(hdrs) != 5 {
+		t.Errorf("expected 5 entries, got %d", len(hdrs))
+	}
+	if h := hdrs["etc/passwd.orig"]; h == nil || h.Mode&07777 != 0640 || h.Uid != 0 || h.Uname != "" {
+		t.Errorf("unexpected header of the renamed file %+v", h)
+	}
+	if h := hdrs["etc/link"]; h == nil || h.Linkname != "etc/passwd.orig" {
+		t.Errorf("expected the hard link to follow the rename, got %+v", h)
+	}
+	if h := hdrs["var/keep"]; h == nil || !h.ModTime.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected modification time %+v", h)
+	}
+
+	// the new metadata and payloads assemble the edited archive
+	assembled := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(newFgp, storage.NewJSONUnpacker(newMeta), assembled); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(assembled.Bytes(), out.Bytes()) {
+		t.Error("expected the new metadata to assemble the edited archive")
+	}
+
+	// no edits leave the archive as it was
+	out.Reset()
+	if _, err := EditTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), nil, storage.NewJSONPacker(io.MultiWriter()), storage.NewDiscardFilePutter(), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), buildTar(t, files)) {
+		t.Error("expected the archive unchanged, without edits")
+	}
+}
+
+func TestEditHardLinkToDeleted(t *testing.T) {
+	meta, fgp := disassemble(t, buildTar(t, testFiles))
+	edits := []Edit{{Op: EditDelete, Pattern: "dir/hurr.txt"}}
+	_, err := EditTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), edits, storage.NewJSONPacker(io.MultiWriter()), storage.NewDiscardFilePutter(), io.MultiWriter())
+	if err == nil {
+		t.Error("expected an error for a hard link to a deleted entry")
+	}
+}
+
+func TestParseEditsInvalid(t *testing.T) {
+	for _, spec := range []string{"frobnicate x", "rename a", "chmod 999 x", "chown root x", "mtime yesterday x"} {
+		if _, err := ParseEdits(strings.NewReader(spec)); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ErrNoTarFound is returned by FindTarOffset when there is no tar header
+// within the bytes it looks through
+var ErrNoTarFound = errors.New("asm: no tar header found")
+
+// NewEmbeddedInputTarStream is like NewInputTarStream, for tar data that is
+// embedded in a larger blob, after a preamble of `preamble` bytes (like the
+// header of a format that wraps tar archives). The preamble is packed as a
+// segment ahead of the archive, and anything after the end of the archive is
+// kept as it is by NewInputTarStream, so the whole blob is assembled byte for
+// byte.
+//
+// The returned Reader is of the whole blob, preamble included.
+func NewEmbeddedInputTarStream(r io.Reader, preamble int64, p storage.Packer, fp storage.FilePutter) (io.Reader, error) {
+	pre := make([]byte, preamble)
+	if _, err := io.ReadFull(r, pre); err != nil {
+		return nil, err
+	}
+	if preamble > 0 {
+		if _, err := p.AddEntry(storage.Entry{Type: storage.SegmentType, Payload: pre}); err != nil {
+			return nil, err
+		}
+	}
+	its, err := NewInputTarStream(r, p, fp)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(pre), its), nil
+}
+
+// FindTarOffset sniffs the offset of tar data embedded in a blob, as the first
+// offset in the first `limit` bytes of `br` that a valid ustar or GNU header
+// starts at. Nothing is read from `br`, so it can then be passed as is to
+// NewEmbeddedInputTarStream. The buffer of `br` must be at least limit+512
+// bytes (see bufio.NewReaderSize).
+func FindTarOffset(br *bufio.Reader, limit int) (int64, error) {
+	buf, err := br.Peek(limit + blockSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return 0, err
+	}
+	// the magic is at offset 257 of a header
+	for start := 257; start < len(buf); {
+		i := bytes.Index(buf[start:], []byte("ustar"))
+		if i < 0 {
+			break
+		}
+		off := start + i - 257
+		if off > limit || off+blockSize > len(buf) {
+			break
+		}
+		if validHeader(buf[off : off+blockSize]) {
+			return int64(off), nil
+		}
+		start += i + 1
+	}
+	return 0, ErrNoTarFound
+}
+
+// validHeader is whether the block is a ustar or GNU header with a valid
+// checksum
+func validHeader(block []byte) bool {
+	if !bytes.HasPrefix(block[257:], []byte("ustar")) {
+		return false
+	}
+	sum, err := parseNumeric(block[148:156])
+	if err != nil {
+		return false
+	}
+	var actual int64
+	for i, c := range block {
+		if i >= 148 && i < 156 {
+			c = ' '
+		}
+		actual += int64(c)
+	}
+	return sum == actual
+}
@@ -0,0 +1,58 @@
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestEmbeddedTarStream(t *testing.T) {
+	preamble := []byte("WRAP\x01\x02 some container format's own header, not block aligned\n")
+	trailer := []byte("\nsignature: 0123456789abcdef")
+	archive := buildTar(t, testFiles)
+	blob := append(append(append([]byte{}, preamble...), archive...), trailer...)
+
+	br := bufio.NewReaderSize(bytes.NewReader(blob), 4096+blockSize)
+	off, err := FindTarOffset(br, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != int64(len(preamble)) {
+		t.Fatalf("expected the tar data at %d, got %d", len(preamble), off)
+	}
+
+	meta := bytes.NewBuffer(nil)
+	fgp := storage.NewBufferFileGetPutter()
+	rdr, err := NewEmbeddedInputTarStream(br, off, storage.NewJSONPacker(meta), fgp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passed, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(passed, blob) {
+		t.Errorf("expected the whole blob to be read through")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(meta), buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), blob) {
+		t.Errorf("expected the assembled blob to be identical, got %d bytes (from %d)", buf.Len(), len(blob))
+	}
+}
+
+func TestFindTarOffsetNotFound(t *testing.T) {
+	// mentions the magic, but is not a header
+	blob := strings.Repeat("not a tar, not even ustar ", 100)
+	br := bufio.NewReaderSize(strings.NewReader(blob), 1024+blockSize)
+	if _, err := FindTarOffset(br, 1024); err != ErrNoTarFound {
+		t.Errorf("expected ErrNoTarFound, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package asm
+
+import (
+	"io"
+	"path"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// EstimateOptions are the filesystem heuristics of EstimateDiskUsage. Zero
+// values are replaced by the defaults, which are those of a typical ext4.
+type EstimateOptions struct {
+	// BlockSize that file contents are rounded up to. Defaults to 4096.
+	BlockSize int64
+	// InodeSize is the overhead of each inode. Defaults to 256.
+	InodeSize int64
+	// DirSize is the size of each directory's own entries. Defaults to
+	// BlockSize.
+	DirSize int64
+	// InlineSymlink is the longest symbolic link target stored in the inode
+	// itself, rather than a block. Defaults to 60.
+	InlineSymlink int
+}
+
+// DiskUsage is the estimated size of an extracted archive
+type DiskUsage struct {
+	// Bytes is the estimated space occupied on disk
+	Bytes int64
+	// Inodes is the number of inodes created
+	Inodes int64
+	// Apparent is the total size of the file contents
+	Apparent int64
+}
+
+// EstimateDiskUsage estimates, from the metadata in `up` alone, the space that
+// the archive will occupy once extracted, e.g. for a scheduler to enforce a
+// disk quota before pulling a layer.
+//
+// File sizes are rounded up to the filesystem block size, and each inode,
+// directory and long symbolic link adds its overhead. Hard links add nothing,
+// and entries replaced by a later entry of the same name are not counted.
+func EstimateDiskUsage(up storage.Unpacker, opts EstimateOptions) (DiskUsage, error) {
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = 4096
+	}
+	if opts.InodeSize <= 0 {
+		opts.InodeSize = 256
+	}
+	if opts.DirSize <= 0 {
+		opts.DirSize = opts.BlockSize
+	}
+	if opts.InlineSymlink <= 0 {
+		opts.InlineSymlink = 60
+	}
+	roundUp := func(n int64) int64 {
+		return (n + opts.BlockSize - 1) / opts.BlockSize * opts.BlockSize
+	}
+
+	var total DiskUsage
+	seen := map[string]DiskUsage{}
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return total, nil
+			}
+			return total, err
+		}
+		var u DiskUsage
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+			u = DiskUsage{Bytes: opts.InodeSize + roundUp(entry.Size), Inodes: 1, Apparent: hdr.Size}
+		case tar.TypeDir, typeGNUDumpDir:
+			u = DiskUsage{Bytes: opts.InodeSize + opts.DirSize, Inodes: 1}
+		case tar.TypeSymlink:
+			u = DiskUsage{Bytes: opts.InodeSize, Inodes: 1}
+			if len(hdr.Linkname) > opts.InlineSymlink {
+				u.Bytes += opts.BlockSize
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			u = DiskUsage{Bytes: opts.InodeSize, Inodes: 1}
+		default:
+			// hard links share the inode of their target, and the rest are not
+			// extracted at all
+			continue
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if prior, ok := seen[name]; ok {
+			total.Bytes -= prior.Bytes
+			total.Inodes -= prior.Inodes
+			total.Apparent -= prior.Apparent
+		}
+		seen[name] = u
+		total.Bytes += u.Bytes
+		total.Inodes += u.Inodes
+		total.Apparent += u.Apparent
+	}
+}
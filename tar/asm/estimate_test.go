@@ -0,0 +1,43 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestEstimateDiskUsage(t *testing.T) {
+	files := append([]testFile{}, testFiles...)
+	files = append(files,
+		testFile{hdr: tar.Header{Name: "dir/far", Typeflag: tar.TypeSymlink, Linkname: strings.Repeat("far/", 20), Mode: 0777}},
+		testFile{hdr: tar.Header{Name: "dir/big", Typeflag: tar.TypeReg, Mode: 0644}, body: strings.Repeat("x", 5000)},
+	)
+	meta, _ := disassemble(t, buildTar(t, files))
+
+	for _, tc := range []struct {
+		opts     EstimateOptions
+		expected DiskUsage
+	}{
+		{
+			// dir/, hurr.txt, the long name and the long symlink each take a
+			// block, big takes two, and hurr2.txt is a hard link
+			opts:     EstimateOptions{},
+			expected: DiskUsage{Bytes: 7*256 + 6*4096, Inodes: 7, Apparent: 5031},
+		},
+		{
+			opts:     EstimateOptions{BlockSize: 1024, InodeSize: 128, DirSize: 512, InlineSymlink: 100},
+			expected: DiskUsage{Bytes: 7*128 + 512 + 1024 + 1024 + 5*1024, Inodes: 7, Apparent: 5031},
+		},
+	} {
+		du, err := EstimateDiskUsage(storage.NewJSONUnpacker(bytes.NewReader(meta)), tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if du != tc.expected {
+			t.Errorf("%+v: expected %+v, got %+v", tc.opts, tc.expected, du)
+		}
+	}
+}
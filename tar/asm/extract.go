@@ -0,0 +1,295 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// errNotSupported is returned by the platform specific helpers of extraction
+// when the operation is not available on this platform.
+var errNotSupported = errors.New("asm: not supported on this platform")
+
+// ExtractTarStream applies the archive described by the metadata in `up`, and
+// the file payloads from `fg`, directly to the directory `dir`. No tar stream
+// is produced in the middle.
+//
+// Directories, regular files, hard and symbolic links, device nodes and fifos
+// are created, and their ownership, mode, xattrs and modification times
+// applied. When this is not permitted (e.g. running unprivileged), ownership,
+// device nodes and xattrs are skipped rather than failing the extraction.
+//
+// Entries can not be extracted outside of `dir`, including through symbolic
+// links extracted earlier.
+func ExtractTarStream(fg storage.FileGetter, up storage.Unpacker, dir string) error {
+	if fg == nil || up == nil {
+		return nil
+	}
+	x := &extractor{dir: dir}
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		err = x.extract(hdr, func(target string) error {
+			return extractFile(fg, entry, target)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+// NewExtractingInputTarStream is like NewInputTarStream, but also extracts the
+// archive to the directory `dir` as it is disassembled, as ExtractTarStream
+// would from the metadata and payloads after, so the archive is only read
+// once. The extracted files are where the payloads are got from again, with
+// storage.NewPathFileGetter(dir), so `fp` may be nil, unless the payloads are
+// to be stored elsewhere too.
+//
+// The archive is extracted as the returned Reader is read, and the times of
+// the directories are set once it has been read to the end.
+func NewExtractingInputTarStream(r io.Reader, p storage.Packer, fp storage.FilePutter, dir string) (io.Reader, error) {
+	x := &extractor{dir: dir}
+	its, err := NewInputTarStreamCallback(r, p, fp, func(hdr *tar.Header, entry storage.Entry, payload io.Reader) error {
+		return x.extract(hdr, func(target string) error {
+			return extractPayload(payload, target)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &extractingReader{r: its, x: x}, nil
+}
+
+// extractingReader finishes the extraction at the end of the archive
+type extractingReader struct {
+	r    io.Reader
+	x    *extractor
+	done bool
+}
+
+func (er *extractingReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err == io.EOF && !er.done {
+		er.done = true
+		if ferr := er.x.finish(); ferr != nil {
+			return n, ferr
+		}
+	}
+	return n, err
+}
+
+// extractor applies the entries of an archive to the directory `dir`
+type extractor struct {
+	dir string
+	// directory times are set last, since extracting their contents changes
+	// them
+	dirs []dirTime
+}
+
+type dirTime struct {
+	path  string
+	mtime time.Time
+}
+
+// extract applies the entry of `hdr`, with `writeFile` to create a regular
+// file at the target path with its payload
+func (x *extractor) extract(hdr *tar.Header, writeFile func(target string) error) error {
+	target, err := extractPath(x.dir, hdr.Name)
+	if err != nil {
+		return err
+	}
+	if target == x.dir {
+		return nil
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir, typeGNUDumpDir:
+		fi, err := os.Lstat(target)
+		if err != nil || !fi.IsDir() {
+			os.RemoveAll(target)
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+		}
+		x.dirs = append(x.dirs, dirTime{target, hdr.ModTime})
+	case tar.TypeReg, tar.TypeRegA:
+		os.RemoveAll(target)
+		if err := writeFile(target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		os.RemoveAll(target)
+		source, err := extractPath(x.dir, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		os.RemoveAll(target)
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		os.RemoveAll(target)
+		if err := mknod(target, hdr); err != nil {
+			if !skippable(err) {
+				return err
+			}
+			return nil
+		}
+	default:
+		// global headers and the like have nothing to extract
+		return nil
+	}
+
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !skippable(err) {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink {
+		return nil
+	}
+	if err := os.Chmod(target, hdr.FileInfo().Mode()); err != nil {
+		return err
+	}
+	for key, value := range hdr.Xattrs {
+		if err := setxattr(target, key, value); err != nil && !skippable(err) {
+			return err
+		}
+	}
+	if hdr.Typeflag != tar.TypeDir && hdr.Typeflag != typeGNUDumpDir {
+		if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish sets the times of the directories extracted
+func (x *extractor) finish() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		if err := os.Chtimes(x.dirs[i].path, x.dirs[i].mtime, x.dirs[i].mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractPath returns the path for `name` within `dir`, ensuring that none of
+// its parent directories are symbolic links that could lead out of `dir`.
+func extractPath(dir, name string) (string, error) {
+	cleaned := path.Clean("/" + name)
+	target := filepath.Join(dir, filepath.FromSlash(cleaned))
+	parent := dir
+	for _, elem := range splitPath(path.Dir(cleaned)) {
+		parent = filepath.Join(parent, elem)
+		fi, err := os.Lstat(parent)
+		if err != nil {
+			if os.IsNotExist(err) {
+				if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+					return "", err
+				}
+				break
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("asm: %q is beneath the symbolic link %q", name, parent)
+		}
+	}
+	return target, nil
+}
+
+func splitPath(p string) []string {
+	if p == "/" || p == "." {
+		return nil
+	}
+	dir, base := path.Split(p)
+	return append(splitPath(path.Clean(dir)), base)
+}
+
+// extractFile creates the regular file `target` with the payload of `entry`,
+// verifying its checksum.
+func extractFile(fg storage.FileGetter, entry *storage.Entry, target string) error {
+	fh, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if entry.Size == 0 {
+		return nil
+	}
+	rdr, err := getPayload(fg, entry)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	verifier, verify, err := newPayloadVerifier(entry)
+	if err != nil {
+		return err
+	}
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+	if entry.SparsePacked {
+		// the payload is stored without the holes, that are filled in again
+		_, err = copyWithBuffer(fh, storage.NewSparseFileReader(io.TeeReader(rdr, verifier), entry.Sparse, entry.Size), *buf)
+	} else {
+		_, err = copyWithBuffer(io.MultiWriter(fh, verifier), rdr, *buf)
+	}
+	if err != nil {
+		return err
+	}
+	if err := verify(); err != nil {
+		return err
+	}
+	return fh.Close()
+}
+
+// extractPayload creates the regular file `target` with the payload read
+// from `r`
+func extractPayload(r io.Reader, target string) error {
+	fh, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+	if _, err := copyWithBuffer(fh, r, *buf); err != nil {
+		return err
+	}
+	return fh.Close()
+}
+
+// skippable reports whether err is due to a lack of privilege or support,
+// in which case the operation is skipped.
+func skippable(err error) bool {
+	if err == errNotSupported || os.IsPermission(err) {
+		return true
+	}
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	if le, ok := err.(*os.LinkError); ok {
+		err = le.Err
+	}
+	return err == syscall.EPERM || err == syscall.ENOTSUP
+}
@@ -0,0 +1,39 @@
+package asm
+
+import (
+	"syscall"
+
+	"github.com/vbatts/tar-split/archive/tar"
+)
+
+func mknod(path string, hdr *tar.Header) error {
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode |= syscall.S_IFCHR
+	case tar.TypeBlock:
+		mode |= syscall.S_IFBLK
+	case tar.TypeFifo:
+		mode |= syscall.S_IFIFO
+	}
+	major, minor := uint64(hdr.Devmajor), uint64(hdr.Devminor)
+	dev := (minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32)
+	return syscall.Mknod(path, mode, int(dev))
+}
+
+func setxattr(path, key, value string) error {
+	return syscall.Setxattr(path, key, []byte(value), 0)
+}
+
+func getxattr(path, key string) (string, error) {
+	size, err := syscall.Getxattr(path, key, nil)
+	if err != nil {
+		return "", err
+	}
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(path, key, value)
+	if err != nil {
+		return "", err
+	}
+	return string(value[:size]), nil
+}
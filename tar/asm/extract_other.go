@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package asm
+
+import "github.com/vbatts/tar-split/archive/tar"
+
+func mknod(path string, hdr *tar.Header) error {
+	return errNotSupported
+}
+
+func setxattr(path, key, value string) error {
+	return errNotSupported
+}
+
+func getxattr(path, key string) (string, error) {
+	return "", errNotSupported
+}
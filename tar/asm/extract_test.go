@@ -0,0 +1,131 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestExtractTarStream(t *testing.T) {
+	meta, fgp := disassemble(t, buildTar(t, testFiles))
+
+	dir, err := ioutil.TempDir("", "tar-split-extract.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ExtractTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	checkExtracted(t, dir)
+}
+
+func TestExtractingInputTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	dir, err := ioutil.TempDir("", "tar-split-extract.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := bytes.NewBuffer(nil)
+	its, err := NewExtractingInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(meta), nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := bytes.NewBuffer(nil)
+	if _, err := io.Copy(out, its); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Error("expected the archive to be passed through as it was")
+	}
+	checkExtracted(t, dir)
+	fi, err := os.Stat(filepath.Join(dir, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("expected the time of the directory to be set, got %s", fi.ModTime())
+	}
+
+	// the extracted files are the payloads to assemble it again
+	assembled := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(storage.NewPathFileGetter(dir), storage.NewJSONUnpacker(meta), assembled); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(assembled.Bytes(), archive) {
+		t.Error("expected the archive assembled from the extracted files as it was")
+	}
+}
+
+// checkExtracted checks that testFiles are extracted to `dir`
+func checkExtracted(t *testing.T, dir string) {
+	for _, f := range testFiles {
+		p := filepath.Join(dir, f.hdr.Name)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if fi.Mode() != f.hdr.FileInfo().Mode() {
+			t.Errorf("%s: expected mode %s, got %s", f.hdr.Name, f.hdr.FileInfo().Mode(), fi.Mode())
+		}
+		if fi.Mode().IsRegular() {
+			buf, err := ioutil.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := f.body
+			if f.hdr.Linkname != "" {
+				body = "imma hurr til I derp"
+			}
+			if string(buf) != body {
+				t.Errorf("%s: expected %q, got %q", f.hdr.Name, body, buf)
+			}
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "dir/derp")); err != nil || target != "hurr.txt" {
+		t.Errorf("expected symlink to hurr.txt, got %q (%v)", target, err)
+	}
+}
+
+func TestExtractTarStreamEscape(t *testing.T) {
+	files := []testFile{
+		{hdr: testFiles[0].hdr},
+		{hdr: testFiles[0].hdr},
+	}
+	files[0].hdr.Name = "link"
+	files[0].hdr.Typeflag = tar.TypeSymlink
+	files[0].hdr.Linkname = "/tmp"
+	files[1].hdr.Name = "link/escaped"
+	files[1].hdr.Typeflag = tar.TypeReg
+	files[1].body = "nope"
+	meta, fgp := disassemble(t, buildTar(t, files))
+
+	dir, err := ioutil.TempDir("", "tar-split-extract.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ExtractTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), dir); err == nil {
+		t.Error("expected extracting beneath a symbolic link to fail")
+	}
+	its, err := NewExtractingInputTarStream(bytes.NewReader(buildTar(t, files)), storage.NewJSONPacker(ioutil.Discard), nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err == nil {
+		t.Error("expected extracting beneath a symbolic link to fail, as it is disassembled")
+	}
+}
@@ -0,0 +1,218 @@
+package asm
+
+import (
+	"compress/gzip"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// OutputFilter transforms the assembled tar stream on its way out, like
+// compressing, encrypting, throttling or digesting it.
+type OutputFilter interface {
+	// Wrap returns a writer that writes the filtered stream to `w`. It is
+	// closed once the whole stream is written through it.
+	Wrap(w io.Writer) (io.WriteCloser, error)
+	// Record describes what the filter did, once the stream is written, so
+	// that consumers know how to reverse it
+	Record() FilterRecord
+}
+
+// FilterRecord is an OutputFilter applied to an assembled stream, as returned
+// by WriteFilteredTarStream
+type FilterRecord struct {
+	// Name of the filter, like "gzip"
+	Name string `json:"name"`
+	// Params of the filter, like the digest that was computed
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// WriteFilteredTarStream writes the assembled tar archive to `w`, through the
+// filters in the order they are given, i.e. the first filter gets the tar
+// stream itself. It returns the record of the filter chain, in the same
+// order, to be kept with the output (see NewUnfilterReader).
+func WriteFilteredTarStream(fg storage.FileGetter, up storage.Unpacker, w io.Writer, filters ...OutputFilter) ([]FilterRecord, error) {
+	writers := make([]io.WriteCloser, len(filters))
+	out := w
+	for i := len(filters) - 1; i >= 0; i-- {
+		fw, err := filters[i].Wrap(out)
+		if err != nil {
+			return nil, err
+		}
+		writers[i], out = fw, fw
+	}
+	if err := WriteOutputTarStream(fg, up, out); err != nil {
+		return nil, err
+	}
+	// each filter writes to the next, so they are flushed from the first
+	for _, fw := range writers {
+		if err := fw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	chain := make([]FilterRecord, len(filters))
+	for i, f := range filters {
+		chain[i] = f.Record()
+	}
+	return chain, nil
+}
+
+// NewUnfilterReader reverses a filter chain recorded by
+// WriteFilteredTarStream, reading the tar archive back from `r`. Filters that
+// need a secret to be reversed, like encryption, are not supported here; those
+// streams must be decrypted first, and the chain given from after it.
+func NewUnfilterReader(r io.Reader, chain []FilterRecord) (io.Reader, error) {
+	for i := len(chain) - 1; i >= 0; i-- {
+		switch chain[i].Name {
+		case "gzip":
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			r = gz
+		case "digest", "throttle":
+			// the stream passed through unchanged
+		default:
+			return nil, fmt.Errorf("asm: can not reverse the %q filter", chain[i].Name)
+		}
+	}
+	return r, nil
+}
+
+// GzipFilter compresses the stream at the given compression level (see
+// compress/gzip)
+func GzipFilter(level int) OutputFilter {
+	return &gzipFilter{level: level}
+}
+
+type gzipFilter struct {
+	level int
+}
+
+func (f *gzipFilter) Wrap(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, f.level)
+}
+
+func (f *gzipFilter) Record() FilterRecord {
+	return FilterRecord{Name: "gzip", Params: map[string]string{"level": strconv.Itoa(f.level)}}
+}
+
+// DigestFilter digests the stream as it passes through unchanged, with the
+// algorithm "sha256" or "sha512". The digest is recorded as the "digest"
+// param, like "sha256:<hex>", and is of the stream at its place in the chain.
+func DigestFilter(algorithm string) (OutputFilter, error) {
+	switch algorithm {
+	case "sha256", "sha512":
+	default:
+		return nil, fmt.Errorf("asm: unknown digest algorithm %q", algorithm)
+	}
+	return &digestFilter{algorithm: algorithm}, nil
+}
+
+type digestFilter struct {
+	algorithm string
+	h         hash.Hash
+}
+
+func (f *digestFilter) Wrap(w io.Writer) (io.WriteCloser, error) {
+	if f.algorithm == "sha512" {
+		f.h = sha512.New()
+	} else {
+		f.h = sha256.New()
+	}
+	return nopWriteCloser{io.MultiWriter(w, f.h)}, nil
+}
+
+func (f *digestFilter) Record() FilterRecord {
+	rec := FilterRecord{Name: "digest", Params: map[string]string{"algorithm": f.algorithm}}
+	if f.h != nil {
+		rec.Params["digest"] = f.algorithm + ":" + hex.EncodeToString(f.h.Sum(nil))
+	}
+	return rec
+}
+
+// ThrottleFilter limits the stream to about the given bytes per second
+func ThrottleFilter(bytesPerSecond int64) OutputFilter {
+	return &throttleFilter{rate: bytesPerSecond}
+}
+
+type throttleFilter struct {
+	rate int64
+}
+
+func (f *throttleFilter) Wrap(w io.Writer) (io.WriteCloser, error) {
+	if f.rate <= 0 {
+		return nil, fmt.Errorf("asm: invalid throttle rate %d", f.rate)
+	}
+	return &throttledWriter{w: w, rate: f.rate, start: time.Now()}, nil
+}
+
+func (f *throttleFilter) Record() FilterRecord {
+	return FilterRecord{Name: "throttle", Params: map[string]string{"rate": strconv.FormatInt(f.rate, 10)}}
+}
+
+type throttledWriter struct {
+	w       io.Writer
+	rate    int64
+	start   time.Time
+	written int64
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		// at most about a tenth of a second of the rate at a time
+		chunk := p
+		if max := tw.rate/10 + 1; int64(len(chunk)) > max {
+			chunk = chunk[:max]
+		}
+		m, err := tw.w.Write(chunk)
+		n += m
+		tw.written += int64(m)
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+		due := time.Duration(tw.written * int64(time.Second) / tw.rate)
+		if wait := due - time.Since(tw.start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, nil
+}
+
+func (tw *throttledWriter) Close() error { return nil }
+
+// CipherFilter encrypts the stream with a stream cipher (like AES in CTR
+// mode, see crypto/cipher). The name is recorded for the consumer to pick the
+// cipher to decrypt with, and the key and IV are left to the caller.
+func CipherFilter(name string, stream cipher.Stream) OutputFilter {
+	return &cipherFilter{name: name, stream: stream}
+}
+
+type cipherFilter struct {
+	name   string
+	stream cipher.Stream
+}
+
+func (f *cipherFilter) Wrap(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{cipher.StreamWriter{S: f.stream, W: w}}, nil
+}
+
+func (f *cipherFilter) Record() FilterRecord {
+	return FilterRecord{Name: "cipher", Params: map[string]string{"cipher": f.name}}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
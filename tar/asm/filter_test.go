@@ -0,0 +1,80 @@
+package asm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestWriteFilteredTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+
+	digest, err := DigestFilter("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	chain, err := WriteFilteredTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), buf, digest, GzipFilter(9), ThrottleFilter(1<<30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[0].Name != "digest" || chain[1].Name != "gzip" || chain[2].Name != "throttle" {
+		t.Fatalf("unexpected filter chain %v", chain)
+	}
+	sum := sha256.Sum256(archive)
+	if chain[0].Params["digest"] != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the digest of the archive, got %q", chain[0].Params["digest"])
+	}
+
+	r, err := NewUnfilterReader(buf, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, archive) {
+		t.Errorf("expected the unfiltered stream to be the archive")
+	}
+}
+
+func TestCipherFilter(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+
+	key, iv := make([]byte, 16), make([]byte, aes.BlockSize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	chain, err := WriteFilteredTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), buf, GzipFilter(1), CipherFilter("aes-ctr", cipher.NewCTR(block, iv)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewUnfilterReader(bytes.NewReader(buf.Bytes()), chain); err == nil {
+		t.Error("expected an error reversing the encryption without a key")
+	}
+
+	// decrypt, and reverse the rest of the chain
+	decrypted := cipher.StreamReader{S: cipher.NewCTR(block, iv), R: buf}
+	r, err := NewUnfilterReader(decrypted, chain[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, archive) {
+		t.Errorf("expected the decrypted stream to be the archive")
+	}
+}
@@ -0,0 +1,273 @@
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ErrGzipNotReproducible is returned by NewGzipWriter for the parameters of a
+// blob that compress/gzip does not compress the same
+var ErrGzipNotReproducible = errors.New("asm: the gzip blob can not be reproduced")
+
+// ErrGzipSize is returned by the writer of NewGzipWriter when what is written
+// is not the size of the members of the blob
+var ErrGzipSize = errors.New("asm: the data does not fit the members of the gzip blob")
+
+// gzipLevels are the levels of compress/gzip tried by FingerprintGzip, with
+// that of gzip.DefaultCompression first, as the most likely
+var gzipLevels = []int{6, gzip.NoCompression, 1, 2, 3, 4, 5, 7, 8, 9}
+
+// FingerprintGzip reads the gzip blob `r` to the end, and returns the
+// parameters to compress its data to the same blob with NewGzipWriter. The
+// data of the blob is decompressed as it is compressed again at each level
+// of compress/gzip, and the levels are dropped as soon as their output
+// differs from the blob, so it is about as fast as a decompression unless
+// one matches.
+//
+// Only blobs that were compressed with compress/gzip (as most container
+// layers are) can be reproduced, and then only by a Go with the same
+// compression, which has changed between versions. Otherwise the parameters
+// are not Reproducible, but still record the headers and sizes of the
+// members of the blob.
+func FingerprintGzip(r io.Reader) (storage.GzipParams, error) {
+	var params storage.GzipParams
+	f := &gzipFingerprinter{br: bufio.NewReader(r)}
+	for _, level := range gzipLevels {
+		f.candidates = append(f.candidates, &gzipCandidate{level: level})
+	}
+	f.recording = true
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return params, err
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		zr.Multistream(false)
+		member := storage.GzipMember{
+			Name:    zr.Name,
+			Comment: zr.Comment,
+			Extra:   zr.Extra,
+			OS:      zr.OS,
+		}
+		if !zr.ModTime.IsZero() {
+			member.ModTime = zr.ModTime.Unix()
+		}
+		for _, c := range f.candidates {
+			c.start(member)
+		}
+		for {
+			n, err := zr.Read(buf)
+			member.Size += int64(n)
+			for _, c := range f.candidates {
+				c.zw.Write(buf[:n])
+			}
+			f.compare()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return params, err
+			}
+		}
+		for _, c := range f.candidates {
+			c.zw.Close()
+		}
+		f.compare()
+		// what a candidate did not compress to, of the member, it misses
+		alive := f.candidates[:0]
+		for _, c := range f.candidates {
+			if c.pending.Len() == 0 && c.matched == f.base+int64(len(f.orig)) {
+				alive = append(alive, c)
+			}
+		}
+		f.candidates = alive
+		params.Members = append(params.Members, member)
+
+		f.orig, f.base, f.recording = f.orig[:0], 0, len(f.candidates) > 0
+		if err := zr.Reset(f); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return params, err
+		}
+	}
+	if len(f.candidates) > 0 {
+		params.Reproducible, params.Level = true, f.candidates[0].level
+	}
+	return params, nil
+}
+
+// gzipFingerprinter reads the gzip blob for its decompression, keeping the
+// bytes of the current member that not all of the candidates have been
+// compared to. It is a flate.Reader, for the decompression to read no more
+// of the blob than it decompresses.
+type gzipFingerprinter struct {
+	br         *bufio.Reader
+	recording  bool
+	orig       []byte
+	base       int64 // the offset in the member of orig
+	candidates []*gzipCandidate
+}
+
+func (f *gzipFingerprinter) Read(p []byte) (int, error) {
+	n, err := f.br.Read(p)
+	if f.recording {
+		f.orig = append(f.orig, p[:n]...)
+	}
+	return n, err
+}
+
+func (f *gzipFingerprinter) ReadByte() (byte, error) {
+	b, err := f.br.ReadByte()
+	if err == nil && f.recording {
+		f.orig = append(f.orig, b)
+	}
+	return b, err
+}
+
+// compare drops the candidates whose output differs from the member, and
+// the bytes of the member that all those left have matched
+func (f *gzipFingerprinter) compare() {
+	alive := f.candidates[:0]
+	for _, c := range f.candidates {
+		avail := f.orig[c.matched-f.base:]
+		n := c.pending.Len()
+		if n > len(avail) {
+			n = len(avail)
+		}
+		if !bytes.Equal(c.pending.Next(n), avail[:n]) {
+			continue
+		}
+		c.matched += int64(n)
+		alive = append(alive, c)
+	}
+	f.candidates = alive
+	if len(alive) == 0 {
+		f.orig, f.recording = nil, false
+		return
+	}
+	least := alive[0].matched
+	for _, c := range alive[1:] {
+		if c.matched < least {
+			least = c.matched
+		}
+	}
+	f.orig = append(f.orig[:0], f.orig[least-f.base:]...)
+	f.base = least
+}
+
+// gzipCandidate is the compression of the member at a level, and how much of
+// it matched the member so far
+type gzipCandidate struct {
+	level   int
+	zw      *gzip.Writer
+	pending bytes.Buffer
+	matched int64
+}
+
+func (c *gzipCandidate) start(member storage.GzipMember) {
+	c.pending.Reset()
+	c.matched = 0
+	if c.zw == nil {
+		// the level is valid, so there is no error
+		c.zw, _ = gzip.NewWriterLevel(&c.pending, c.level)
+	} else {
+		c.zw.Reset(&c.pending)
+	}
+	setGzipHeader(c.zw, member)
+}
+
+func setGzipHeader(zw *gzip.Writer, member storage.GzipMember) {
+	zw.Name = member.Name
+	zw.Comment = member.Comment
+	zw.Extra = member.Extra
+	zw.OS = member.OS
+	zw.ModTime = time.Unix(member.ModTime, 0)
+}
+
+// NewGzipWriter returns a writer that compresses what is written to it to
+// `w`, as the blob of `params` was, from FingerprintGzip. What is written
+// must be the data of the blob, as the members are split by their size, and
+// the writer must be closed to finish the last member. The blob is then the
+// same, if compress/gzip compresses as it did when it was fingerprinted.
+func NewGzipWriter(w io.Writer, params storage.GzipParams) (io.WriteCloser, error) {
+	if !params.Reproducible || len(params.Members) == 0 {
+		return nil, ErrGzipNotReproducible
+	}
+	zw, err := gzip.NewWriterLevel(w, params.Level)
+	if err != nil {
+		return nil, err
+	}
+	gw := &gzipWriter{w: w, zw: zw, members: params.Members}
+	setGzipHeader(zw, gw.members[0])
+	gw.left = gw.members[0].Size
+	return gw, nil
+}
+
+type gzipWriter struct {
+	w       io.Writer
+	zw      *gzip.Writer
+	members []storage.GzipMember
+	i       int
+	left    int64 // of the data of the current member
+}
+
+// next finishes the current member, and starts the next one
+func (gw *gzipWriter) next() error {
+	if err := gw.zw.Close(); err != nil {
+		return err
+	}
+	gw.i++
+	gw.zw.Reset(gw.w)
+	setGzipHeader(gw.zw, gw.members[gw.i])
+	gw.left = gw.members[gw.i].Size
+	return nil
+}
+
+func (gw *gzipWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		for gw.left == 0 && gw.i < len(gw.members)-1 {
+			if err := gw.next(); err != nil {
+				return written, err
+			}
+		}
+		if gw.left == 0 {
+			return written, ErrGzipSize
+		}
+		chunk := p
+		if int64(len(chunk)) > gw.left {
+			chunk = chunk[:gw.left]
+		}
+		n, err := gw.zw.Write(chunk)
+		written += n
+		gw.left -= int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close finishes the members left, which are to be empty
+func (gw *gzipWriter) Close() error {
+	for gw.left == 0 && gw.i < len(gw.members)-1 {
+		if err := gw.next(); err != nil {
+			return err
+		}
+	}
+	if err := gw.zw.Close(); err != nil {
+		return err
+	}
+	if gw.left != 0 {
+		return ErrGzipSize
+	}
+	return nil
+}
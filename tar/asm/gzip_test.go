@@ -0,0 +1,132 @@
+package asm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// gzipMember returns `data` compressed at `level`, with a header
+func gzipMember(t *testing.T, data []byte, level int, name string) []byte {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Name = name
+	zw.ModTime = time.Unix(1500000000, 0)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func recompress(t *testing.T, params storage.GzipParams, data []byte) []byte {
+	var buf bytes.Buffer
+	gw, err := NewGzipWriter(&buf, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in writes across the members
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := gw.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFingerprintGzip(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	archive = append(archive, bytes.Repeat(archive, 50)...)
+	for _, level := range []int{gzip.DefaultCompression, gzip.BestSpeed, gzip.BestCompression, gzip.NoCompression} {
+		blob := gzipMember(t, archive, level, "layer.tar")
+		params, err := FingerprintGzip(bytes.NewReader(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !params.Reproducible || len(params.Members) != 1 {
+			t.Fatalf("level %d: expected a reproducible blob of one member, got %+v", level, params)
+		}
+		m := params.Members[0]
+		if m.Name != "layer.tar" || m.ModTime != 1500000000 || m.Size != int64(len(archive)) {
+			t.Errorf("level %d: unexpected member %+v", level, m)
+		}
+		if got := recompress(t, params, archive); !bytes.Equal(got, blob) {
+			t.Errorf("level %d: expected the blob recompressed the same", level)
+		}
+	}
+}
+
+func TestFingerprintGzipMembers(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	half := len(archive) / 2
+	blob := gzipMember(t, archive[:half], gzip.BestSpeed, "")
+	blob = append(blob, gzipMember(t, nil, gzip.BestSpeed, "")...)
+	blob = append(blob, gzipMember(t, archive[half:], gzip.BestSpeed, "")...)
+
+	params, err := FingerprintGzip(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !params.Reproducible || params.Level != gzip.BestSpeed || len(params.Members) != 3 {
+		t.Fatalf("expected a reproducible blob of 3 members, got %+v", params)
+	}
+	if params.Members[0].Size != int64(half) || params.Members[1].Size != 0 || params.Members[2].Size != int64(len(archive)-half) {
+		t.Errorf("unexpected sizes of the members %+v", params.Members)
+	}
+	if got := recompress(t, params, archive); !bytes.Equal(got, blob) {
+		t.Errorf("expected the members recompressed the same")
+	}
+
+	var buf bytes.Buffer
+	gw, err := NewGzipWriter(&buf, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gw.Write(append(archive, 'x')); err != ErrGzipSize {
+		t.Errorf("expected ErrGzipSize writing past the members, got %v", err)
+	}
+	gw, _ = NewGzipWriter(&buf, params)
+	io.WriteString(gw, "short")
+	if err := gw.Close(); err != ErrGzipSize {
+		t.Errorf("expected ErrGzipSize closing short of the members, got %v", err)
+	}
+}
+
+func TestFingerprintGzipNotReproducible(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	blob := gzipMember(t, archive, gzip.DefaultCompression, "")
+	// as if written by another gzip, that sets the extra flags otherwise
+	blob[8] = 2
+
+	params, err := FingerprintGzip(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Reproducible || len(params.Members) != 1 || params.Members[0].Size != int64(len(archive)) {
+		t.Errorf("expected the member of a blob that is not reproducible, got %+v", params)
+	}
+	if _, err := NewGzipWriter(ioutil.Discard, params); err != ErrGzipNotReproducible {
+		t.Errorf("expected ErrGzipNotReproducible, got %v", err)
+	}
+	if _, err := FingerprintGzip(bytes.NewReader([]byte("not gzip"))); err == nil {
+		t.Error("expected an error for a blob that is not gzip")
+	}
+}
@@ -0,0 +1,157 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// HeaderReader reads the FileType entries of packed metadata, along with the
+// tar.Header parsed from the raw segments that precede each of them. This
+// provides the full header information of an archive from its metadata alone.
+type HeaderReader struct {
+	up      storage.Unpacker
+	raw     bytes.Buffer
+	pad     int64
+	padding []byte
+	header  []byte
+	trailer []byte
+}
+
+// NewHeaderReader returns a HeaderReader for the metadata read from `up`
+func NewHeaderReader(up storage.Unpacker) *HeaderReader {
+	return &HeaderReader{up: up}
+}
+
+// Next returns the header and entry of the next file in the archive. At the
+// end of the metadata, io.EOF is returned.
+func (hr *HeaderReader) Next() (*tar.Header, *storage.Entry, error) {
+	for {
+		entry, err := hr.up.Next()
+		if err != nil {
+			if err == io.EOF {
+				raw := hr.raw.Bytes()
+				if int64(len(raw)) < hr.pad {
+					hr.padding = raw
+				} else {
+					hr.padding, hr.trailer = raw[:hr.pad], raw[hr.pad:]
+				}
+			}
+			return nil, nil, err
+		}
+		if entry.Type == storage.SegmentType {
+			hr.raw.Write(entry.Payload)
+			continue
+		}
+		if entry.Type != storage.FileType {
+			continue
+		}
+
+		// the raw bytes start with the padding of the prior file's payload
+		raw := hr.raw.Bytes()
+		if int64(len(raw)) < hr.pad {
+			return nil, nil, fmt.Errorf("asm: missing header of %q", entry.GetName())
+		}
+		hr.padding = append([]byte(nil), raw[:hr.pad]...)
+		hr.header = append([]byte(nil), raw[hr.pad:]...)
+		hdr, err := tar.NewReader(bytes.NewReader(hr.header)).Next()
+		hr.raw.Reset()
+		if err != nil {
+			return nil, nil, fmt.Errorf("asm: parsing header of %q: %v", entry.GetName(), err)
+		}
+		hr.pad = 0
+		if !isHeaderOnlyType(hdr.Typeflag) {
+			hr.pad = -entry.DataSize() & (blockSize - 1)
+		}
+		return hdr, entry, nil
+	}
+}
+
+// RawHeader returns the raw bytes of the header(s) of the file last returned
+// by Next, including any PAX or GNU extended headers, but not the padding of
+// the file before it.
+func (hr *HeaderReader) RawHeader() []byte {
+	return hr.header
+}
+
+// Padding returns the raw bytes of the padding of the payload of the file
+// before the one last returned by Next, as they are in the archive, which are
+// not always zeros. Once Next has returned io.EOF, it is the padding of the
+// last file in the archive.
+func (hr *HeaderReader) Padding() []byte {
+	return hr.padding
+}
+
+// Trailer returns the raw bytes following the padding of the last file in the
+// archive, which are usually the end of archive marker. It is only available
+// once Next has returned io.EOF.
+func (hr *HeaderReader) Trailer() []byte {
+	return hr.trailer
+}
+
+const blockSize = 512
+
+// isHeaderOnlyType checks if the given type flag is of the type that has no
+// data section even if a size is specified.
+func isHeaderOnlyType(flag byte) bool {
+	switch flag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseNumeric parses a numeric field of a raw header block, in either octal
+// or the GNU base-256 encoding.
+func parseNumeric(field []byte) (int64, error) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		var n int64
+		for i, c := range field {
+			if i == 0 {
+				c &= 0x7f
+			}
+			if n > (1<<55)-1 {
+				return 0, errors.New("asm: numeric field out of range")
+			}
+			n = n<<8 | int64(c)
+		}
+		return n, nil
+	}
+	var n int64
+	for _, c := range bytes.Trim(field, " \x00") {
+		if c < '0' || c > '7' {
+			return 0, fmt.Errorf("asm: invalid numeric field %q", field)
+		}
+		n = n<<3 | int64(c-'0')
+	}
+	return n, nil
+}
+
+// formatNumeric formats `n` into a numeric field of a raw header block, in
+// octal if it fits, and otherwise the GNU base-256 encoding.
+func formatNumeric(field []byte, n int64) {
+	if s := fmt.Sprintf("%0*o", len(field)-1, n); len(s) < len(field) {
+		copy(field, s+"\x00")
+		return
+	}
+	for i := len(field) - 1; i >= 0; i-- {
+		field[i] = byte(n)
+		n >>= 8
+	}
+	field[0] |= 0x80
+}
+
+// setChecksum sets the checksum field of a raw header block
+func setChecksum(block []byte) {
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, c := range block {
+		sum += int64(c)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+}
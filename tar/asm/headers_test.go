@@ -0,0 +1,94 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+type testFile struct {
+	hdr  tar.Header
+	body string
+}
+
+var testFiles = []testFile{
+	{hdr: tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}},
+	{hdr: tar.Header{Name: "dir/hurr.txt", Typeflag: tar.TypeReg, Mode: 0644}, body: "imma hurr til I derp"},
+	{hdr: tar.Header{Name: "dir/derp", Typeflag: tar.TypeSymlink, Linkname: "hurr.txt", Mode: 0777}},
+	{hdr: tar.Header{Name: "dir/hurr2.txt", Typeflag: tar.TypeLink, Linkname: "dir/hurr.txt", Mode: 0644}},
+	{hdr: tar.Header{Name: "dir/" + string(bytes.Repeat([]byte("long"), 50)), Typeflag: tar.TypeReg, Mode: 0600}, body: "a long name"},
+	{hdr: tar.Header{Name: "empty", Typeflag: tar.TypeReg, Mode: 0644}},
+}
+
+// buildTar returns a tar archive of the files
+func buildTar(t testing.TB, files []testFile) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		hdr := f.hdr
+		hdr.Size = int64(len(f.body))
+		if hdr.ModTime.IsZero() {
+			hdr.ModTime = time.Unix(1500000000, 0)
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, f.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// disassemble returns the packed metadata of the archive, and a FileGetPutter
+// with its payloads
+func disassemble(t testing.TB, archive []byte) ([]byte, storage.FileGetPutter) {
+	meta := bytes.NewBuffer(nil)
+	fgp := storage.NewBufferFileGetPutter()
+	rdr, err := NewInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(meta), fgp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+		t.Fatal(err)
+	}
+	return meta.Bytes(), fgp
+}
+
+func TestHeaderReader(t *testing.T) {
+	meta, _ := disassemble(t, buildTar(t, testFiles))
+	hr := NewHeaderReader(storage.NewJSONUnpacker(bytes.NewReader(meta)))
+	for i := 0; ; i++ {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				if i != len(testFiles) {
+					t.Errorf("expected %d headers, got %d", len(testFiles), i)
+				}
+				break
+			}
+			t.Fatal(err)
+		}
+		expected := testFiles[i].hdr
+		if hdr.Name != expected.Name || hdr.Name != entry.GetName() {
+			t.Errorf("expected name %q, got %q (entry %q)", expected.Name, hdr.Name, entry.GetName())
+		}
+		if hdr.Typeflag != expected.Typeflag || hdr.Linkname != expected.Linkname || hdr.Mode != expected.Mode {
+			t.Errorf("%s: header mismatch %#v", hdr.Name, hdr)
+		}
+		if entry.GetLinkname() != expected.Linkname || (entry.Link != "") != (expected.Linkname != "") {
+			t.Errorf("%s: expected the link to %q recorded, got %q %q", hdr.Name, expected.Linkname, entry.Link, entry.GetLinkname())
+		}
+		if hdr.Size != int64(len(testFiles[i].body)) {
+			t.Errorf("%s: expected size %d, got %d", hdr.Name, len(testFiles[i].body), hdr.Size)
+		}
+	}
+}
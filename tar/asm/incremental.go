@@ -0,0 +1,87 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+const (
+	// typeGNUDumpDir is the type flag of a directory in a GNU incremental
+	// archive, with a payload listing its contents
+	typeGNUDumpDir = 'D'
+	// typeGNUNames is the type flag of the old GNU record of renames and
+	// symbolic links
+	typeGNUNames = 'N'
+)
+
+// specialKind returns the storage.Entry Special kind for the type flag, or
+// an empty string if the entry's payload is an ordinary file.
+func specialKind(flag byte) string {
+	switch flag {
+	case typeGNUDumpDir:
+		return storage.GNUDumpDir
+	case typeGNUNames:
+		return storage.GNUNames
+	}
+	return ""
+}
+
+// setInline reads the payload of a special entry from `r` into the entry,
+// rather than storing it with a FilePutter.
+func setInline(entry *storage.Entry, special string, r io.Reader) error {
+	payload, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(payload)) != entry.Size {
+		return io.ErrUnexpectedEOF
+	}
+	crcHash := crc64.New(storage.CRCTable)
+	crcHash.Write(payload)
+	entry.Special = special
+	entry.Inline = payload
+	entry.Payload = crcHash.Sum(nil)
+	return nil
+}
+
+// ErrInvalidDumpDir is returned when the payload of a GNU dumpdir can not be
+// parsed.
+var ErrInvalidDumpDir = errors.New("asm: invalid GNU dumpdir")
+
+// DumpDirRecord is one record of the payload of a GNU dumpdir
+type DumpDirRecord struct {
+	// Code is 'Y' for a file included in the archive, 'N' for one that is
+	// not, 'D' for a directory, 'R' and 'T' for the source and target of a
+	// rename, and 'X' for a temporary directory name.
+	Code byte
+	Name string
+}
+
+// ParseDumpDir parses the Inline payload of a storage.GNUDumpDir entry, which
+// lists the contents of the directory at the time of the backup.
+func ParseDumpDir(payload []byte) ([]DumpDirRecord, error) {
+	var records []DumpDirRecord
+	for len(payload) > 0 {
+		i := bytes.IndexByte(payload, 0)
+		if i < 0 {
+			return nil, ErrInvalidDumpDir
+		}
+		if i == 0 {
+			// the list ends with an empty record
+			break
+		}
+		switch payload[0] {
+		case 'Y', 'N', 'D', 'R', 'T', 'X':
+		default:
+			return nil, ErrInvalidDumpDir
+		}
+		records = append(records, DumpDirRecord{Code: payload[0], Name: string(payload[1:i])})
+		payload = payload[i+1:]
+	}
+	return records, nil
+}
@@ -0,0 +1,135 @@
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+var testIncrementalFiles = []testFile{
+	{hdr: tar.Header{Name: "dir/", Typeflag: typeGNUDumpDir, Mode: 0755}, body: "Yhurr.txt\x00Nold.txt\x00Dsub\x00\x00"},
+	{hdr: tar.Header{Name: "dir/hurr.txt", Typeflag: tar.TypeReg, Mode: 0644}, body: "imma hurr til I derp"},
+	{hdr: tar.Header{Name: "dir/derp", Typeflag: tar.TypeSymlink, Linkname: strings.Repeat("long/", 30) + "hurr.txt", Mode: 0777}},
+	{hdr: tar.Header{Name: "././@renames", Typeflag: typeGNUNames, Mode: 0644}, body: "Rdir/old.txt\x00Tdir/new.txt\x00\x00"},
+}
+
+func TestIncrementalRoundTrip(t *testing.T) {
+	archive := buildTar(t, testIncrementalFiles)
+	meta, fgp := disassemble(t, archive)
+
+	// special payloads are not stored as files
+	names, err := fgp.(storage.FileLister).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "dir/hurr.txt" {
+		t.Errorf("expected only dir/hurr.txt to be stored, got %q", names)
+	}
+
+	up := storage.NewJSONUnpacker(bytes.NewReader(meta))
+	var specials []string
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			break
+		}
+		if entry.Special == "" {
+			continue
+		}
+		specials = append(specials, entry.GetName()+":"+entry.Special)
+		if entry.Special == storage.GNUDumpDir {
+			records, err := ParseDumpDir(entry.Inline)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []DumpDirRecord{{'Y', "hurr.txt"}, {'N', "old.txt"}, {'D', "sub"}}
+			if len(records) != len(expected) {
+				t.Fatalf("expected %d dumpdir records, got %d", len(expected), len(records))
+			}
+			for i := range records {
+				if records[i] != expected[i] {
+					t.Errorf("expected dumpdir record %v, got %v", expected[i], records[i])
+				}
+			}
+		}
+	}
+	if strings.Join(specials, ",") != "dir/:gnu-dumpdir,././@renames:gnu-names" {
+		t.Errorf("unexpected special entries: %q", specials)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), archive) {
+		t.Errorf("reassembled %d bytes, expected %d bytes", buf.Len(), len(archive))
+	}
+
+	dir, err := ioutil.TempDir("", "tar-split-incremental")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ExtractTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), dir); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "dir")); err != nil || !fi.IsDir() {
+		t.Errorf("expected the dumpdir to be extracted as a directory: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "@renames")); !os.IsNotExist(err) {
+		t.Errorf("expected the names record to not be extracted: %v", err)
+	}
+}
+
+func TestParseDumpDirInvalid(t *testing.T) {
+	for _, payload := range []string{"Yunterminated", "Qbad\x00\x00"} {
+		if _, err := ParseDumpDir([]byte(payload)); err != ErrInvalidDumpDir {
+			t.Errorf("%q: expected ErrInvalidDumpDir, got %v", payload, err)
+		}
+	}
+}
+
+func TestIncrementalCorruptInline(t *testing.T) {
+	archive := buildTar(t, testIncrementalFiles)
+	meta, fgp := disassemble(t, archive)
+
+	for _, tc := range []struct {
+		desc    string
+		corrupt func([]byte) []byte
+	}{
+		{"changed", func(b []byte) []byte { b[0] = 'N'; return b }},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-1] }},
+	} {
+		up := storage.NewJSONUnpacker(bytes.NewReader(meta))
+		corrupted := bytes.NewBuffer(nil)
+		p := storage.NewJSONPacker(corrupted)
+		for {
+			entry, err := up.Next()
+			if err != nil {
+				break
+			}
+			if entry.Special == storage.GNUDumpDir {
+				entry.Inline = tc.corrupt(append([]byte(nil), entry.Inline...))
+			}
+			if _, err := p.AddEntry(*entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(corrupted.Bytes())), ioutil.Discard)
+		if err == nil {
+			t.Errorf("%s: expected the inline payload to fail", tc.desc)
+		} else if _, ok := err.(*ChecksumError); ok != (tc.desc == "changed") {
+			t.Errorf("%s: unexpected error %v", tc.desc, err)
+		}
+		if _, err := NewTarReadSeeker(fgp, storage.NewJSONUnpacker(bytes.NewReader(corrupted.Bytes()))); err == nil {
+			t.Errorf("%s: expected the inline payload to fail to seek", tc.desc)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ArchiveInfo is the layout that disassembling an archive would record, from
+// InspectTarStream
+type ArchiveInfo struct {
+	// Size of the tar stream
+	Size int64
+	// Segments and SegmentBytes are of the raw segments (headers and padding)
+	Segments     int
+	SegmentBytes int64
+	// Files is the number of entries, and Payloads those with a payload to be
+	// stored, of PayloadBytes in all
+	Files        int
+	Payloads     int
+	PayloadBytes int64
+	// Types is the number of entries of each kind, like "regular" or "dir"
+	Types map[string]int
+	// PAXHeaders is the number of entries with PAX extended headers
+	PAXHeaders int
+	// Sparse, LongNames and InvalidUTF8 are the names of the entries that are
+	// sparse files, that have names or link targets too long for the header
+	// (in GNU or PAX extended headers), and that are not valid UTF-8 (which are
+	// recorded as raw bytes)
+	Sparse      []string
+	LongNames   []string
+	InvalidUTF8 []string
+	// TrailerBytes is the size of what follows the last entry, which is
+	// usually the 1024 bytes of the end of archive marker
+	TrailerBytes int
+}
+
+// InspectTarStream reads the archive `r` as disassembly would, without
+// producing metadata or storing payloads, and reports the layout it would
+// record and its unusual constructs. It is for assessing archives before
+// committing them to a store.
+func InspectTarStream(r io.Reader) (ArchiveInfo, error) {
+	info := ArchiveInfo{Types: map[string]int{}}
+	entries := make(chanPacker)
+	done := make(chan error, 1)
+	go func() {
+		its, err := NewInputTarStream(r, entries, storage.NewDiscardFilePutter())
+		if err == nil {
+			info.Size, err = io.Copy(ioutil.Discard, its)
+		}
+		close(entries)
+		done <- err
+	}()
+
+	hr := NewHeaderReader(&countingUnpacker{up: entries, info: &info})
+	var err error
+	for {
+		var hdr *tar.Header
+		var entry *storage.Entry
+		hdr, entry, err = hr.Next()
+		if err != nil {
+			break
+		}
+		name := entry.GetName()
+		info.Types[TypeName(hdr.Typeflag)]++
+		raw := hr.RawHeader()
+		if hdr.Typeflag == tar.TypeGNUSparse || extendedHeaderHas(raw, 'x', "GNU.sparse.") {
+			info.Sparse = append(info.Sparse, name)
+		}
+		if extendedHeaderHas(raw, 'L', "") || extendedHeaderHas(raw, 'K', "") || extendedHeaderHas(raw, 'x', " path=") || extendedHeaderHas(raw, 'x', " linkpath=") {
+			info.LongNames = append(info.LongNames, name)
+		}
+		if extendedHeaderHas(raw, 'x', "") {
+			info.PAXHeaders++
+		}
+		if !utf8.ValidString(name) {
+			info.InvalidUTF8 = append(info.InvalidUTF8, name)
+		}
+	}
+	// drained, so that the disassembly finishes when the headers could not be
+	// read
+	for range entries {
+	}
+	if derr := <-done; derr != nil {
+		return info, derr
+	}
+	if err != io.EOF {
+		return info, err
+	}
+	info.TrailerBytes = len(hr.Trailer())
+	return info, nil
+}
+
+// chanPacker is a Packer that sends each entry to be unpacked by another
+// goroutine, until it is closed
+type chanPacker chan storage.Entry
+
+func (cp chanPacker) AddEntry(e storage.Entry) (int, error) {
+	cp <- e
+	return 0, nil
+}
+
+func (cp chanPacker) Next() (*storage.Entry, error) {
+	e, ok := <-cp
+	if !ok {
+		return nil, io.EOF
+	}
+	return &e, nil
+}
+
+// countingUnpacker counts the entries read through it
+type countingUnpacker struct {
+	up   storage.Unpacker
+	info *ArchiveInfo
+}
+
+func (cu *countingUnpacker) Next() (*storage.Entry, error) {
+	e, err := cu.up.Next()
+	if err != nil {
+		return nil, err
+	}
+	switch e.Type {
+	case storage.SegmentType:
+		cu.info.Segments++
+		cu.info.SegmentBytes += int64(len(e.Payload))
+	case storage.FileType:
+		cu.info.Files++
+		if e.Size > 0 {
+			cu.info.Payloads++
+			cu.info.PayloadBytes += e.Size
+		}
+	}
+	return e, nil
+}
+
+// extendedHeaderHas is whether the raw header blocks of an entry have an
+// extended header of `flag`, whose data contains `s`
+func extendedHeaderHas(raw []byte, flag byte, s string) bool {
+	for len(raw) >= blockSize {
+		block := raw[:blockSize]
+		raw = raw[blockSize:]
+		switch block[156] {
+		case 'x', 'L', 'K', 'g':
+			size, err := parseNumeric(block[124:136])
+			padded := size + (-size & (blockSize - 1))
+			if err != nil || padded > int64(len(raw)) {
+				return false
+			}
+			if block[156] == flag && bytes.Contains(raw[:size], []byte(s)) {
+				return true
+			}
+			raw = raw[padded:]
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// TypeName is a short name of the kind of entry of `flag`, like "regular" or
+// "dir", as in ArchiveInfo.Types
+func TypeName(flag byte) string {
+	switch flag {
+	case tar.TypeReg, tar.TypeRegA:
+		return "regular"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeFifo:
+		return "fifo"
+	case tar.TypeCont:
+		return "contiguous"
+	case tar.TypeGNUSparse:
+		return "sparse"
+	case typeGNUDumpDir:
+		return "gnu-dumpdir"
+	case typeGNUNames:
+		return "gnu-names"
+	case typeGNUVolumeHeader:
+		return "gnu-volume-header"
+	case typeGNUMultiVolume:
+		return "gnu-multi-volume"
+	}
+	return fmt.Sprintf("type %q", flag)
+}
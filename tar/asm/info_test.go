@@ -0,0 +1,57 @@
+package asm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"testing"
+)
+
+func TestInspectTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	info, err := InspectTarStream(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(archive)) {
+		t.Errorf("expected the size %d, got %d", len(archive), info.Size)
+	}
+	if info.Files != len(testFiles) || info.Payloads != 2 {
+		t.Errorf("expected %d files with 2 payloads, got %d and %d", len(testFiles), info.Files, info.Payloads)
+	}
+	if info.SegmentBytes+info.PayloadBytes != info.Size {
+		t.Errorf("expected the segments and payloads to make up the archive, got %d and %d", info.SegmentBytes, info.PayloadBytes)
+	}
+	if info.Types["regular"] != 3 || info.Types["dir"] != 1 || info.Types["symlink"] != 1 || info.Types["hardlink"] != 1 {
+		t.Errorf("unexpected types %v", info.Types)
+	}
+	if len(info.LongNames) != 1 || info.LongNames[0] != testFiles[4].hdr.Name {
+		t.Errorf("expected the long name, got %q", info.LongNames)
+	}
+	if info.TrailerBytes != 1024 {
+		t.Errorf("expected the end of archive marker, got %d bytes", info.TrailerBytes)
+	}
+
+	fh, err := os.Open("./testdata/iso-8859.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	gz, err := gzip.NewReader(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = InspectTarStream(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.InvalidUTF8) != 1 {
+		t.Errorf("expected a name that is not UTF-8, got %q", info.InvalidUTF8)
+	}
+}
+
+func TestInspectTarStreamInvalid(t *testing.T) {
+	if _, err := InspectTarStream(bytes.NewReader(bytes.Repeat([]byte("not a tar"), 100))); err == nil {
+		t.Error("expected an error for data that is not a tar archive")
+	}
+}
@@ -0,0 +1,78 @@
+package asm
+
+import (
+	"io"
+	"time"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+const (
+	// MetricAssembleDuration is how long an archive took to be assembled
+	// by WriteOutputTarStreamMetrics, reported once all of it is written
+	MetricAssembleDuration storage.Metric = "assemble_duration"
+	// MetricDisassembleDuration is how long an archive took to be
+	// disassembled by NewInputTarStreamMetrics, reported once all of it is
+	// read
+	MetricDisassembleDuration storage.Metric = "disassemble_duration"
+)
+
+// NewOutputTarStreamMetrics is like NewOutputTarStream, reporting to `m` the
+// metrics of the assembly, as by WriteOutputTarStreamMetrics
+func NewOutputTarStreamMetrics(fg storage.FileGetter, up storage.Unpacker, m storage.Metrics) io.ReadCloser {
+	if fg == nil || up == nil {
+		return nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(WriteOutputTarStreamMetrics(fg, up, pw, m))
+	}()
+	return pr
+}
+
+// WriteOutputTarStreamMetrics is like WriteOutputTarStream, reporting to `m`
+// the entries unpacked, the gets of the payloads and the bytes read of them,
+// as by the storage.NewMetricsUnpacker and storage.NewMetricsFileGetter of
+// `up` and `fg`, and the MetricAssembleDuration, if it succeeds.
+func WriteOutputTarStreamMetrics(fg storage.FileGetter, up storage.Unpacker, w io.Writer, m storage.Metrics) error {
+	start := time.Now()
+	if err := WriteOutputTarStream(storage.NewMetricsFileGetter(fg, m), storage.NewMetricsUnpacker(up, m), w); err != nil {
+		return err
+	}
+	m.Observe(MetricAssembleDuration, time.Since(start))
+	return nil
+}
+
+// NewInputTarStreamMetrics is like NewInputTarStream, reporting to `m` the
+// entries packed, and the payloads put and the bytes hashed of them, as by
+// the storage.NewMetricsPacker and storage.NewMetricsFilePutter of `p` and
+// `fp`, and the MetricDisassembleDuration, once all of the archive is read
+// through the reader returned.
+func NewInputTarStreamMetrics(r io.Reader, p storage.Packer, fp storage.FilePutter, m storage.Metrics) (io.Reader, error) {
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	rdr, err := NewInputTarStream(r, storage.NewMetricsPacker(p, m), storage.NewMetricsFilePutter(fp, m))
+	if err != nil {
+		return nil, err
+	}
+	return &metricsDoneReader{r: rdr, m: m, start: time.Now()}, nil
+}
+
+// metricsDoneReader reports the MetricDisassembleDuration once all of the
+// archive is read
+type metricsDoneReader struct {
+	r     io.Reader
+	m     storage.Metrics
+	start time.Time
+	done  bool
+}
+
+func (mdr *metricsDoneReader) Read(p []byte) (int, error) {
+	n, err := mdr.r.Read(p)
+	if err == io.EOF && !mdr.done {
+		mdr.done = true
+		mdr.m.Observe(MetricDisassembleDuration, time.Since(mdr.start))
+	}
+	return n, err
+}
@@ -0,0 +1,70 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+type recordedMetrics struct {
+	mu        sync.Mutex
+	counts    map[storage.Metric]int64
+	durations map[storage.Metric]int
+}
+
+func (rm *recordedMetrics) Count(name storage.Metric, n int64) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.counts[name] += n
+}
+
+func (rm *recordedMetrics) Observe(name storage.Metric, d time.Duration) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.durations[name]++
+}
+
+func TestTarStreamMetrics(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	var payloads int64
+	for _, f := range testFiles {
+		payloads += int64(len(f.body))
+	}
+	m := &recordedMetrics{counts: map[storage.Metric]int64{}, durations: map[storage.Metric]int{}}
+
+	meta := bytes.NewBuffer(nil)
+	fgp := storage.NewBufferFileGetPutter()
+	rdr, err := NewInputTarStreamMetrics(bytes.NewReader(archive), storage.NewJSONPacker(meta), fgp, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStreamMetrics(fgp, storage.NewJSONUnpacker(meta), out, m); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Fatal("expected the archive assembled as it was")
+	}
+
+	if m.counts[storage.MetricEntriesPacked] == 0 || m.counts[storage.MetricEntriesPacked] != m.counts[storage.MetricEntriesUnpacked] {
+		t.Errorf("expected as many entries unpacked as packed, got %v", m.counts)
+	}
+	if m.counts[storage.MetricBytesHashed] != payloads || m.counts[storage.MetricBytesRead] != payloads {
+		t.Errorf("expected %d bytes of payloads hashed and read, got %v", payloads, m.counts)
+	}
+	if m.counts[storage.MetricGetterMisses] != 0 || m.counts[storage.MetricGetterHits] == 0 {
+		t.Errorf("expected only hits, got %v", m.counts)
+	}
+	if m.durations[MetricDisassembleDuration] != 1 || m.durations[MetricAssembleDuration] != 1 {
+		t.Errorf("expected the disassembly and the assembly timed once, got %v", m.durations)
+	}
+}
@@ -0,0 +1,160 @@
+package asm
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// Migration upgrades a FileType entry of existing metadata in place, e.g. to
+// fill in Entry fields added since it was disassembled. It is given the
+// entry's header, and a FileGetter of the payloads (like the extracted
+// archive) for when the payload needs to be read again.
+//
+// Migrations are expected to leave an entry that is already upgraded as it is.
+type Migration func(hdr *tar.Header, entry *storage.Entry, fg storage.FileGetter) error
+
+// MigrateMetadata re-packs the metadata read from `up` to `p`, applying the
+// migrations to each of its FileType entries in order. The archive that the
+// metadata assembles is unchanged.
+func MigrateMetadata(up storage.Unpacker, p storage.Packer, fg storage.FileGetter, migrations ...Migration) error {
+	rec := &recordingUnpacker{up: up}
+	hr := NewHeaderReader(rec)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == nil {
+			for _, m := range migrations {
+				if err := m(hdr, entry, fg); err != nil {
+					return fmt.Errorf("asm: migrating %q: %v", entry.GetName(), err)
+				}
+			}
+		}
+		for _, e := range rec.entries {
+			if _, err := p.AddEntry(*e); err != nil {
+				return err
+			}
+		}
+		rec.entries = nil
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// errNoFileGetter is returned by migrations that need to read payloads again,
+// when no FileGetter is given
+var errNoFileGetter = errors.New("asm: a FileGetter of the payloads is needed")
+
+var migrations = map[string]Migration{
+	"inline-special": InlineSpecial,
+	"digest-sha256":  DigestPayloads("sha256"),
+	"digest-sha512":  DigestPayloads("sha512"),
+}
+
+// LookupMigration returns the built-in migration of the given name, for tools
+// like `tar-split migrate`.
+func LookupMigration(name string) (Migration, bool) {
+	m, ok := migrations[name]
+	return m, ok
+}
+
+// MigrationNames returns the names of the built-in migrations
+func MigrationNames() []string {
+	var names []string
+	for name := range migrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InlineSpecial is a Migration for metadata from before the special records
+// of GNU incremental archives were kept inline. Their payloads are read back
+// from the FileGetter into the entry.
+func InlineSpecial(hdr *tar.Header, entry *storage.Entry, fg storage.FileGetter) error {
+	special := specialKind(hdr.Typeflag)
+	if special == "" || entry.Special != "" || entry.Size == 0 {
+		return nil
+	}
+	if fg == nil {
+		return errNoFileGetter
+	}
+	rdr, err := getPayload(fg, entry)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	checksum := entry.Payload
+	if err := setInline(entry, special, rdr); err != nil {
+		return err
+	}
+	if string(checksum) != string(entry.Payload) {
+		return &ChecksumError{
+			Position: entry.Position,
+			Name:     entry.GetName(),
+			Expected: "crc64:" + hex.EncodeToString(checksum),
+			Actual:   "crc64:" + hex.EncodeToString(entry.Payload),
+		}
+	}
+	return nil
+}
+
+// DigestAnnotation is the annotation of a payload's digest, added by
+// DigestPayloads, like "sha256:<hex>"
+const DigestAnnotation = "digest"
+
+// DigestPayloads returns a Migration that annotates each file payload with its
+// digest, of the algorithm "sha256" or "sha512", by reading it again.
+func DigestPayloads(algorithm string) Migration {
+	return func(hdr *tar.Header, entry *storage.Entry, fg storage.FileGetter) error {
+		if _, ok := entry.Annotations[DigestAnnotation]; ok || entry.Size == 0 {
+			return nil
+		}
+		var h hash.Hash
+		switch algorithm {
+		case "sha256":
+			h = sha256.New()
+		case "sha512":
+			h = sha512.New()
+		default:
+			return fmt.Errorf("asm: unknown digest algorithm %q", algorithm)
+		}
+		if entry.Special != "" {
+			h.Write(entry.Inline)
+		} else {
+			if fg == nil {
+				return errNoFileGetter
+			}
+			rdr, err := getPayload(fg, entry)
+			if err != nil {
+				return err
+			}
+			defer rdr.Close()
+			var r io.Reader = rdr
+			if entry.Partial {
+				if r, err = partReader(rdr, entry); err != nil {
+					return err
+				}
+			}
+			if _, err := io.Copy(h, r); err != nil {
+				return err
+			}
+		}
+		if entry.Annotations == nil {
+			entry.Annotations = map[string]string{}
+		}
+		entry.Annotations[DigestAnnotation] = algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+		return nil
+	}
+}
@@ -0,0 +1,105 @@
+package asm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestMigrateInlineSpecial(t *testing.T) {
+	archive := buildTar(t, testIncrementalFiles)
+	meta, fgp := disassemble(t, archive)
+
+	// metadata as it was, with the special payloads stored as files
+	old := bytes.NewBuffer(nil)
+	up := storage.NewJSONUnpacker(bytes.NewReader(meta))
+	p := storage.NewJSONPacker(old)
+	for {
+		entry, err := up.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Special != "" {
+			if _, _, err := fgp.Put(entry.GetName(), bytes.NewReader(entry.Inline)); err != nil {
+				t.Fatal(err)
+			}
+			entry.Special, entry.Inline = "", nil
+		}
+		if _, err := p.AddEntry(*entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrated := bytes.NewBuffer(nil)
+	if err := MigrateMetadata(storage.NewJSONUnpacker(old), storage.NewJSONPacker(migrated), fgp, InlineSpecial); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(migrated.Bytes(), meta) {
+		t.Errorf("expected the migrated metadata to be as if newly disassembled:\n%s\n%s", migrated.Bytes(), meta)
+	}
+}
+
+func TestMigrateDigests(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+
+	m, ok := LookupMigration("digest-sha256")
+	if !ok {
+		t.Fatalf("expected digest-sha256 in %q", MigrationNames())
+	}
+	migrated := bytes.NewBuffer(nil)
+	if err := MigrateMetadata(storage.NewJSONUnpacker(bytes.NewReader(meta)), storage.NewJSONPacker(migrated), fgp, m); err != nil {
+		t.Fatal(err)
+	}
+
+	digests := map[string]string{}
+	up := storage.NewJSONUnpacker(bytes.NewReader(migrated.Bytes()))
+	for {
+		entry, err := up.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d, ok := entry.Annotations[DigestAnnotation]; ok {
+			digests[entry.GetName()] = d
+		}
+	}
+	for _, f := range testFiles {
+		sum := sha256.Sum256([]byte(f.body))
+		expected := "sha256:" + hex.EncodeToString(sum[:])
+		if f.body == "" {
+			expected = ""
+		}
+		if digests[f.hdr.Name] != expected {
+			t.Errorf("%s: expected digest %q, got %q", f.hdr.Name, expected, digests[f.hdr.Name])
+		}
+	}
+
+	// the archive is unchanged
+	buf := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(migrated), buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), archive) {
+		t.Errorf("reassembled %d bytes, expected %d bytes", buf.Len(), len(archive))
+	}
+}
+
+func TestMigrationNames(t *testing.T) {
+	if names := strings.Join(MigrationNames(), ","); names != "digest-sha256,digest-sha512,inline-special" {
+		t.Errorf("unexpected migrations %q", names)
+	}
+	if _, ok := LookupMigration("nope"); ok {
+		t.Error("expected no migration named nope")
+	}
+}
@@ -0,0 +1,127 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// MissingPolicy is what WriteRecoveredTarStream does about file payloads that
+// are missing from the FileGetter
+type MissingPolicy int
+
+const (
+	// MissingFail fails on the first missing payload, like
+	// WriteOutputTarStream
+	MissingFail MissingPolicy = iota
+	// MissingZero zero-fills the missing payloads, keeping the headers and
+	// the layout and size of the archive
+	MissingZero
+	// MissingSkip leaves the entries with missing payloads out of the archive
+	MissingSkip
+)
+
+// ParseMissingPolicy parses "fail", "zero" or "skip" into a MissingPolicy
+func ParseMissingPolicy(s string) (MissingPolicy, error) {
+	switch s {
+	case "fail":
+		return MissingFail, nil
+	case "zero":
+		return MissingZero, nil
+	case "skip":
+		return MissingSkip, nil
+	}
+	return 0, fmt.Errorf("asm: unknown missing payload policy %q", s)
+}
+
+// WriteRecoveredTarStream is like WriteOutputTarStream, for when some of the
+// file payloads may be missing, i.e. `fg` fails to Get them. The policy picks
+// between strict reconstruction and best-effort recovery, and `missing`, if
+// not nil, is called with each entry whose payload is missing and the error
+// from the FileGetter.
+//
+// Payloads that are there but do not match their checksum are still an error.
+func WriteRecoveredTarStream(fg storage.FileGetter, up storage.Unpacker, policy MissingPolicy, w io.Writer, missing func(entry *storage.Entry, err error)) error {
+	hr := NewHeaderReader(up)
+	// whether the file before was written, and so is its padding
+	written := false
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// the padding is as it was recorded, for a missing payload too
+		if written {
+			if _, err := w.Write(hr.Padding()); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		written = true
+		if entry.Size == 0 || entry.Special != "" || isHeaderOnlyType(hdr.Typeflag) {
+			if _, err := w.Write(hr.RawHeader()); err != nil {
+				return err
+			}
+			if err := writeEntryData(w, fg, entry); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fh, err := getPayload(fg, entry)
+		if err != nil {
+			if missing != nil {
+				missing(entry, err)
+			}
+			switch policy {
+			case MissingZero:
+				if _, err := w.Write(hr.RawHeader()); err != nil {
+					return err
+				}
+				if err := writeZeros(w, entry.DataSize()); err != nil {
+					return err
+				}
+			case MissingSkip:
+				written = false
+			default:
+				return err
+			}
+			continue
+		}
+		if _, err := w.Write(hr.RawHeader()); err != nil {
+			fh.Close()
+			return err
+		}
+		if err := writeEntryData(w, openedFile{fh}, entry); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(hr.Trailer())
+	return err
+}
+
+// openedFile is a FileGetter of a payload that is already open
+type openedFile struct {
+	io.ReadCloser
+}
+
+func (of openedFile) Get(string) (io.ReadCloser, error) {
+	return of.ReadCloser, nil
+}
+
+func writeZeros(w io.Writer, n int64) error {
+	for n > 0 {
+		chunk := zeros
+		if n < int64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		n -= int64(len(chunk))
+	}
+	return nil
+}
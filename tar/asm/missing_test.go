@@ -0,0 +1,98 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/gen"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestWriteRecoveredTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+	if err := fgp.(storage.FileDeleter).Delete("dir/hurr.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, policy := range []MissingPolicy{MissingFail, MissingZero, MissingSkip} {
+		var missing []string
+		buf := bytes.NewBuffer(nil)
+		err := WriteRecoveredTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), policy, buf, func(entry *storage.Entry, err error) {
+			missing = append(missing, entry.GetName())
+		})
+		if len(missing) != 1 || missing[0] != "dir/hurr.txt" {
+			t.Errorf("policy %d: expected dir/hurr.txt to be missing, got %v", policy, missing)
+		}
+		if policy == MissingFail {
+			if err == nil {
+				t.Error("expected an error for the missing payload")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy == MissingZero && buf.Len() != len(archive) {
+			t.Errorf("expected the zero filled archive to be %d bytes, got %d", len(archive), buf.Len())
+		}
+
+		var names []string
+		tr := tar.NewReader(buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("policy %d: %v", policy, err)
+			}
+			names = append(names, hdr.Name)
+			payload, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Name == "dir/hurr.txt" && len(bytes.Trim(payload, "\x00")) != 0 {
+				t.Errorf("expected the missing payload to be zero filled, got %q", payload)
+			}
+		}
+		expected := len(testFiles)
+		if policy == MissingSkip {
+			expected--
+		}
+		if len(names) != expected {
+			t.Errorf("policy %d: expected %d entries, got %v", policy, expected, names)
+		}
+	}
+}
+
+// paddedArchives returns archives whose padding is not all zeros, by name
+func paddedArchives(t testing.TB) map[string][]byte {
+	v7, err := ioutil.ReadFile("../../archive/tar/testdata/v7.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archives := map[string][]byte{"v7.tar": v7}
+	for _, a := range gen.Archives() {
+		if a.Name == "nonzero-padding" {
+			archives[a.Name] = a.Data
+		}
+	}
+	return archives
+}
+
+func TestWriteRecoveredTarStreamPadding(t *testing.T) {
+	for name, archive := range paddedArchives(t) {
+		meta, fgp := disassemble(t, archive)
+		buf := bytes.NewBuffer(nil)
+		if err := WriteRecoveredTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), MissingFail, buf, nil); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), archive) {
+			t.Errorf("%s: expected the recovery of all the payloads to be the archive byte for byte", name)
+		}
+	}
+}
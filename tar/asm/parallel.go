@@ -0,0 +1,175 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// parallelBufferLimit is the largest payload that NewParallelInputTarStream
+// buffers, to be stored by a worker. Larger payloads are stored as they are
+// read, so at most `workers` of these are held in memory at once.
+const parallelBufferLimit = 8 << 20
+
+// NewParallelInputTarStream is like NewInputTarStream, but the file payloads
+// are stored to `fp`, and checksummed, by up to `workers` goroutines at once,
+// while the headers that follow them are read on. The entries are still
+// packed to `p` in the order of the archive, so the metadata is the same.
+//
+// As Puts are concurrent, `fp` must be safe for concurrent use (see
+// storage.NewPathFilePutter).
+func NewParallelInputTarStream(r io.Reader, p storage.Packer, fp storage.FilePutter, workers int) (io.Reader, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	pR, pW := io.Pipe()
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	go disassembleParallelTo(r, p, fp, workers, pW)
+	return pR, nil
+}
+
+// pendingEntry is an entry to pack, once its payload is stored
+type pendingEntry struct {
+	entry storage.Entry
+	done  chan struct{}
+	err   error
+}
+
+// disassembleParallelTo is disassembleTo, with the payloads stored by up to
+// `workers` goroutines at once
+func disassembleParallelTo(r io.Reader, p storage.Packer, fp storage.FilePutter, workers int, pW *io.PipeWriter) {
+	var (
+		pending = make(chan *pendingEntry, 2*workers)
+		slots   = make(chan struct{}, workers)
+		packed  = make(chan struct{})
+		mu      sync.Mutex
+		failure error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if failure == nil {
+			failure = err
+		}
+		mu.Unlock()
+	}
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return failure
+	}
+
+	// the entries are packed in order, as each one's payload is stored
+	go func() {
+		defer close(packed)
+		for pe := range pending {
+			<-pe.done
+			if failed() != nil {
+				continue
+			}
+			if pe.err != nil {
+				fail(pe.err)
+				continue
+			}
+			if _, err := p.AddEntry(pe.entry); err != nil {
+				fail(err)
+			}
+		}
+	}()
+	closed := make(chan struct{})
+	close(closed)
+	// the raw bytes are copied, as the reader reuses them before they are
+	// packed
+	addSegment := func(b []byte) {
+		if len(b) > 0 {
+			payload := append([]byte(nil), b...)
+			pending <- &pendingEntry{entry: storage.Entry{Type: storage.SegmentType, Payload: payload}, done: closed}
+		}
+	}
+
+	err := func() error {
+		outputRdr := io.TeeReader(r, pW)
+		tr := tar.NewReader(outputRdr)
+		tr.RawAccounting = true
+		for failed() == nil {
+			hdr, err := tr.Next()
+			if err != nil {
+				if err != io.EOF {
+					return err
+				}
+				// even when an EOF is reached, there is often 1024 null bytes
+				// on the end of an archive. Collect them too.
+				addSegment(tr.RawBytes())
+				break
+			}
+			if hdr == nil {
+				break
+			}
+			addSegment(tr.RawBytes())
+
+			pe := &pendingEntry{
+				entry: storage.Entry{Type: storage.FileType, Size: hdr.Size},
+				done:  closed,
+			}
+			// For proper marshalling of non-utf8 characters
+			pe.entry.SetName(hdr.Name)
+			setLink(&pe.entry, hdr)
+			if special := specialKind(hdr.Typeflag); special != "" && hdr.Size > 0 {
+				if err := setInline(&pe.entry, special, tr); err != nil {
+					return err
+				}
+			} else if setSparse(&pe.entry, tr); hdr.Size > parallelBufferLimit || len(pe.entry.Sparse) > 0 {
+				// a sparse file is stored as it is read too, as it may be
+				// mostly holes that are not to be held in memory
+				slots <- struct{}{}
+				pe.err = putPayload(fp, hdr.Name, tr, &pe.entry)
+				<-slots
+				if pe.err != nil {
+					return pe.err
+				}
+			} else if hdr.Size > 0 {
+				slots <- struct{}{}
+				buf := bytes.NewBuffer(make([]byte, 0, hdr.Size))
+				if _, err := io.Copy(buf, tr); err != nil {
+					<-slots
+					return err
+				}
+				pe.done = make(chan struct{})
+				go func(pe *pendingEntry, name string) {
+					_, pe.entry.Payload, pe.err = fp.Put(name, buf)
+					<-slots
+					close(pe.done)
+				}(pe, hdr.Name)
+			}
+			pending <- pe
+			addSegment(tr.RawBytes())
+		}
+		if err := failed(); err != nil {
+			return err
+		}
+
+		// it is allowable, and not uncommon that there is further padding on
+		// the end of an archive, apart from the expected 1024 null bytes.
+		remainder, err := ioutil.ReadAll(outputRdr)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		pending <- &pendingEntry{entry: storage.Entry{Type: storage.SegmentType, Payload: remainder}, done: closed}
+		return nil
+	}()
+	close(pending)
+	<-packed
+	if err == nil {
+		err = failed()
+	}
+	if err != nil {
+		pW.CloseWithError(err)
+		return
+	}
+	pW.Close()
+}
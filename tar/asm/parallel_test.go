@@ -0,0 +1,78 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestParallelInputTarStream(t *testing.T) {
+	files := append([]testFile{}, testFiles...)
+	for i := 0; i < 32; i++ {
+		files = append(files, testFile{
+			hdr:  tar.Header{Name: fmt.Sprintf("many/%d", i), Typeflag: tar.TypeReg, Mode: 0644},
+			body: string(bytes.Repeat([]byte{byte('a' + i%26)}, i*100)),
+		})
+	}
+	files = append(files, testFile{
+		hdr:  tar.Header{Name: "huge", Typeflag: tar.TypeReg, Mode: 0644},
+		body: string(bytes.Repeat([]byte("huge"), parallelBufferLimit/4+1)),
+	})
+	archive := buildTar(t, files)
+	expected, _ := disassemble(t, archive)
+
+	dir, err := ioutil.TempDir("", "parallel-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	meta := bytes.NewBuffer(nil)
+	its, err := NewParallelInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(meta), storage.NewPathFilePutter(dir), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(its)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, archive) {
+		t.Error("expected the archive passed through")
+	}
+	if !bytes.Equal(meta.Bytes(), expected) {
+		t.Error("expected the same metadata as NewInputTarStream")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(storage.NewPathFileGetter(dir), storage.NewJSONUnpacker(meta), buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), archive) {
+		t.Error("expected the archive assembled as it was")
+	}
+}
+
+type failingFilePutter struct{}
+
+var errFailingPut = errors.New("failing put")
+
+func (failingFilePutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	return 0, nil, errFailingPut
+}
+
+func TestParallelInputTarStreamFailure(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	its, err := NewParallelInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(ioutil.Discard), failingFilePutter{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(its); err != errFailingPut {
+		t.Errorf("expected the error of the FilePutter, got %v", err)
+	}
+}
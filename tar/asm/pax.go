@@ -0,0 +1,228 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ErrPAXKeywordLost is returned by VerifyPAXKeywords when a vendor or unknown
+// PAX record is not reproduced byte for byte
+var ErrPAXKeywordLost = errors.New("asm: PAX records were not reproduced")
+
+// PAXKeyword is the report of VerifyPAXKeywords on a vendor or unknown PAX
+// keyword, like "SCHILY.xattr.user.foo" or "LIBARCHIVE.creationtime"
+type PAXKeyword struct {
+	Keyword string
+	// Entries are the names of the entries with a record of the keyword, in
+	// either a local or a global extended header
+	Entries []string
+	// Lost are the names of the entries whose record of the keyword was not
+	// reproduced byte for byte by assembly
+	Lost []string
+}
+
+// Preserved is whether every record of the keyword was reproduced
+func (k PAXKeyword) Preserved() bool {
+	return len(k.Lost) == 0
+}
+
+// standardPAXKeywords are those defined by POSIX, which archive/tar handles
+// itself, and are not reported
+var standardPAXKeywords = map[string]bool{
+	"atime": true, "charset": true, "comment": true, "gid": true, "gname": true,
+	"hdrcharset": true, "linkpath": true, "mtime": true, "path": true,
+	"size": true, "uid": true, "uname": true,
+}
+
+func isVendorPAXKeyword(keyword string) bool {
+	if strings.HasPrefix(keyword, "realtime.") || strings.HasPrefix(keyword, "security.") {
+		return false
+	}
+	return !standardPAXKeywords[keyword]
+}
+
+// paxRecord is a record of an extended header, as its raw bytes
+type paxRecord struct {
+	keyword string
+	raw     string
+}
+
+// VerifyPAXKeywords enumerates the vendor and unknown PAX keywords (like
+// GNU.dumpdir, SCHILY.* and LIBARCHIVE.*) recorded in the metadata read from
+// `up`, then assembles the archive with the payloads of `fg` and disassembles
+// it again, to check that each of their records is reproduced byte for byte
+// in the headers of the same entry. It is for validating the fidelity of
+// archives from unusual producers.
+//
+// The keywords are returned sorted, and if any record was lost, the error is
+// ErrPAXKeywordLost.
+func VerifyPAXKeywords(fg storage.FileGetter, up storage.Unpacker) ([]PAXKeyword, error) {
+	rec := &recordingUnpacker{up: up}
+	pR, pW := io.Pipe()
+	go func() {
+		pW.CloseWithError(WriteOutputTarStream(fg, rec, pW))
+	}()
+
+	entries := make(chanPacker)
+	done := make(chan error, 1)
+	go func() {
+		its, err := NewInputTarStream(pR, entries, storage.NewDiscardFilePutter())
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, its)
+		}
+		pR.CloseWithError(err)
+		close(entries)
+		done <- err
+	}()
+	reassembled, err := readPAXRecords(entries)
+	// drained, so that the disassembly finishes when the headers could not be
+	// read
+	for range entries {
+	}
+	if derr := <-done; derr != nil {
+		return nil, derr
+	}
+	if err != nil {
+		return nil, err
+	}
+	original, err := readPAXRecords(&entriesUnpacker{entries: rec.entries})
+	if err != nil {
+		return nil, err
+	}
+
+	keywords := map[string]*PAXKeyword{}
+	var lost bool
+	for i, entry := range original {
+		for _, r := range entry.records {
+			k, ok := keywords[r.keyword]
+			if !ok {
+				k = &PAXKeyword{Keyword: r.keyword}
+				keywords[r.keyword] = k
+			}
+			k.Entries = append(k.Entries, entry.name)
+			if i >= len(reassembled) || !hasPAXRecord(reassembled[i].records, r) {
+				k.Lost = append(k.Lost, entry.name)
+				lost = true
+			}
+		}
+	}
+	var names []string
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	report := make([]PAXKeyword, 0, len(names))
+	for _, name := range names {
+		report = append(report, *keywords[name])
+	}
+	if lost {
+		return report, ErrPAXKeywordLost
+	}
+	return report, nil
+}
+
+// entryPAXRecords are the vendor PAX records of an entry
+type entryPAXRecords struct {
+	name    string
+	records []paxRecord
+}
+
+func readPAXRecords(up storage.Unpacker) ([]entryPAXRecords, error) {
+	var all []entryPAXRecords
+	hr := NewHeaderReader(up)
+	for {
+		hdr, _, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return all, nil
+			}
+			return nil, err
+		}
+		var records []paxRecord
+		for _, r := range rawPAXRecords(hr.RawHeader()) {
+			if isVendorPAXKeyword(r.keyword) {
+				records = append(records, r)
+			}
+		}
+		all = append(all, entryPAXRecords{name: hdr.Name, records: records})
+	}
+}
+
+// rawPAXRecords returns the records of the local and global extended headers
+// among the raw header blocks of an entry
+func rawPAXRecords(raw []byte) []paxRecord {
+	var records []paxRecord
+	for len(raw) >= blockSize {
+		block := raw[:blockSize]
+		raw = raw[blockSize:]
+		switch block[156] {
+		case 'x', 'L', 'K', 'g':
+			size, err := parseNumeric(block[124:136])
+			padded := size + (-size & (blockSize - 1))
+			if err != nil || padded > int64(len(raw)) {
+				return records
+			}
+			if block[156] == 'x' || block[156] == 'g' {
+				records = append(records, parsePAXRecords(raw[:size])...)
+			}
+			raw = raw[padded:]
+		default:
+			return records
+		}
+	}
+	return records
+}
+
+// parsePAXRecords splits the data of an extended header into its records,
+// each of the form "%d %s=%s\n", stopping at the first malformed one
+func parsePAXRecords(data []byte) []paxRecord {
+	var records []paxRecord
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		if sp < 0 {
+			break
+		}
+		n, err := strconv.Atoi(string(data[:sp]))
+		if err != nil || n <= sp || n > len(data) {
+			break
+		}
+		record := data[:n]
+		eq := bytes.IndexByte(record[sp+1:], '=')
+		if eq < 0 {
+			break
+		}
+		records = append(records, paxRecord{keyword: string(record[sp+1 : sp+1+eq]), raw: string(record)})
+		data = data[n:]
+	}
+	return records
+}
+
+func hasPAXRecord(records []paxRecord, r paxRecord) bool {
+	for _, other := range records {
+		if other == r {
+			return true
+		}
+	}
+	return false
+}
+
+// entriesUnpacker unpacks entries already read
+type entriesUnpacker struct {
+	entries []*storage.Entry
+}
+
+func (eu *entriesUnpacker) Next() (*storage.Entry, error) {
+	if len(eu.entries) == 0 {
+		return nil, io.EOF
+	}
+	e := eu.entries[0]
+	eu.entries = eu.entries[1:]
+	return e, nil
+}
@@ -0,0 +1,55 @@
+package asm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestVerifyPAXKeywords(t *testing.T) {
+	files := append([]testFile(nil), testFiles...)
+	files = append(files,
+		testFile{hdr: tar.Header{Name: "xattrs.txt", Mode: 0644, Typeflag: tar.TypeReg, Xattrs: map[string]string{"user.foo": "bar", "user.baz": "quux"}}, body: "xattrs"},
+		testFile{hdr: tar.Header{Name: "more-xattrs.txt", Mode: 0644, Typeflag: tar.TypeReg, Xattrs: map[string]string{"user.foo": "other"}}},
+	)
+	meta, fgp := disassemble(t, buildTar(t, files))
+	report, err := VerifyPAXKeywords(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the long name of testFiles is in a standard "path" record, which is not
+	// reported
+	if len(report) != 2 {
+		t.Fatalf("expected 2 keywords, got %#v", report)
+	}
+	if report[0].Keyword != "SCHILY.xattr.user.baz" || report[1].Keyword != "SCHILY.xattr.user.foo" {
+		t.Errorf("unexpected keywords %q and %q", report[0].Keyword, report[1].Keyword)
+	}
+	if len(report[1].Entries) != 2 || report[1].Entries[1] != "more-xattrs.txt" {
+		t.Errorf("expected the keyword in both entries, got %q", report[1].Entries)
+	}
+	for _, k := range report {
+		if !k.Preserved() {
+			t.Errorf("expected %s to be preserved, lost in %q", k.Keyword, k.Lost)
+		}
+	}
+}
+
+func TestParsePAXRecords(t *testing.T) {
+	data := []byte("30 mtime=1500000000.123456789\n32 LIBARCHIVE.creationtime=1234\n5 bad")
+	records := parsePAXRecords(data)
+	if len(records) != 2 {
+		t.Fatalf("expected the 2 well formed records, got %#v", records)
+	}
+	if records[1].keyword != "LIBARCHIVE.creationtime" || records[1].raw != "32 LIBARCHIVE.creationtime=1234\n" {
+		t.Errorf("unexpected record %#v", records[1])
+	}
+	if isVendorPAXKeyword(records[0].keyword) || !isVendorPAXKeyword(records[1].keyword) {
+		t.Errorf("expected only %s to be a vendor keyword", records[1].keyword)
+	}
+	if hasPAXRecord(records, paxRecord{keyword: "LIBARCHIVE.creationtime", raw: "32 LIBARCHIVE.creationtime=1235\n"}) {
+		t.Error("expected a record with another value not to match")
+	}
+}
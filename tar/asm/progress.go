@@ -0,0 +1,203 @@
+package asm
+
+import (
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ProgressInterval is how many bytes of the archive are written (or read)
+// between the calls of a ProgressFunc within a file, for a large payload to
+// still show progress
+const ProgressInterval = 1 << 20
+
+// Progress is passed to a ProgressFunc as an archive is assembled or
+// disassembled
+type Progress struct {
+	// Entries is the number of file entries done so far
+	Entries int
+	// Bytes of the archive written so far, or read on disassembly
+	Bytes int64
+	// Name of the file entry last done, or the one in progress between them
+	Name string
+	// Done is set on the last call, once all of the archive is done
+	Done bool
+}
+
+// ProgressFunc is called as each file entry of an archive is done, every
+// ProgressInterval bytes in between, and once at the end, to drive progress
+// bars, or to time out an assembly that stalls. Calls are not concurrent, and
+// hold up the archive until they return.
+type ProgressFunc func(Progress)
+
+// progressCounter counts the bytes of an archive through it, calling `fn`
+// every ProgressInterval bytes
+type progressCounter struct {
+	fn       ProgressFunc
+	progress Progress
+	next     int64
+}
+
+func (pc *progressCounter) add(n int) {
+	pc.progress.Bytes += int64(n)
+	if pc.progress.Bytes >= pc.next {
+		pc.fn(pc.progress)
+		pc.next = pc.progress.Bytes - pc.progress.Bytes%ProgressInterval + ProgressInterval
+	}
+}
+
+// entryDone counts a file entry as done, and reports it
+func (pc *progressCounter) entryDone(name string) {
+	pc.progress.Entries++
+	pc.progress.Name = name
+	pc.fn(pc.progress)
+}
+
+func (pc *progressCounter) done() {
+	pc.progress.Done = true
+	pc.fn(pc.progress)
+}
+
+// NewOutputTarStreamProgress is like NewOutputTarStream, calling `fn` with
+// the progress of the assembly, as the archive is read.
+func NewOutputTarStreamProgress(fg storage.FileGetter, up storage.Unpacker, fn ProgressFunc) io.ReadCloser {
+	if fg == nil || up == nil {
+		return nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(WriteOutputTarStreamProgress(fg, up, pw, fn))
+	}()
+	return pr
+}
+
+// WriteOutputTarStreamProgress is like WriteOutputTarStream, calling `fn`
+// with the progress of the assembly. The last call, with Done set, is only
+// made if all of the archive is written.
+func WriteOutputTarStreamProgress(fg storage.FileGetter, up storage.Unpacker, w io.Writer, fn ProgressFunc) error {
+	pc := &progressCounter{fn: fn, next: ProgressInterval}
+	if err := WriteOutputTarStream(fg, &progressUnpacker{up: up, pc: pc}, &progressWriter{w: w, pc: pc}); err != nil {
+		return err
+	}
+	pc.done()
+	return nil
+}
+
+// progressUnpacker counts a file entry as done once the entry after it is
+// read, as its payload is written by then
+type progressUnpacker struct {
+	up   storage.Unpacker
+	pc   *progressCounter
+	file string
+	open bool
+}
+
+func (pu *progressUnpacker) Next() (*storage.Entry, error) {
+	entry, err := pu.up.Next()
+	if pu.open && (err != nil || entry.Type == storage.FileType) {
+		pu.open = false
+		pu.pc.entryDone(pu.file)
+	}
+	if err == nil && entry.Type == storage.FileType {
+		pu.file, pu.open = entry.GetName(), true
+		pu.pc.progress.Name = pu.file
+	}
+	return entry, err
+}
+
+type progressWriter struct {
+	w  io.Writer
+	pc *progressCounter
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.pc.add(n)
+	return n, err
+}
+
+// NewInputTarStreamProgress is like NewInputTarStream, calling `fn` with the
+// progress of the disassembly, as the archive is read through the reader
+// returned. The Bytes are those read of `r`, which may be ahead of the file
+// entries done.
+func NewInputTarStreamProgress(r io.Reader, p storage.Packer, fp storage.FilePutter, fn ProgressFunc) (io.Reader, error) {
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	pc := &progressCounter{fn: fn, next: ProgressInterval}
+	var pfp storage.FilePutter = &progressFilePutter{fp: fp, pc: pc}
+	if sfp, ok := fp.(storage.SparseFilePutter); ok {
+		pfp = &progressSparseFilePutter{progressFilePutter{fp: fp, pc: pc}, sfp}
+	}
+	rdr, err := NewInputTarStream(&progressReader{r: r, pc: pc}, &progressPacker{p: p, pc: pc}, pfp)
+	if err != nil {
+		return nil, err
+	}
+	return &progressDoneReader{r: rdr, pc: pc}, nil
+}
+
+type progressReader struct {
+	r  io.Reader
+	pc *progressCounter
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.pc.add(n)
+	return n, err
+}
+
+// progressPacker counts each file entry as done as it is packed, after its
+// payload is stored
+type progressPacker struct {
+	p  storage.Packer
+	pc *progressCounter
+}
+
+func (pp *progressPacker) AddEntry(e storage.Entry) (int, error) {
+	pos, err := pp.p.AddEntry(e)
+	if err == nil && e.Type == storage.FileType {
+		pp.pc.entryDone(e.GetName())
+	}
+	return pos, err
+}
+
+// progressFilePutter names the file in progress as its payload is stored
+type progressFilePutter struct {
+	fp storage.FilePutter
+	pc *progressCounter
+}
+
+func (pfp *progressFilePutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	pfp.pc.progress.Name = name
+	return pfp.fp.Put(name, r)
+}
+
+// progressSparseFilePutter is a progressFilePutter of a
+// storage.SparseFilePutter, for sparse files to still be stored without their
+// holes
+type progressSparseFilePutter struct {
+	progressFilePutter
+	sfp storage.SparseFilePutter
+}
+
+func (psfp *progressSparseFilePutter) PutSparse(name string, r io.Reader, extents []storage.SparseExtent) (int64, []byte, error) {
+	psfp.pc.progress.Name = name
+	return psfp.sfp.PutSparse(name, r, extents)
+}
+
+// progressDoneReader makes the last call once all of the archive is read
+type progressDoneReader struct {
+	r    io.Reader
+	pc   *progressCounter
+	done bool
+}
+
+func (pdr *progressDoneReader) Read(p []byte) (int, error) {
+	n, err := pdr.r.Read(p)
+	if err == io.EOF && !pdr.done {
+		pdr.done = true
+		pdr.pc.done()
+	}
+	return n, err
+}
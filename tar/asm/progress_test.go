@@ -0,0 +1,90 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// progressFiles are testFiles, with a payload over two ProgressIntervals
+func progressFiles() []testFile {
+	big := testFile{
+		hdr:  tar.Header{Name: "dir/big", Typeflag: tar.TypeReg, Mode: 0644},
+		body: string(bytes.Repeat([]byte("big"), ProgressInterval)),
+	}
+	return append(append([]testFile(nil), testFiles...), big)
+}
+
+// checkProgress checks the calls of a ProgressFunc, over an archive of `files`
+// of `size` bytes
+func checkProgress(t *testing.T, calls []Progress, files []testFile, size int64) {
+	var names []string
+	var bytes int64
+	for i, p := range calls {
+		if p.Bytes < bytes {
+			t.Errorf("call %d: expected the bytes to only grow, from %d to %d", i, bytes, p.Bytes)
+		}
+		bytes = p.Bytes
+		if n := len(names); n < p.Entries {
+			names = append(names, p.Name)
+		}
+	}
+	if len(names) != len(files) {
+		t.Fatalf("expected %d entries done, got %d", len(files), len(names))
+	}
+	for i, f := range files {
+		if names[i] != f.hdr.Name {
+			t.Errorf("entry %d: expected %q done, got %q", i, f.hdr.Name, names[i])
+		}
+	}
+	last := calls[len(calls)-1]
+	if !last.Done || last.Bytes != size || last.Entries != len(files) {
+		t.Errorf("expected the last call to be done, with all %d bytes and %d entries, got %+v", size, len(files), last)
+	}
+	// the entry calls, the last, and at least one within the big payload
+	if len(calls) < len(files)+2 {
+		t.Errorf("expected calls within the big payload, got %d calls for %d entries", len(calls), len(files))
+	}
+}
+
+func TestOutputTarStreamProgress(t *testing.T) {
+	files := progressFiles()
+	archive := buildTar(t, files)
+	meta, fgp := disassemble(t, archive)
+
+	var calls []Progress
+	ots := NewOutputTarStreamProgress(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), func(p Progress) {
+		calls = append(calls, p)
+	})
+	defer ots.Close()
+	got, err := ioutil.ReadAll(ots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, archive) {
+		t.Fatalf("expected the archive, got %d bytes rather than %d", len(got), len(archive))
+	}
+	checkProgress(t, calls, files, int64(len(archive)))
+}
+
+func TestInputTarStreamProgress(t *testing.T) {
+	files := progressFiles()
+	archive := buildTar(t, files)
+
+	var calls []Progress
+	meta := bytes.NewBuffer(nil)
+	its, err := NewInputTarStreamProgress(bytes.NewReader(archive), storage.NewJSONPacker(meta), storage.NewHolelessFilePutter(storage.NewBufferFileGetPutter()), func(p Progress) {
+		calls = append(calls, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err != nil {
+		t.Fatal(err)
+	}
+	checkProgress(t, calls, files, int64(len(archive)))
+}
@@ -0,0 +1,277 @@
+package asm
+
+import (
+	"bytes"
+	"hash/crc64"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ChangeKind is the kind of a Change found while rebuilding an archive
+type ChangeKind int
+
+const (
+	// Modified is an entry whose header or payload differs from the template
+	Modified ChangeKind = 1 + iota
+	// Added is an entry that is not in the template
+	Added
+	// Deleted is an entry of the template that is no longer present
+	Deleted
+)
+
+func (ck ChangeKind) String() string {
+	switch ck {
+	case Modified:
+		return "modified"
+	case Added:
+		return "added"
+	case Deleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Change is an entry that differs between the template and the rebuilt
+// archive.
+type Change struct {
+	Name string
+	Kind ChangeKind
+}
+
+// RebuildTarStream writes to `w` a tar archive of the directory `dir`, using
+// the metadata read from `up` (of the archive that `dir` was extracted from)
+// as a template. The new archive's metadata is packed to `p`, and its file
+// payloads stored to `fp`, as with NewInputTarStream.
+//
+// Entries that are unchanged on disk reuse the raw header bytes of the
+// template, so if nothing changed the resulting archive is identical to the
+// original. Headers for changed or new entries are synthesized from the files
+// on disk, and entries that no longer exist are left out. New entries are
+// appended after those of the template.
+//
+// The changes found are returned.
+func RebuildTarStream(up storage.Unpacker, dir string, p storage.Packer, fp storage.FilePutter, w io.Writer) ([]Change, error) {
+	pR, pW := io.Pipe()
+	type result struct {
+		changes []Change
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		changes, err := writeRebuiltTar(up, dir, pW)
+		pW.CloseWithError(err)
+		done <- result{changes, err}
+	}()
+
+	its, err := NewInputTarStream(pR, p, fp)
+	if err == nil {
+		_, err = io.Copy(w, its)
+	}
+	if err != nil {
+		pR.CloseWithError(err)
+		<-done
+		return nil, err
+	}
+	res := <-done
+	return res.changes, res.err
+}
+
+func writeRebuiltTar(up storage.Unpacker, dir string, w io.Writer) ([]Change, error) {
+	var changes []Change
+	seen := map[string]struct{}{}
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		name := path.Clean("/" + hdr.Name)
+		seen[name] = struct{}{}
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		fi, err := os.Lstat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				changes = append(changes, Change{Name: hdr.Name, Kind: Deleted})
+				continue
+			}
+			return nil, err
+		}
+
+		newHdr, err := headerFromDisk(dir, p, fi, hdr)
+		if err != nil {
+			return nil, err
+		}
+		newHdr.Name = hdr.Name
+		newHdr.Uname = hdr.Uname
+		newHdr.Gname = hdr.Gname
+		newHdr.Xattrs = hdr.Xattrs
+
+		unchanged := sameHeader(hdr, newHdr)
+		if unchanged && newHdr.Typeflag == tar.TypeReg && newHdr.Size > 0 {
+			if unchanged, err = sameChecksum(p, entry.Payload); err != nil {
+				return nil, err
+			}
+		}
+		if unchanged {
+			if _, err := w.Write(hr.RawHeader()); err != nil {
+				return nil, err
+			}
+		} else {
+			changes = append(changes, Change{Name: hdr.Name, Kind: Modified})
+			if err := writeHeader(w, newHdr); err != nil {
+				return nil, err
+			}
+		}
+		if err := writePayload(w, p, newHdr); err != nil {
+			return nil, err
+		}
+	}
+	trailer := hr.Trailer()
+
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + filepath.ToSlash(rel))
+		if name == "/" {
+			return nil
+		}
+		if _, ok := seen[name]; ok {
+			return nil
+		}
+		hdr, err := headerFromDisk(dir, p, fi, nil)
+		if err != nil {
+			return err
+		}
+		hdr.Name = strings.TrimPrefix(name, "/")
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		changes = append(changes, Change{Name: hdr.Name, Kind: Added})
+		if err := writeHeader(w, hdr); err != nil {
+			return err
+		}
+		return writePayload(w, p, hdr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(trailer) == 0 {
+		trailer = make([]byte, blockSize*2)
+	}
+	if _, err := w.Write(trailer); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// headerFromDisk synthesizes a header for the file at `p`. If the template
+// `hdr` was a hard link that still links to the same file, it is kept as one.
+func headerFromDisk(dir, p string, fi os.FileInfo, hdr *tar.Header) (*tar.Header, error) {
+	if hdr != nil && hdr.Typeflag == tar.TypeLink {
+		target, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(path.Clean("/"+hdr.Linkname))))
+		if err == nil && os.SameFile(fi, target) {
+			newHdr, err := tar.FileInfoHeader(fi, "")
+			if err != nil {
+				return nil, err
+			}
+			newHdr.Typeflag = tar.TypeLink
+			newHdr.Linkname = hdr.Linkname
+			newHdr.Size = 0
+			return newHdr, nil
+		}
+	}
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(p); err != nil {
+			return nil, err
+		}
+	}
+	return tar.FileInfoHeader(fi, link)
+}
+
+// sameHeader compares the fields of a template header to one synthesized from
+// disk, that extraction would have preserved. The modification time of
+// symbolic links is not compared, since it is not generally settable.
+func sameHeader(a, b *tar.Header) bool {
+	typeA, typeB := a.Typeflag, b.Typeflag
+	if typeA == tar.TypeRegA {
+		typeA = tar.TypeReg
+	}
+	if typeA == tar.TypeSymlink && typeB == tar.TypeSymlink {
+		b.ModTime = a.ModTime
+	}
+	return typeA == typeB &&
+		a.FileInfo().Mode() == b.FileInfo().Mode() &&
+		a.Uid == b.Uid && a.Gid == b.Gid &&
+		a.Size == b.Size &&
+		a.ModTime.Unix() == b.ModTime.Unix() &&
+		a.Linkname == b.Linkname &&
+		a.Devmajor == b.Devmajor && a.Devminor == b.Devminor
+}
+
+func sameChecksum(p string, payload []byte) (bool, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+	crcHash := crc64.New(storage.CRCTable)
+	if _, err := io.Copy(crcHash, fh); err != nil {
+		return false, err
+	}
+	return bytes.Equal(crcHash.Sum(nil), payload), nil
+}
+
+// writeHeader writes just the header blocks for hdr to w
+func writeHeader(w io.Writer, hdr *tar.Header) error {
+	return tar.NewWriter(w).WriteHeader(hdr)
+}
+
+// writeHeaderLike is writeHeader, for the header read from the raw header
+// blocks `raw`. If they have a PAX size record, a size too large for its octal
+// field is written as one too, rather than in base-256, so an archive of POSIX
+// tar stays one.
+func writeHeaderLike(w io.Writer, hdr *tar.Header, raw []byte) error {
+	tw := tar.NewWriter(w)
+	tw.PAXNumbers = extendedHeaderHas(raw, 'x', " size=")
+	return tw.WriteHeader(hdr)
+}
+
+// writePayload writes the content of the file at `p`, and its padding, if hdr
+// is of a type that has a payload.
+func writePayload(w io.Writer, p string, hdr *tar.Header) error {
+	if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+		return nil
+	}
+	fh, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	n, err := bufpool.Copy(w, io.LimitReader(fh, hdr.Size))
+	if err != nil {
+		return err
+	}
+	if n != hdr.Size {
+		return io.ErrUnexpectedEOF
+	}
+	_, err = w.Write(zeros[:-n&(blockSize-1)])
+	return err
+}
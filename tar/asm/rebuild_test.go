@@ -0,0 +1,145 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestRebuildTarStream(t *testing.T) {
+	// ownership that extraction can preserve, even unprivileged
+	files := make([]testFile, len(testFiles))
+	copy(files, testFiles)
+	for i := range files {
+		files[i].hdr.Uid = os.Getuid()
+		files[i].hdr.Gid = os.Getgid()
+	}
+	original := buildTar(t, files)
+	meta, fgp := disassemble(t, original)
+
+	dir, err := ioutil.TempDir("", "tar-split-rebuild.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ExtractTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing changed, so the archive is reproduced exactly
+	buf := bytes.NewBuffer(nil)
+	changes, err := RebuildTarStream(storage.NewJSONUnpacker(bytes.NewReader(meta)), dir, storage.NewJSONPacker(ioutil.Discard), nil, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+	if !bytes.Equal(buf.Bytes(), original) {
+		t.Errorf("expected the unchanged archive to be identical")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "dir/hurr.txt"), []byte("imma derp til I hurr!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "empty")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "dir"), files[0].hdr.ModTime, files[0].hdr.ModTime); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	newMeta := bytes.NewBuffer(nil)
+	newFgp := storage.NewBufferFileGetPutter()
+	changes, err = RebuildTarStream(storage.NewJSONUnpacker(bytes.NewReader(meta)), dir, storage.NewJSONPacker(newMeta), newFgp, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]ChangeKind{
+		"dir/hurr.txt":  Modified,
+		"dir/hurr2.txt": Modified, // the hard link's target changed size
+		"empty":         Deleted,
+		"new.txt":       Added,
+	}
+	for _, c := range changes {
+		if expected[c.Name] != c.Kind {
+			t.Errorf("unexpected change %s of %q", c.Kind, c.Name)
+		}
+		delete(expected, c.Name)
+	}
+	if len(expected) > 0 {
+		t.Errorf("missing changes %v", expected)
+	}
+
+	bodies := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies[hdr.Name] = string(body)
+	}
+	if bodies["dir/hurr.txt"] != "imma derp til I hurr!" || bodies["new.txt"] != "new" {
+		t.Errorf("unexpected contents of the rebuilt archive %v", bodies)
+	}
+	if _, ok := bodies["empty"]; ok {
+		t.Errorf("expected deleted file to be left out")
+	}
+
+	// and the new metadata reassembles the rebuilt archive
+	rc := NewOutputTarStream(newFgp, storage.NewJSONUnpacker(newMeta))
+	reassembled, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reassembled, buf.Bytes()) {
+		t.Errorf("expected the new metadata to reassemble the rebuilt archive")
+	}
+}
+
+func TestWriteHeaderLike(t *testing.T) {
+	const size = 8<<30 + 1
+	for _, pax := range []bool{false, true} {
+		raw := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(raw)
+		tw.PAXNumbers = pax
+		hdr := &tar.Header{Name: "big.db", Mode: 0644, Size: size, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		hdr.Name = "renamed.db"
+		out := bytes.NewBuffer(nil)
+		if err := writeHeaderLike(out, hdr, raw.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if record := bytes.Contains(out.Bytes(), []byte(" size=8589934593\n")); record != pax {
+			t.Errorf("pax %v: expected a PAX size record %v, as the header rewritten", pax, pax)
+		}
+		got, err := tar.NewReader(bytes.NewReader(out.Bytes())).Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "renamed.db" || got.Size != size {
+			t.Errorf("pax %v: unexpected header %q of size %d", pax, got.Name, got.Size)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ErrUnmappedID is returned when the uid or gid of an entry is not within any
+// of the ID mappings.
+var ErrUnmappedID = errors.New("asm: id is not mapped")
+
+// UnmappedIDError is the uid or gid of an entry that is not within any of the
+// ID mappings. It is ErrUnmappedID to errors.Is.
+type UnmappedIDError struct {
+	// Kind is "uid" or "gid"
+	Kind string
+	ID   int
+	// Name of the entry
+	Name string
+}
+
+func (uie *UnmappedIDError) Error() string {
+	return fmt.Sprintf("%s: %s %d of %q", ErrUnmappedID, uie.Kind, uie.ID, uie.Name)
+}
+
+// Is is whether `target` is ErrUnmappedID
+func (uie *UnmappedIDError) Is(target error) bool {
+	return target == ErrUnmappedID
+}
+
+// IDMap maps a range of IDs, like a line of the uid_map or gid_map of a Linux
+// user namespace.
+type IDMap struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// ParseIDMap parses an IDMap from "containerID:hostID:size"
+func ParseIDMap(s string) (IDMap, error) {
+	var m IDMap
+	fields := strings.Split(s, ":")
+	if len(fields) != 3 {
+		return m, fmt.Errorf("asm: invalid id mapping %q", s)
+	}
+	for i, p := range []*int{&m.ContainerID, &m.HostID, &m.Size} {
+		n, err := strconv.Atoi(fields[i])
+		if err != nil || n < 0 {
+			return m, fmt.Errorf("asm: invalid id mapping %q", s)
+		}
+		*p = n
+	}
+	return m, nil
+}
+
+// mapID maps the container `id` to the host, or is not ok if it is not within
+// any of the mappings. If there are no mappings, it is unchanged.
+func mapID(maps []IDMap, id int) (int, bool) {
+	if len(maps) == 0 {
+		return id, true
+	}
+	for _, m := range maps {
+		if id >= m.ContainerID && id-m.ContainerID < m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return 0, false
+}
+
+// WriteRemappedTarStream is like WriteOutputTarStream, but shifts the uid and
+// gid of each entry by the mappings, e.g. for a rootless container runtime to
+// get a pre-shifted archive straight from the store. The metadata keeps the
+// original IDs.
+//
+// The IDs are rewritten in place in the raw headers, including their PAX
+// records, so the headers are otherwise unchanged. Empty mappings leave the
+// IDs as they are.
+func WriteRemappedTarStream(fg storage.FileGetter, up storage.Unpacker, uidMaps, gidMaps []IDMap, w io.Writer) error {
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// the padding of the file before, as it was recorded
+		if _, err := w.Write(hr.Padding()); err != nil {
+			return err
+		}
+		if err == io.EOF {
+			break
+		}
+		uid, ok := mapID(uidMaps, hdr.Uid)
+		if !ok {
+			return &UnmappedIDError{Kind: "uid", ID: hdr.Uid, Name: hdr.Name}
+		}
+		gid, ok := mapID(gidMaps, hdr.Gid)
+		if !ok {
+			return &UnmappedIDError{Kind: "gid", ID: hdr.Gid, Name: hdr.Name}
+		}
+		raw := hr.RawHeader()
+		if uid != hdr.Uid || gid != hdr.Gid {
+			if raw, err = remapRawHeader(raw, uid, gid); err != nil {
+				return fmt.Errorf("%s: header of %q", err, hdr.Name)
+			}
+		}
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+		if err := writeEntryData(w, fg, entry); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(hr.Trailer())
+	return err
+}
+
+// remapRawHeader returns a copy of the raw header blocks of an entry, with
+// the uid and gid set in its header and any PAX records.
+func remapRawHeader(raw []byte, uid, gid int) ([]byte, error) {
+	var out []byte
+	for len(raw) >= blockSize {
+		block := append([]byte(nil), raw[:blockSize]...)
+		raw = raw[blockSize:]
+		switch block[156] {
+		case 'x', 'L', 'K', 'g':
+			size, err := parseNumeric(block[124:136])
+			if err != nil {
+				return nil, err
+			}
+			padded := size + (-size & (blockSize - 1))
+			if int64(len(raw)) < padded {
+				return nil, io.ErrUnexpectedEOF
+			}
+			data := raw[:padded]
+			raw = raw[padded:]
+			if block[156] == 'x' {
+				records, err := remapPAXRecords(data[:size], uid, gid)
+				if err != nil {
+					return nil, err
+				}
+				formatNumeric(block[124:136], int64(len(records)))
+				setChecksum(block)
+				data = append(records, make([]byte, -len(records)&(blockSize-1))...)
+			}
+			out = append(out, block...)
+			out = append(out, data...)
+		default:
+			formatNumeric(block[108:116], int64(uid))
+			formatNumeric(block[116:124], int64(gid))
+			setChecksum(block)
+			out = append(out, block...)
+			// e.g. the extended blocks of an old GNU sparse header
+			return append(out, raw...), nil
+		}
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// remapPAXRecords rewrites the "uid" and "gid" PAX records
+func remapPAXRecords(records []byte, uid, gid int) ([]byte, error) {
+	var out []byte
+	s := string(records)
+	for len(s) > 0 {
+		sp := strings.IndexByte(s, ' ')
+		if sp < 0 {
+			return nil, errors.New("asm: invalid PAX record")
+		}
+		n, err := strconv.Atoi(s[:sp])
+		if err != nil || n <= sp || n > len(s) {
+			return nil, errors.New("asm: invalid PAX record")
+		}
+		record := s[:n]
+		s = s[n:]
+		kv := strings.TrimSuffix(record[sp+1:], "\n")
+		switch {
+		case strings.HasPrefix(kv, "uid="):
+			record = formatPAXRecord("uid", strconv.Itoa(uid))
+		case strings.HasPrefix(kv, "gid="):
+			record = formatPAXRecord("gid", strconv.Itoa(gid))
+		}
+		out = append(out, record...)
+	}
+	return out, nil
+}
+
+// formatPAXRecord formats a PAX record, prefixed by its own length
+func formatPAXRecord(k, v string) string {
+	const padding = 3 // the space, the '=' and the newline
+	size := len(k) + len(v) + padding
+	size += len(strconv.Itoa(size))
+	record := fmt.Sprintf("%d %s=%s\n", size, k, v)
+	if len(record) != size {
+		// the length grew a digit by including itself
+		size = len(record)
+		record = fmt.Sprintf("%d %s=%s\n", size, k, v)
+	}
+	return record
+}
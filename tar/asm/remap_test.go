@@ -0,0 +1,150 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestWriteRemappedTarStream(t *testing.T) {
+	files := append([]testFile{}, testFiles...)
+	files = append(files,
+		testFile{hdr: tar.Header{Name: "owned", Typeflag: tar.TypeReg, Mode: 0644, Uid: 1000, Gid: 1000}, body: "mine"},
+		// too large for octal
+		testFile{hdr: tar.Header{Name: "big-id", Typeflag: tar.TypeReg, Mode: 0644, Uid: 3000000, Gid: 5, Xattrs: map[string]string{"user.x": "y"}}, body: "big"},
+	)
+	archive := buildTar(t, files)
+	meta, fgp := disassemble(t, archive)
+
+	// without mappings, it is the original archive
+	buf := bytes.NewBuffer(nil)
+	if err := WriteRemappedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), nil, nil, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), archive) {
+		t.Fatalf("expected the original archive of %d bytes, got %d bytes", len(archive), buf.Len())
+	}
+
+	uidMaps := []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}, {ContainerID: 3000000, HostID: 4000000, Size: 1}}
+	gidMaps := []IDMap{{ContainerID: 0, HostID: 200000, Size: 65536}}
+	buf.Reset()
+	if err := WriteRemappedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), uidMaps, gidMaps, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != len(archive) {
+		t.Errorf("expected the remapped archive to be %d bytes, got %d", len(archive), buf.Len())
+	}
+	tr := tar.NewReader(buf)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			if i != len(files) {
+				t.Errorf("expected %d entries, got %d", len(files), i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := files[i].hdr
+		uid, gid := expected.Uid+100000, expected.Gid+200000
+		if expected.Uid == 3000000 {
+			uid = 4000000
+		}
+		if hdr.Name != expected.Name || hdr.Uid != uid || hdr.Gid != gid {
+			t.Errorf("expected %q to be owned by %d:%d, got %q %d:%d", expected.Name, uid, gid, hdr.Name, hdr.Uid, hdr.Gid)
+		}
+		if len(expected.Xattrs) > 0 && hdr.Xattrs["user.x"] != "y" {
+			t.Errorf("expected the other PAX records of %q to be kept, got %v", hdr.Name, hdr.Xattrs)
+		}
+		payload, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(payload) != files[i].body {
+			t.Errorf("expected %q to have %q, got %q", hdr.Name, files[i].body, payload)
+		}
+	}
+}
+
+func TestRemapPAXRecords(t *testing.T) {
+	records := formatPAXRecord("uid", "3000000") + formatPAXRecord("SCHILY.xattr.user.x", "y") + formatPAXRecord("gid", "7")
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "PaxHeaders.0/big-id", Typeflag: tar.TypeXHeader, Size: int64(len(records))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(tw, records); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "big-id", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(tw, "big"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	meta, fgp := disassemble(t, buf.Bytes())
+
+	out := bytes.NewBuffer(nil)
+	uidMaps := []IDMap{{ContainerID: 3000000, HostID: 40000000, Size: 1}}
+	gidMaps := []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	if err := WriteRemappedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), uidMaps, gidMaps, out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(formatPAXRecord("uid", "40000000"))) ||
+		!bytes.Contains(out.Bytes(), []byte(formatPAXRecord("gid", "100007"))) {
+		t.Error("expected the PAX records to be rewritten")
+	}
+	hdr, err := tar.NewReader(out).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Uid != 40000000 || hdr.Gid != 100007 || hdr.Xattrs["user.x"] != "y" {
+		t.Errorf("expected 40000000:100007 with its xattr, got %d:%d %v", hdr.Uid, hdr.Gid, hdr.Xattrs)
+	}
+}
+
+func TestWriteRemappedTarStreamPadding(t *testing.T) {
+	// an identity mapping leaves the archive as it is, padding and all
+	identity := []IDMap{{ContainerID: 0, HostID: 0, Size: 1 << 30}}
+	for name, archive := range paddedArchives(t) {
+		meta, fgp := disassemble(t, archive)
+		buf := bytes.NewBuffer(nil)
+		if err := WriteRemappedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), identity, identity, buf); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), archive) {
+			t.Errorf("%s: expected the identity mapping to be the archive byte for byte", name)
+		}
+	}
+}
+
+func TestWriteRemappedTarStreamUnmapped(t *testing.T) {
+	meta, fgp := disassemble(t, buildTar(t, testFiles))
+	err := WriteRemappedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), []IDMap{{ContainerID: 1, HostID: 100000, Size: 10}}, nil, ioutil.Discard)
+	if uie, ok := err.(*UnmappedIDError); !ok || !uie.Is(ErrUnmappedID) || uie.Kind != "uid" || uie.ID != 0 {
+		t.Errorf("expected ErrUnmappedID, got %v", err)
+	}
+}
+
+func TestParseIDMap(t *testing.T) {
+	m, err := ParseIDMap("0:100000:65536")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != (IDMap{ContainerID: 0, HostID: 100000, Size: 65536}) {
+		t.Errorf("unexpected mapping %+v", m)
+	}
+	for _, s := range []string{"0:1", "a:b:c", "0:-1:1"} {
+		if _, err := ParseIDMap(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
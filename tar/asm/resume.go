@@ -0,0 +1,86 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// Checkpoint is the state of a disassembly after a file, to resume it from
+// with ResumeInputTarStream, as NewCheckpointedInputTarStream reports it
+type Checkpoint struct {
+	// Offset in the archive to resume reading it from, at the end of the
+	// payload of the last file
+	Offset int64 `json:"offset"`
+	// Entries is the number of entries packed, up to and including those of
+	// the last file
+	Entries int `json:"entries"`
+	// LastEntry is the name of the last file
+	LastEntry string `json:"last_entry"`
+}
+
+// CheckpointFunc is called with a Checkpoint after each file is
+// disassembled. It is to persist the checkpoint, along with the metadata
+// packed and payloads stored so far (flushing the writer of the Packer, if it
+// is buffered), for a disassembly that fails after it to be resumed from it.
+// It need not persist every checkpoint, only the last it has. An error
+// returned stops the disassembly with it.
+type CheckpointFunc func(cp Checkpoint) error
+
+// checkpointPadding is the size of the padding after a payload ending at
+// `offset`, up to the block that the next header starts at
+func checkpointPadding(offset int64) int64 {
+	return (blockSize - offset%blockSize) % blockSize
+}
+
+// NewCheckpointedInputTarStream is like NewInputTarStream, but calls `fn`
+// with a Checkpoint after each file, for very large archives that are read
+// over a connection that may fail. Once it does, the disassembly is resumed
+// with ResumeInputTarStream, rather than started over.
+func NewCheckpointedInputTarStream(r io.Reader, p storage.Packer, fp storage.FilePutter, fn CheckpointFunc) (io.Reader, error) {
+	pR, pW := io.Pipe()
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	go disassembleTo(r, p, fp, disassembleOptions{checkpoint: fn}, pW)
+	return pR, nil
+}
+
+// ResumeInputTarStream resumes a disassembly from the Checkpoint `cp`, with
+// the metadata packed before it read from `up`, and the archive read from `r`
+// from cp.Offset on, like the body of an HTTP range request. `fp` is to have
+// the payloads stored before the checkpoint already, as a FilePutter that
+// persists them does.
+//
+// The entries of `up` up to the checkpoint are packed to `p` again, so the
+// Packer is in the same state as when it packed them, and those after it, of
+// a file that was not finished, are dropped. The rest of the archive is then
+// disassembled as NewCheckpointedInputTarStream does, calling `fn` after each
+// file if not nil, so the metadata of `p` is the same as if it were
+// disassembled at once. The returned Reader is of the archive from
+// cp.Offset on.
+func ResumeInputTarStream(up storage.Unpacker, cp Checkpoint, r io.Reader, p storage.Packer, fp storage.FilePutter, fn CheckpointFunc) (io.Reader, error) {
+	if cp.Offset < 0 || cp.Entries < 0 {
+		return nil, fmt.Errorf("asm: invalid checkpoint at offset %d, after %d entries", cp.Offset, cp.Entries)
+	}
+	for i := 0; i < cp.Entries; i++ {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("asm: the metadata has %d entries, fewer than the %d of the checkpoint", i, cp.Entries)
+			}
+			return nil, err
+		}
+		if _, err := p.AddEntry(*entry); err != nil {
+			return nil, err
+		}
+	}
+
+	pR, pW := io.Pipe()
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	go disassembleTo(r, p, fp, disassembleOptions{from: &cp, checkpoint: fn}, pW)
+	return pR, nil
+}
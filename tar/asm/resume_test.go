@@ -0,0 +1,99 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestResumeInputTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	for _, packing := range []struct {
+		name      string
+		newPacker func(io.Writer) storage.Packer
+	}{
+		{"json", storage.NewJSONPacker},
+		{"compact-json", storage.NewCompactJSONPacker},
+	} {
+		// at once, keeping each checkpoint
+		meta := bytes.NewBuffer(nil)
+		fgp := storage.NewBufferFileGetPutter()
+		var checkpoints []Checkpoint
+		rdr, err := NewCheckpointedInputTarStream(bytes.NewReader(archive), packing.newPacker(meta), fgp, func(cp Checkpoint) error {
+			checkpoints = append(checkpoints, cp)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+			t.Fatal(err)
+		}
+		if len(checkpoints) != len(testFiles) {
+			t.Fatalf("%s: expected a checkpoint for each of the %d files, got %d", packing.name, len(testFiles), len(checkpoints))
+		}
+
+		for _, cp := range checkpoints {
+			// the metadata has all the entries, of which those after the
+			// checkpoint are dropped
+			resumed := bytes.NewBuffer(nil)
+			var after int
+			rdr, err := ResumeInputTarStream(storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), cp, bytes.NewReader(archive[cp.Offset:]), packing.newPacker(resumed), fgp, func(Checkpoint) error {
+				after++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest, err := ioutil.ReadAll(rdr)
+			if err != nil {
+				t.Fatalf("%s: resuming after %q: %v", packing.name, cp.LastEntry, err)
+			}
+			if !bytes.Equal(rest, archive[cp.Offset:]) {
+				t.Errorf("%s: expected the archive from offset %d", packing.name, cp.Offset)
+			}
+			if !bytes.Equal(resumed.Bytes(), meta.Bytes()) {
+				t.Errorf("%s: resuming after %q, expected the same metadata as at once:\n%s\ngot:\n%s", packing.name, cp.LastEntry, meta.Bytes(), resumed.Bytes())
+			}
+			if expected := len(checkpoints) - 1 - indexOfCheckpoint(checkpoints, cp); after != expected {
+				t.Errorf("%s: expected %d checkpoints after %q, got %d", packing.name, expected, cp.LastEntry, after)
+			}
+		}
+	}
+}
+
+func indexOfCheckpoint(checkpoints []Checkpoint, cp Checkpoint) int {
+	for i, c := range checkpoints {
+		if c == cp {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestResumeInputTarStreamErrors(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, _ := disassemble(t, archive)
+
+	// a checkpoint of more entries than the metadata has
+	cp := Checkpoint{Offset: int64(len(archive)), Entries: 1000}
+	if _, err := ResumeInputTarStream(storage.NewJSONUnpacker(bytes.NewReader(meta)), cp, bytes.NewReader(nil), storage.NewJSONPacker(ioutil.Discard), nil, nil); err == nil {
+		t.Error("expected a checkpoint past the metadata to fail")
+	}
+
+	// the error of the CheckpointFunc stops the disassembly
+	errStop := errors.New("stop")
+	rdr, err := NewCheckpointedInputTarStream(bytes.NewReader(archive), storage.NewJSONPacker(ioutil.Discard), nil, func(Checkpoint) error {
+		return errStop
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, rdr); err != errStop {
+		t.Errorf("expected the error of the CheckpointFunc, got %v", err)
+	}
+}
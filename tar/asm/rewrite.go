@@ -0,0 +1,173 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// Rewrite changes the header of an entry in place, like its name or owner, as
+// the archive is assembled by a Rewriter. It returns false to leave the entry
+// out. The size can not be changed, as the payload is the one of the
+// metadata.
+type Rewrite func(hdr *tar.Header) (bool, error)
+
+// Rewriter assembles an archive from its metadata with the headers of its
+// entries rewritten, by the Rewrites registered, in order. The headers that
+// are rewritten are written anew, with their checksums, and extended headers
+// for what does not fit the ustar fields, while the others keep their raw
+// headers as they were, so only the rewritten entries differ from the
+// original archive.
+type Rewriter struct {
+	rewrites []Rewrite
+}
+
+// NewRewriter returns a Rewriter of `rewrites`, to register more to
+func NewRewriter(rewrites ...Rewrite) *Rewriter {
+	return &Rewriter{rewrites: rewrites}
+}
+
+// Register `fn`, to rewrite each header after the Rewrites registered before
+// it, so it sees the name given by an earlier rename
+func (rw *Rewriter) Register(fn Rewrite) {
+	rw.rewrites = append(rw.rewrites, fn)
+}
+
+// NewTarStream is like NewOutputTarStream, with the entries rewritten
+func (rw *Rewriter) NewTarStream(fg storage.FileGetter, up storage.Unpacker) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := rw.WriteTarStream(fg, up, pw)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// WriteTarStream writes to `w` the archive of the metadata read from `up` and
+// the payloads of `fg`, with the entries rewritten. A hard link to an entry
+// left out fails, as it could not be extracted. The entries rewritten are
+// returned, by their new name.
+func (rw *Rewriter) WriteTarStream(fg storage.FileGetter, up storage.Unpacker, w io.Writer) ([]Change, error) {
+	var changes []Change
+	deleted := map[string]struct{}{}
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		before := *hdr
+		if hdr.Xattrs != nil {
+			before.Xattrs = make(map[string]string, len(hdr.Xattrs))
+			for k, v := range hdr.Xattrs {
+				before.Xattrs[k] = v
+			}
+		}
+		keep := true
+		for _, fn := range rw.rewrites {
+			if keep, err = fn(hdr); err != nil {
+				return nil, err
+			} else if !keep {
+				break
+			}
+		}
+		if !keep {
+			// by the name that hard links to it are renamed to as well
+			deleted[cleanEntryName(hdr.Name)] = struct{}{}
+			changes = append(changes, Change{Name: hdr.Name, Kind: Deleted})
+			continue
+		}
+		if hdr.Size != before.Size {
+			return nil, fmt.Errorf("asm: the size of %q is rewritten, rather than only its header", before.Name)
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			if _, ok := deleted[cleanEntryName(hdr.Linkname)]; ok {
+				return nil, fmt.Errorf("asm: %q is a hard link to the deleted %q", hdr.Name, hdr.Linkname)
+			}
+		}
+		if !reflect.DeepEqual(before, *hdr) {
+			changes = append(changes, Change{Name: hdr.Name, Kind: Modified})
+			if err := writeHeaderLike(w, hdr, hr.RawHeader()); err != nil {
+				return nil, err
+			}
+		} else if _, err := w.Write(hr.RawHeader()); err != nil {
+			return nil, err
+		}
+		if err := writeEntryPayload(w, fg, entry); err != nil {
+			return nil, err
+		}
+	}
+	trailer := hr.Trailer()
+	if len(trailer) == 0 {
+		trailer = make([]byte, blockSize*2)
+	}
+	if _, err := w.Write(trailer); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// EditRewrite is the Rewrite of `edits`, as EditTarStream applies them
+func EditRewrite(edits []Edit) Rewrite {
+	return func(hdr *tar.Header) (bool, error) {
+		_, del := applyEdits(hdr, edits)
+		return !del, nil
+	}
+}
+
+// RenameRewrite renames the entry `from`, and the entries under it, to `to`,
+// and the hard links to them with them
+func RenameRewrite(from, to string) Rewrite {
+	return EditRewrite([]Edit{{Op: EditRename, From: cleanEntryName(from), To: cleanEntryName(to)}})
+}
+
+// StripPrefixRewrite strips the directory `prefix` from the names of the
+// entries under it, and of the hard links to them, like "rootfs/" of an
+// archive of a whole image directory. The entry of the directory itself is
+// left out, and the entries not under it are left as they are.
+func StripPrefixRewrite(prefix string) Rewrite {
+	prefix = cleanEntryName(prefix)
+	strip := func(name string) string {
+		clean := cleanEntryName(name)
+		if !strings.HasPrefix(clean, prefix+"/") {
+			return name
+		}
+		stripped := clean[len(prefix)+1:]
+		if strings.HasSuffix(name, "/") {
+			stripped += "/"
+		}
+		return stripped
+	}
+	return func(hdr *tar.Header) (bool, error) {
+		if cleanEntryName(hdr.Name) == prefix {
+			return false, nil
+		}
+		hdr.Name = strip(hdr.Name)
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = strip(hdr.Linkname)
+		}
+		return true, nil
+	}
+}
+
+// ChownRewrite sets the owner of all of the entries to `uid` and `gid`, or
+// leaves either as it is if -1. The user and group names are cleared, as they
+// would take precedence over the ids on extraction.
+func ChownRewrite(uid, gid int) Rewrite {
+	return func(hdr *tar.Header) (bool, error) {
+		if uid >= 0 && hdr.Uid != uid {
+			hdr.Uid, hdr.Uname = uid, ""
+		}
+		if gid >= 0 && hdr.Gid != gid {
+			hdr.Gid, hdr.Gname = gid, ""
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,83 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestRewriter(t *testing.T) {
+	meta, fgp := disassemble(t, buildTar(t, testFiles))
+	rw := NewRewriter(StripPrefixRewrite("./dir/"), ChownRewrite(1000, -1))
+	rw.Register(func(hdr *tar.Header) (bool, error) {
+		return hdr.Name != "empty", nil
+	})
+	rw.Register(RenameRewrite("hurr.txt", "renamed.txt"))
+
+	rc := rw.NewTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)))
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	expected := []struct {
+		name, linkname, body string
+	}{
+		{"renamed.txt", "", "imma hurr til I derp"},
+		{"derp", "hurr.txt", ""},
+		{"hurr2.txt", "renamed.txt", ""},
+		{strings.Repeat("long", 50), "", "a long name"},
+	}
+	for i, e := range expected {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != e.name || hdr.Linkname != e.linkname || string(body) != e.body {
+			t.Errorf("entry %d: expected %q (%q) of %q, got %q (%q) of %q", i, e.name, e.linkname, e.body, hdr.Name, hdr.Linkname, body)
+		}
+		if hdr.Uid != 1000 {
+			t.Errorf("%s: expected the uid rewritten to 1000, got %d", hdr.Name, hdr.Uid)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected the end of the archive, got %v", err)
+	}
+}
+
+func TestRewriterUnchanged(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+	buf := bytes.NewBuffer(nil)
+	// renamed to themselves, the entries keep their raw headers
+	changes, err := NewRewriter(RenameRewrite("dir", "dir")).WriteTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 || !bytes.Equal(buf.Bytes(), archive) {
+		t.Errorf("expected the archive as it was, got %d changes", len(changes))
+	}
+}
+
+func TestRewriterErrors(t *testing.T) {
+	meta, fgp := disassemble(t, buildTar(t, testFiles))
+	resize := NewRewriter(func(hdr *tar.Header) (bool, error) {
+		hdr.Size++
+		return true, nil
+	})
+	if _, err := resize.WriteTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), ioutil.Discard); err == nil || !strings.Contains(err.Error(), "size") {
+		t.Errorf("expected a rewritten size to fail, got %v", err)
+	}
+	drop := NewRewriter(func(hdr *tar.Header) (bool, error) {
+		return hdr.Name != "dir/hurr.txt", nil
+	})
+	if _, err := drop.WriteTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), ioutil.Discard); err == nil || !strings.Contains(err.Error(), "hard link") {
+		t.Errorf("expected a hard link to an entry left out to fail, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ErrInvalidOffset is returned by a TarReadSeeker for a negative offset
+var ErrInvalidOffset = errors.New("asm: invalid offset")
+
+// TarReadSeeker is the assembled tar archive, that any window of can be read
+// without assembling what is before it, e.g. to serve ranged requests of it.
+//
+// Unlike WriteOutputTarStream, the file payloads are not checked against
+// their checksums, as a window rarely holds all of one.
+type TarReadSeeker struct {
+	fg      storage.FileGetter
+	extents []extent
+	size    int64
+	offset  int64
+
+	mu   sync.Mutex
+	open *openPayload
+}
+
+// extent is where a segment, or the payload of a FileType entry, is in the
+// archive
+type extent struct {
+	offset int64
+	// segment is the raw bytes, if this is not a file payload
+	segment []byte
+	entry   *storage.Entry
+}
+
+func (e extent) length() int64 {
+	if e.entry != nil {
+		return e.entry.DataSize()
+	}
+	return int64(len(e.segment))
+}
+
+// openPayload is the file payload last read from, kept open for the read
+// that follows on from it
+type openPayload struct {
+	entry *storage.Entry
+	rc    io.ReadCloser
+	r     io.Reader
+	pos   int64
+}
+
+// NewTarReadSeeker reads all of `up`, and returns the archive it assembles
+// with the payloads from `fg`. Only the payloads are read on demand; the
+// metadata is held in memory.
+func NewTarReadSeeker(fg storage.FileGetter, up storage.Unpacker) (*TarReadSeeker, error) {
+	trs := &TarReadSeeker{fg: fg}
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		var e extent
+		switch entry.Type {
+		case storage.SegmentType:
+			e = extent{segment: entry.Payload}
+		case storage.FileType:
+			if entry.Special != "" {
+				if err := checkInline(entry); err != nil {
+					return nil, err
+				}
+				e = extent{segment: entry.Inline}
+			} else {
+				e = extent{entry: entry}
+			}
+		default:
+			continue
+		}
+		if e.length() == 0 {
+			continue
+		}
+		e.offset = trs.size
+		trs.extents = append(trs.extents, e)
+		trs.size += e.length()
+	}
+	return trs, nil
+}
+
+// Size is the length of the assembled archive
+func (trs *TarReadSeeker) Size() int64 {
+	return trs.size
+}
+
+// Read reads from the current offset, like io.Reader
+func (trs *TarReadSeeker) Read(p []byte) (int, error) {
+	n, err := trs.ReadAt(p, trs.offset)
+	trs.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read, like io.Seeker
+func (trs *TarReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 0:
+	case 1:
+		offset += trs.offset
+	case 2:
+		offset += trs.size
+	default:
+		return 0, fmt.Errorf("asm: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, ErrInvalidOffset
+	}
+	trs.offset = offset
+	return offset, nil
+}
+
+// ReadAt reads the window of the archive at `off`, like io.ReaderAt
+func (trs *TarReadSeeker) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrInvalidOffset
+	}
+	i := sort.Search(len(trs.extents), func(i int) bool {
+		return trs.extents[i].offset+trs.extents[i].length() > off
+	})
+	var n int
+	for ; n < len(p) && i < len(trs.extents); i++ {
+		e := trs.extents[i]
+		within := off + int64(n) - e.offset
+		want := p[n:]
+		if rest := e.length() - within; int64(len(want)) > rest {
+			want = want[:rest]
+		}
+		if e.entry == nil {
+			n += copy(want, e.segment[within:])
+			continue
+		}
+		m, err := trs.readPayload(e.entry, want, within)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readPayload fills `p` from the payload of `entry` at `within`, carrying on
+// with the payload that is already open if the read follows on from the last
+func (trs *TarReadSeeker) readPayload(entry *storage.Entry, p []byte, within int64) (int, error) {
+	trs.mu.Lock()
+	defer trs.mu.Unlock()
+	op := trs.open
+	if op == nil || op.entry != entry || op.pos != within {
+		if op != nil {
+			op.rc.Close()
+			trs.open = nil
+		}
+		fh, err := getPayload(trs.fg, entry)
+		if err != nil {
+			return 0, err
+		}
+		var r io.Reader = fh
+		start := entry.PartOffset + within
+		if len(entry.Sparse) > 0 && !entry.SparsePacked {
+			// the data of a sparse file stored with its holes is only found
+			// by reading through them
+			r = storage.NewSparseDataReader(fh, entry.Sparse)
+			_, err = io.CopyN(ioutil.Discard, r, within)
+		} else if s, ok := fh.(io.Seeker); ok {
+			_, err = s.Seek(start, 0)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, fh, start)
+		}
+		if err != nil {
+			fh.Close()
+			return 0, fmt.Errorf("asm: %q at offset %d: %v", entry.GetName(), start, err)
+		}
+		op = &openPayload{entry: entry, rc: fh, r: io.LimitReader(r, entry.DataSize()-within), pos: within}
+		trs.open = op
+	}
+	n, err := io.ReadFull(op.r, p)
+	op.pos += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("asm: payload of %q is short", entry.GetName())
+	}
+	return n, err
+}
+
+// Close closes the payload that is open, if any
+func (trs *TarReadSeeker) Close() error {
+	trs.mu.Lock()
+	defer trs.mu.Unlock()
+	if trs.open == nil {
+		return nil
+	}
+	err := trs.open.rc.Close()
+	trs.open = nil
+	return err
+}
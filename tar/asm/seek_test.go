@@ -0,0 +1,61 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestTarReadSeeker(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+	trs, err := NewTarReadSeeker(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trs.Close()
+	if trs.Size() != int64(len(archive)) {
+		t.Fatalf("expected size %d, got %d", len(archive), trs.Size())
+	}
+
+	all, err := ioutil.ReadAll(trs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(all, archive) {
+		t.Fatal("expected the whole archive from Read")
+	}
+
+	// every window that starts and ends in or across headers and payloads
+	for _, w := range [][2]int64{{0, 1}, {500, 30}, {512, 20}, {515, 600}, {1020, 4}, {int64(len(archive)) - 10, 10}} {
+		p := make([]byte, w[1])
+		n, err := trs.ReadAt(p, w[0])
+		if err != nil {
+			t.Fatalf("window %v: %v", w, err)
+		}
+		if !bytes.Equal(p[:n], archive[w[0]:w[0]+w[1]]) {
+			t.Errorf("window %v: mismatched bytes", w)
+		}
+	}
+
+	if _, err := trs.Seek(-20, 2); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 40)
+	n, err := trs.ReadAt(p, int64(len(archive))-20)
+	if n != 20 || err != io.EOF {
+		t.Errorf("expected 20 bytes and io.EOF past the end, got %d and %v", n, err)
+	}
+	if n, err := trs.Read(p); n != 20 || err != nil {
+		t.Errorf("expected 20 bytes from Read at the end, got %d and %v", n, err)
+	}
+	if _, err := trs.Read(p); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if _, err := trs.Seek(-1, 0); err != ErrInvalidOffset {
+		t.Errorf("expected ErrInvalidOffset, got %v", err)
+	}
+}
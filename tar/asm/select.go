@@ -0,0 +1,141 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// Selector decides whether an entry of the archive is kept by
+// WriteSelectedTarStream, from its header
+type Selector func(hdr *tar.Header) bool
+
+// SelectPatterns returns a Selector of the entries matching any of the
+// `include` patterns (or all of them, if there are none), and none of the
+// `exclude` patterns. Patterns are matched against the entry names as with
+// path.Match, and an entry is matched if a directory it is under is, so
+// "usr/share" selects everything under it.
+func SelectPatterns(include, exclude []string) (Selector, error) {
+	inc, err := cleanPatterns(include)
+	if err != nil {
+		return nil, err
+	}
+	exc, err := cleanPatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return func(hdr *tar.Header) bool {
+		name := cleanEntryName(hdr.Name)
+		for _, pattern := range exc {
+			if matchUnder(pattern, name) {
+				return false
+			}
+		}
+		if len(inc) == 0 {
+			return true
+		}
+		for _, pattern := range inc {
+			if matchUnder(pattern, name) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// cleanPatterns checks the patterns, and cleans them as the entry names are
+func cleanPatterns(patterns []string) ([]string, error) {
+	var clean []string
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("asm: pattern %q: %v", pattern, err)
+		}
+		clean = append(clean, cleanEntryName(pattern))
+	}
+	return clean, nil
+}
+
+// NewSelectedTarStream returns an io.ReadCloser of the archive written by
+// WriteSelectedTarStream, like NewOutputTarStream.
+func NewSelectedTarStream(fg storage.FileGetter, up storage.Unpacker, sel Selector) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(WriteSelectedTarStream(fg, up, sel, pw))
+	}()
+	return pr
+}
+
+// WriteSelectedTarStream writes to `w` a tar archive of only the entries of
+// the metadata in `up` that `sel` keeps, with the payloads of `fg`. The
+// payloads of the entries left out are not read.
+//
+// The entries kept have their raw headers as they were, with their payloads
+// padded anew, and the archive ends with the original end of archive marker.
+// A hard link to an entry that is left out fails, as it could not be
+// extracted.
+func WriteSelectedTarStream(fg storage.FileGetter, up storage.Unpacker, sel Selector, w io.Writer) error {
+	trailer, err := selectEntries(up, sel, func(raw []byte, entry *storage.Entry) error {
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+		return writeEntryPayload(w, fg, entry)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(trailer)
+	return err
+}
+
+// SelectedTarSize returns the size of the archive that WriteSelectedTarStream
+// writes, from the metadata in `up` alone, like storage.TarSize
+func SelectedTarSize(up storage.Unpacker, sel Selector) (int64, error) {
+	var size int64
+	trailer, err := selectEntries(up, sel, func(raw []byte, entry *storage.Entry) error {
+		size += int64(len(raw))
+		if entry.Size > 0 {
+			n := entry.DataSize()
+			size += n + -n&(blockSize-1)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size + int64(len(trailer)), nil
+}
+
+// selectEntries calls `fn` with the raw header and entry of each file of the
+// metadata in `up` that `sel` keeps, and returns the end of archive marker
+func selectEntries(up storage.Unpacker, sel Selector, fn func(raw []byte, entry *storage.Entry) error) ([]byte, error) {
+	skipped := map[string]struct{}{}
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if !sel(hdr) {
+			skipped[cleanEntryName(hdr.Name)] = struct{}{}
+			continue
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			if _, ok := skipped[cleanEntryName(hdr.Linkname)]; ok {
+				return nil, fmt.Errorf("asm: %q is a hard link to %q, which is not selected", hdr.Name, hdr.Linkname)
+			}
+		}
+		if err := fn(hr.RawHeader(), entry); err != nil {
+			return nil, err
+		}
+	}
+	if trailer := hr.Trailer(); len(trailer) > 0 {
+		return trailer, nil
+	}
+	return endOfArchive, nil
+}
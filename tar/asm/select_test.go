@@ -0,0 +1,65 @@
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestWriteSelectedTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+
+	cases := []struct {
+		include, exclude []string
+		expected         []testFile
+	}{
+		{nil, nil, testFiles},
+		{[]string{"./dir"}, []string{"dir/long*"}, testFiles[:4]},
+		{[]string{"empty", "dir/*.txt"}, []string{"dir/hurr2.txt"}, []testFile{testFiles[1], testFiles[5]}},
+		{nil, []string{"dir"}, testFiles[5:]},
+	}
+	for _, c := range cases {
+		sel, err := SelectPatterns(c.include, c.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc := NewSelectedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), sel)
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%v, %v: %v", c.include, c.exclude, err)
+		}
+		if expected := buildTar(t, c.expected); !bytes.Equal(got, expected) {
+			t.Errorf("%v, %v: selected %d bytes, rather than the %d bytes of an archive of those files", c.include, c.exclude, len(got), len(expected))
+		}
+		size, err := SelectedTarSize(storage.NewJSONUnpacker(bytes.NewReader(meta)), sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(got)) {
+			t.Errorf("%v, %v: expected a size of %d bytes, got %d", c.include, c.exclude, len(got), size)
+		}
+	}
+}
+
+func TestWriteSelectedTarStreamHardlink(t *testing.T) {
+	meta, fgp := disassemble(t, buildTar(t, testFiles))
+	sel := func(hdr *tar.Header) bool {
+		return hdr.Typeflag != tar.TypeReg
+	}
+	err := WriteSelectedTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), sel, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "hard link") {
+		t.Errorf("expected the hard link to a file left out to fail, got %v", err)
+	}
+}
+
+func TestSelectPatternsInvalid(t *testing.T) {
+	if _, err := SelectPatterns([]string{"dir/["}, nil); err == nil {
+		t.Errorf("expected an invalid pattern to fail")
+	}
+}
@@ -0,0 +1,111 @@
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ErrSourceMismatch is returned when the blob supplied for metadata is not the
+// one recorded in it, so the metadata does not apply to it.
+var ErrSourceMismatch = errors.New("asm: the source blob does not match the metadata")
+
+// ErrNoSource is returned by VerifySource for metadata without a source record
+var ErrNoSource = errors.New("asm: the metadata has no source record")
+
+// compressionMagic is the leading bytes of the compressed formats recognized
+// by DigestSource
+var compressionMagic = []struct {
+	name  string
+	magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"bzip2", []byte("BZh")},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// DigestSource reads the blob `r` to the end, returning its identity to be
+// recorded with AddSource. The compression is detected from its leading bytes.
+func DigestSource(r io.Reader) (storage.Source, error) {
+	br := bufio.NewReader(r)
+	var src storage.Source
+	peek, _ := br.Peek(6)
+	for _, c := range compressionMagic {
+		if bytes.HasPrefix(peek, c.magic) {
+			src.Compression = c.name
+			break
+		}
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, br)
+	if err != nil {
+		return src, err
+	}
+	src.Digest, src.Size = "sha256:"+hex.EncodeToString(h.Sum(nil)), n
+	return src, nil
+}
+
+// AddSource packs the record of the blob that the archive is disassembled
+// from. It is added before the archive, so that it is read before any of the
+// archive is assembled.
+func AddSource(p storage.Packer, src storage.Source) error {
+	_, err := p.AddEntry(storage.Entry{Type: storage.SourceType, Source: &src})
+	return err
+}
+
+// NewSourceCheckingUnpacker returns an Unpacker of the metadata read from
+// `up`, that fails with ErrSourceMismatch when the source recorded in it is
+// not `src`, so that any of the assembly functions given it stop before
+// applying the metadata to the wrong blob. Metadata without a source record,
+// like that from before they were added, is read as it is.
+func NewSourceCheckingUnpacker(up storage.Unpacker, src storage.Source) storage.Unpacker {
+	return &sourceCheckingUnpacker{up: up, src: src}
+}
+
+type sourceCheckingUnpacker struct {
+	up  storage.Unpacker
+	src storage.Source
+}
+
+func (scu *sourceCheckingUnpacker) Next() (*storage.Entry, error) {
+	entry, err := scu.up.Next()
+	if err != nil {
+		return nil, err
+	}
+	if entry.Type == storage.SourceType && entry.Source != nil && *entry.Source != scu.src {
+		return nil, ErrSourceMismatch
+	}
+	return entry, nil
+}
+
+// VerifySource reads the metadata from `up` up to its source record, and
+// checks that it is of the blob `r`. Metadata without a source record fails
+// with ErrNoSource, as it can not be verified.
+func VerifySource(up storage.Unpacker, r io.Reader) error {
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				return ErrNoSource
+			}
+			return err
+		}
+		if entry.Type != storage.SourceType || entry.Source == nil {
+			continue
+		}
+		src, err := DigestSource(r)
+		if err != nil {
+			return err
+		}
+		if src != *entry.Source {
+			return ErrSourceMismatch
+		}
+		return nil
+	}
+}
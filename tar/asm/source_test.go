@@ -0,0 +1,74 @@
+package asm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestSourceRecord(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	blob := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(blob)
+	gz.Write(archive)
+	gz.Close()
+
+	src, err := DigestSource(bytes.NewReader(blob.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Compression != "gzip" || src.Size != int64(blob.Len()) {
+		t.Errorf("unexpected source %+v", src)
+	}
+
+	meta := bytes.NewBuffer(nil)
+	p := storage.NewJSONPacker(meta)
+	if err := AddSource(p, src); err != nil {
+		t.Fatal(err)
+	}
+	fgp := storage.NewBufferFileGetPutter()
+	its, err := NewInputTarStream(bytes.NewReader(archive), p, fgp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, its); err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	up := NewSourceCheckingUnpacker(storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), src)
+	if err := WriteOutputTarStream(fgp, up, out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Error("expected the archive assembled as it was, with the source record")
+	}
+	if err := VerifySource(storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), bytes.NewReader(blob.Bytes())); err != nil {
+		t.Errorf("expected the blob to verify, got %v", err)
+	}
+
+	other, _ := DigestSource(bytes.NewReader(archive))
+	if other.Compression != "" {
+		t.Errorf("expected no compression of the tar stream, got %q", other.Compression)
+	}
+	up = NewSourceCheckingUnpacker(storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), other)
+	if err := WriteOutputTarStream(fgp, up, ioutil.Discard); err != ErrSourceMismatch {
+		t.Errorf("expected ErrSourceMismatch, got %v", err)
+	}
+	if err := VerifySource(storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())), bytes.NewReader(archive)); err != ErrSourceMismatch {
+		t.Errorf("expected ErrSourceMismatch, got %v", err)
+	}
+
+	plain, _ := disassemble(t, archive)
+	if err := VerifySource(storage.NewJSONUnpacker(bytes.NewReader(plain)), bytes.NewReader(blob.Bytes())); err != ErrNoSource {
+		t.Errorf("expected ErrNoSource, got %v", err)
+	}
+	up = NewSourceCheckingUnpacker(storage.NewJSONUnpacker(bytes.NewReader(plain)), src)
+	if err := WriteOutputTarStream(fgp, up, ioutil.Discard); err != nil {
+		t.Errorf("expected metadata without a source record to assemble, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ErrSplitLimit is returned when an entry of the archive does not fit within
+// the size limit of a part, and chunking is not enabled.
+var ErrSplitLimit = errors.New("asm: entry does not fit within the part size limit")
+
+// SplitLimitError is the entry that does not fit within the size limit of a
+// part. It is ErrSplitLimit to errors.Is.
+type SplitLimitError struct {
+	// Name of the entry
+	Name string
+	// Size of the entry, with its headers, in the archive
+	Size int64
+}
+
+func (sle *SplitLimitError) Error() string {
+	return fmt.Sprintf("%s: %q is %d bytes", ErrSplitLimit, sle.Name, sle.Size)
+}
+
+// Is is whether `target` is ErrSplitLimit
+func (sle *SplitLimitError) Is(target error) bool {
+	return target == ErrSplitLimit
+}
+
+// SplitOptions are the options for SplitTarStream
+type SplitOptions struct {
+	// Limit is the maximum size of each part, in bytes
+	Limit int64
+	// Chunking allows an entry larger than the Limit to be cut across
+	// several parts. Such parts are raw chunks of the archive, rather than
+	// valid tar archives on their own.
+	Chunking bool
+}
+
+// SplitPart is where one part of a split archive is written
+type SplitPart struct {
+	// W receives the part
+	W io.Writer
+	// Packer and Putter receive the part's metadata and file payloads, as
+	// with NewInputTarStream. If Packer is nil, no metadata is produced.
+	// Neither is used for chunk parts.
+	Packer storage.Packer
+	Putter storage.FilePutter
+}
+
+// SplitManifest describes how to join the parts of a split archive back into
+// the original.
+type SplitManifest struct {
+	// Size of the original archive
+	Size  int64               `json:"size"`
+	Parts []SplitManifestPart `json:"parts"`
+}
+
+// SplitManifestPart is one part of a SplitManifest
+type SplitManifestPart struct {
+	// Size of the part
+	Size int64 `json:"size"`
+	// Length is the number of bytes, from the start of the part, that belong
+	// to the original archive. The rest is the end of archive marker added
+	// to make the part a valid tar archive.
+	Length int64 `json:"length"`
+	// Chunk is whether the part is a raw chunk of an entry too large for a
+	// part, rather than a tar archive.
+	Chunk bool `json:"chunk,omitempty"`
+}
+
+// endOfArchive is the marker added to the end of each part
+var endOfArchive = make([]byte, blockSize*2)
+
+// SplitTarStream splits the tar archive read from `r` into parts no larger
+// than opts.Limit, each of which is a valid tar archive with its own
+// metadata. Entries are never split across parts, unless opts.Chunking is
+// enabled.
+//
+// The function `next` is called for where to write each part, numbered from
+// 0. The returned manifest is needed to join the parts back into the original
+// archive, byte for byte (see JoinTarStream).
+func SplitTarStream(r io.Reader, opts SplitOptions, next func(n int) (SplitPart, error)) (*SplitManifest, error) {
+	if opts.Limit < blockSize*3+int64(len(endOfArchive)) {
+		return nil, fmt.Errorf("asm: part size limit must be at least %d", blockSize*3+len(endOfArchive))
+	}
+	s := &splitter{opts: opts, next: next, manifest: &SplitManifest{}}
+	tr := tar.NewReader(r)
+	tr.RawAccounting = true
+	var pad int64
+	for {
+		hdr, err := tr.Next()
+		if err != nil && err != io.EOF {
+			s.abort(err)
+			return nil, err
+		}
+		raw := tr.RawBytes()
+		if int64(len(raw)) < pad {
+			pad = int64(len(raw))
+		}
+		// the prior entry's padding goes to its part
+		if _, err := s.Write(raw[:pad]); err != nil {
+			s.abort(err)
+			return nil, err
+		}
+		raw = raw[pad:]
+
+		if err == io.EOF {
+			// it is allowable, and not uncommon that there is further padding
+			// on the end of an archive, apart from the expected 1024 null bytes.
+			remainder, err := ioutil.ReadAll(r)
+			if err != nil {
+				s.abort(err)
+				return nil, err
+			}
+			raw = append(raw, remainder...)
+			if err := s.place(int64(len(raw)), "end of archive"); err != nil {
+				s.abort(err)
+				return nil, err
+			}
+			if _, err := s.Write(raw); err != nil {
+				s.abort(err)
+				return nil, err
+			}
+			break
+		}
+
+		// the payload as it is in the archive, which for a sparse file is its
+		// data fragments and not its size
+		if err := s.place(int64(len(raw))+tr.PayloadBlocks()*blockSize, hdr.Name); err != nil {
+			s.abort(err)
+			return nil, err
+		}
+		if _, err := s.Write(raw); err != nil {
+			s.abort(err)
+			return nil, err
+		}
+		size, err := io.Copy(s, tr.RawReader())
+		if err != nil {
+			s.abort(err)
+			return nil, err
+		}
+		pad = -size & (blockSize - 1)
+	}
+	if err := s.finish(); err != nil {
+		return nil, err
+	}
+	return s.manifest, nil
+}
+
+// JoinTarStream writes the original archive to `w`, from the parts described
+// by the manifest `m`. The function `open` is called for each part, numbered
+// from 0.
+func JoinTarStream(w io.Writer, m *SplitManifest, open func(n int) (io.ReadCloser, error)) error {
+	var total int64
+	for i, p := range m.Parts {
+		rc, err := open(i)
+		if err != nil {
+			return err
+		}
+		n, err := io.CopyN(w, rc, p.Length)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("asm: part %d: %v", i, err)
+		}
+		total += n
+	}
+	if total != m.Size {
+		return fmt.Errorf("asm: joined %d bytes, but the original archive is %d bytes", total, m.Size)
+	}
+	return nil
+}
+
+type splitter struct {
+	opts     SplitOptions
+	next     func(int) (SplitPart, error)
+	manifest *SplitManifest
+	cur      *splitPartWriter
+}
+
+type splitPartWriter struct {
+	w     io.Writer
+	chunk bool
+	size  int64
+	pw    *io.PipeWriter // when the part is disassembled for its metadata
+	done  chan error
+}
+
+// place ensures the current part has room for `n` more bytes of the archive,
+// starting a new part if needed.
+func (s *splitter) place(n int64, name string) error {
+	limit := s.opts.Limit - int64(len(endOfArchive))
+	if s.cur != nil && !s.cur.chunk && s.cur.size+n <= limit {
+		return nil
+	}
+	if err := s.finish(); err != nil {
+		return err
+	}
+	if n <= limit {
+		return s.open(false)
+	}
+	if !s.opts.Chunking {
+		return &SplitLimitError{Name: name, Size: n}
+	}
+	return s.open(true)
+}
+
+func (s *splitter) open(chunk bool) error {
+	part, err := s.next(len(s.manifest.Parts))
+	if err != nil {
+		return err
+	}
+	s.manifest.Parts = append(s.manifest.Parts, SplitManifestPart{Chunk: chunk})
+	s.cur = &splitPartWriter{w: part.W, chunk: chunk}
+	if chunk || part.Packer == nil {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	s.cur.pw = pw
+	s.cur.w = pw
+	s.cur.done = done
+	go func() {
+		its, err := NewInputTarStream(pr, part.Packer, part.Putter)
+		if err == nil {
+			_, err = io.Copy(part.W, its)
+		}
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	return nil
+}
+
+// finish completes the current part, if any
+func (s *splitter) finish() error {
+	cur := s.cur
+	if cur == nil {
+		return nil
+	}
+	s.cur = nil
+	mp := &s.manifest.Parts[len(s.manifest.Parts)-1]
+	mp.Length = cur.size
+	mp.Size = cur.size
+	s.manifest.Size += cur.size
+	if !cur.chunk {
+		if _, err := cur.w.Write(endOfArchive); err != nil {
+			return err
+		}
+		mp.Size += int64(len(endOfArchive))
+	}
+	if cur.pw != nil {
+		cur.pw.Close()
+		return <-cur.done
+	}
+	return nil
+}
+
+func (s *splitter) abort(err error) {
+	if s.cur != nil && s.cur.pw != nil {
+		s.cur.pw.CloseWithError(err)
+		<-s.cur.done
+	}
+	s.cur = nil
+}
+
+// Write writes bytes of the archive to the current part, moving on to new
+// chunk parts as they fill up.
+func (s *splitter) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		n := int64(len(b))
+		if s.cur.chunk {
+			if s.cur.size == s.opts.Limit {
+				if err := s.finish(); err != nil {
+					return written, err
+				}
+				if err := s.open(true); err != nil {
+					return written, err
+				}
+			}
+			if room := s.opts.Limit - s.cur.size; n > room {
+				n = room
+			}
+		}
+		nw, err := s.cur.w.Write(b[:n])
+		s.cur.size += int64(nw)
+		written += nw
+		if err != nil {
+			return written, err
+		}
+		b = b[nw:]
+	}
+	return written, nil
+}
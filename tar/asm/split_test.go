@@ -0,0 +1,142 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/gen"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+type splitTestPart struct {
+	buf  *bytes.Buffer
+	meta *bytes.Buffer
+	fgp  storage.FileGetPutter
+}
+
+func splitTestParts(parts *[]splitTestPart) func(int) (SplitPart, error) {
+	return func(n int) (SplitPart, error) {
+		p := splitTestPart{buf: bytes.NewBuffer(nil), meta: bytes.NewBuffer(nil), fgp: storage.NewBufferFileGetPutter()}
+		*parts = append(*parts, p)
+		return SplitPart{W: p.buf, Packer: storage.NewJSONPacker(p.meta), Putter: p.fgp}, nil
+	}
+}
+
+func joinTestParts(t *testing.T, m *SplitManifest, parts []splitTestPart) []byte {
+	joined := bytes.NewBuffer(nil)
+	err := JoinTarStream(joined, m, func(n int) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(parts[n].buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return joined.Bytes()
+}
+
+func TestSplitTarStream(t *testing.T) {
+	files := append([]testFile{}, testFiles...)
+	files = append(files, testFile{hdr: tar.Header{Name: "big", Typeflag: tar.TypeReg, Mode: 0644}, body: strings.Repeat("b", 3000)})
+	original := buildTar(t, files)
+
+	var parts []splitTestPart
+	m, err := SplitTarStream(bytes.NewReader(original), SplitOptions{Limit: 6 * 1024}, splitTestParts(&parts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) < 2 || len(parts) != len(m.Parts) {
+		t.Fatalf("expected several parts, got %d (manifest %d)", len(parts), len(m.Parts))
+	}
+
+	var names []string
+	for i, p := range parts {
+		if int64(p.buf.Len()) > 6*1024 || int64(p.buf.Len()) != m.Parts[i].Size {
+			t.Errorf("part %d: size %d, manifest %d", i, p.buf.Len(), m.Parts[i].Size)
+		}
+		// each part is a valid archive
+		tr := tar.NewReader(bytes.NewReader(p.buf.Bytes()))
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatalf("part %d: %s", i, err)
+			}
+			names = append(names, hdr.Name)
+		}
+		// and has metadata to reassemble it
+		rc := NewOutputTarStream(p.fgp, storage.NewJSONUnpacker(p.meta))
+		reassembled, err := ioutil.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(reassembled, p.buf.Bytes()) {
+			t.Errorf("part %d: metadata did not reassemble the part", i)
+		}
+	}
+	if len(names) != len(files) {
+		t.Errorf("expected %d entries across the parts, got %v", len(files), names)
+	}
+
+	if !bytes.Equal(joinTestParts(t, m, parts), original) {
+		t.Errorf("joined parts differ from the original")
+	}
+}
+
+func TestSplitTarStreamGen(t *testing.T) {
+	for _, a := range gen.Archives() {
+		if a.Truncated {
+			continue
+		}
+		var parts []splitTestPart
+		m, err := SplitTarStream(bytes.NewReader(a.Data), SplitOptions{Limit: 64 * 1024}, splitTestParts(&parts))
+		if err != nil {
+			t.Errorf("%s: %v", a.Name, err)
+			continue
+		}
+		// a sparse file is split as it is in the archive, not with its holes
+		if joined := joinTestParts(t, m, parts); !bytes.Equal(joined, a.Data) {
+			t.Errorf("%s: joined %d bytes, differing from the %d bytes of the original", a.Name, len(joined), len(a.Data))
+		}
+	}
+}
+
+func TestSplitTarStreamChunking(t *testing.T) {
+	files := []testFile{
+		{hdr: tar.Header{Name: "small", Typeflag: tar.TypeReg, Mode: 0644}, body: "small"},
+		{hdr: tar.Header{Name: "huge", Typeflag: tar.TypeReg, Mode: 0644}, body: strings.Repeat("h", 10000)},
+		{hdr: tar.Header{Name: "after", Typeflag: tar.TypeReg, Mode: 0644}, body: "after"},
+	}
+	original := buildTar(t, files)
+
+	var parts []splitTestPart
+	_, err := SplitTarStream(bytes.NewReader(original), SplitOptions{Limit: 4096}, splitTestParts(&parts))
+	if sle, ok := err.(*SplitLimitError); !ok || !sle.Is(ErrSplitLimit) || sle.Name != "huge" {
+		t.Errorf("expected a *SplitLimitError of huge splitting an entry larger than the limit, got %v", err)
+	}
+
+	parts = nil
+	m, err := SplitTarStream(bytes.NewReader(original), SplitOptions{Limit: 4096, Chunking: true}, splitTestParts(&parts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks int
+	for i, p := range m.Parts {
+		if p.Size > 4096 {
+			t.Errorf("part %d: size %d over the limit", i, p.Size)
+		}
+		if p.Chunk {
+			chunks++
+		}
+	}
+	if chunks < 3 {
+		t.Errorf("expected the huge entry to be in at least 3 chunks, got %d", chunks)
+	}
+	if !bytes.Equal(joinTestParts(t, m, parts), original) {
+		t.Errorf("joined parts differ from the original")
+	}
+}
@@ -0,0 +1,118 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ThinMode is how WriteThinTarStream leaves out file payloads
+type ThinMode int
+
+const (
+	// ThinZero zero-fills the payloads, keeping the original headers and the
+	// layout and size of the archive
+	ThinZero ThinMode = iota
+	// ThinSkip skips the payloads entirely, rewriting the headers of files
+	// with a payload to have a size of 0
+	ThinSkip
+)
+
+// ParseThinMode parses "zero" or "skip" into a ThinMode
+func ParseThinMode(s string) (ThinMode, error) {
+	switch s {
+	case "zero":
+		return ThinZero, nil
+	case "skip":
+		return ThinSkip, nil
+	}
+	return 0, fmt.Errorf("asm: unknown thin mode %q", s)
+}
+
+// WriteThinTarStream writes to `w` a structurally valid tar archive with the
+// headers from the metadata in `up`, but without the file payloads. No
+// FileGetter is needed. This is for tools that only walk the headers, or to
+// test consumers against the shape of an archive without moving its data.
+//
+// With ThinSkip, the headers that are rewritten are synthesized anew, so they
+// may not be byte for byte the originals (e.g. the PAX records they use).
+func WriteThinTarStream(up storage.Unpacker, mode ThinMode, w io.Writer) error {
+	if mode == ThinSkip {
+		return writeSkippedTarStream(up, w)
+	}
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch entry.Type {
+		case storage.SegmentType:
+			if _, err := w.Write(entry.Payload); err != nil {
+				return err
+			}
+		case storage.FileType:
+			if entry.Special != "" {
+				// it is metadata of the archive, rather than a file
+				if err := writeInline(w, entry); err != nil {
+					return err
+				}
+				continue
+			}
+			for n := entry.DataSize(); n > 0; {
+				chunk := zeros
+				if n < int64(len(chunk)) {
+					chunk = chunk[:n]
+				}
+				if _, err := w.Write(chunk); err != nil {
+					return err
+				}
+				n -= int64(len(chunk))
+			}
+		}
+	}
+}
+
+func writeSkippedTarStream(up storage.Unpacker, w io.Writer) error {
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+		if isHeaderOnlyType(hdr.Typeflag) || hdr.Size == 0 {
+			if _, err := w.Write(hr.RawHeader()); err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.Special != "" {
+			if _, err := w.Write(hr.RawHeader()); err != nil {
+				return err
+			}
+			if err := writeInline(w, entry); err != nil {
+				return err
+			}
+			if _, err := w.Write(zeros[:-entry.DataSize()&(blockSize-1)]); err != nil {
+				return err
+			}
+			continue
+		}
+		hdr.Size = 0
+		if err := writeHeader(w, hdr); err != nil {
+			return err
+		}
+	}
+	trailer := hr.Trailer()
+	if len(trailer) == 0 {
+		trailer = endOfArchive
+	}
+	_, err := w.Write(trailer)
+	return err
+}
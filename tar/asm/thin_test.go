@@ -0,0 +1,70 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestWriteThinTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, _ := disassemble(t, archive)
+
+	for _, mode := range []ThinMode{ThinZero, ThinSkip} {
+		buf := bytes.NewBuffer(nil)
+		if err := WriteThinTarStream(storage.NewJSONUnpacker(bytes.NewReader(meta)), mode, buf); err != nil {
+			t.Fatal(err)
+		}
+		if mode == ThinZero && buf.Len() != len(archive) {
+			t.Errorf("expected the zero filled archive to be %d bytes, got %d", len(archive), buf.Len())
+		}
+		if mode == ThinSkip && buf.Len() >= len(archive) {
+			t.Errorf("expected the skipped archive to be smaller than %d bytes, got %d", len(archive), buf.Len())
+		}
+
+		tr := tar.NewReader(buf)
+		for i := 0; ; i++ {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				if i != len(testFiles) {
+					t.Errorf("mode %d: expected %d entries, got %d", mode, len(testFiles), i)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("mode %d: %s", mode, err)
+			}
+			expected := testFiles[i]
+			if hdr.Name != expected.hdr.Name || hdr.Typeflag != expected.hdr.Typeflag {
+				t.Errorf("mode %d: expected %q, got %q", mode, expected.hdr.Name, hdr.Name)
+			}
+			payload, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch mode {
+			case ThinZero:
+				if len(payload) != len(expected.body) || len(bytes.Trim(payload, "\x00")) != 0 {
+					t.Errorf("expected %q to have %d zero bytes, got %q", hdr.Name, len(expected.body), payload)
+				}
+			case ThinSkip:
+				if len(payload) != 0 {
+					t.Errorf("expected %q to have no payload, got %q", hdr.Name, payload)
+				}
+			}
+		}
+	}
+}
+
+func TestParseThinMode(t *testing.T) {
+	if m, err := ParseThinMode("skip"); err != nil || m != ThinSkip {
+		t.Errorf("expected ThinSkip, got %d, %v", m, err)
+	}
+	if _, err := ParseThinMode("fat"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,156 @@
+package asm
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// TOC is the table of contents of an archive, in the format of eStargz
+// (stargz.index.json), which is that of the manifest of zstd:chunked as well.
+// It lets lazily pulled layers find each file's payload in the archive.
+type TOC struct {
+	Version int        `json:"version"`
+	Entries []TOCEntry `json:"entries"`
+}
+
+// TOCEntry is a file of a TOC, or a chunk of its payload after the first one
+type TOCEntry struct {
+	Name string `json:"name"`
+	// Type is one of "dir", "reg", "symlink", "hardlink", "char", "block",
+	// "fifo" or "chunk"
+	Type     string `json:"type"`
+	Size     int64  `json:"size,omitempty"`
+	ModTime  string `json:"modtime,omitempty"`
+	LinkName string `json:"linkName,omitempty"`
+	Mode     int64  `json:"mode,omitempty"`
+	UID      int    `json:"uid,omitempty"`
+	GID      int    `json:"gid,omitempty"`
+	Uname    string `json:"userName,omitempty"`
+	Gname    string `json:"groupName,omitempty"`
+	DevMajor int64  `json:"devMajor,omitempty"`
+	DevMinor int64  `json:"devMinor,omitempty"`
+	// Xattrs are encoded in base64, as eStargz and zstd:chunked do
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+	// Digest is of the whole payload of a "reg" entry
+	Digest string `json:"digest,omitempty"`
+	// Offset and EndOffset are where the payload, or the chunk, is in the
+	// archive
+	Offset    int64 `json:"offset,omitempty"`
+	EndOffset int64 `json:"endOffset,omitempty"`
+	// ChunkOffset, ChunkSize and ChunkDigest are of the chunk of the payload
+	ChunkOffset int64  `json:"chunkOffset,omitempty"`
+	ChunkSize   int64  `json:"chunkSize,omitempty"`
+	ChunkDigest string `json:"chunkDigest,omitempty"`
+}
+
+// ErrTOCSparse is returned by NewTOC for a sparse file, as its payload is not
+// contiguous in the archive
+var ErrTOCSparse = errors.New("asm: sparse files have no TOC entry")
+
+var tocTypes = map[byte]string{
+	tar.TypeReg:     "reg",
+	tar.TypeRegA:    "reg",
+	tar.TypeLink:    "hardlink",
+	tar.TypeSymlink: "symlink",
+	tar.TypeChar:    "char",
+	tar.TypeBlock:   "block",
+	tar.TypeDir:     "dir",
+	tar.TypeFifo:    "fifo",
+}
+
+// NewTOC returns the TOC of the archive of the metadata read from `up`, which
+// must have been packed with the offsets of its entries (see
+// storage.NewOffsetPacker), without reading the archive again.
+//
+// The offsets are those in the archive as it was disassembled, so for a
+// compressed layer, the TOC is to be of the layer after its decompression. The
+// digests of the payloads are those checksummed in sha256, whether as their
+// Checksum or one of their Checksums (see storage.ChecksumPacker), and the payloads with content-defined chunks (see
+// storage.ChunkPacker) are split by them, with an entry of type "chunk" for
+// each of their chunks after the first.
+func NewTOC(up storage.Unpacker) (TOC, error) {
+	toc := TOC{Version: 1}
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return toc, nil
+			}
+			return toc, err
+		}
+		typ, ok := tocTypes[hdr.Typeflag]
+		if !ok || entry.Special != "" {
+			if hdr.Typeflag == tar.TypeGNUSparse || len(entry.Sparse) > 0 {
+				return toc, ErrTOCSparse
+			}
+			continue
+		}
+		te := TOCEntry{
+			Name:     hdr.Name,
+			Type:     typ,
+			LinkName: hdr.Linkname,
+			Mode:     hdr.Mode,
+			UID:      hdr.Uid,
+			GID:      hdr.Gid,
+			Uname:    hdr.Uname,
+			Gname:    hdr.Gname,
+			DevMajor: hdr.Devmajor,
+			DevMinor: hdr.Devminor,
+		}
+		if !hdr.ModTime.IsZero() {
+			te.ModTime = hdr.ModTime.UTC().Format(time.RFC3339)
+		}
+		if len(hdr.Xattrs) > 0 {
+			te.Xattrs = map[string][]byte{}
+			for k, v := range hdr.Xattrs {
+				te.Xattrs[k] = []byte(v)
+			}
+		}
+		if typ != "reg" {
+			toc.Entries = append(toc.Entries, te)
+			continue
+		}
+		if len(entry.Sparse) > 0 {
+			return toc, ErrTOCSparse
+		}
+		te.Size = entry.Size
+		for _, checksum := range entry.AllChecksums() {
+			if strings.HasPrefix(checksum, "sha256:") {
+				te.Digest = checksum
+				break
+			}
+		}
+		if entry.Size == 0 {
+			toc.Entries = append(toc.Entries, te)
+			continue
+		}
+		// a file is never at the start of the archive, before its header
+		if entry.Offset == 0 {
+			return toc, storage.ErrNoOffsets
+		}
+		if len(entry.Chunks) == 0 {
+			te.Offset = entry.Offset
+			te.EndOffset = entry.Offset + entry.Size
+			toc.Entries = append(toc.Entries, te)
+			continue
+		}
+		for i, c := range entry.Chunks {
+			ce := te
+			if i > 0 {
+				ce = TOCEntry{Name: hdr.Name, Type: "chunk"}
+			}
+			ce.Offset = entry.Offset + c.Offset
+			ce.EndOffset = ce.Offset + c.Length
+			ce.ChunkOffset = c.Offset
+			ce.ChunkSize = c.Length
+			ce.ChunkDigest = c.Digest
+			toc.Entries = append(toc.Entries, ce)
+		}
+	}
+}
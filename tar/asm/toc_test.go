@@ -0,0 +1,103 @@
+package asm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestNewTOC(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta := bytes.NewBuffer(nil)
+	cp, err := storage.NewChecksumPacker(storage.NewOffsetPacker(storage.NewJSONPacker(meta)), nil, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewInputTarStream(bytes.NewReader(archive), cp, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+		t.Fatal(err)
+	}
+
+	toc, err := NewTOC(storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toc.Version != 1 || len(toc.Entries) != len(testFiles) {
+		t.Fatalf("expected a TOC of %d entries, got %+v", len(testFiles), toc)
+	}
+	types := []string{"dir", "reg", "symlink", "hardlink", "reg", "reg"}
+	for i, te := range toc.Entries {
+		f := testFiles[i]
+		if te.Name != f.hdr.Name || te.Type != types[i] || te.LinkName != f.hdr.Linkname || te.Mode != f.hdr.Mode {
+			t.Errorf("%d: unexpected entry %+v", i, te)
+		}
+		if te.ModTime != "2017-07-14T02:40:00Z" {
+			t.Errorf("%s: unexpected modtime %q", te.Name, te.ModTime)
+		}
+		if te.Type != "reg" || f.body == "" {
+			continue
+		}
+		if got := string(archive[te.Offset:te.EndOffset]); got != f.body {
+			t.Errorf("%s: expected the payload at its offsets, got %q", te.Name, got)
+		}
+		sum := sha256.Sum256([]byte(f.body))
+		if te.Digest != "sha256:"+hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: unexpected digest %q", te.Name, te.Digest)
+		}
+	}
+
+	plain, _ := disassemble(t, archive)
+	if _, err := NewTOC(storage.NewJSONUnpacker(bytes.NewReader(plain))); err != storage.ErrNoOffsets {
+		t.Errorf("expected ErrNoOffsets without offsets, got %v", err)
+	}
+}
+
+func TestNewTOCChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "toc-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	body := bytes.Repeat([]byte("0123456789abcdef"), 40<<10)
+	archive := buildTar(t, []testFile{{hdr: testFiles[1].hdr, body: string(body)}})
+	meta := bytes.NewBuffer(nil)
+	cp := storage.NewChunkPacker(storage.NewOffsetPacker(storage.NewJSONPacker(meta)), nil, dir)
+	rdr, err := NewInputTarStream(bytes.NewReader(archive), cp, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+		t.Fatal(err)
+	}
+
+	toc, err := NewTOC(storage.NewJSONUnpacker(bytes.NewReader(meta.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toc.Entries) < 2 || toc.Entries[0].Type != "reg" {
+		t.Fatalf("expected a file of several chunks, got %+v", toc.Entries)
+	}
+	var payload []byte
+	for i, te := range toc.Entries {
+		if i > 0 && te.Type != "chunk" {
+			t.Errorf("%d: expected a chunk, got %q", i, te.Type)
+		}
+		if te.ChunkOffset != int64(len(payload)) || te.EndOffset-te.Offset != te.ChunkSize {
+			t.Errorf("%d: unexpected chunk %+v", i, te)
+		}
+		payload = append(payload, archive[te.Offset:te.EndOffset]...)
+	}
+	if !bytes.Equal(payload, body) {
+		t.Errorf("expected the chunks to make up the payload")
+	}
+}
@@ -0,0 +1,189 @@
+package asm
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ErrTreeChanged is returned by WriteTreeTarStream when the payload of a file
+// on disk no longer matches its entry, so the archive can not be assembled
+var ErrTreeChanged = errors.New("asm: files changed on disk since disassembly")
+
+// TreeChange is a file on disk that differs from the entry it was extracted
+// from, as found by CheckTree
+type TreeChange struct {
+	Name string
+	// Kind is Deleted if the file is no longer on disk, and otherwise
+	// Modified
+	Kind ChangeKind
+	// Fields are what differ: "Size" and "Checksum" of the payload, or
+	// "Type", "Mode", "ModTime", "Linkname" or "Xattrs" of the header
+	Fields []string
+}
+
+// PayloadChanged is whether the payload of the file differs, or it is
+// missing, so the archive can not be assembled from it
+func (tc TreeChange) PayloadChanged() bool {
+	if tc.Kind != Modified {
+		return true
+	}
+	for _, f := range tc.Fields {
+		if f == "Size" || f == "Checksum" || f == "Type" {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTree checks the files of the directory `dir`, extracted from the
+// archive of the metadata `up`, against their entries: the size and checksums
+// of each payload, and the type, mode, modification time, link target and
+// extended attributes of each header. Ownership is not checked, as it is only
+// extracted as root. Files on disk that are not in the metadata are ignored.
+func CheckTree(dir string, up storage.Unpacker) ([]TreeChange, error) {
+	var changes []TreeChange
+	hr := NewHeaderReader(up)
+	for {
+		hdr, entry, err := hr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return changes, nil
+			}
+			return nil, err
+		}
+		change, err := checkTreeFile(dir, hdr, entry)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			changes = append(changes, *change)
+		}
+	}
+}
+
+// checkTreeFile returns how the file of `hdr` differs on disk, if at all
+func checkTreeFile(dir string, hdr *tar.Header, entry *storage.Entry) (*TreeChange, error) {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink, tar.TypeGNUSparse:
+	default:
+		// hard links are checked as the file they link to, and the other
+		// types have no payload or are not extracted
+		return nil, nil
+	}
+	p := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+hdr.Name)))
+	fi, err := os.Lstat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &TreeChange{Name: hdr.Name, Kind: Deleted}, nil
+		}
+		return nil, err
+	}
+	change := &TreeChange{Name: hdr.Name, Kind: Modified}
+	differ := func(field string) {
+		change.Fields = append(change.Fields, field)
+	}
+
+	mode := hdr.FileInfo().Mode()
+	if mode&os.ModeType != fi.Mode()&os.ModeType {
+		differ("Type")
+		return change, nil
+	}
+	switch {
+	case mode&os.ModeSymlink != 0:
+		link, err := os.Readlink(p)
+		if err != nil {
+			return nil, err
+		}
+		if link != hdr.Linkname {
+			differ("Linkname")
+		}
+		// the modification time of a symbolic link is not generally settable
+		return changeOrNil(change), nil
+	case mode.IsRegular():
+		if fi.Size() != hdr.Size {
+			differ("Size")
+		} else if entry.Size > 0 && entry.Special == "" {
+			same, err := samePayload(p, entry)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				differ("Checksum")
+			}
+		}
+	}
+	if mode.Perm()|mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != fi.Mode().Perm()|fi.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) {
+		differ("Mode")
+	}
+	if fi.ModTime().Unix() != hdr.ModTime.Unix() {
+		differ("ModTime")
+	}
+	for key, value := range hdr.Xattrs {
+		got, err := getxattr(p, key)
+		if err == errNotSupported {
+			break
+		}
+		if err != nil || got != value {
+			differ("Xattrs")
+			break
+		}
+	}
+	return changeOrNil(change), nil
+}
+
+func changeOrNil(change *TreeChange) *TreeChange {
+	if len(change.Fields) == 0 {
+		return nil
+	}
+	return change
+}
+
+// samePayload is whether the file at `p` matches the checksums of `entry`
+func samePayload(p string, entry *storage.Entry) (bool, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+	var r io.Reader = fh
+	if entry.SparsePacked {
+		// the checksum is of the data of the extents alone
+		r = storage.NewSparseDataReader(fh, entry.Sparse)
+	}
+	w, verify, err := newPayloadVerifier(entry)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return false, err
+	}
+	return verify() == nil, nil
+}
+
+// WriteTreeTarStream assembles the archive of the metadata `up` to `w`, with
+// the file payloads read from the directory `dir` it was extracted to, like
+// WriteOutputTarStream with storage.NewPathFileGetter(dir). The files are
+// first checked with CheckTree, and the changes found are returned. If any
+// payload changed, nothing is written, and ErrTreeChanged is returned; other
+// changes are only reported, as the headers are assembled from the metadata.
+//
+// The metadata is read into memory for the check.
+func WriteTreeTarStream(dir string, up storage.Unpacker, w io.Writer) ([]TreeChange, error) {
+	rec := &recordingUnpacker{up: up}
+	changes, err := CheckTree(dir, rec)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		if c.PayloadChanged() {
+			return changes, ErrTreeChanged
+		}
+	}
+	return changes, WriteOutputTarStream(storage.NewPathFileGetter(dir), &entriesUnpacker{entries: rec.entries}, w)
+}
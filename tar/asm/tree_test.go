@@ -0,0 +1,79 @@
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestWriteTreeTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, fgp := disassemble(t, archive)
+
+	dir, err := ioutil.TempDir("", "tar-split-tree.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ExtractTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(meta)), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	changes, err := WriteTreeTarStream(dir, storage.NewJSONUnpacker(bytes.NewReader(meta)), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+	if !bytes.Equal(buf.Bytes(), archive) {
+		t.Error("expected the archive assembled from the tree")
+	}
+
+	// a change of mode is reported, but the archive is still assembled
+	if err := os.Chmod(filepath.Join(dir, "dir/hurr.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	changes, err = WriteTreeTarStream(dir, storage.NewJSONUnpacker(bytes.NewReader(meta)), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Name != "dir/hurr.txt" || strings.Join(changes[0].Fields, ",") != "Mode" {
+		t.Errorf("expected the mode of dir/hurr.txt changed, got %+v", changes)
+	}
+	if !bytes.Equal(buf.Bytes(), archive) {
+		t.Error("expected the archive assembled from the tree")
+	}
+
+	// a change of content, of the same size, fails before anything is written
+	if err := ioutil.WriteFile(filepath.Join(dir, "dir/hurr.txt"), []byte("imma derp til I hurr"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "empty")); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	changes, err = WriteTreeTarStream(dir, storage.NewJSONUnpacker(bytes.NewReader(meta)), buf)
+	if err != ErrTreeChanged {
+		t.Fatalf("expected ErrTreeChanged, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing written, got %d bytes", buf.Len())
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	if c := changes[0]; c.Name != "dir/hurr.txt" || c.Kind != Modified || !c.PayloadChanged() {
+		t.Errorf("expected the payload of dir/hurr.txt changed, got %+v", c)
+	}
+	if c := changes[1]; c.Name != "empty" || c.Kind != Deleted {
+		t.Errorf("expected empty deleted, got %+v", c)
+	}
+}
@@ -0,0 +1,158 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// MismatchError is the first place at which a tar archive differs from its
+// metadata, as found by VerifyTarStream
+type MismatchError struct {
+	// Position of the entry in the metadata, or -1 if the archive is longer
+	// than the metadata
+	Position int
+	// Offset in the archive of the first byte of a segment that differs, of
+	// a payload that does not match its checksum, or where the archive ends
+	Offset int64
+	// Name of the FileType entry, or for a SegmentType entry, of the file
+	// entry before it, if any
+	Name string
+	// Reason is what differs
+	Reason string
+}
+
+func (me *MismatchError) Error() string {
+	switch {
+	case me.Position < 0:
+		return fmt.Sprintf("asm: at offset %d: %s", me.Offset, me.Reason)
+	case me.Name == "":
+		return fmt.Sprintf("asm: entry %d at offset %d: %s", me.Position, me.Offset, me.Reason)
+	}
+	return fmt.Sprintf("asm: entry %d (%q) at offset %d: %s", me.Position, me.Name, me.Offset, me.Reason)
+}
+
+// VerifyTarStream reads the tar archive `r` alongside its metadata, checking
+// that each segment is the same bytes, and each file payload matches its
+// checksums, without assembling the archive or needing the payloads stored.
+// The first difference is returned as a *MismatchError.
+func VerifyTarStream(r io.Reader, up storage.Unpacker) error {
+	var (
+		offset int64
+		name   string
+		buf    []byte
+	)
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+		mismatch := func(format string, args ...interface{}) error {
+			return &MismatchError{Position: entry.Position, Offset: offset, Name: name, Reason: fmt.Sprintf(format, args...)}
+		}
+		switch entry.Type {
+		case storage.SegmentType:
+			if int64(cap(buf)) < int64(len(entry.Payload)) {
+				buf = make([]byte, len(entry.Payload))
+			}
+			b := buf[:len(entry.Payload)]
+			n, err := io.ReadFull(r, b)
+			if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+				return err
+			}
+			if !bytes.Equal(b[:n], entry.Payload[:n]) {
+				i := 0
+				for b[i] == entry.Payload[i] {
+					i++
+				}
+				offset += int64(i)
+				return mismatch("segment differs")
+			}
+			if n < len(b) {
+				offset += int64(n)
+				return mismatch("archive ends %d bytes into a segment of %d", n, len(b))
+			}
+			offset += int64(n)
+		case storage.FileType:
+			name = entry.GetName()
+			if entry.Size == 0 {
+				continue
+			}
+			var n int64
+			if entry.Special != "" {
+				// the size is not trusted to allocate by, as the inline
+				// payload it is the size of is at hand
+				if int64(len(entry.Inline)) != entry.Size {
+					return mismatch("inline payload of %d bytes, for a size of %d", len(entry.Inline), entry.Size)
+				}
+				b := make([]byte, entry.Size)
+				m, err := io.ReadFull(r, b)
+				if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+					return err
+				}
+				n = int64(m)
+				if n == entry.Size && !bytes.Equal(b, entry.Inline) {
+					return mismatch("payload differs")
+				}
+			} else {
+				w, verify, err := newPayloadVerifier(entry)
+				if err != nil {
+					return err
+				}
+				if len(entry.Sparse) > 0 && !entry.SparsePacked {
+					// the checksum is of the file, holes and all
+					n, err = expandSparse(w, r, entry.Sparse, entry.Size)
+				} else {
+					n, err = io.Copy(w, io.LimitReader(r, entry.DataSize()))
+				}
+				if err != nil {
+					return err
+				}
+				if n == entry.DataSize() && verify() != nil {
+					return mismatch("payload does not match its checksum")
+				}
+			}
+			if size := entry.DataSize(); n < size {
+				offset += n
+				return mismatch("archive ends %d bytes into a payload of %d", n, size)
+			}
+			offset += n
+		}
+	}
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return &MismatchError{Position: -1, Offset: offset, Reason: fmt.Sprintf("archive has %d bytes more than the metadata", n)}
+	}
+	return nil
+}
+
+// expandSparse writes the sparse file of `size` to `w`, from the data of its
+// extents read from `r`, with the holes filled in with zeros. It returns the
+// size of the data read, which is short if `r` ends first.
+func expandSparse(w io.Writer, r io.Reader, extents []storage.SparseExtent, size int64) (int64, error) {
+	var pos, n int64
+	for _, e := range extents {
+		if err := writeZeros(w, e.Offset-pos); err != nil {
+			return n, err
+		}
+		m, err := io.CopyN(w, r, e.Length)
+		n += m
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		pos = e.Offset + e.Length
+	}
+	return n, writeZeros(w, size-pos)
+}
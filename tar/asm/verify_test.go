@@ -0,0 +1,54 @@
+package asm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestVerifyTarStream(t *testing.T) {
+	archive := buildTar(t, testFiles)
+	meta, _ := disassemble(t, archive)
+	if err := VerifyTarStream(bytes.NewReader(archive), storage.NewJSONUnpacker(bytes.NewReader(meta))); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := bytes.Index(archive, []byte("imma hurr"))
+	header := bytes.Index(archive, []byte("dir/derp"))
+	for _, tc := range []struct {
+		desc   string
+		change func([]byte) []byte
+		name   string
+		offset int64
+	}{
+		{"payload", func(b []byte) []byte { b[payload+2] = 'X'; return b }, "dir/hurr.txt", int64(payload)},
+		{"header", func(b []byte) []byte { b[header+1] = 'X'; return b }, "dir/hurr.txt", int64(header + 1)},
+		{"truncated", func(b []byte) []byte { return b[:payload+3] }, "dir/hurr.txt", int64(payload + 3)},
+		{"appended", func(b []byte) []byte { return append(b, 1) }, "", int64(len(archive))},
+	} {
+		changed := tc.change(append([]byte(nil), archive...))
+		err := VerifyTarStream(bytes.NewReader(changed), storage.NewJSONUnpacker(bytes.NewReader(meta)))
+		me, ok := err.(*MismatchError)
+		if !ok {
+			t.Errorf("%s: expected a *MismatchError, got %v", tc.desc, err)
+			continue
+		}
+		if me.Name != tc.name || me.Offset != tc.offset {
+			t.Errorf("%s: expected %q at offset %d, got %v", tc.desc, tc.name, tc.offset, me)
+		}
+	}
+}
+
+func TestVerifyTarStreamInlineSize(t *testing.T) {
+	// a hostile size is not allocated for, as the inline payload is at hand
+	buf := bytes.NewBuffer(nil)
+	e := storage.Entry{Type: storage.FileType, Name: "dev", Special: "dumpdir", Size: 1 << 50, Inline: []byte("ab")}
+	if _, err := storage.NewJSONPacker(buf).AddEntry(e); err != nil {
+		t.Fatal(err)
+	}
+	err := VerifyTarStream(bytes.NewReader([]byte("ab")), storage.NewJSONUnpacker(buf))
+	if me, ok := err.(*MismatchError); !ok || me.Name != "dev" {
+		t.Errorf("expected a *MismatchError of the inline payload, got %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+const (
+	// typeGNUVolumeHeader is the type flag of the label at the start of a
+	// volume of a GNU multi-volume archive
+	typeGNUVolumeHeader = 'V'
+	// typeGNUMultiVolume is the type flag of the header continuing a file
+	// that began in a prior volume
+	typeGNUMultiVolume = 'M'
+)
+
+// ErrVolumeContinuation is returned when a volume of a GNU multi-volume
+// archive does not continue the file that the prior volume ended within.
+var ErrVolumeContinuation = errors.New("asm: volume does not continue the prior volume")
+
+// VolumeContinuationError is the file of a GNU multi-volume archive that a
+// volume does not continue as it should. It is ErrVolumeContinuation to
+// errors.Is.
+type VolumeContinuationError struct {
+	// Name of the file
+	Name string
+	// Reason is how the volume does not continue it
+	Reason string
+}
+
+func (vce *VolumeContinuationError) Error() string {
+	return fmt.Sprintf("%s: %q %s", ErrVolumeContinuation, vce.Name, vce.Reason)
+}
+
+// Is is whether `target` is ErrVolumeContinuation
+func (vce *VolumeContinuationError) Is(target error) bool {
+	return target == ErrVolumeContinuation
+}
+
+// Volume is one volume of a GNU multi-volume archive, and where its metadata
+// is packed.
+type Volume struct {
+	R      io.Reader
+	Packer storage.Packer
+}
+
+// DisassembleVolumes reads the volumes of a GNU multi-volume archive, in
+// order, packing the metadata of each volume to its own Packer. Each volume
+// can then be reassembled byte for byte with NewOutputTarStream, and the set
+// joined into one logical archive with JoinVolumes.
+//
+// Volume labels and continuation headers are kept as raw segments. A file
+// that is split across volumes is stored whole to `fp`, and each volume's
+// metadata has a Partial entry for just its part of the file.
+func DisassembleVolumes(volumes []Volume, fp storage.FilePutter) error {
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	vs := &volumeSet{volumes: volumes, n: -1}
+	if err := vs.next(); err != nil {
+		return err
+	}
+	for {
+		hdr, err := vs.tr.Next()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			if err := vs.finish(); err != nil {
+				return err
+			}
+			if vs.n == len(vs.volumes)-1 {
+				return nil
+			}
+			if err := vs.next(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := vs.addSegment(vs.tr.RawBytes()); err != nil {
+			return err
+		}
+		if hdr.Typeflag == typeGNUMultiVolume {
+			return &VolumeContinuationError{Name: hdr.Name, Reason: fmt.Sprintf("continues in volume %d without its start", vs.n)}
+		}
+
+		entry := storage.Entry{
+			Type: storage.FileType,
+			Size: hdr.Size,
+		}
+		if special := specialKind(hdr.Typeflag); special != "" && hdr.Size > 0 {
+			if err := setInline(&entry, special, vs.tr); err != nil {
+				return err
+			}
+		} else if hdr.Size > 0 {
+			sr := &spanReader{vs: vs, name: hdr.Name, size: hdr.Size, crc: crc64.New(storage.CRCTable)}
+			if _, _, err := fp.Put(hdr.Name, sr); err != nil {
+				return err
+			}
+			if sr.done != sr.size {
+				return io.ErrUnexpectedEOF
+			}
+			entry.Size = sr.partSize
+			entry.Payload = sr.crc.Sum(nil)
+			if sr.partOffset > 0 {
+				entry.Partial = true
+				entry.PartOffset = sr.partOffset
+			}
+		}
+		entry.SetName(hdr.Name)
+		setLink(&entry, hdr)
+		if _, err := vs.p.AddEntry(entry); err != nil {
+			return err
+		}
+		if err := vs.addSegment(vs.tr.RawBytes()); err != nil {
+			return err
+		}
+	}
+}
+
+type volumeSet struct {
+	volumes []Volume
+	n       int
+	r       io.Reader
+	tr      *tar.Reader
+	p       storage.Packer
+}
+
+// next moves on to the next volume
+func (vs *volumeSet) next() error {
+	vs.n++
+	if vs.n >= len(vs.volumes) {
+		return io.ErrUnexpectedEOF
+	}
+	vs.r = vs.volumes[vs.n].R
+	vs.p = vs.volumes[vs.n].Packer
+	vs.tr = tar.NewReader(vs.r)
+	vs.tr.RawAccounting = true
+	return nil
+}
+
+// finish packs whatever is left of the current volume, once the end of its
+// entries is reached.
+func (vs *volumeSet) finish() error {
+	raw := vs.tr.RawBytes()
+	remainder, err := ioutil.ReadAll(vs.r)
+	if err != nil {
+		return err
+	}
+	_, err = vs.p.AddEntry(storage.Entry{
+		Type:    storage.SegmentType,
+		Payload: append(raw, remainder...),
+	})
+	return err
+}
+
+func (vs *volumeSet) addSegment(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := vs.p.AddEntry(storage.Entry{
+		Type:    storage.SegmentType,
+		Payload: b,
+	})
+	return err
+}
+
+// spanReader reads the payload of a file, following it across volumes
+type spanReader struct {
+	vs   *volumeSet
+	name string
+	size int64
+	done int64
+
+	// the part of the file in the current volume
+	partOffset int64
+	partSize   int64
+	crc        hash.Hash
+}
+
+func (sr *spanReader) Read(b []byte) (int, error) {
+	n, err := sr.vs.tr.Read(b)
+	sr.crc.Write(b[:n])
+	sr.partSize += int64(n)
+	sr.done += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = sr.continueVolume()
+	}
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// continueVolume packs the part of the file in the current volume, and moves
+// on to the rest of the file in the next volume.
+func (sr *spanReader) continueVolume() error {
+	vs := sr.vs
+	entry := storage.Entry{
+		Type:       storage.FileType,
+		Size:       sr.partSize,
+		Payload:    sr.crc.Sum(nil),
+		Partial:    true,
+		PartOffset: sr.partOffset,
+	}
+	entry.SetName(sr.name)
+	if _, err := vs.p.AddEntry(entry); err != nil {
+		return err
+	}
+
+	if err := vs.next(); err != nil {
+		return err
+	}
+	for {
+		hdr, err := vs.tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = &VolumeContinuationError{Name: sr.name, Reason: fmt.Sprintf("is not continued in volume %d", vs.n)}
+			}
+			return err
+		}
+		raw := vs.tr.RawBytes()
+		if err := vs.addSegment(raw); err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case typeGNUVolumeHeader:
+			entry := storage.Entry{Type: storage.FileType}
+			entry.SetName(hdr.Name)
+			if _, err := vs.p.AddEntry(entry); err != nil {
+				return err
+			}
+			continue
+		case typeGNUMultiVolume:
+		default:
+			return &VolumeContinuationError{Name: sr.name, Reason: fmt.Sprintf("is not continued, found %q", hdr.Name)}
+		}
+
+		// the offset field of the old GNU header
+		offset, err := parseNumeric(raw[len(raw)-blockSize:][369:381])
+		if err != nil {
+			return err
+		}
+		if hdr.Name != sr.name || offset != sr.done || offset+hdr.Size != sr.size {
+			return &VolumeContinuationError{Name: sr.name, Reason: fmt.Sprintf("is not continued at offset %d, found %q at offset %d", sr.done, hdr.Name, offset)}
+		}
+		sr.partOffset = offset
+		sr.partSize = 0
+		sr.crc.Reset()
+		return nil
+	}
+}
+
+// JoinVolumes writes to `w` the volumes of a GNU multi-volume archive as one
+// logical archive. The volume labels and continuation headers that start
+// each volume after the first are left out, so that files split across
+// volumes are contiguous.
+func JoinVolumes(w io.Writer, volumes ...io.Reader) error {
+	for i, r := range volumes {
+		if i > 0 {
+			tr := tar.NewReader(r)
+			tr.RawAccounting = true
+			for {
+				hdr, err := tr.Next()
+				if err != nil && err != io.EOF {
+					return fmt.Errorf("asm: volume %d: %v", i, err)
+				}
+				if err == nil && hdr.Typeflag == typeGNUVolumeHeader {
+					continue
+				}
+				if err == io.EOF || hdr.Typeflag != typeGNUMultiVolume {
+					if _, err := w.Write(tr.RawBytes()); err != nil {
+						return err
+					}
+				}
+				break
+			}
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// volumeHeader returns the header blocks for hdr, with the old GNU offset
+// field set for a continuation header
+func volumeHeader(t *testing.T, hdr tar.Header, offset int64) []byte {
+	buf := bytes.NewBuffer(nil)
+	hdr.ModTime = time.Unix(1500000000, 0)
+	if err := writeHeader(buf, &hdr); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if offset == 0 {
+		return b
+	}
+	block := b[len(b)-blockSize:]
+	formatNumeric(block[369:381], offset)
+	setChecksum(block)
+	return b
+}
+
+func padded(s string) []byte {
+	return append([]byte(s), make([]byte, -len(s)&(blockSize-1))...)
+}
+
+// testVolumes returns three volumes, with big.bin spanning all of them, and
+// the equivalent archive of one volume.
+func testVolumes(t *testing.T) ([][]byte, []byte, string) {
+	big := strings.Repeat("0123456789abcdef", 190)[:3000]
+	label := volumeHeader(t, tar.Header{Name: "backup", Typeflag: typeGNUVolumeHeader}, 0)
+	a := volumeHeader(t, tar.Header{Name: "a.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 5}, 0)
+	bigHdr := volumeHeader(t, tar.Header{Name: "big.bin", Typeflag: tar.TypeReg, Mode: 0644, Size: 3000}, 0)
+	c := volumeHeader(t, tar.Header{Name: "c.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}, 0)
+
+	var vol1, vol2, vol3 []byte
+	vol1 = append(vol1, label...)
+	vol1 = append(vol1, a...)
+	vol1 = append(vol1, padded("hello")...)
+	vol1 = append(vol1, bigHdr...)
+	vol1 = append(vol1, big[:1024]...)
+
+	vol2 = append(vol2, label...)
+	vol2 = append(vol2, volumeHeader(t, tar.Header{Name: "big.bin", Typeflag: typeGNUMultiVolume, Mode: 0644, Size: 1976}, 1024)...)
+	vol2 = append(vol2, big[1024:2048]...)
+
+	vol3 = append(vol3, label...)
+	vol3 = append(vol3, volumeHeader(t, tar.Header{Name: "big.bin", Typeflag: typeGNUMultiVolume, Mode: 0644, Size: 952}, 2048)...)
+	vol3 = append(vol3, padded(big[2048:])...)
+	vol3 = append(vol3, c...)
+	vol3 = append(vol3, padded("bye")...)
+	vol3 = append(vol3, endOfArchive...)
+
+	var joined []byte
+	joined = append(joined, vol1...)
+	joined = append(joined, padded(big[1024:])...)
+	joined = append(joined, c...)
+	joined = append(joined, padded("bye")...)
+	joined = append(joined, endOfArchive...)
+	return [][]byte{vol1, vol2, vol3}, joined, big
+}
+
+func TestDisassembleVolumes(t *testing.T) {
+	volumes, joined, big := testVolumes(t)
+	metas := make([]*bytes.Buffer, len(volumes))
+	var vols []Volume
+	for i, v := range volumes {
+		metas[i] = bytes.NewBuffer(nil)
+		vols = append(vols, Volume{R: bytes.NewReader(v), Packer: storage.NewJSONPacker(metas[i])})
+	}
+	fgp := storage.NewBufferFileGetPutter()
+	if err := DisassembleVolumes(vols, fgp); err != nil {
+		t.Fatal(err)
+	}
+
+	// split files are stored whole
+	rc, err := fgp.Get("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != big {
+		t.Errorf("expected big.bin to be stored whole, got %d bytes", len(got))
+	}
+
+	var outputs []io.Reader
+	for i, v := range volumes {
+		buf := bytes.NewBuffer(nil)
+		if err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(bytes.NewReader(metas[i].Bytes())), buf); err != nil {
+			t.Fatalf("volume %d: %s", i, err)
+		}
+		if !bytes.Equal(buf.Bytes(), v) {
+			t.Errorf("volume %d: reassembled %d bytes, expected %d bytes", i, buf.Len(), len(v))
+		}
+		outputs = append(outputs, buf)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := JoinVolumes(buf, outputs...); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), joined) {
+		t.Fatalf("joined %d bytes, expected %d bytes", buf.Len(), len(joined))
+	}
+	tr := tar.NewReader(buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == "big.bin" {
+			got, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != big {
+				t.Errorf("expected the joined big.bin to be whole, got %d bytes", len(got))
+			}
+		}
+	}
+	if strings.Join(names, ",") != "backup,a.txt,big.bin,c.txt" {
+		t.Errorf("unexpected entries of the joined archive: %q", names)
+	}
+}
+
+func TestDisassembleVolumesMissing(t *testing.T) {
+	volumes, _, _ := testVolumes(t)
+	for _, tc := range []struct {
+		volumes  [][]byte
+		expected error
+	}{
+		// the file in the last volume continues past it
+		{volumes[:2], io.ErrUnexpectedEOF},
+		{[][]byte{volumes[0], volumes[2]}, ErrVolumeContinuation},
+		{[][]byte{volumes[1], volumes[2]}, ErrVolumeContinuation},
+	} {
+		var vols []Volume
+		for _, v := range tc.volumes {
+			vols = append(vols, Volume{R: bytes.NewReader(v), Packer: storage.NewJSONPacker(ioutil.Discard)})
+		}
+		err := DisassembleVolumes(vols, nil)
+		if vce, ok := err.(*VolumeContinuationError); ok && vce.Is(ErrVolumeContinuation) {
+			err = ErrVolumeContinuation
+		}
+		if err != tc.expected {
+			t.Errorf("expected %v for %d mismatched volumes, got %v", tc.expected, len(tc.volumes), err)
+		}
+	}
+}
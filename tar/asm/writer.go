@@ -0,0 +1,173 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// TarWriter is a tar.Writer that packs the metadata of the archive as it is
+// written, as NewInputTarStream would when disassembling it, so a tool that
+// generates an archive (like a layer) has its metadata in the same pass. The
+// options of the tar.Writer, like RecordSize, are to be set before the first
+// header.
+//
+// The metadata is only complete once the TarWriter is closed.
+type TarWriter struct {
+	*tar.Writer
+	rec     *recordingWriter
+	p       storage.Packer
+	fp      storage.FilePutter
+	entry   *storage.Entry
+	payload *pendingPut
+	inline  *bytes.Buffer
+}
+
+// pendingPut is the payload being stored, as it is written
+type pendingPut struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// recordingWriter writes on to `w`, keeping what is not a file payload as the
+// raw bytes of the segment being written
+type recordingWriter struct {
+	w       io.Writer
+	segment bytes.Buffer
+	// payload is where the bytes of a file payload go, instead of the segment
+	payload io.Writer
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	if rw.payload != nil {
+		if _, perr := rw.payload.Write(p[:n]); err == nil {
+			err = perr
+		}
+	} else {
+		rw.segment.Write(p[:n])
+	}
+	return n, err
+}
+
+// NewOutputTarWriter returns a TarWriter of the archive written to `w`, with
+// its metadata packed to `p`, and the file payloads stored to `fp`. If `fp`
+// is nil, the payloads are only checksummed, as by NewInputTarStream.
+func NewOutputTarWriter(w io.Writer, p storage.Packer, fp storage.FilePutter) *TarWriter {
+	if fp == nil {
+		fp = storage.NewDiscardFilePutter()
+	}
+	rec := &recordingWriter{w: w}
+	return &TarWriter{Writer: tar.NewWriter(rec), rec: rec, p: p, fp: fp}
+}
+
+// WriteHeader writes `hdr`, as tar.Writer.WriteHeader, and packs the entry of
+// the file before it, once its payload is stored
+func (tw *TarWriter) WriteHeader(hdr *tar.Header) error {
+	if err := tw.finishEntry(); err != nil {
+		return err
+	}
+	if err := tw.Writer.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if err := tw.addSegment(); err != nil {
+		return err
+	}
+
+	entry := &storage.Entry{Type: storage.FileType, Size: hdr.Size}
+	entry.SetName(hdr.Name)
+	setLink(entry, hdr)
+	if hdr.Size == 0 {
+		_, err := tw.p.AddEntry(*entry)
+		return err
+	}
+	tw.entry = entry
+	if special := specialKind(hdr.Typeflag); special != "" {
+		entry.Special = special
+		tw.inline = bytes.NewBuffer(nil)
+		tw.rec.payload = tw.inline
+		return nil
+	}
+	pr, pw := io.Pipe()
+	put := &pendingPut{pw: pw, done: make(chan error, 1)}
+	go func(name string) {
+		_, crc, err := tw.fp.Put(name, pr)
+		entry.Payload = crc
+		// the rest is discarded, if the FilePutter stopped short of it
+		pr.CloseWithError(err)
+		put.done <- err
+	}(hdr.Name)
+	tw.payload = put
+	tw.rec.payload = pw
+	return nil
+}
+
+// finishEntry flushes the payload of the current file, if any, and packs its
+// entry, once it is stored
+func (tw *TarWriter) finishEntry() error {
+	if tw.entry == nil {
+		return nil
+	}
+	// the padding is part of the segment that follows
+	tw.rec.payload = nil
+	err := tw.Writer.Flush()
+	entry := tw.entry
+	tw.entry = nil
+	if tw.payload != nil {
+		tw.payload.pw.CloseWithError(err)
+		if perr := <-tw.payload.done; err == nil {
+			err = perr
+		}
+		tw.payload = nil
+	}
+	if tw.inline != nil {
+		if err == nil {
+			err = setInline(entry, entry.Special, tw.inline)
+		}
+		tw.inline = nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tw.p.AddEntry(*entry)
+	return err
+}
+
+// Flush finishes the current file, as tar.Writer.Flush, and packs its entry
+func (tw *TarWriter) Flush() error {
+	if err := tw.finishEntry(); err != nil {
+		return err
+	}
+	return tw.Writer.Flush()
+}
+
+// addSegment packs the raw bytes written since the last payload
+func (tw *TarWriter) addSegment() error {
+	if tw.rec.segment.Len() == 0 {
+		return nil
+	}
+	payload := append([]byte(nil), tw.rec.segment.Bytes()...)
+	tw.rec.segment.Reset()
+	_, err := tw.p.AddEntry(storage.Entry{Type: storage.SegmentType, Payload: payload})
+	return err
+}
+
+// Close writes the end of the archive, as tar.Writer.Close, and packs the
+// last of its metadata
+func (tw *TarWriter) Close() error {
+	if err := tw.finishEntry(); err != nil {
+		return err
+	}
+	if err := tw.Writer.Close(); err != nil {
+		return err
+	}
+	if err := tw.addSegment(); err != nil {
+		return err
+	}
+	// as disassembly packs what is after the end of archive marker, even when
+	// it is nothing
+	_, err := tw.p.AddEntry(storage.Entry{Type: storage.SegmentType, Payload: []byte{}})
+	return err
+}
@@ -0,0 +1,67 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestOutputTarWriter(t *testing.T) {
+	files := append([]testFile{}, testFiles...)
+	files = append(files, testFile{
+		hdr:  tar.Header{Name: "dumpdir/", Typeflag: typeGNUDumpDir, Mode: 0755},
+		body: "Yfoo\x00Nbar\x00\x00",
+	})
+	archive := bytes.NewBuffer(nil)
+	meta := bytes.NewBuffer(nil)
+	fgp := storage.NewBufferFileGetPutter()
+	tw := NewOutputTarWriter(archive, storage.NewJSONPacker(meta), fgp)
+	for _, f := range files {
+		hdr := f.hdr
+		hdr.Size = int64(len(f.body))
+		hdr.ModTime = time.Unix(1500000000, 0)
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		// in two writes, as a payload often is
+		half := len(f.body) / 2
+		if _, err := io.WriteString(tw, f.body[:half]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, f.body[half:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := disassemble(t, archive.Bytes())
+	if meta.String() != string(expected) {
+		t.Errorf("expected the metadata of disassembling the archive:\n%s\ngot:\n%s", expected, meta)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := WriteOutputTarStream(fgp, storage.NewJSONUnpacker(meta), buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), archive.Bytes()) {
+		t.Error("expected the archive assembled from the metadata and payloads")
+	}
+}
+
+func TestOutputTarWriterShort(t *testing.T) {
+	tw := NewOutputTarWriter(bytes.NewBuffer(nil), storage.NewJSONPacker(bytes.NewBuffer(nil)), nil)
+	if err := tw.WriteHeader(&tar.Header{Name: "short", Typeflag: tar.TypeReg, Size: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(tw, "short"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err == nil {
+		t.Error("expected an error for the payload written short")
+	}
+}
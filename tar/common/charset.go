@@ -0,0 +1,124 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrNoCharset occurs when names are to be decoded from a charset that no
+// decoder is registered for
+var ErrNoCharset = errors.New("common: no decoder registered for the charset")
+
+const (
+	// UTF8 is the charset of the names that are valid UTF-8 already
+	UTF8 = "utf-8"
+	// Latin1 is ISO-8859-1, of which every byte is the code point of its
+	// value, so every name can be decoded from it
+	Latin1 = "iso-8859-1"
+	// ShiftJIS is the charset of the names of archives made on Japanese
+	// systems. Only its detection is built in, as decoding it takes the
+	// tables of JIS X 0208, so its decoder is to be registered, like that of
+	// golang.org/x/text/encoding/japanese.
+	ShiftJIS = "shift_jis"
+)
+
+// CharsetDecoder decodes a name in a charset to UTF-8
+type CharsetDecoder func(name []byte) (string, error)
+
+var charsets = struct {
+	sync.RWMutex
+	decoders map[string]CharsetDecoder
+}{decoders: map[string]CharsetDecoder{
+	Latin1: func(name []byte) (string, error) { return DecodeLatin1(name), nil },
+}}
+
+// RegisterCharset registers the decoder of the charset `name`, like ShiftJIS,
+// replacing any before it
+func RegisterCharset(name string, decode CharsetDecoder) {
+	charsets.Lock()
+	defer charsets.Unlock()
+	charsets.decoders[name] = decode
+}
+
+// DecodeCharset decodes `name` from `charset` to UTF-8. It is ErrNoCharset if
+// no decoder is registered for it.
+func DecodeCharset(name []byte, charset string) (string, error) {
+	if charset == UTF8 {
+		if !utf8.Valid(name) {
+			return "", errors.New("common: name is not valid UTF-8")
+		}
+		return string(name), nil
+	}
+	charsets.RLock()
+	decode, ok := charsets.decoders[charset]
+	charsets.RUnlock()
+	if !ok {
+		return "", ErrNoCharset
+	}
+	return decode(name)
+}
+
+// DecodeLatin1 decodes `name` from ISO-8859-1 to UTF-8
+func DecodeLatin1(name []byte) string {
+	runes := make([]rune, len(name))
+	for i, c := range name {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// LooksShiftJIS is whether `name` looks like Shift-JIS: it is all valid
+// Shift-JIS, with at least one double-byte character whose lead byte is in
+// 0x81 to 0x9F. Those bytes are control characters in ISO-8859-1, so names in
+// it are not taken for Shift-JIS, though some in windows-1252 may be.
+func LooksShiftJIS(name []byte) bool {
+	var double bool
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c < 0x80, c >= 0xa1 && c <= 0xdf:
+			// ASCII, or half-width katakana
+		case c >= 0x81 && c <= 0x9f, c >= 0xe0 && c <= 0xef:
+			if i+1 >= len(name) {
+				return false
+			}
+			t := name[i+1]
+			if t < 0x40 || t == 0x7f || t > 0xfc {
+				return false
+			}
+			double = double || c <= 0x9f
+			i++
+		default:
+			return false
+		}
+	}
+	return double
+}
+
+// DetectCharset returns the charset that `name` is most likely in: UTF8 if it
+// is valid UTF-8, ShiftJIS if it LooksShiftJIS, and otherwise Latin1
+func DetectCharset(name []byte) string {
+	switch {
+	case utf8.Valid(name):
+		return UTF8
+	case LooksShiftJIS(name):
+		return ShiftJIS
+	}
+	return Latin1
+}
+
+// DecodeName decodes `name`, of an archive made on a system of a legacy
+// charset, to UTF-8 for display, from the charset DetectCharset finds. If it
+// is one with no decoder registered, or its decoder fails, the name is decoded
+// from Latin1, so a name is always returned, along with the charset that it
+// was decoded from. The raw bytes are still what the entry is named.
+func DecodeName(name []byte) (string, string) {
+	charset := DetectCharset(name)
+	if charset != Latin1 {
+		if decoded, err := DecodeCharset(name, charset); err == nil {
+			return decoded, charset
+		}
+	}
+	return DecodeLatin1(name), Latin1
+}
@@ -0,0 +1,73 @@
+package common
+
+import "testing"
+
+// sjisHiragana decodes the Shift-JIS of the hiragana, and the ASCII, only,
+// for the tests
+func sjisHiragana(name []byte) (string, error) {
+	var runes []rune
+	for i := 0; i < len(name); i++ {
+		if name[i] == 0x82 && i+1 < len(name) && name[i+1] >= 0x9f && name[i+1] <= 0xf1 {
+			runes = append(runes, rune(0x3041+int(name[i+1])-0x9f))
+			i++
+			continue
+		}
+		runes = append(runes, rune(name[i]))
+	}
+	return string(runes), nil
+}
+
+func TestDecodeLatin1(t *testing.T) {
+	if got := DecodeLatin1([]byte("caf\xe9/f\xfcr")); got != "café/für" {
+		t.Errorf("expected %q, got %q", "café/für", got)
+	}
+}
+
+func TestDetectCharset(t *testing.T) {
+	for _, c := range []struct {
+		name, charset string
+	}{
+		{"plain/ascii", UTF8},
+		{"café", UTF8},
+		{"caf\xe9", Latin1},
+		{"f\xfcr", Latin1},
+		// lead bytes of 0xe0 to 0xef are also letters of ISO-8859-1
+		{"\xe4b", Latin1},
+		// "ありがとう.txt"
+		{"\x82\xa0\x82\xe8\x82\xaa\x82\xc6\x82\xa4.txt", ShiftJIS},
+		// half-width katakana only
+		{"\xb1\xb2", Latin1},
+		{"\x82", Latin1},
+	} {
+		if got := DetectCharset([]byte(c.name)); got != c.charset {
+			t.Errorf("%q: expected %s, got %s", c.name, c.charset, got)
+		}
+	}
+}
+
+func TestDecodeName(t *testing.T) {
+	sjis := []byte("\x82\xa0\x82\xe8\x82\xaa\x82\xc6\x82\xa4.txt")
+	// with no decoder of Shift-JIS, it is decoded as ISO-8859-1
+	if _, err := DecodeCharset(sjis, ShiftJIS); err != ErrNoCharset {
+		t.Errorf("expected ErrNoCharset, got %v", err)
+	}
+	if _, charset := DecodeName(sjis); charset != Latin1 {
+		t.Errorf("expected the fallback to %s, got %s", Latin1, charset)
+	}
+
+	RegisterCharset(ShiftJIS, sjisHiragana)
+	defer func() {
+		charsets.Lock()
+		delete(charsets.decoders, ShiftJIS)
+		charsets.Unlock()
+	}()
+	if name, charset := DecodeName(sjis); name != "ありがとう.txt" || charset != ShiftJIS {
+		t.Errorf("expected %q from %s, got %q from %s", "ありがとう.txt", ShiftJIS, name, charset)
+	}
+	if name, charset := DecodeName([]byte("caf\xe9")); name != "café" || charset != Latin1 {
+		t.Errorf("expected %q from %s, got %q from %s", "café", Latin1, name, charset)
+	}
+	if name, charset := DecodeName([]byte("café")); name != "café" || charset != UTF8 {
+		t.Errorf("expected the UTF-8 name as it is, got %q from %s", name, charset)
+	}
+}
@@ -0,0 +1,194 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrNoCompressor occurs when a stream is to be compressed in a format that no
+// compressor is registered for
+var ErrNoCompressor = errors.New("common: no compressor for the compression")
+
+// CompressOptions are how a stream is compressed by CompressWriter
+type CompressOptions struct {
+	// Level of the compression, in the range of its compressor, or 0 for
+	// its default
+	Level int
+	// Jobs is how many blocks of the stream are compressed at once, for the
+	// compressors that can, or 0 or 1 for one at a time
+	Jobs int
+}
+
+// Compressor returns a writer that compresses what is written to it to `w`,
+// and is closed to finish the stream, without closing `w`
+type Compressor func(w io.Writer, opts CompressOptions) (io.WriteCloser, error)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{}
+)
+
+// RegisterCompressor makes the compressor of the named compression available
+// to CompressWriter, replacing any before it. "gzip" is built in; "zstd"
+// needs packages outside the standard library, so it is for the importer to
+// register.
+func RegisterCompressor(name string, c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[name] = c
+}
+
+func init() {
+	RegisterCompressor(Gzip, func(w io.Writer, opts CompressOptions) (io.WriteCloser, error) {
+		level := opts.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if opts.Jobs > 1 {
+			return NewParallelGzipWriter(w, level, opts.Jobs)
+		}
+		return gzip.NewWriterLevel(w, level)
+	})
+}
+
+// CompressWriter returns a writer that compresses what is written to it to
+// `w`, in `compression`, like "gzip". For Uncompressed, it is written as it
+// is. The writer is to be closed to finish the stream; closing it does not
+// close `w`.
+func CompressWriter(w io.Writer, compression string, opts CompressOptions) (io.WriteCloser, error) {
+	if compression == Uncompressed {
+		return nopWriteCloser{w}, nil
+	}
+	compressorsMu.RLock()
+	c, ok := compressors[compression]
+	compressorsMu.RUnlock()
+	if !ok {
+		return nil, ErrNoCompressor
+	}
+	return c(w, opts)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ParallelGzipBlockSize is the size of the blocks that NewParallelGzipWriter
+// compresses at once
+const ParallelGzipBlockSize = 1 << 20
+
+// NewParallelGzipWriter returns a writer that gzips what is written to it to
+// `w` in `level`, compressing up to `jobs` blocks of ParallelGzipBlockSize at
+// once. Each block is a gzip member of its own, so the stream is a
+// multistream gzip, which gzip and compress/gzip read as one, at a slightly
+// lower ratio than compressing it whole.
+func NewParallelGzipWriter(w io.Writer, level, jobs int) (io.WriteCloser, error) {
+	// for the error of an invalid level, before any block is compressed
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		return nil, err
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	pw := &parallelGzipWriter{
+		level: level,
+		queue: make(chan chan []byte, jobs),
+		done:  make(chan struct{}),
+	}
+	go pw.drain(w)
+	return pw, nil
+}
+
+type parallelGzipWriter struct {
+	level  int
+	block  []byte
+	queue  chan chan []byte // of the blocks being compressed, in order
+	done   chan struct{}
+	mu     sync.Mutex
+	err    error
+	blocks int
+	closed bool
+}
+
+// drain writes the compressed blocks to `w` in order, as they are done
+func (pw *parallelGzipWriter) drain(w io.Writer) {
+	defer close(pw.done)
+	for ch := range pw.queue {
+		b := <-ch
+		if pw.error() != nil {
+			continue
+		}
+		if _, err := w.Write(b); err != nil {
+			pw.mu.Lock()
+			pw.err = err
+			pw.mu.Unlock()
+		}
+	}
+}
+
+func (pw *parallelGzipWriter) error() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.err
+}
+
+// flush starts the compression of the block written so far
+func (pw *parallelGzipWriter) flush() {
+	block := pw.block
+	pw.block = nil
+	pw.blocks++
+	ch := make(chan []byte, 1)
+	pw.queue <- ch
+	go func() {
+		buf := bytes.NewBuffer(make([]byte, 0, len(block)/2))
+		zw, _ := gzip.NewWriterLevel(buf, pw.level)
+		zw.Write(block)
+		zw.Close()
+		ch <- buf.Bytes()
+	}()
+}
+
+func (pw *parallelGzipWriter) Write(p []byte) (int, error) {
+	if pw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	var n int
+	for len(p) > 0 {
+		if err := pw.error(); err != nil {
+			return n, err
+		}
+		if pw.block == nil {
+			pw.block = make([]byte, 0, ParallelGzipBlockSize)
+		}
+		i := ParallelGzipBlockSize - len(pw.block)
+		if i > len(p) {
+			i = len(p)
+		}
+		pw.block = append(pw.block, p[:i]...)
+		n += i
+		p = p[i:]
+		if len(pw.block) == ParallelGzipBlockSize {
+			pw.flush()
+		}
+	}
+	return n, nil
+}
+
+// Close compresses the last block, and waits for all to be written. An empty
+// stream is one empty member, as gzip writes it.
+func (pw *parallelGzipWriter) Close() error {
+	if pw.closed {
+		return pw.error()
+	}
+	pw.closed = true
+	if len(pw.block) > 0 || pw.blocks == 0 {
+		pw.flush()
+	}
+	close(pw.queue)
+	<-pw.done
+	return pw.error()
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressWriter(t *testing.T) {
+	// more than two blocks, of text that compresses
+	data := bytes.Repeat([]byte(content), 2*ParallelGzipBlockSize/len(content)+100)
+	for _, tc := range []struct {
+		data []byte
+		opts CompressOptions
+	}{
+		{data, CompressOptions{}},
+		{data, CompressOptions{Level: gzip.BestSpeed, Jobs: 4}},
+		{[]byte(content), CompressOptions{Jobs: 4}},
+		{nil, CompressOptions{Jobs: 2}},
+	} {
+		buf := bytes.NewBuffer(nil)
+		cw, err := CompressWriter(buf, Gzip, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cw.Write(tc.data); err != nil {
+			t.Fatal(err)
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		rc, compression, err := DecompressReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compression != Gzip {
+			t.Errorf("expected gzip, got %q", compression)
+		}
+		out, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, tc.data) {
+			t.Errorf("%+v: expected %d bytes back, got %d", tc.opts, len(tc.data), len(out))
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	cw, err := CompressWriter(buf, Uncompressed, CompressOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(cw, content)
+	if cw.Close(); buf.String() != content {
+		t.Errorf("expected %q as it was, got %q", content, buf.String())
+	}
+
+	if _, err := CompressWriter(buf, Gzip, CompressOptions{Level: 42}); err == nil {
+		t.Errorf("expected an invalid level to fail")
+	}
+	if _, err := CompressWriter(buf, Gzip, CompressOptions{Level: 42, Jobs: 2}); err == nil {
+		t.Errorf("expected an invalid level to fail in parallel")
+	}
+	if _, err := CompressWriter(buf, Xz, CompressOptions{}); err != ErrNoCompressor {
+		t.Errorf("expected ErrNoCompressor for xz, got %v", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, io.ErrShortWrite }
+
+func TestParallelGzipWriterError(t *testing.T) {
+	pw, err := NewParallelGzipWriter(failingWriter{}, gzip.DefaultCompression, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3*ParallelGzipBlockSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	pw.Write(data)
+	if err := pw.Close(); err != io.ErrShortWrite {
+		t.Errorf("expected the error of the writer, got %v", err)
+	}
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	RegisterCompressor(Zstd, func(w io.Writer, opts CompressOptions) (io.WriteCloser, error) {
+		return nopWriteCloser{w}, nil
+	})
+	defer func() {
+		compressorsMu.Lock()
+		delete(compressors, Zstd)
+		compressorsMu.Unlock()
+	}()
+	buf := bytes.NewBuffer(nil)
+	cw, err := CompressWriter(buf, Zstd, CompressOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(cw, content)
+	cw.Close()
+	if buf.String() != content {
+		t.Errorf("expected the registered compressor used, got %q", buf.String())
+	}
+}
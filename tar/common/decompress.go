@@ -0,0 +1,108 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// ErrUnsupportedCompression occurs when a stream is compressed in a format
+// that no decompressor is registered for
+var ErrUnsupportedCompression = errors.New("common: no decompressor for the compression of the stream")
+
+// Compressions of a stream, as detected by DetectCompression
+const (
+	Uncompressed = ""
+	Gzip         = "gzip"
+	Bzip2        = "bzip2"
+	Xz           = "xz"
+	Zstd         = "zstd"
+)
+
+// magics are the leading bytes of each compression
+var magics = []struct {
+	name  string
+	magic []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Bzip2, []byte("BZh")},
+	{Xz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// magicLen is the most leading bytes needed to detect a compression
+const magicLen = 6
+
+// Decompressor returns a reader of the stream `r` decompressed
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	decompressorsMu sync.RWMutex
+	decompressors   = map[string]Decompressor{}
+)
+
+// RegisterDecompressor makes the decompressor of the named compression
+// available to DecompressReader, replacing any before it. "gzip" and "bzip2"
+// are built in; "xz" and "zstd" need packages outside the standard library,
+// so they are for the importer to register.
+func RegisterDecompressor(name string, d Decompressor) {
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+	decompressors[name] = d
+}
+
+func init() {
+	RegisterDecompressor(Gzip, func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	})
+	RegisterDecompressor(Bzip2, func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	})
+}
+
+// DetectCompression returns the compression of a stream that starts with
+// `b`, or Uncompressed if it is none known
+func DetectCompression(b []byte) string {
+	for _, m := range magics {
+		if bytes.HasPrefix(b, m.magic) {
+			return m.name
+		}
+	}
+	return Uncompressed
+}
+
+// DecompressReader detects the compression of `r`, and returns the stream
+// decompressed, and the compression. An uncompressed stream is returned as it
+// is. Closing the reader does not close `r`.
+//
+// Only gzip and bzip2 are decompressed by the standard library. xz and zstd
+// are detected, but are ErrUnsupportedCompression unless a decompressor is
+// registered for them with RegisterDecompressor, as the tar-split command
+// does with the `xz` and `zstd` programs.
+func DecompressReader(r io.Reader) (io.ReadCloser, string, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(magicLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, "", err
+	}
+	compression := DetectCompression(magic)
+	if compression == Uncompressed {
+		return ioutil.NopCloser(br), Uncompressed, nil
+	}
+	decompressorsMu.RLock()
+	d, ok := decompressors[compression]
+	decompressorsMu.RUnlock()
+	if !ok {
+		return nil, compression, ErrUnsupportedCompression
+	}
+	rc, err := d(br)
+	if err != nil {
+		return nil, compression, err
+	}
+	return rc, compression, nil
+}
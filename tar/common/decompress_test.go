@@ -0,0 +1,81 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const content = "hello, tar-split\n"
+
+// bzip2 of content, as there is no bzip2 writer in the standard library
+const bzip2Content = "425a6839314159265359dd95d1ad0000035180001040062264dc0020002200433284000031823d5a35d0804fc5dc914e14243765746b40"
+
+// xz of content
+const xzContent = "fd377a585a000004e6d6b44604c01511210116000000000000000000b218dc5f01001068656c6c6f2c207461722d73706c69740a00000000a0f067ca88683fe7000131116b926b8c1fb6f37d010000000004595a"
+
+func TestDecompressReader(t *testing.T) {
+	gz := bytes.NewBuffer(nil)
+	gzw := gzip.NewWriter(gz)
+	io.WriteString(gzw, content)
+	gzw.Close()
+	bz, _ := hex.DecodeString(bzip2Content)
+
+	for _, tc := range []struct {
+		compression string
+		stream      []byte
+	}{
+		{Uncompressed, []byte(content)},
+		{Uncompressed, []byte("x")},
+		{Gzip, gz.Bytes()},
+		{Bzip2, bz},
+	} {
+		rc, compression, err := DecompressReader(bytes.NewReader(tc.stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compression != tc.compression {
+			t.Errorf("expected %q, got %q", tc.compression, compression)
+		}
+		out, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := string(tc.stream); tc.compression != Uncompressed {
+			if string(out) != content {
+				t.Errorf("%s: expected %q, got %q", compression, content, out)
+			}
+		} else if string(out) != expected {
+			t.Errorf("expected %q as it was, got %q", expected, out)
+		}
+	}
+}
+
+func TestRegisterDecompressor(t *testing.T) {
+	xz, _ := hex.DecodeString(xzContent)
+	if _, compression, err := DecompressReader(bytes.NewReader(xz)); err != ErrUnsupportedCompression || compression != Xz {
+		t.Fatalf("expected ErrUnsupportedCompression for xz, got %q and %v", compression, err)
+	}
+
+	RegisterDecompressor(Xz, func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(content)), nil
+	})
+	defer func() {
+		decompressorsMu.Lock()
+		delete(decompressors, Xz)
+		decompressorsMu.Unlock()
+	}()
+	rc, _, err := DecompressReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if out, _ := ioutil.ReadAll(rc); string(out) != content {
+		t.Errorf("expected the registered decompressor used, got %q", out)
+	}
+}
@@ -0,0 +1,6 @@
+/*
+Package common is for what the other packages of tar-split, and its commands,
+share in handling the archives, like the compression of the streams they are
+read from.
+*/
+package common
@@ -0,0 +1,187 @@
+/*
+Package gen synthesizes tar archives that exercise the edge cases of the
+format, such as invalid UTF-8 names, GNU long links, sparse files, base-256
+numbers, non-zero padding and truncation.
+
+They are for validating that a deployment, or a custom storage driver, keeps
+the round trip of disassembly and assembly byte for byte.
+*/
+package gen
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Archive is a synthesized tar archive
+type Archive struct {
+	Name        string
+	Description string
+	Data        []byte
+	// Truncated is set for an archive that ends abruptly, which can not be
+	// disassembled
+	Truncated bool
+	// Sparse is set for an archive with sparse files, whose sparse maps are
+	// kept on disassembly, so they are reassembled byte for byte too
+	Sparse bool
+}
+
+// Archives returns all of the synthesized archives
+func Archives() []Archive {
+	return []Archive{
+		invalidUTF8(),
+		gnuLongLink(),
+		gnuSparse(),
+		base256(),
+		nonZeroPadding(),
+		extraTrailer(),
+		truncated(),
+	}
+}
+
+const blockSize = 512
+
+// header describes the fields of a raw header block
+type header struct {
+	name     string
+	mode     int64
+	size     int64
+	typeflag byte
+	linkname string
+	gnu      bool // old GNU magic, rather than ustar
+	base256  bool // size in the GNU base-256 encoding
+}
+
+// block returns the raw header block, with its checksum
+func (h header) block() []byte {
+	b := make([]byte, blockSize)
+	copy(b[0:100], h.name)
+	formatOctal(b[100:108], h.mode)
+	formatOctal(b[108:116], 0)
+	formatOctal(b[116:124], 0)
+	if h.base256 {
+		n := h.size
+		for i := 135; i > 124; i-- {
+			b[i] = byte(n)
+			n >>= 8
+		}
+		b[124] = 0x80
+	} else {
+		formatOctal(b[124:136], h.size)
+	}
+	formatOctal(b[136:148], 1500000000)
+	b[156] = h.typeflag
+	copy(b[157:257], h.linkname)
+	if h.gnu {
+		copy(b[257:265], "ustar  \x00")
+	} else {
+		copy(b[257:265], "ustar\x0000")
+	}
+	copy(b[265:297], "root")
+	copy(b[297:329], "root")
+	setChecksum(b)
+	return b
+}
+
+func formatOctal(field []byte, n int64) {
+	copy(field, fmt.Sprintf("%0*o\x00", len(field)-1, n))
+}
+
+func setChecksum(b []byte) {
+	copy(b[148:156], "        ")
+	var sum int64
+	for _, c := range b {
+		sum += int64(c)
+	}
+	copy(b[148:156], fmt.Sprintf("%06o\x00 ", sum))
+}
+
+// archive accumulates the raw bytes of an archive
+type archive struct {
+	bytes.Buffer
+}
+
+// file writes a header and its payload, padded with `pad`
+func (a *archive) file(h header, payload []byte, pad byte) {
+	h.size = int64(len(payload))
+	a.Write(h.block())
+	a.Write(payload)
+	a.Write(bytes.Repeat([]byte{pad}, -len(payload)&(blockSize-1)))
+}
+
+// end writes the end of archive marker
+func (a *archive) end() {
+	a.Write(make([]byte, blockSize*2))
+}
+
+func invalidUTF8() Archive {
+	a := &archive{}
+	a.file(header{name: "\xff\xfe/", mode: 0755, typeflag: '5'}, nil, 0)
+	a.file(header{name: "\xff\xfe/caf\xe9.txt", mode: 0644, typeflag: '0'}, []byte("latin-1 name\n"), 0)
+	a.file(header{name: "\xff\xfe/link", mode: 0777, typeflag: '2', linkname: "caf\xe9.txt"}, nil, 0)
+	a.end()
+	return Archive{Name: "invalid-utf8", Description: "names and link targets that are not valid UTF-8", Data: a.Bytes()}
+}
+
+func gnuLongLink() Archive {
+	long := bytes.Repeat([]byte("0123456789/"), 20)
+	a := &archive{}
+	a.file(header{name: "././@LongLink", mode: 0644, typeflag: 'L', gnu: true}, append(long, "file\x00"...), 0)
+	a.file(header{name: string(long[:100]), mode: 0644, typeflag: '0', gnu: true}, []byte("long name\n"), 0)
+	a.file(header{name: "././@LongLink", mode: 0644, typeflag: 'L', gnu: true}, append(long, "link\x00"...), 0)
+	a.file(header{name: "././@LongLink", mode: 0644, typeflag: 'K', gnu: true}, append(long, "file\x00"...), 0)
+	a.file(header{name: string(long[:100]), mode: 0777, typeflag: '2', linkname: string(long[:100]), gnu: true}, nil, 0)
+	a.end()
+	return Archive{Name: "gnu-longlink", Description: "GNU long name and long link records", Data: a.Bytes()}
+}
+
+func gnuSparse() Archive {
+	// an old GNU sparse file of 10000 bytes, with data at 0 and 8192
+	h := header{name: "sparse.bin", mode: 0644, typeflag: 'S', gnu: true, size: 1024}
+	b := h.block()
+	formatOctal(b[386:398], 0)
+	formatOctal(b[398:410], 512)
+	formatOctal(b[410:422], 8192)
+	formatOctal(b[422:434], 512)
+	formatOctal(b[483:495], 10000)
+	setChecksum(b)
+
+	a := &archive{}
+	a.Write(b)
+	a.Write(bytes.Repeat([]byte("d"), 512))
+	a.Write(bytes.Repeat([]byte("e"), 512))
+	a.end()
+	return Archive{Name: "gnu-sparse", Description: "an old GNU sparse file with holes", Data: a.Bytes(), Sparse: true}
+}
+
+func base256() Archive {
+	a := &archive{}
+	a.file(header{name: "base256.txt", mode: 0644, typeflag: '0', gnu: true, base256: true}, []byte("size in base-256\n"), 0)
+	a.end()
+	return Archive{Name: "base256-size", Description: "a size field in the GNU base-256 encoding", Data: a.Bytes()}
+}
+
+func nonZeroPadding() Archive {
+	a := &archive{}
+	a.file(header{name: "padded.txt", mode: 0644, typeflag: '0'}, []byte("garbage follows\n"), 0xaa)
+	a.file(header{name: "padded2.txt", mode: 0644, typeflag: '0'}, []byte("and again\n"), 'x')
+	a.end()
+	return Archive{Name: "nonzero-padding", Description: "payload padding that is not zeroed", Data: a.Bytes()}
+}
+
+func extraTrailer() Archive {
+	a := &archive{}
+	a.file(header{name: "file.txt", mode: 0644, typeflag: '0'}, []byte("content\n"), 0)
+	a.end()
+	a.Write(make([]byte, blockSize*7))
+	a.WriteString("trailing junk after the end of the archive")
+	return Archive{Name: "extra-trailer", Description: "more than the end of archive marker, and trailing bytes", Data: a.Bytes()}
+}
+
+func truncated() Archive {
+	a := &archive{}
+	h := header{name: "cut.bin", mode: 0644, typeflag: '0', size: 1000}
+	a.Write(h.block())
+	a.Write(bytes.Repeat([]byte("c"), 600))
+	return Archive{Name: "truncated", Description: "a payload cut short, without the end of archive marker", Data: a.Bytes(), Truncated: true}
+}
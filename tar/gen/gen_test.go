@@ -0,0 +1,59 @@
+package gen
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func TestArchivesParse(t *testing.T) {
+	for _, a := range Archives() {
+		tr := tar.NewReader(bytes.NewReader(a.Data))
+		var err error
+		for err == nil {
+			if _, err = tr.Next(); err == nil {
+				_, err = io.Copy(ioutil.Discard, tr)
+			}
+		}
+		if a.Truncated {
+			if err != io.ErrUnexpectedEOF {
+				t.Errorf("%s: expected io.ErrUnexpectedEOF, got %v", a.Name, err)
+			}
+		} else if err != io.EOF {
+			t.Errorf("%s: %v", a.Name, err)
+		}
+	}
+}
+
+func TestArchivesRoundTrip(t *testing.T) {
+	for _, a := range Archives() {
+		meta := bytes.NewBuffer(nil)
+		fgp := storage.NewBufferFileGetPutter()
+		its, err := asm.NewInputTarStream(bytes.NewReader(a.Data), storage.NewJSONPacker(meta), fgp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(ioutil.Discard, its)
+		if a.Truncated {
+			if err == nil {
+				t.Errorf("%s: expected an error disassembling", a.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", a.Name, err)
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := asm.WriteOutputTarStream(fgp, storage.NewJSONUnpacker(meta), buf); err != nil {
+			t.Fatalf("%s: %v", a.Name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), a.Data) {
+			t.Errorf("%s: reassembled %d bytes, expected %d bytes", a.Name, buf.Len(), len(a.Data))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Package bufpool pools the buffers that tar-split copies payloads through, so
+// that assembling and disassembling layers of many small files does not
+// allocate a buffer for each of them.
+package bufpool
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSize is the size of the buffers, unless set otherwise with SetSize
+const DefaultSize = 32 * 1024
+
+var (
+	size int64 = DefaultSize
+	// the pool holds pointers, as putting a slice in it would allocate
+	pool sync.Pool
+)
+
+// SetSize sets the size of the buffers to get from now on. The buffers of the
+// former size are dropped as they are put back.
+func SetSize(n int) {
+	if n < 1 {
+		n = DefaultSize
+	}
+	atomic.StoreInt64(&size, int64(n))
+}
+
+// Size is the size of the buffers got
+func Size() int {
+	return int(atomic.LoadInt64(&size))
+}
+
+// Get returns a buffer, to Put back once it is no longer used
+func Get() *[]byte {
+	n := Size()
+	if b, ok := pool.Get().(*[]byte); ok && len(*b) == n {
+		return b
+	}
+	b := make([]byte, n)
+	return &b
+}
+
+// Put returns a buffer from Get to the pool
+func Put(b *[]byte) {
+	if len(*b) == Size() {
+		pool.Put(b)
+	}
+}
+
+// Copy is io.Copy, with a buffer of the pool
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	b := Get()
+	defer Put(b)
+	return io.CopyBuffer(dst, src, *b)
+}
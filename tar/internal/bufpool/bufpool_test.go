@@ -0,0 +1,40 @@
+package bufpool
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSetSize(t *testing.T) {
+	defer SetSize(DefaultSize)
+	b := Get()
+	if len(*b) != DefaultSize {
+		t.Errorf("expected a buffer of %d bytes, got %d", DefaultSize, len(*b))
+	}
+	Put(b)
+	SetSize(512)
+	if b := Get(); len(*b) != 512 {
+		t.Errorf("expected a buffer of 512 bytes once set, got %d", len(*b))
+	}
+	SetSize(0)
+	if Size() != DefaultSize {
+		t.Errorf("expected the default size back, got %d", Size())
+	}
+}
+
+func TestCopy(t *testing.T) {
+	defer SetSize(DefaultSize)
+	SetSize(7)
+	src := strings.Repeat("copied through a small buffer ", 10)
+	var dst bytes.Buffer
+	// hiding the WriterTo and ReaderFrom, for the buffer to be used
+	n, err := Copy(struct{ io.Writer }{&dst}, struct{ io.Reader }{strings.NewReader(src)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(src)) || dst.String() != src {
+		t.Errorf("expected %d bytes copied, got %d", len(src), n)
+	}
+}
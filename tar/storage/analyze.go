@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"io"
+	"sort"
+)
+
+// MetadataReport is the overhead of metadata, by the type of its entries and
+// by encoding, as found by AnalyzeMetadata, for tuning how it is packed
+type MetadataReport struct {
+	Segments EntryOverhead `json:"segments"`
+	Files    EntryOverhead `json:"files"`
+	// SegmentSizes are the percentiles of the sizes of the segments'
+	// payloads
+	SegmentSizes Percentiles `json:"segment_sizes"`
+	// Encodings are the sizes of the metadata packed each way, the first
+	// being as NewJSONPacker packs it
+	Encodings []EncodingSize `json:"encodings"`
+}
+
+// EntryOverhead is the metadata of the entries of a type
+type EntryOverhead struct {
+	Entries int `json:"entries"`
+	// Bytes of the entries, as NewJSONPacker packs them
+	Bytes int64 `json:"bytes"`
+	// PayloadBytes are those of the raw payloads of segments, or the sizes of
+	// the files, that the entries are for
+	PayloadBytes int64 `json:"payload_bytes"`
+}
+
+// Percentiles of a distribution of sizes
+type Percentiles struct {
+	P50 int `json:"p50"`
+	P90 int `json:"p90"`
+	P99 int `json:"p99"`
+	Max int `json:"max"`
+}
+
+// EncodingSize is the size of the metadata in an encoding
+type EncodingSize struct {
+	// Name is the packing, "json", "compact-json" or "proto", with the
+	// Encoding compressing it, if any, like "proto+gzip"
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	// Savings is the fraction of the size as NewJSONPacker packs it that is
+	// saved, which is negative if it is larger
+	Savings float64 `json:"savings"`
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// AnalyzeMetadata reads `up` to the end, and reports the overhead of the
+// metadata by the type of its entries, the distribution of the sizes of its
+// segments, and its size if it were packed as compact JSON, or protobuf, and
+// compressed with each of the Encodings registered.
+func AnalyzeMetadata(up Unpacker) (*MetadataReport, error) {
+	type packing struct {
+		name string
+		cw   *countingWriter
+		ew   io.WriteCloser
+		p    Packer
+	}
+	formats := []struct {
+		name      string
+		newPacker func(io.Writer) Packer
+	}{
+		{"json", NewJSONPacker},
+		{"compact-json", NewCompactJSONPacker},
+		{"proto", NewProtoPacker},
+	}
+	var packings []*packing
+	for _, f := range formats {
+		for _, encoding := range append([]string{""}, Encodings()...) {
+			if encoding == "identity" {
+				continue
+			}
+			pk := &packing{name: f.name, cw: &countingWriter{}}
+			var w io.Writer = pk.cw
+			if encoding != "" {
+				enc, err := lookupEncoding(encoding)
+				if err != nil {
+					return nil, err
+				}
+				if pk.ew, err = enc.NewWriter(pk.cw); err != nil {
+					return nil, err
+				}
+				pk.name += "+" + encoding
+				w = pk.ew
+			}
+			pk.p = f.newPacker(w)
+			packings = append(packings, pk)
+		}
+	}
+	// the first packing is plain json, of which the entries are measured
+	plain := packings[0]
+
+	report := &MetadataReport{}
+	var sizes []int
+	for {
+		e, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		before := plain.cw.n
+		for _, pk := range packings {
+			if _, err := pk.p.AddEntry(*e); err != nil {
+				return nil, err
+			}
+		}
+		overhead := &report.Files
+		if e.Type == SegmentType {
+			overhead = &report.Segments
+			overhead.PayloadBytes += int64(len(e.Payload))
+			sizes = append(sizes, len(e.Payload))
+		} else {
+			overhead.PayloadBytes += e.Size
+		}
+		overhead.Entries++
+		overhead.Bytes += plain.cw.n - before
+	}
+
+	sort.Ints(sizes)
+	if n := len(sizes); n > 0 {
+		report.SegmentSizes = Percentiles{
+			P50: sizes[(n-1)*50/100],
+			P90: sizes[(n-1)*90/100],
+			P99: sizes[(n-1)*99/100],
+			Max: sizes[n-1],
+		}
+	}
+	for _, pk := range packings {
+		if pk.ew != nil {
+			if err := pk.ew.Close(); err != nil {
+				return nil, err
+			}
+		}
+		es := EncodingSize{Name: pk.name, Bytes: pk.cw.n}
+		if plain.cw.n > 0 {
+			es.Savings = 1 - float64(pk.cw.n)/float64(plain.cw.n)
+		}
+		report.Encodings = append(report.Encodings, es)
+	}
+	return report, nil
+}
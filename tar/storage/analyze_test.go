@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeMetadata(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	p := NewJSONPacker(buf)
+	for i := 0; i < 100; i++ {
+		entries := []Entry{
+			{Type: SegmentType, Payload: bytes.Repeat([]byte{'h'}, 512)},
+			{Type: FileType, Name: fmt.Sprintf("file%d", i), Size: int64(i), Payload: []byte("crc64sum")},
+			{Type: SegmentType, Payload: make([]byte, 100+i%10)},
+		}
+		for _, e := range entries {
+			if _, err := p.AddEntry(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	report, err := AnalyzeMetadata(NewJSONUnpacker(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Segments.Entries != 200 || report.Files.Entries != 100 {
+		t.Errorf("expected 200 segments and 100 files, got %d and %d", report.Segments.Entries, report.Files.Entries)
+	}
+	if report.Segments.PayloadBytes != 100*512+10450 || report.Files.PayloadBytes != 4950 {
+		t.Errorf("unexpected payload bytes %d and %d", report.Segments.PayloadBytes, report.Files.PayloadBytes)
+	}
+	if total := report.Segments.Bytes + report.Files.Bytes; total != int64(buf.Len()) {
+		t.Errorf("expected the entries to be all %d bytes of the metadata, got %d", buf.Len(), total)
+	}
+	if s := report.SegmentSizes; s.P50 != 109 || s.P90 != 512 || s.Max != 512 {
+		t.Errorf("unexpected percentiles %+v", s)
+	}
+
+	sizes := map[string]EncodingSize{}
+	var names []string
+	for _, es := range report.Encodings {
+		sizes[es.Name] = es
+		names = append(names, es.Name)
+	}
+	if report.Encodings[0].Name != "json" || report.Encodings[0].Bytes != int64(buf.Len()) || report.Encodings[0].Savings != 0 {
+		t.Errorf("expected plain json first, got %+v", report.Encodings[0])
+	}
+	for _, name := range []string{"json+gzip", "compact-json", "compact-json+gzip", "proto", "proto+gzip"} {
+		es, ok := sizes[name]
+		if !ok {
+			t.Errorf("expected %s among %s", name, strings.Join(names, ", "))
+			continue
+		}
+		if es.Bytes >= int64(buf.Len()) || es.Savings <= 0 {
+			t.Errorf("expected %s to be smaller than plain json, got %+v", name, es)
+		}
+	}
+}
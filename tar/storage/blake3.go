@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"encoding/binary"
+	"hash"
+	"runtime"
+	"sync"
+)
+
+// The BLAKE3 hash (https://github.com/BLAKE3-team/BLAKE3-specs), registered
+// as the "blake3" checksum. Its tree of 1 KiB chunks is hashed in parallel,
+// on all of the CPUs, for each MiB of input, so for multi-GB payloads, where
+// hashing dominates disassembly and assembly, it scales with the cores where
+// "sha256" does not.
+
+const (
+	blake3BlockLen = 64
+	blake3ChunkLen = 1024
+	blake3Size     = 32
+
+	// blake3BatchChunks is the chunks of each subtree hashed in parallel, in
+	// blake3Pieces pieces
+	blake3BatchChunks = 1024
+	blake3BatchLen    = blake3BatchChunks * blake3ChunkLen
+	blake3Pieces      = 16
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+// blake3Schedule is the message words of each round, as permuted
+var blake3Schedule = [7][16]int{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8},
+	{3, 4, 10, 12, 13, 2, 7, 14, 6, 5, 9, 0, 11, 15, 8, 1},
+	{10, 7, 12, 9, 14, 3, 13, 15, 4, 0, 11, 2, 5, 8, 1, 6},
+	{12, 13, 9, 11, 15, 10, 14, 8, 7, 2, 5, 3, 0, 1, 6, 4},
+	{9, 14, 11, 5, 8, 12, 15, 1, 13, 3, 0, 10, 2, 6, 4, 7},
+	{11, 15, 5, 0, 1, 9, 8, 6, 14, 10, 2, 12, 3, 4, 7, 13},
+}
+
+func blake3Compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	v0, v1, v2, v3, v4, v5, v6, v7 := cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7]
+	v8, v9, v10, v11 := blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3]
+	v12, v13, v14, v15 := uint32(counter), uint32(counter>>32), blockLen, flags
+	m := block
+	for i := range blake3Schedule {
+		s := &blake3Schedule[i]
+		v0, v4, v8, v12 = blake3G(v0, v4, v8, v12, m[s[0]], m[s[1]])
+		v1, v5, v9, v13 = blake3G(v1, v5, v9, v13, m[s[2]], m[s[3]])
+		v2, v6, v10, v14 = blake3G(v2, v6, v10, v14, m[s[4]], m[s[5]])
+		v3, v7, v11, v15 = blake3G(v3, v7, v11, v15, m[s[6]], m[s[7]])
+		v0, v5, v10, v15 = blake3G(v0, v5, v10, v15, m[s[8]], m[s[9]])
+		v1, v6, v11, v12 = blake3G(v1, v6, v11, v12, m[s[10]], m[s[11]])
+		v2, v7, v8, v13 = blake3G(v2, v7, v8, v13, m[s[12]], m[s[13]])
+		v3, v4, v9, v14 = blake3G(v3, v4, v9, v14, m[s[14]], m[s[15]])
+	}
+	return [16]uint32{
+		v0 ^ v8, v1 ^ v9, v2 ^ v10, v3 ^ v11, v4 ^ v12, v5 ^ v13, v6 ^ v14, v7 ^ v15,
+		v8 ^ cv[0], v9 ^ cv[1], v10 ^ cv[2], v11 ^ cv[3], v12 ^ cv[4], v13 ^ cv[5], v14 ^ cv[6], v15 ^ cv[7],
+	}
+}
+
+func blake3G(a, b, c, d, mx, my uint32) (uint32, uint32, uint32, uint32) {
+	a += b + mx
+	d ^= a
+	d = d>>16 | d<<16
+	c += d
+	b ^= c
+	b = b>>12 | b<<20
+	a += b + my
+	d ^= a
+	d = d>>8 | d<<24
+	c += d
+	b ^= c
+	b = b>>7 | b<<25
+	return a, b, c, d
+}
+
+func blake3Words(b []byte) (words [16]uint32) {
+	var block [blake3BlockLen]byte
+	copy(block[:], b)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(block[i*4:])
+	}
+	return words
+}
+
+// blake3Output is a node of the tree, that is compressed for its chaining
+// value, or for the hash if it is the root
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() (cv [8]uint32) {
+	s := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	copy(cv[:], s[:8])
+	return cv
+}
+
+func (o *blake3Output) root(b []byte) []byte {
+	s := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	var out [blake3Size]byte
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(out[i*4:], s[i])
+	}
+	return append(b, out[:]...)
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	o := blake3Output{cv: blake3IV, blockLen: blake3BlockLen, flags: blake3Parent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+// blake3ChunkState is the chunk being hashed
+type blake3ChunkState struct {
+	cv               [8]uint32
+	counter          uint64
+	block            [blake3BlockLen]byte
+	blockLen         int
+	blocksCompressed int
+}
+
+func newBlake3ChunkState(counter uint64) blake3ChunkState {
+	return blake3ChunkState{cv: blake3IV, counter: counter}
+}
+
+func (cs *blake3ChunkState) len() int {
+	return cs.blocksCompressed*blake3BlockLen + cs.blockLen
+}
+
+func (cs *blake3ChunkState) startFlag() uint32 {
+	if cs.blocksCompressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (cs *blake3ChunkState) update(b []byte) {
+	for len(b) > 0 {
+		// the last block is compressed by output, with the end flag
+		if cs.blockLen == blake3BlockLen {
+			words := blake3Words(cs.block[:])
+			s := blake3Compress(&cs.cv, &words, cs.counter, blake3BlockLen, cs.startFlag())
+			copy(cs.cv[:], s[:8])
+			cs.blocksCompressed++
+			cs.blockLen = 0
+		}
+		n := copy(cs.block[cs.blockLen:], b)
+		cs.blockLen += n
+		b = b[n:]
+	}
+}
+
+func (cs *blake3ChunkState) output() blake3Output {
+	return blake3Output{
+		cv:       cs.cv,
+		block:    blake3Words(cs.block[:cs.blockLen]),
+		counter:  cs.counter,
+		blockLen: uint32(cs.blockLen),
+		flags:    cs.startFlag() | blake3ChunkEnd,
+	}
+}
+
+// blake3SubtreeCV is the chaining value of the subtree of `b`, a power of two
+// chunks starting at chunk `counter`
+func blake3SubtreeCV(b []byte, counter uint64) [8]uint32 {
+	if len(b) <= blake3ChunkLen {
+		cs := newBlake3ChunkState(counter)
+		cs.update(b)
+		o := cs.output()
+		return o.chainingValue()
+	}
+	half := len(b) / 2
+	o := blake3ParentOutput(blake3SubtreeCV(b[:half], counter), blake3SubtreeCV(b[half:], counter+uint64(half/blake3ChunkLen)))
+	return o.chainingValue()
+}
+
+// blake3Hasher is the hash.Hash of BLAKE3
+type blake3Hasher struct {
+	// stack is the chaining values of the completed subtrees, each of a power
+	// of two chunks, from the largest
+	stack []([8]uint32)
+	// chunks is how many have been hashed into the stack
+	chunks uint64
+	// buf is the input not yet hashed. The last of the input is only hashed
+	// by Sum, as it is the root.
+	buf []byte
+}
+
+// newBlake3 returns a BLAKE3 hash
+func newBlake3() hash.Hash {
+	return &blake3Hasher{}
+}
+
+func (h *blake3Hasher) Size() int      { return blake3Size }
+func (h *blake3Hasher) BlockSize() int { return blake3BlockLen }
+
+func (h *blake3Hasher) Reset() {
+	h.stack, h.chunks, h.buf = h.stack[:0], 0, h.buf[:0]
+}
+
+func (h *blake3Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(h.buf) == 0 && len(p) > blake3BatchLen {
+			// whole batches are hashed from `p` itself
+			h.addBatch(p[:blake3BatchLen])
+			p = p[blake3BatchLen:]
+			continue
+		}
+		take := blake3BatchLen + 1 - len(h.buf)
+		if take > len(p) {
+			take = len(p)
+		}
+		h.buf = append(h.buf, p[:take]...)
+		p = p[take:]
+		if len(h.buf) > blake3BatchLen {
+			h.addBatch(h.buf[:blake3BatchLen])
+			h.buf = append(h.buf[:0], h.buf[blake3BatchLen:]...)
+		}
+	}
+	return n, nil
+}
+
+// addBatch hashes a batch of input that is not the last, with its pieces
+// hashed in parallel
+func (h *blake3Hasher) addBatch(b []byte) {
+	var cvs [blake3Pieces][8]uint32
+	pieceLen := blake3BatchLen / blake3Pieces
+	workers := runtime.GOMAXPROCS(0)
+	if workers > blake3Pieces {
+		workers = blake3Pieces
+	}
+	var wg sync.WaitGroup
+	pieces := make(chan int, blake3Pieces)
+	for i := range cvs {
+		pieces <- i
+	}
+	close(pieces)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pieces {
+				cvs[i] = blake3SubtreeCV(b[i*pieceLen:(i+1)*pieceLen], h.chunks+uint64(i*pieceLen/blake3ChunkLen))
+			}
+		}()
+	}
+	wg.Wait()
+	level := cvs[:]
+	for len(level) > 1 {
+		for i := 0; i < len(level)/2; i++ {
+			o := blake3ParentOutput(level[2*i], level[2*i+1])
+			level[i] = o.chainingValue()
+		}
+		level = level[:len(level)/2]
+	}
+	h.chunks += blake3BatchChunks
+	h.stack = pushBlake3CV(h.stack, level[0], h.chunks/blake3BatchChunks)
+}
+
+// pushBlake3CV pushes the chaining value of a subtree, merging it with the
+// completed subtrees of the same size, as `total` (in units of its size) is
+// the count after it
+func pushBlake3CV(stack [][8]uint32, cv [8]uint32, total uint64) [][8]uint32 {
+	for total&1 == 0 {
+		o := blake3ParentOutput(stack[len(stack)-1], cv)
+		cv = o.chainingValue()
+		stack = stack[:len(stack)-1]
+		total >>= 1
+	}
+	return append(stack, cv)
+}
+
+// Sum appends the hash of the input so far, which is not changed
+func (h *blake3Hasher) Sum(b []byte) []byte {
+	stack := append([][8]uint32(nil), h.stack...)
+	chunks := h.chunks
+	cs := newBlake3ChunkState(chunks)
+	for p := h.buf; len(p) > 0; {
+		if cs.len() == blake3ChunkLen {
+			o := cs.output()
+			chunks++
+			stack = pushBlake3CV(stack, o.chainingValue(), chunks)
+			cs = newBlake3ChunkState(chunks)
+		}
+		take := blake3ChunkLen - cs.len()
+		if take > len(p) {
+			take = len(p)
+		}
+		cs.update(p[:take])
+		p = p[take:]
+	}
+	o := cs.output()
+	for i := len(stack) - 1; i >= 0; i-- {
+		o = blake3ParentOutput(stack[i], o.chainingValue())
+	}
+	return o.root(b)
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// the input of the BLAKE3 test vectors, of bytes counting up modulo 251
+func blake3Input(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestBlake3(t *testing.T) {
+	vectors := []struct {
+		len int
+		sum string
+	}{
+		{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{3, "e1be4d7a8ab5560aa4199eea339849ba8e293d55ca0a81006726d184519e647f"},
+		{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+		{2049, "5f4d72f40d7a5f82b15ca2b2e44b1de3c2ef86c426c95c1af0b6879522563030"},
+		{3072, "b98cb0ff3623be03326b373de6b9095218513e64f1ee2edd2525c7ad1e5cffd2"},
+		{5000, "ee78d92070de3df1c57c37002abf0a6b1a6589acdeef4d8ffac7cf3d9e8f2836"},
+		{65536, "68d647e619a930e7b1082f74f334b0c65a315725569bdc123f0ee11881717bfe"},
+		// at and over the batches hashed in parallel
+		{blake3BatchLen, "74cb441fd087764ca9c3694da742ebe30cbeb3060a17009ca81825c7a8d10343"},
+		{blake3BatchLen + 1, "2f053cd7472cf0cd2f9adaf45c1180255b91b9a865404a63671a0ee5f792ed33"},
+		{2*blake3BatchLen + 1024, "9fa22e1f0f1cdf5b8523d388b65eaa0d18b071c539b91af24ed36518539e58aa"},
+	}
+	h := newBlake3()
+	for _, v := range vectors {
+		input := blake3Input(v.len)
+		// in one write, and in writes that do not line up with the chunks
+		for _, size := range []int{len(input) + 1, 1000} {
+			h.Reset()
+			for p := input; len(p) > 0; {
+				n := size
+				if n > len(p) {
+					n = len(p)
+				}
+				h.Write(p[:n])
+				p = p[n:]
+			}
+			if got := hex.EncodeToString(h.Sum(nil)); got != v.sum {
+				t.Errorf("%d bytes, in writes of %d: expected %s, got %s", v.len, size, v.sum, got)
+			}
+			// Sum does not change the state
+			if got := hex.EncodeToString(h.Sum(nil)); got != v.sum {
+				t.Errorf("%d bytes: expected the same sum again, got %s", v.len, got)
+			}
+		}
+	}
+
+	if _, err := NewChecksumHash("blake3"); err != nil {
+		t.Errorf("expected blake3 registered, got %v", err)
+	}
+}
+
+func BenchmarkBlake3(b *testing.B) {
+	input := blake3Input(16 << 20)
+	h := newBlake3()
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		h.Reset()
+		h.Write(input)
+		h.Sum(nil)
+	}
+}
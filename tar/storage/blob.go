@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// ErrNoSuchFile is returned by a FileGetter when it has no payload for the
+// requested name.
+var ErrNoSuchFile = errors.New("no such file")
+
+// NewTarFileGetter returns a FileGetter that serves file payloads directly out
+// of the original (uncompressed) tar archive `ra`, rather than from a separate
+// payload store.
+//
+// The Unpacker `up` is read to the end to find the offset of each file payload
+// within the archive, as the sum of the sizes of all the entries prior to it.
+// This means the metadata must be read again (with a new Unpacker) for the
+// assembly itself.
+func NewTarFileGetter(ra io.ReaderAt, up Unpacker) (FileGetter, error) {
+	tfg := &tarFileGetter{
+		ra:      ra,
+		entries: map[string]blobExtent{},
+	}
+	var offset int64
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		switch entry.Type {
+		case SegmentType:
+			offset += int64(len(entry.Payload))
+		case FileType:
+			be := blobExtent{offset: offset, size: entry.DataSize()}
+			if !entry.SparsePacked {
+				be.sparse, be.fileSize = entry.Sparse, entry.Size
+			}
+			tfg.entries[filepath.Clean(entry.GetName())] = be
+			offset += be.size
+		}
+	}
+	return tfg, nil
+}
+
+type blobExtent struct {
+	offset, size int64
+	// sparse is set for a sparse file, whose data in the archive is served
+	// with its holes filled in, as the payload of an entry that is not
+	// SparsePacked
+	sparse   []SparseExtent
+	fileSize int64
+}
+
+type tarFileGetter struct {
+	ra      io.ReaderAt
+	entries map[string]blobExtent
+}
+
+func (tfg *tarFileGetter) Get(filename string) (io.ReadCloser, error) {
+	ext, ok := tfg.entries[filepath.Clean(filename)]
+	if !ok {
+		return nil, ErrNoSuchFile
+	}
+	var r io.Reader = io.NewSectionReader(tfg.ra, ext.offset, ext.size)
+	if len(ext.sparse) > 0 {
+		r = NewSparseFileReader(r, ext.sparse, ext.fileSize)
+	}
+	return ioutil.NopCloser(r), nil
+}
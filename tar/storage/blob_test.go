@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestTarFileGetter(t *testing.T) {
+	// a fake "archive", where the segments and payloads are just laid end to end
+	e := []Entry{
+		{Type: SegmentType, Payload: []byte("hdr1")},
+		{Type: FileType, Name: "./hurr.txt", Size: 5},
+		{Type: SegmentType, Payload: []byte("pad1hdr2")},
+		{Type: FileType, Name: "./derp.txt", Size: 3},
+		{Type: SegmentType, Payload: []byte("pad2")},
+		{Type: FileType, Name: "./empty", Size: 0},
+		{Type: SegmentType, Payload: []byte("eof")},
+	}
+	blob := []byte("hdr1" + "hello" + "pad1hdr2" + "foo" + "pad2" + "eof")
+
+	buf := bytes.NewBuffer(nil)
+	jp := NewJSONPacker(buf)
+	for i := range e {
+		if _, err := jp.AddEntry(e[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fg, err := NewTarFileGetter(bytes.NewReader(blob), NewJSONUnpacker(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"./hurr.txt": "hello",
+		"derp.txt":   "foo",
+		"./empty":    "",
+	}
+	for name, body := range expected {
+		rdr, err := fg.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rdr.Close()
+		if string(got) != body {
+			t.Errorf("%s: expected %q, got %q", name, body, got)
+		}
+	}
+
+	if _, err := fg.Get("./nope"); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// PayloadCache is the minimal key/value interface needed to cache file
+// payloads in front of a slower FileGetter.
+//
+// It is intentionally small so that a thin adapter over a Redis or memcached
+// client will satisfy it.
+type PayloadCache interface {
+	// Get returns the cached value for key, and whether it was found.
+	Get(key string) (value []byte, ok bool, err error)
+	// Set stores value for key, expiring it after ttl. A ttl of 0 means the
+	// entry does not expire.
+	Set(key string, value []byte, ttl time.Duration) error
+}
+
+// NewCachingFileGetter returns a FileGetter that serves payloads from the
+// PayloadCache `c` when present, and otherwise reads them from `fg`.
+//
+// Payloads of at most `threshold` bytes read from `fg` are stored to the cache
+// for `ttl`. Larger payloads are streamed straight through and never cached.
+// Since file names are only unique within one archive, `prefix` is prepended
+// to each name to form the cache key (e.g. the layer's digest).
+//
+// The cache is best effort; errors from it are not returned to the caller,
+// and the payload is read from `fg` instead.
+func NewCachingFileGetter(fg FileGetter, c PayloadCache, prefix string, threshold int64, ttl time.Duration) FileGetter {
+	return &cachingFileGetter{
+		fg:        fg,
+		cache:     c,
+		prefix:    prefix,
+		threshold: threshold,
+		ttl:       ttl,
+	}
+}
+
+type cachingFileGetter struct {
+	fg        FileGetter
+	cache     PayloadCache
+	prefix    string
+	threshold int64
+	ttl       time.Duration
+}
+
+func (cfg *cachingFileGetter) Get(filename string) (io.ReadCloser, error) {
+	key := cfg.prefix + filename
+	if buf, ok, err := cfg.cache.Get(key); err == nil && ok {
+		return ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+
+	fh, err := cfg.fg.Get(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// read one more than the threshold, to know whether it is small enough
+	buf, err := ioutil.ReadAll(io.LimitReader(fh, cfg.threshold+1))
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	if int64(len(buf)) > cfg.threshold {
+		return &multiReadCloser{
+			Reader: io.MultiReader(bytes.NewReader(buf), fh),
+			c:      fh,
+		}, nil
+	}
+	fh.Close()
+
+	cfg.cache.Set(key, buf, cfg.ttl)
+	return ioutil.NopCloser(bytes.NewReader(buf)), nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	c io.Closer
+}
+
+func (mrc *multiReadCloser) Close() error { return mrc.c.Close() }
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mapPayloadCache struct {
+	values map[string][]byte
+	ttls   map[string]time.Duration
+}
+
+func (mpc *mapPayloadCache) Get(key string) ([]byte, bool, error) {
+	v, ok := mpc.values[key]
+	return v, ok, nil
+}
+
+func (mpc *mapPayloadCache) Set(key string, value []byte, ttl time.Duration) error {
+	mpc.values[key] = value
+	mpc.ttls[key] = ttl
+	return nil
+}
+
+type countingFileGetter struct {
+	FileGetter
+	gets int
+}
+
+func (cfg *countingFileGetter) Get(name string) (io.ReadCloser, error) {
+	cfg.gets++
+	return cfg.FileGetter.Get(name)
+}
+
+func TestCachingFileGetter(t *testing.T) {
+	fgp := NewBufferFileGetPutter()
+	files := map[string]string{
+		"small.txt": "foo",
+		"big.txt":   strings.Repeat("bar", 100),
+	}
+	for n, body := range files {
+		if _, _, err := fgp.Put(n, bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backing := &countingFileGetter{FileGetter: fgp}
+	cache := &mapPayloadCache{values: map[string][]byte{}, ttls: map[string]time.Duration{}}
+	fg := NewCachingFileGetter(backing, cache, "layer1:", 64, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		for n, body := range files {
+			rdr, err := fg.Get(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := ioutil.ReadAll(rdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rdr.Close()
+			if string(buf) != body {
+				t.Errorf("%s: expected %q, got %q", n, body, buf)
+			}
+		}
+	}
+
+	// small.txt once, big.txt both times
+	if backing.gets != 3 {
+		t.Errorf("expected 3 gets from the backing store, got %d", backing.gets)
+	}
+	if _, ok := cache.values["layer1:small.txt"]; !ok {
+		t.Errorf("expected small.txt to be cached")
+	}
+	if _, ok := cache.values["layer1:big.txt"]; ok {
+		t.Errorf("expected big.txt to not be cached")
+	}
+	if cache.ttls["layer1:small.txt"] != time.Minute {
+		t.Errorf("expected ttl of %s, got %s", time.Minute, cache.ttls["layer1:small.txt"])
+	}
+}
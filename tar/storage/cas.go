@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
+)
+
+// NewCASFilePutter returns a FilePutter that stores payloads by the sha256
+// digest of their content, as "sha256/<hex>" under `root`, so that each
+// distinct payload is stored once, however many entries and archives have it.
+// As the files are named by their content and renamed into place, several
+// processes can share `root` without locking.
+//
+// The names of the entries are not kept, so the digest of each has to be,
+// like with a ManifestPacker, for NewCASFileGetter to find the payloads.
+func NewCASFilePutter(root string) FilePutter {
+	return casFilePutter{root: root}
+}
+
+type casFilePutter struct {
+	root string
+}
+
+func (cfp casFilePutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	size, csum, _, err := cfp.store(r)
+	return size, csum, err
+}
+
+// store stores the payload `r`, returning its size, crc64 checksum and the
+// hex of its sha256 digest
+func (cfp casFilePutter) store(r io.Reader) (int64, []byte, string, error) {
+	dir := filepath.Join(cfp.root, "sha256")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, nil, "", err
+	}
+	fh, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return 0, nil, "", err
+	}
+	defer func() {
+		// after the rename, there is nothing left to remove
+		fh.Close()
+		os.Remove(fh.Name())
+	}()
+	pd := NewPayloadDigester()
+	h := sha256.New()
+	if _, err := bufpool.Copy(io.MultiWriter(pd, h, fh), r); err != nil {
+		return 0, nil, "", err
+	}
+	if err := fh.Chmod(0444); err != nil {
+		return 0, nil, "", err
+	}
+	if err := fh.Close(); err != nil {
+		return 0, nil, "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	p := filepath.Join(dir, sum)
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		if err := os.Rename(fh.Name(), p); err != nil {
+			return 0, nil, "", err
+		}
+	}
+	return pd.Size(), pd.Checksum(), sum, nil
+}
+
+// NewCASFileGetter returns a FileGetter of the payloads stored under `root`
+// by a CASFilePutter, finding them by the digests of the manifest of the
+// archive. It is also a DigestFileGetter, of the sha256 digests, so with no
+// manifest, it gets the payloads of the entries with sha256 checksums only.
+func NewCASFileGetter(root string, manifest []ManifestEntry) FileGetter {
+	cfg := casFileGetter{root: root, digests: map[string]string{}}
+	for _, me := range manifest {
+		if me.Digest != "" {
+			cfg.digests[me.GetName()] = me.Digest
+		}
+	}
+	return cfg
+}
+
+type casFileGetter struct {
+	root    string
+	digests map[string]string
+}
+
+func (cfg casFileGetter) Get(filename string) (io.ReadCloser, error) {
+	digest, ok := cfg.digests[filename]
+	if !ok {
+		return nil, ErrNoSuchFile
+	}
+	i := strings.Index(digest, ":")
+	if i < 0 || digest[:i] != "sha256" {
+		return nil, fmt.Errorf("storage: unsupported digest %q of %q", digest, filename)
+	}
+	return os.Open(filepath.Join(cfg.root, "sha256", filepath.Base(digest[i+1:])))
+}
+
+func (cfg casFileGetter) GetByDigest(algorithm, sum string) (io.ReadCloser, error) {
+	return casOpen(cfg.root, algorithm, sum)
+}
+
+// casOpen opens the payload of the digest stored under `root`
+func casOpen(root, algorithm, sum string) (io.ReadCloser, error) {
+	if algorithm != "sha256" || len(sum) != sha256.Size*2 {
+		return nil, ErrNoSuchFile
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return nil, ErrNoSuchFile
+	}
+	fh, err := os.Open(filepath.Join(root, "sha256", strings.ToLower(sum)))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchFile
+	}
+	return fh, err
+}
+
+// NewCASFileGetPutter returns a FileGetPutter that stores payloads on disk
+// under `dir` like a CASFilePutter, so identical payloads are stored once,
+// and keeps which payload each name is in memory, for getting them back. It
+// is for archives too big for a BufferFileGetPutter. Puts may be concurrent.
+// It is also a DigestFileGetter, of the sha256 digests.
+func NewCASFileGetPutter(dir string) FileGetPutter {
+	return &casFileGetPutter{
+		casFilePutter: casFilePutter{root: dir},
+		sums:          map[string]string{},
+	}
+}
+
+type casFileGetPutter struct {
+	casFilePutter
+	mu   sync.RWMutex
+	sums map[string]string
+}
+
+func (cfgp *casFileGetPutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	size, csum, sum, err := cfgp.store(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	cfgp.mu.Lock()
+	cfgp.sums[name] = sum
+	cfgp.mu.Unlock()
+	return size, csum, nil
+}
+
+func (cfgp *casFileGetPutter) Get(name string) (io.ReadCloser, error) {
+	cfgp.mu.RLock()
+	sum, ok := cfgp.sums[name]
+	cfgp.mu.RUnlock()
+	if !ok {
+		return nil, ErrNoSuchFile
+	}
+	return os.Open(filepath.Join(cfgp.root, "sha256", sum))
+}
+
+func (cfgp *casFileGetPutter) GetByDigest(algorithm, sum string) (io.ReadCloser, error) {
+	return casOpen(cfgp.root, algorithm, sum)
+}
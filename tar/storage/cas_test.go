@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCASFileGetPutter(t *testing.T) {
+	root, err := ioutil.TempDir("", "cas-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	var meta, manifest bytes.Buffer
+	mp := NewManifestPacker(NewJSONPacker(&meta), NewCASFilePutter(root), &manifest)
+	files := []struct{ name, content string }{
+		{"a", "same content"},
+		{"b", "same content"},
+		{"c", "other content"},
+	}
+	for _, f := range files {
+		size, sum, err := mp.Put(f.name, strings.NewReader(f.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mp.AddEntry(Entry{Type: FileType, Name: f.name, Size: size, Payload: sum}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored, err := ioutil.ReadDir(filepath.Join(root, "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Errorf("expected the 2 distinct payloads stored, got %d files", len(stored))
+	}
+
+	entries, err := ReadManifest(&manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fg := NewCASFileGetter(root, entries)
+	for _, f := range files {
+		if got := readPayload(t, fg, f.name); got != f.content {
+			t.Errorf("expected %q for %s, got %q", f.content, f.name, got)
+		}
+	}
+	if _, err := fg.Get("d"); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+}
+
+func TestCASFileGetPutterConcurrentPuts(t *testing.T) {
+	root, err := ioutil.TempDir("", "cas-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	fgp := NewCASFileGetPutter(root)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := "even content"
+			if i%2 == 1 {
+				content = "odd content"
+			}
+			if _, _, err := fgp.Put(fmt.Sprintf("file%d", i), strings.NewReader(content)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stored, err := ioutil.ReadDir(filepath.Join(root, "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Errorf("expected the 2 distinct payloads stored, got %d files", len(stored))
+	}
+	for i := 0; i < 16; i++ {
+		expected := "even content"
+		if i%2 == 1 {
+			expected = "odd content"
+		}
+		if got := readPayload(t, fgp, fmt.Sprintf("file%d", i)); got != expected {
+			t.Errorf("expected %q for file%d, got %q", expected, i, got)
+		}
+	}
+	if _, err := fgp.Get("missing"); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+}
+
+func TestCASGetByDigest(t *testing.T) {
+	root, err := ioutil.TempDir("", "cas-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	fgp := NewCASFileGetPutter(root)
+	if _, _, err := fgp.Put("a", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("content"))
+	digest := hex.EncodeToString(sum[:])
+	for _, fg := range []FileGetter{fgp, NewCASFileGetter(root, nil)} {
+		dfg, ok := fg.(DigestFileGetter)
+		if !ok {
+			t.Fatalf("expected %T to be a DigestFileGetter", fg)
+		}
+		rc, err := dfg.GetByDigest("sha256", digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != "content" {
+			t.Errorf("expected the payload of the digest, got %q, %v", got, err)
+		}
+		for _, bad := range [][2]string{
+			{"sha256", strings.Repeat("0", 64)},
+			{"sha512", digest},
+			{"sha256", "../" + digest[3:]},
+			{"sha256", "abc"},
+		} {
+			if _, err := dfg.GetByDigest(bad[0], bad[1]); err != ErrNoSuchFile {
+				t.Errorf("%s:%s: expected ErrNoSuchFile, got %v", bad[0], bad[1], err)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import "github.com/vbatts/tar-split/tar/common"
+
+// CharsetAnnotation is the annotation of the charset that the Name of an entry
+// was decoded from, by a NameDecodingPacker, like "shift_jis"
+const CharsetAnnotation = "name.charset"
+
+// NewNameDecodingPacker returns a Packer that packs the entries to `p` with
+// the names that are not valid UTF-8 in both NameRaw, as they are, and Name,
+// decoded from their most likely charset by common.DecodeName, for tooling to
+// display meaningful names of legacy archives. The charset is the
+// CharsetAnnotation of the entry, as it is only a guess. The link targets
+// that are not valid UTF-8 are decoded to Linkname too.
+//
+// As GetName is the NameRaw if there is one, the entries are stored and
+// assembled by their raw names still.
+func NewNameDecodingPacker(p Packer) Packer {
+	return &nameDecodingPacker{p: p}
+}
+
+type nameDecodingPacker struct {
+	p Packer
+}
+
+func (ndp *nameDecodingPacker) AddEntry(e Entry) (int, error) {
+	if e.Type == FileType {
+		raw := e.GetNameBytes()
+		if common.DetectCharset(raw) != common.UTF8 {
+			e.NameRaw = raw
+			name, charset := common.DecodeName(raw)
+			e.Name = name
+			annotations := map[string]string{}
+			for k, v := range e.Annotations {
+				annotations[k] = v
+			}
+			annotations[CharsetAnnotation] = charset
+			e.Annotations = annotations
+		}
+		if raw := []byte(e.GetLinkname()); len(raw) > 0 && common.DetectCharset(raw) != common.UTF8 {
+			e.LinknameRaw = raw
+			e.Linkname, _ = common.DecodeName(raw)
+		}
+	}
+	return ndp.p.AddEntry(e)
+}
+
+// DisplayName returns the name of the entry to display: its Name if it has
+// one, even if it was decoded from its NameRaw by a NameDecodingPacker, and
+// otherwise its GetName
+func (e *Entry) DisplayName() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.GetName()
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestNameDecodingPacker(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	p := NewNameDecodingPacker(NewJSONPacker(buf))
+	var entries []Entry
+	for _, name := range []string{"plain", "caf\xe9", "f\xfcr"} {
+		e := Entry{Type: FileType, Size: 1, Payload: []byte{1}}
+		e.SetName(name)
+		entries = append(entries, e)
+	}
+	link := Entry{Type: FileType}
+	link.SetName("link")
+	link.SetLink(SymLink, "caf\xe9")
+	entries = append(entries, link)
+	for _, e := range entries {
+		if _, err := p.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up := NewJSONUnpacker(buf)
+	for _, expected := range []struct {
+		raw, display, charset string
+	}{
+		{"plain", "plain", ""},
+		{"caf\xe9", "café", "iso-8859-1"},
+		{"f\xfcr", "für", "iso-8859-1"},
+		{"link", "link", ""},
+	} {
+		e, err := up.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.GetName() != expected.raw {
+			t.Errorf("expected the entry still named %q, got %q", expected.raw, e.GetName())
+		}
+		if e.DisplayName() != expected.display {
+			t.Errorf("%q: expected to display %q, got %q", expected.raw, expected.display, e.DisplayName())
+		}
+		if e.Annotations[CharsetAnnotation] != expected.charset {
+			t.Errorf("%q: expected the charset %q, got %q", expected.raw, expected.charset, e.Annotations[CharsetAnnotation])
+		}
+		if expected.raw == "link" && (e.GetLinkname() != "caf\xe9" || e.Linkname != "café") {
+			t.Errorf("expected the raw and decoded targets, got %q and %q", e.GetLinkname(), e.Linkname)
+		}
+	}
+	if _, err := up.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownChecksum is returned for a checksum algorithm that is not
+// registered
+var ErrUnknownChecksum = errors.New("storage: unknown checksum algorithm")
+
+var (
+	checksumsMu sync.RWMutex
+	checksums   = map[string]func() hash.Hash{
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+		"blake3": newBlake3,
+	}
+)
+
+// RegisterChecksum makes a checksum algorithm available by `name`, for the
+// Checksum of entries, like one from a package outside of the standard
+// library. "sha256", "sha512" and "blake3" are registered already. If RegisterChecksum
+// is called twice with the same name, or newHash is nil, it panics.
+func RegisterChecksum(name string, newHash func() hash.Hash) {
+	checksumsMu.Lock()
+	defer checksumsMu.Unlock()
+	if newHash == nil {
+		panic("storage: RegisterChecksum hash is nil")
+	}
+	if _, dup := checksums[name]; dup {
+		panic("storage: RegisterChecksum called twice for " + name)
+	}
+	checksums[name] = newHash
+}
+
+// Checksums returns the sorted names of the registered checksum algorithms
+func Checksums() []string {
+	checksumsMu.RLock()
+	defer checksumsMu.RUnlock()
+	var names []string
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewChecksumHash returns a hash of the checksum algorithm `name`, or
+// ErrUnknownChecksum
+func NewChecksumHash(name string) (hash.Hash, error) {
+	checksumsMu.RLock()
+	newHash, ok := checksums[name]
+	checksumsMu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownChecksum
+	}
+	return newHash(), nil
+}
+
+// NewChecksumVerifier returns a hash for the Checksum of an entry, like
+// "sha256:<hex>", and the function that checks it once the payload is written
+// to the hash.
+func NewChecksumVerifier(checksum string) (hash.Hash, func() bool, error) {
+	i := strings.Index(checksum, ":")
+	if i < 0 {
+		return nil, nil, fmt.Errorf("storage: invalid checksum %q", checksum)
+	}
+	h, err := NewChecksumHash(checksum[:i])
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, func() bool {
+		return hex.EncodeToString(h.Sum(nil)) == checksum[i+1:]
+	}, nil
+}
+
+// ChecksumPacker is both a FilePutter and a Packer, that sets the Checksum of
+// each FileType entry with a payload, in the algorithm given, as the payloads
+// are disassembled. The algorithm is recorded in each Checksum, so assembly
+// verifies it with no other configuration, as long as it is registered.
+//
+// Give it as both the Packer and the FilePutter to asm.NewInputTarStream.
+type ChecksumPacker struct {
+	p         Packer
+	fp        FilePutter
+	algorithm string
+	newHash   func() hash.Hash
+	mu        sync.Mutex
+	sums      map[string]string
+
+	// largeAlgorithm is for the payloads of more than largeThreshold bytes,
+	// if set
+	largeAlgorithm string
+	newLargeHash   func() hash.Hash
+	largeThreshold int64
+
+	// extra are the algorithms of the further Checksums, if any
+	extra     []string
+	newExtra  []func() hash.Hash
+	extraSums map[string][]string
+}
+
+// NewChecksumPacker returns a ChecksumPacker that stores file payloads to
+// `fp` (or discards them, if nil), and packs the entries to `p`, with
+// checksums in `algorithm`, like "sha256".
+func NewChecksumPacker(p Packer, fp FilePutter, algorithm string) (*ChecksumPacker, error) {
+	checksumsMu.RLock()
+	newHash, ok := checksums[algorithm]
+	checksumsMu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownChecksum
+	}
+	if fp == nil {
+		fp = NewDiscardFilePutter()
+	}
+	return &ChecksumPacker{
+		p:         p,
+		fp:        fp,
+		algorithm: algorithm,
+		newHash:   newHash,
+		sums:      map[string]string{},
+		extraSums: map[string][]string{},
+	}, nil
+}
+
+// AddChecksum has each payload also checksummed in `algorithm`, in the same
+// pass, recorded in the Checksums of its entry after those added before it.
+// The Checksum stays in the algorithm of NewChecksumPacker, for consumers
+// that only know of it, so an ecosystem can move to a stronger algorithm
+// without breaking them. It is to be added before any Put.
+func (cp *ChecksumPacker) AddChecksum(algorithm string) error {
+	checksumsMu.RLock()
+	newHash, ok := checksums[algorithm]
+	checksumsMu.RUnlock()
+	if !ok {
+		return ErrUnknownChecksum
+	}
+	cp.extra = append(cp.extra, algorithm)
+	cp.newExtra = append(cp.newExtra, newHash)
+	return nil
+}
+
+// SetLargeChecksum has the payloads of more than `threshold` bytes checksummed
+// in `algorithm` instead, like "blake3", which is hashed in parallel, for
+// layers of multi-GB files where hashing dominates. It is to be set before
+// any Put.
+func (cp *ChecksumPacker) SetLargeChecksum(algorithm string, threshold int64) error {
+	checksumsMu.RLock()
+	newHash, ok := checksums[algorithm]
+	checksumsMu.RUnlock()
+	if !ok {
+		return ErrUnknownChecksum
+	}
+	cp.largeAlgorithm, cp.newLargeHash, cp.largeThreshold = algorithm, newHash, threshold
+	return nil
+}
+
+// Put stores the payload, checksumming it on the way. Puts may be
+// concurrent, if those of the FilePutter may be.
+func (cp *ChecksumPacker) Put(name string, r io.Reader) (int64, []byte, error) {
+	h := &sizedHash{small: cp.newHash()}
+	if cp.newLargeHash != nil {
+		h.large, h.threshold = cp.newLargeHash(), cp.largeThreshold
+	}
+	var w io.Writer = h
+	var extra []hash.Hash
+	if len(cp.newExtra) > 0 {
+		writers := []io.Writer{h}
+		for _, newHash := range cp.newExtra {
+			extra = append(extra, newHash())
+			writers = append(writers, extra[len(extra)-1])
+		}
+		w = io.MultiWriter(writers...)
+	}
+	size, csum, err := cp.fp.Put(name, io.TeeReader(r, w))
+	if err != nil {
+		return 0, nil, err
+	}
+	algorithm, sum := cp.algorithm, h.small
+	if h.n > h.threshold && h.large != nil {
+		algorithm, sum = cp.largeAlgorithm, h.large
+	}
+	cp.mu.Lock()
+	cp.sums[name] = algorithm + ":" + hex.EncodeToString(sum.Sum(nil))
+	if len(extra) > 0 {
+		sums := make([]string, len(extra))
+		for i, eh := range extra {
+			sums[i] = cp.extra[i] + ":" + hex.EncodeToString(eh.Sum(nil))
+		}
+		cp.extraSums[name] = sums
+	}
+	cp.mu.Unlock()
+	return size, csum, nil
+}
+
+// sizedHash hashes a payload in the `small` algorithm, and also in the
+// `large` one, if any, as it is not known until the end which is used. Past
+// the `threshold`, only the large one is.
+type sizedHash struct {
+	small, large hash.Hash
+	threshold    int64
+	n            int64
+}
+
+func (sh *sizedHash) Write(p []byte) (int, error) {
+	if sh.large == nil || sh.n <= sh.threshold {
+		sh.small.Write(p)
+	}
+	if sh.large != nil {
+		sh.large.Write(p)
+	}
+	sh.n += int64(len(p))
+	return len(p), nil
+}
+
+// AddEntry packs the entry, with the checksum of its payload
+func (cp *ChecksumPacker) AddEntry(e Entry) (int, error) {
+	if e.Type == FileType {
+		name := e.GetName()
+		cp.mu.Lock()
+		if sum, ok := cp.sums[name]; ok {
+			e.Checksum = sum
+			delete(cp.sums, name)
+		}
+		if sums, ok := cp.extraSums[name]; ok {
+			e.Checksums = sums
+			delete(cp.extraSums, name)
+		}
+		cp.mu.Unlock()
+	}
+	return cp.p.AddEntry(e)
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha512"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestChecksumPacker(t *testing.T) {
+	if _, err := NewChecksumPacker(NewJSONPacker(&bytes.Buffer{}), nil, "md4"); err != ErrUnknownChecksum {
+		t.Errorf("expected ErrUnknownChecksum, got %v", err)
+	}
+
+	var meta bytes.Buffer
+	cp, err := NewChecksumPacker(NewJSONPacker(&meta), nil, "sha512")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, csum, err := cp.Put("file", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cp.AddEntry(Entry{Type: FileType, Name: "file", Size: size, Payload: csum}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cp.AddEntry(Entry{Type: FileType, Name: "empty"}); err != nil {
+		t.Fatal(err)
+	}
+
+	up := NewJSONUnpacker(&meta)
+	e, err := up.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha512.Sum512([]byte("hello"))
+	if e.Checksum != "sha512:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected checksum %q", e.Checksum)
+	}
+	h, ok, err := NewChecksumVerifier(e.Checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write([]byte("hello"))
+	if !ok() {
+		t.Error("expected the payload to verify")
+	}
+	if e, err = up.Next(); err != nil || e.Checksum != "" {
+		t.Errorf("expected no checksum without a payload, got %q and %v", e.Checksum, err)
+	}
+}
+
+func TestChecksumPackerAddChecksum(t *testing.T) {
+	var meta bytes.Buffer
+	cp, err := NewChecksumPacker(NewJSONPacker(&meta), nil, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.AddChecksum("md4"); err != ErrUnknownChecksum {
+		t.Errorf("expected ErrUnknownChecksum, got %v", err)
+	}
+	if err := cp.AddChecksum("sha512"); err != nil {
+		t.Fatal(err)
+	}
+	size, csum, err := cp.Put("file", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cp.AddEntry(Entry{Type: FileType, Name: "file", Size: size, Payload: csum}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewJSONUnpacker(&meta).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha512.Sum512([]byte("hello"))
+	if len(e.Checksums) != 1 || e.Checksums[0] != "sha512:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected checksums %q", e.Checksums)
+	}
+	all := e.AllChecksums()
+	if len(all) != 2 || all[0] != e.Checksum || !strings.HasPrefix(all[0], "sha256:") {
+		t.Errorf("unexpected checksums %q", all)
+	}
+	for _, checksum := range all {
+		h, ok, err := NewChecksumVerifier(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Write([]byte("hello"))
+		if !ok() {
+			t.Errorf("expected %q to verify", checksum)
+		}
+	}
+}
+
+func TestChecksumPackerLarge(t *testing.T) {
+	var meta bytes.Buffer
+	cp, err := NewChecksumPacker(NewJSONPacker(&meta), nil, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.SetLargeChecksum("md4", 10); err != ErrUnknownChecksum {
+		t.Errorf("expected ErrUnknownChecksum, got %v", err)
+	}
+	if err := cp.SetLargeChecksum("blake3", 10); err != nil {
+		t.Fatal(err)
+	}
+	payloads := map[string]string{
+		"small":  "0123456789",
+		"large":  "0123456789a",
+		"larger": strings.Repeat("large ", blake3BatchLen/3),
+	}
+	for _, name := range []string{"small", "large", "larger"} {
+		size, csum, err := cp.Put(name, strings.NewReader(payloads[name]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cp.AddEntry(Entry{Type: FileType, Name: name, Size: size, Payload: csum}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up := NewJSONUnpacker(&meta)
+	for _, want := range []struct{ name, algorithm string }{{"small", "sha256"}, {"large", "blake3"}, {"larger", "blake3"}} {
+		e, err := up.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(e.Checksum, want.algorithm+":") {
+			t.Errorf("%s: expected a %s checksum, got %q", want.name, want.algorithm, e.Checksum)
+		}
+		h, ok, err := NewChecksumVerifier(e.Checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Write([]byte(payloads[want.name]))
+		if !ok() {
+			t.Errorf("%s: expected the payload to verify", want.name)
+		}
+	}
+}
+
+func TestRegisterChecksum(t *testing.T) {
+	RegisterChecksum("md5-test", md5.New)
+	if _, err := NewChecksumHash("md5-test"); err != nil {
+		t.Error(err)
+	}
+	var found bool
+	for _, name := range Checksums() {
+		found = found || name == "md5-test"
+	}
+	if !found {
+		t.Errorf("expected md5-test among %q", Checksums())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering twice to panic")
+		}
+	}()
+	RegisterChecksum("md5-test", md5.New)
+}
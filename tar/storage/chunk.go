@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Chunk is a content-defined chunk of a file payload (see ChunkPacker)
+type Chunk struct {
+	// Offset of the chunk in the payload
+	Offset int64 `json:"offset"`
+	// Length of the chunk
+	Length int64 `json:"length"`
+	// Digest of the chunk, like "sha256:<hex>"
+	Digest string `json:"digest"`
+}
+
+// The default sizes of the chunks of a ChunkPacker
+const (
+	DefaultChunkMin = 16 << 10
+	DefaultChunkAvg = 64 << 10
+	DefaultChunkMax = 256 << 10
+)
+
+// ErrInvalidChunkSizes is returned for chunk sizes that are not ordered
+// min <= avg <= max, or where avg is not a power of two
+var ErrInvalidChunkSizes = errors.New("storage: invalid chunk sizes")
+
+// gearTable is the random value of each byte for the rolling hash of FastCDC.
+// It is generated from a fixed seed, as the chunks found depend on it.
+var gearTable [256]uint64
+
+func init() {
+	// splitmix64
+	x := uint64(0x7461722d73706c74) // "tar-splt"
+	for i := range gearTable {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		gearTable[i] = z ^ z>>31
+	}
+}
+
+// chunker cuts the bytes written to it into content-defined chunks, with
+// FastCDC (https://www.usenix.org/conference/atc16/technical-sessions/presentation/xia),
+// passing each to `emit`
+type chunker struct {
+	min, avg, max int
+	// maskS is harder to match, before the average size, and maskL easier,
+	// after it, to normalize the sizes of the chunks
+	maskS, maskL uint64
+	buf          []byte
+	offset       int64
+	emit         func(offset int64, b []byte) error
+}
+
+func newChunker(min, avg, max int, emit func(offset int64, b []byte) error) *chunker {
+	bits := uint(0)
+	for 1<<(bits+1) <= avg {
+		bits++
+	}
+	mask := func(n uint) uint64 {
+		// the high bits, as they depend on the most bytes of the window
+		return (1<<n - 1) << (64 - n)
+	}
+	return &chunker{min: min, avg: avg, max: max, maskS: mask(bits + 1), maskL: mask(bits - 1), emit: emit}
+}
+
+// cut returns the length of the chunk at the start of `b`
+func (c *chunker) cut(b []byte) int {
+	n := len(b)
+	if n <= c.min {
+		return n
+	}
+	if n > c.max {
+		n = c.max
+	}
+	normal := c.avg
+	if normal > n {
+		normal = n
+	}
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = fp<<1 + gearTable[b[i]]
+		if fp&c.maskS == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gearTable[b[i]]
+		if fp&c.maskL == 0 {
+			return i
+		}
+	}
+	return n
+}
+
+func (c *chunker) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for len(c.buf) >= c.max {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *chunker) next() error {
+	n := c.cut(c.buf)
+	if err := c.emit(c.offset, c.buf[:n]); err != nil {
+		return err
+	}
+	c.offset += int64(n)
+	c.buf = append(c.buf[:0], c.buf[n:]...)
+	return nil
+}
+
+// Close emits the chunks of what is left
+func (c *chunker) Close() error {
+	for len(c.buf) > 0 {
+		if err := c.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChunkPacker is both a FilePutter and a Packer, that stores each file payload
+// as its content-defined chunks (with FastCDC), by their sha256 digests under
+// a directory, and records them as the Chunks of each FileType entry. Like
+// with zstd:chunked, the chunks that are the same across payloads and layers
+// are stored once, and a part of a file can be fetched by its chunks, while
+// the archive is still assembled exactly (see NewChunkFileGetter).
+//
+// Give it as both the Packer and the FilePutter to asm.NewInputTarStream.
+type ChunkPacker struct {
+	p             Packer
+	fp            FilePutter
+	store         casFilePutter
+	min, avg, max int
+	mu            sync.Mutex
+	chunks        map[string][]Chunk
+}
+
+// NewChunkPacker returns a ChunkPacker that stores the chunks under `dir`, as
+// "sha256/<hex>", and the file payloads to `fp` as well (unless it is nil),
+// and packs the entries to `p`.
+func NewChunkPacker(p Packer, fp FilePutter, dir string) *ChunkPacker {
+	if fp == nil {
+		fp = NewDiscardFilePutter()
+	}
+	return &ChunkPacker{
+		p:      p,
+		fp:     fp,
+		store:  casFilePutter{root: dir},
+		min:    DefaultChunkMin,
+		avg:    DefaultChunkAvg,
+		max:    DefaultChunkMax,
+		chunks: map[string][]Chunk{},
+	}
+}
+
+// SetChunkSizes sets the minimum, average and maximum sizes of the chunks,
+// from the defaults. The average must be a power of two. It is to be set
+// before any Put.
+func (cp *ChunkPacker) SetChunkSizes(min, avg, max int) error {
+	if min < 1 || min > avg || avg > max || avg&(avg-1) != 0 || avg < 4 {
+		return ErrInvalidChunkSizes
+	}
+	cp.min, cp.avg, cp.max = min, avg, max
+	return nil
+}
+
+// Put stores the payload, and its chunks on the way. Puts may be concurrent,
+// if those of the FilePutter may be.
+func (cp *ChunkPacker) Put(name string, r io.Reader) (int64, []byte, error) {
+	var chunks []Chunk
+	c := newChunker(cp.min, cp.avg, cp.max, func(offset int64, b []byte) error {
+		_, _, sum, err := cp.store.store(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, Chunk{Offset: offset, Length: int64(len(b)), Digest: "sha256:" + sum})
+		return nil
+	})
+	size, csum, err := cp.fp.Put(name, io.TeeReader(r, c))
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := c.Close(); err != nil {
+		return 0, nil, err
+	}
+	if c.offset != size {
+		return 0, nil, fmt.Errorf("storage: %q was chunked to %d of its %d bytes", name, c.offset, size)
+	}
+	cp.mu.Lock()
+	cp.chunks[name] = chunks
+	cp.mu.Unlock()
+	return size, csum, nil
+}
+
+// AddEntry packs the entry, with the chunks of its payload
+func (cp *ChunkPacker) AddEntry(e Entry) (int, error) {
+	if e.Type == FileType {
+		name := e.GetName()
+		cp.mu.Lock()
+		if chunks, ok := cp.chunks[name]; ok {
+			e.Chunks = chunks
+			delete(cp.chunks, name)
+		}
+		cp.mu.Unlock()
+	}
+	return cp.p.AddEntry(e)
+}
+
+// NewChunkFileGetter returns a FileGetter of the payloads stored as chunks
+// under `dir` by a ChunkPacker, by the Chunks of the entries of `up`, which is
+// read to the end.
+func NewChunkFileGetter(dir string, up Unpacker) (FileGetter, error) {
+	cfg := chunkFileGetter{dir: dir, chunks: map[string][]Chunk{}}
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if entry.Type == FileType && len(entry.Chunks) > 0 {
+			for _, c := range entry.Chunks {
+				if !strings.HasPrefix(c.Digest, "sha256:") {
+					return nil, fmt.Errorf("storage: unsupported digest %q of a chunk of %q", c.Digest, entry.GetName())
+				}
+			}
+			cfg.chunks[filepath.Clean(entry.GetName())] = entry.Chunks
+		}
+	}
+	return cfg, nil
+}
+
+type chunkFileGetter struct {
+	dir    string
+	chunks map[string][]Chunk
+}
+
+func (cfg chunkFileGetter) Get(filename string) (io.ReadCloser, error) {
+	chunks, ok := cfg.chunks[filepath.Clean(filename)]
+	if !ok {
+		return nil, ErrNoSuchFile
+	}
+	return &chunkReader{dir: cfg.dir, chunks: chunks}, nil
+}
+
+// chunkReader reads the chunks of a payload one after another, opening each
+// as it is reached
+type chunkReader struct {
+	dir    string
+	chunks []Chunk
+	cur    *os.File
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if cr.cur == nil {
+			if len(cr.chunks) == 0 {
+				return 0, io.EOF
+			}
+			digest := cr.chunks[0].Digest
+			fh, err := os.Open(filepath.Join(cr.dir, "sha256", filepath.Base(strings.TrimPrefix(digest, "sha256:"))))
+			if err != nil {
+				return 0, err
+			}
+			cr.cur = fh
+			cr.chunks = cr.chunks[1:]
+		}
+		n, err := cr.cur.Read(p)
+		if err == io.EOF {
+			cr.cur.Close()
+			cr.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (cr *chunkReader) Close() error {
+	if cr.cur == nil {
+		return nil
+	}
+	err := cr.cur.Close()
+	cr.cur = nil
+	return err
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func chunkDigests(t *testing.T, data []byte) []Chunk {
+	dir, err := ioutil.TempDir("", "chunk-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var meta bytes.Buffer
+	cp := NewChunkPacker(NewJSONPacker(&meta), nil, dir)
+	if err := cp.SetChunkSizes(1<<10, 4<<10, 16<<10); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cp.Put("f", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return cp.chunks["f"]
+}
+
+func TestChunkPacker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunk-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 300<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	files := map[string][]byte{
+		"big":   data,
+		"again": data,
+		"small": []byte("small"),
+	}
+
+	var meta bytes.Buffer
+	cp := NewChunkPacker(NewJSONPacker(&meta), nil, dir)
+	if err := cp.SetChunkSizes(1<<10, 4<<10, 16<<10); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"big", "again", "small"} {
+		size, sum, err := cp.Put(name, bytes.NewReader(files[name]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cp.AddEntry(Entry{Type: FileType, Name: name, Size: size, Payload: sum}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stored int64
+	infos, err := ioutil.ReadDir(dir + "/sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range infos {
+		stored += fi.Size()
+	}
+	if want := int64(len(data) + 5); stored != want {
+		t.Errorf("expected %d bytes of chunks stored once, got %d", want, stored)
+	}
+
+	up := NewJSONUnpacker(bytes.NewReader(meta.Bytes()))
+	for {
+		e, err := up.Next()
+		if err != nil {
+			break
+		}
+		var off int64
+		for _, c := range e.Chunks {
+			if c.Offset != off || c.Length < 1 || c.Length > 16<<10 {
+				t.Errorf("%s: unexpected chunk %+v at %d", e.GetName(), c, off)
+			}
+			off += c.Length
+		}
+		if off != e.Size {
+			t.Errorf("%s: expected chunks over %d bytes, got %d", e.GetName(), e.Size, off)
+		}
+	}
+
+	fg, err := NewChunkFileGetter(dir, NewJSONUnpacker(bytes.NewReader(meta.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if got := readPayload(t, fg, name); got != string(content) {
+			t.Errorf("%s: expected the payload reassembled from its chunks", name)
+		}
+	}
+	if _, err := fg.Get("missing"); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+}
+
+func TestChunkBoundariesAreContentDefined(t *testing.T) {
+	data := make([]byte, 200<<10)
+	rand.New(rand.NewSource(2)).Read(data)
+	before := chunkDigests(t, data)
+	inserted := append(append([]byte("a few inserted bytes"), data[:100]...), data[100:]...)
+	after := chunkDigests(t, inserted)
+
+	seen := map[string]bool{}
+	for _, c := range before {
+		seen[c.Digest] = true
+	}
+	var shared int
+	for _, c := range after {
+		if seen[c.Digest] {
+			shared++
+		}
+	}
+	// only the chunks around the insertion are to differ
+	if shared < len(before)-2 {
+		t.Errorf("expected all but the first chunks shared, got %d of %d", shared, len(before))
+	}
+}
+
+func TestSetChunkSizes(t *testing.T) {
+	cp := NewChunkPacker(NewJSONPacker(ioutil.Discard), nil, "")
+	for _, s := range [][3]int{{0, 4, 8}, {8, 4, 16}, {1, 6, 16}, {1, 16, 8}} {
+		if err := cp.SetChunkSizes(s[0], s[1], s[2]); err != ErrInvalidChunkSizes {
+			t.Errorf("%v: expected ErrInvalidChunkSizes, got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+//go:build go1.7
+// +build go1.7
+
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// NewContextPacker returns a Packer whose AddEntry returns ctx.Err() once
+// `ctx` is done, even while `p` is blocked on a slow writer, as with metadata
+// written over the network under a deadline. Once an AddEntry is interrupted,
+// the entry may or may not be packed, so every later one fails too.
+func NewContextPacker(ctx context.Context, p Packer) Packer {
+	return &contextPacker{ctx: ctx, p: p}
+}
+
+type contextPacker struct {
+	ctx context.Context
+	p   Packer
+
+	mu  sync.Mutex
+	err error // of an interrupted call
+}
+
+type packResult struct {
+	pos int
+	err error
+}
+
+func (cp *contextPacker) AddEntry(e Entry) (int, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.err != nil {
+		return -1, cp.err
+	}
+	if err := cp.ctx.Err(); err != nil {
+		cp.err = err
+		return -1, err
+	}
+	done := make(chan packResult, 1)
+	go func() {
+		pos, err := cp.p.AddEntry(e)
+		done <- packResult{pos, err}
+	}()
+	select {
+	case res := <-done:
+		return res.pos, res.err
+	case <-cp.ctx.Done():
+		// the call is left to finish, or not, in the background
+		cp.err = cp.ctx.Err()
+		return -1, cp.err
+	}
+}
+
+// NewContextUnpacker returns an Unpacker whose Next returns ctx.Err() once
+// `ctx` is done, even while `up` is blocked on a slow reader. Once a Next is
+// interrupted, every later one fails too, as the entry being read is lost.
+func NewContextUnpacker(ctx context.Context, up Unpacker) Unpacker {
+	return &contextUnpacker{ctx: ctx, up: up}
+}
+
+type contextUnpacker struct {
+	ctx context.Context
+	up  Unpacker
+
+	mu  sync.Mutex
+	err error // of an interrupted call
+}
+
+type unpackResult struct {
+	entry *Entry
+	err   error
+}
+
+func (cu *contextUnpacker) Next() (*Entry, error) {
+	cu.mu.Lock()
+	defer cu.mu.Unlock()
+	if cu.err != nil {
+		return nil, cu.err
+	}
+	if err := cu.ctx.Err(); err != nil {
+		cu.err = err
+		return nil, err
+	}
+	done := make(chan unpackResult, 1)
+	go func() {
+		entry, err := cu.up.Next()
+		done <- unpackResult{entry, err}
+	}()
+	select {
+	case res := <-done:
+		return res.entry, res.err
+	case <-cu.ctx.Done():
+		// the call is left to finish, or not, in the background
+		cu.err = cu.ctx.Err()
+		return nil, cu.err
+	}
+}
@@ -0,0 +1,69 @@
+//go:build go1.7
+// +build go1.7
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// blockingWriter blocks every Write until it is closed
+type blockingWriter chan struct{}
+
+func (bw blockingWriter) Write(p []byte) (int, error) {
+	<-bw
+	return 0, io.ErrClosedPipe
+}
+
+func TestContextPacker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var buf bytes.Buffer
+	p := NewContextPacker(ctx, NewJSONPacker(&buf))
+	if _, err := p.AddEntry(Entry{Type: SegmentType, Payload: []byte("y")}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := p.AddEntry(Entry{Type: SegmentType, Payload: []byte("z")}); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	bw := make(blockingWriter)
+	defer close(bw)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	p = NewContextPacker(ctx, NewJSONPacker(bw))
+	if _, err := p.AddEntry(Entry{Type: SegmentType, Payload: []byte("y")}); err != context.DeadlineExceeded {
+		t.Errorf("expected the blocked AddEntry to be interrupted, got %v", err)
+	}
+}
+
+func TestContextUnpacker(t *testing.T) {
+	var buf bytes.Buffer
+	jp := NewJSONPacker(&buf)
+	for _, s := range []string{"a", "b"} {
+		if _, err := jp.AddEntry(Entry{Type: SegmentType, Payload: []byte(s)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	up := NewContextUnpacker(ctx, NewJSONUnpacker(&buf))
+	if e, err := up.Next(); err != nil || string(e.Payload) != "a" {
+		t.Fatalf("expected the first entry, got %v and %v", e, err)
+	}
+	cancel()
+	if _, err := up.Next(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := NewContextUnpacker(ctx, NewJSONUnpacker(pr)).Next(); err != context.DeadlineExceeded {
+		t.Errorf("expected the blocked Next to be interrupted, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DedupeReport is the result of FindDuplicates
+type DedupeReport struct {
+	// Files is the number of file payloads
+	Files int
+	// TotalBytes is the size of all the file payloads, and UniqueBytes the size
+	// of only the distinct ones
+	TotalBytes, UniqueBytes int64
+	// HardlinkBytes is how much of the savings are from duplicates within the
+	// same metadata stream, which could be hard links in that archive
+	HardlinkBytes int64
+	// Duplicates are the payloads found more than once, the largest savings
+	// first
+	Duplicates []Duplicate
+}
+
+// Savings is the number of bytes that storing each distinct payload once
+// would save
+func (r DedupeReport) Savings() int64 {
+	return r.TotalBytes - r.UniqueBytes
+}
+
+// Duplicate is a payload found in more than one entry
+type Duplicate struct {
+	Size     int64
+	Checksum []byte
+	// Entries with the payload
+	Entries []DuplicateEntry
+}
+
+// Savings is the number of bytes that storing the payload once would save
+func (d Duplicate) Savings() int64 {
+	return d.Size * int64(len(d.Entries)-1)
+}
+
+// DuplicateEntry is one entry of a Duplicate
+type DuplicateEntry struct {
+	// Source is the index of the Unpacker the entry is from
+	Source int
+	Name   string
+}
+
+// FindDuplicates reads each of the Unpackers to the end (e.g. the metadata of
+// each layer of an image), and reports the file payloads that are duplicated
+// across entries, and how much storing them by content would save.
+//
+// Payloads are the same if their size and crc64 checksum are, so this is an
+// estimate rather than proof; a content digest should be compared before
+// linking files.
+func FindDuplicates(ups ...Unpacker) (DedupeReport, error) {
+	var report DedupeReport
+	type key struct {
+		size int64
+		crc  string
+	}
+	payloads := map[key]*Duplicate{}
+	var order []key
+	for i, up := range ups {
+		for {
+			entry, err := up.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return report, err
+			}
+			if entry.Type != FileType || entry.Size == 0 || entry.Special != "" || entry.Partial {
+				continue
+			}
+			report.Files++
+			report.TotalBytes += entry.Size
+			k := key{entry.Size, string(entry.Payload)}
+			d, ok := payloads[k]
+			if !ok {
+				d = &Duplicate{Size: entry.Size, Checksum: entry.Payload}
+				payloads[k] = d
+				order = append(order, k)
+				report.UniqueBytes += entry.Size
+			} else {
+				for _, de := range d.Entries {
+					if de.Source == i {
+						report.HardlinkBytes += entry.Size
+						break
+					}
+				}
+			}
+			d.Entries = append(d.Entries, DuplicateEntry{Source: i, Name: entry.GetName()})
+		}
+	}
+	for _, k := range order {
+		if d := payloads[k]; len(d.Entries) > 1 {
+			report.Duplicates = append(report.Duplicates, *d)
+		}
+	}
+	sort.Stable(bySavings(report.Duplicates))
+	return report, nil
+}
+
+// bySavings sorts Duplicates by their savings, largest first
+type bySavings []Duplicate
+
+func (d bySavings) Len() int           { return len(d) }
+func (d bySavings) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d bySavings) Less(i, j int) bool { return d[i].Savings() > d[j].Savings() }
+
+// String is a short description of the payload, for reports
+func (d Duplicate) String() string {
+	return fmt.Sprintf("crc64:%s (%d bytes) x%d", hex.EncodeToString(d.Checksum), d.Size, len(d.Entries))
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"bytes"
+	"hash/crc64"
+	"testing"
+)
+
+func packFiles(t *testing.T, files map[string]string, names ...string) *bytes.Buffer {
+	buf := bytes.NewBuffer(nil)
+	p := NewJSONPacker(buf)
+	for _, name := range names {
+		crcHash := crc64.New(CRCTable)
+		crcHash.Write([]byte(files[name]))
+		e := Entry{Type: FileType, Name: name, Size: int64(len(files[name]))}
+		if e.Size > 0 {
+			e.Payload = crcHash.Sum(nil)
+		}
+		if _, err := p.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf
+}
+
+func TestFindDuplicates(t *testing.T) {
+	files := map[string]string{
+		"bin/a":   "same content",
+		"bin/b":   "same content",
+		"lib/big": "a larger payload, in both layers",
+		"etc/one": "unique",
+		"empty":   "",
+	}
+	layer1 := packFiles(t, files, "bin/a", "bin/b", "lib/big", "empty")
+	layer2 := packFiles(t, files, "lib/big", "etc/one", "bin/a")
+
+	report, err := FindDuplicates(NewJSONUnpacker(layer1), NewJSONUnpacker(layer2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, big := int64(len(files["bin/a"])), int64(len(files["lib/big"]))
+	if report.Files != 6 {
+		t.Errorf("expected 6 file payloads, got %d", report.Files)
+	}
+	if report.TotalBytes != 3*same+2*big+6 || report.UniqueBytes != same+big+6 {
+		t.Errorf("unexpected total %d and unique %d bytes", report.TotalBytes, report.UniqueBytes)
+	}
+	if report.Savings() != 2*same+big {
+		t.Errorf("expected savings of %d, got %d", 2*same+big, report.Savings())
+	}
+	// bin/b duplicates bin/a within the first layer
+	if report.HardlinkBytes != same {
+		t.Errorf("expected %d bytes that could be hard links, got %d", same, report.HardlinkBytes)
+	}
+	if len(report.Duplicates) != 2 {
+		t.Fatalf("expected 2 duplicated payloads, got %v", report.Duplicates)
+	}
+	if d := report.Duplicates[0]; d.Size != big || len(d.Entries) != 2 || d.Entries[1] != (DuplicateEntry{Source: 1, Name: "lib/big"}) {
+		t.Errorf("expected lib/big first, with the largest savings, got %+v", d)
+	}
+	if d := report.Duplicates[1]; d.Size != same || len(d.Entries) != 3 {
+		t.Errorf("expected the payload of bin/a three times, got %+v", d)
+	}
+}
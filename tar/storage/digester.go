@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"hash"
+	"hash/crc64"
+)
+
+// PayloadDigester computes the size and checksum of a file payload written to
+// it, as the FilePutters return them for the metadata. It is for tools and
+// FilePutters outside of this package to compute them the same way.
+type PayloadDigester struct {
+	crc  hash.Hash64
+	size int64
+}
+
+// NewPayloadDigester returns a PayloadDigester of no payload yet
+func NewPayloadDigester() *PayloadDigester {
+	return &PayloadDigester{crc: crc64.New(CRCTable)}
+}
+
+// Write adds `p` to the payload. It never fails.
+func (pd *PayloadDigester) Write(p []byte) (int, error) {
+	pd.size += int64(len(p))
+	return pd.crc.Write(p)
+}
+
+// Size returns the number of bytes of the payload written so far
+func (pd *PayloadDigester) Size() int64 {
+	return pd.size
+}
+
+// Checksum returns the crc64 checksum of the payload written so far, as it is
+// in the Payload of a FileType Entry
+func (pd *PayloadDigester) Checksum() []byte {
+	return pd.crc.Sum(nil)
+}
+
+// Reset starts over, for another payload
+func (pd *PayloadDigester) Reset() {
+	pd.crc.Reset()
+	pd.size = 0
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPayloadDigester(t *testing.T) {
+	payload := strings.Repeat("payload ", 1000)
+	size, sum, err := NewBufferFileGetPutter().Put("file", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pd := NewPayloadDigester()
+	// written in pieces, as a stream would be
+	for i := 0; i < len(payload); i += 100 {
+		pd.Write([]byte(payload[i : i+100]))
+	}
+	if pd.Size() != size || !bytes.Equal(pd.Checksum(), sum) {
+		t.Errorf("expected the size %d and checksum %x of a FilePutter, got %d and %x", size, sum, pd.Size(), pd.Checksum())
+	}
+
+	pd.Reset()
+	if pd.Size() != 0 {
+		t.Errorf("expected no payload after Reset, got %d bytes", pd.Size())
+	}
+	_, emptySum, _ := NewDiscardFilePutter().Put("empty", strings.NewReader(""))
+	if !bytes.Equal(pd.Checksum(), emptySum) {
+		t.Errorf("expected the checksum of an empty payload, got %x", pd.Checksum())
+	}
+}
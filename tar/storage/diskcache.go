@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
+)
+
+// diskCacheTempPrefix is of the files that payloads are written to while
+// they are fetched
+const diskCacheTempPrefix = "tmp-"
+
+// DiskCache is a directory of payloads read through from slower FileGetters
+// (like a remote store), so that assembling the same payloads again on a node
+// does not fetch them again. It persists across processes, and is bounded in
+// size, evicting the least recently used payloads.
+//
+// Each payload is stored with its checksum, and checked against it before it
+// is reused; payloads that do not match are fetched again.
+type DiskCache struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*diskCacheEntry // by file name in dir
+}
+
+type diskCacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// NewDiskCache returns a DiskCache in the directory `dir`, of at most
+// `maxBytes`. Payloads already in the directory are kept.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dc := &DiskCache{dir: dir, max: maxBytes, entries: map[string]*diskCacheEntry{}}
+	for _, fi := range infos {
+		if fi.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(fi.Name(), diskCacheTempPrefix) {
+			// left from an interrupted fetch
+			os.Remove(filepath.Join(dir, fi.Name()))
+			continue
+		}
+		dc.entries[fi.Name()] = &diskCacheEntry{size: fi.Size(), used: fi.ModTime()}
+		dc.size += fi.Size()
+	}
+	dc.mu.Lock()
+	dc.evict()
+	dc.mu.Unlock()
+	return dc, nil
+}
+
+// Size returns the number of bytes cached
+func (dc *DiskCache) Size() int64 {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.size
+}
+
+// FileGetter returns a FileGetter that reads payloads through from `fg`, by
+// way of the cache. Since file names are only unique within one archive,
+// `prefix` is prepended to each name to form the cache key (e.g. the layer's
+// digest).
+func (dc *DiskCache) FileGetter(fg FileGetter, prefix string) FileGetter {
+	return &diskCachingFileGetter{dc: dc, fg: fg, prefix: prefix}
+}
+
+type diskCachingFileGetter struct {
+	dc     *DiskCache
+	fg     FileGetter
+	prefix string
+}
+
+func (dcfg *diskCachingFileGetter) Get(filename string) (io.ReadCloser, error) {
+	sum := sha256.Sum256([]byte(dcfg.prefix + filename))
+	name := hex.EncodeToString(sum[:])
+	if fh := dcfg.dc.open(name); fh != nil {
+		return fh, nil
+	}
+
+	rc, err := dcfg.fg.Get(filename)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(dcfg.dc.dir, diskCacheTempPrefix)
+	if err != nil {
+		// not cached, but still read
+		return rc, nil
+	}
+	// room for the checksum, written once the payload is
+	if _, err := tmp.Write(make([]byte, crc64.Size)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return rc, nil
+	}
+	return &cacheFillReader{
+		rc:   rc,
+		tmp:  tmp,
+		crc:  crc64.New(CRCTable),
+		dc:   dcfg.dc,
+		name: name,
+	}, nil
+}
+
+// open returns the cached payload, positioned after its checksum, or nil if
+// it is not cached (or did not match its checksum)
+func (dc *DiskCache) open(name string) *os.File {
+	dc.mu.Lock()
+	e, ok := dc.entries[name]
+	if ok {
+		e.used = time.Now()
+	}
+	dc.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	p := filepath.Join(dc.dir, name)
+	fh, err := os.Open(p)
+	if err != nil {
+		dc.remove(name)
+		return nil
+	}
+	expected := make([]byte, crc64.Size)
+	crc := crc64.New(CRCTable)
+	if _, err := io.ReadFull(fh, expected); err != nil {
+		fh.Close()
+		dc.remove(name)
+		return nil
+	}
+	if _, err := bufpool.Copy(crc, fh); err != nil || !bytes.Equal(crc.Sum(nil), expected) {
+		fh.Close()
+		dc.remove(name)
+		return nil
+	}
+	if _, err := fh.Seek(crc64.Size, 0); err != nil {
+		fh.Close()
+		return nil
+	}
+	now := time.Now()
+	os.Chtimes(p, now, now) // for the order of eviction, in later processes
+	return fh
+}
+
+func (dc *DiskCache) remove(name string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if e, ok := dc.entries[name]; ok {
+		dc.size -= e.size
+		delete(dc.entries, name)
+	}
+	os.Remove(filepath.Join(dc.dir, name))
+}
+
+// add moves the fetched payload at `tmp` into the cache as `name`
+func (dc *DiskCache) add(name, tmp string, size int64) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if size > dc.max {
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(dc.dir, name)); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	if e, ok := dc.entries[name]; ok {
+		dc.size -= e.size
+	}
+	dc.entries[name] = &diskCacheEntry{size: size, used: time.Now()}
+	dc.size += size
+	dc.evict()
+}
+
+// evict removes the least recently used payloads, until the cache is within
+// its bounds. dc.mu is held.
+func (dc *DiskCache) evict() {
+	for dc.size > dc.max && len(dc.entries) > 0 {
+		var oldest string
+		for name, e := range dc.entries {
+			if oldest == "" || e.used.Before(dc.entries[oldest].used) {
+				oldest = name
+			}
+		}
+		dc.size -= dc.entries[oldest].size
+		delete(dc.entries, oldest)
+		os.Remove(filepath.Join(dc.dir, oldest))
+	}
+}
+
+// cacheFillReader reads a payload through, writing it to a temporary file in
+// the cache. Once it is read to the end and closed, the file is added to the
+// cache; otherwise it is removed.
+type cacheFillReader struct {
+	rc   io.ReadCloser
+	tmp  *os.File
+	crc  hash.Hash
+	dc   *DiskCache
+	name string
+	size int64
+	eof  bool
+}
+
+func (cfr *cacheFillReader) Read(p []byte) (int, error) {
+	n, err := cfr.rc.Read(p)
+	if n > 0 && cfr.tmp != nil {
+		cfr.crc.Write(p[:n])
+		cfr.size += int64(n)
+		if _, werr := cfr.tmp.Write(p[:n]); werr != nil || cfr.size > cfr.dc.max {
+			// give up on caching it, but keep reading
+			cfr.abandon()
+		}
+	}
+	if err == io.EOF {
+		cfr.eof = true
+	}
+	return n, err
+}
+
+func (cfr *cacheFillReader) abandon() {
+	cfr.tmp.Close()
+	os.Remove(cfr.tmp.Name())
+	cfr.tmp = nil
+}
+
+func (cfr *cacheFillReader) Close() error {
+	err := cfr.rc.Close()
+	if cfr.tmp == nil {
+		return err
+	}
+	if !cfr.eof {
+		cfr.abandon()
+		return err
+	}
+	if _, werr := cfr.tmp.WriteAt(cfr.crc.Sum(nil), 0); werr != nil {
+		cfr.abandon()
+		return err
+	}
+	if cerr := cfr.tmp.Close(); cerr != nil {
+		os.Remove(cfr.tmp.Name())
+		return err
+	}
+	cfr.dc.add(cfr.name, cfr.tmp.Name(), cfr.size+crc64.Size)
+	return err
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readPayload(t *testing.T, fg FileGetter, name string) string {
+	rc, err := fg.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	buf, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tar-split-diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewBufferFileGetPutter()
+	store.Put("a", strings.NewReader(strings.Repeat("a", 100)))
+	store.Put("b", strings.NewReader(strings.Repeat("b", 100)))
+	remote := &countingFileGetter{FileGetter: store}
+
+	dc, err := NewDiskCache(dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fg := dc.FileGetter(remote, "layer1/")
+	for i := 0; i < 2; i++ {
+		if got := readPayload(t, fg, "a"); got != strings.Repeat("a", 100) {
+			t.Errorf("unexpected payload %q", got)
+		}
+	}
+	if remote.gets != 1 {
+		t.Errorf("expected the payload to be fetched once, got %d", remote.gets)
+	}
+
+	// a payload that is not read to the end is not cached
+	rc, _ := fg.Get("b")
+	rc.Read(make([]byte, 10))
+	rc.Close()
+	if dc.Size() != 100+8 {
+		t.Errorf("expected only the first payload cached, got %d bytes", dc.Size())
+	}
+
+	// corrupted on disk, it is fetched again
+	infos, _ := ioutil.ReadDir(dir)
+	if len(infos) != 1 {
+		t.Fatalf("expected one cached file, got %d", len(infos))
+	}
+	p := filepath.Join(dir, infos[0].Name())
+	buf, _ := ioutil.ReadFile(p)
+	buf[len(buf)-1] = 'x'
+	ioutil.WriteFile(p, buf, 0644)
+	remote.gets = 0
+	if got := readPayload(t, fg, "a"); got != strings.Repeat("a", 100) {
+		t.Errorf("expected the fetched payload, got %q", got)
+	}
+	if remote.gets != 1 {
+		t.Errorf("expected the corrupted payload to be fetched again")
+	}
+
+	// the same name from another archive is its own payload
+	readPayload(t, dc.FileGetter(remote, "layer2/"), "a")
+	if remote.gets != 2 {
+		t.Errorf("expected a separate key for another prefix")
+	}
+
+	// a third payload is over the bound, evicting the least recently used
+	readPayload(t, fg, "a")
+	readPayload(t, fg, "b")
+	if dc.Size() > 250 {
+		t.Errorf("expected the cache within its bound, got %d bytes", dc.Size())
+	}
+	remote.gets = 0
+	readPayload(t, fg, "a")
+	readPayload(t, fg, "b")
+	if remote.gets != 0 {
+		t.Errorf("expected the recently used payloads to be kept, got %d fetches", remote.gets)
+	}
+
+	// the cache persists
+	dc2, err := NewDiskCache(dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc2.Size() != dc.Size() {
+		t.Errorf("expected %d bytes cached, got %d", dc.Size(), dc2.Size())
+	}
+	readPayload(t, dc2.FileGetter(remote, "layer1/"), "b")
+	if remote.gets != 0 {
+		t.Errorf("expected the payload from the existing cache")
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Package azure provides a payload store backed by Azure Blob Storage, using its
+REST API.
+
+Importing this package registers the "azure" driver, with the params
+"account_url", "container", "prefix", "sas" and "block_size" (see Config).
+*/
+package azure
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/vbatts/tar-split/tar/storage"
+	"github.com/vbatts/tar-split/tar/storage/driver"
+)
+
+const (
+	// DefaultBlockSize is the size of each block of an uploaded blob
+	DefaultBlockSize = 8 << 20
+	// apiVersion is the x-ms-version of the Blob service REST API used
+	apiVersion = "2020-04-08"
+)
+
+// Config for the Azure Blob Storage payload store
+type Config struct {
+	AccountURL string // like "https://<account>.blob.core.windows.net"
+	Container  string
+	Prefix     string       // prepended to each blob name
+	SASToken   string       // shared access signature query string, without the leading '?'
+	BlockSize  int          // defaults to DefaultBlockSize
+	Client     *http.Client // defaults to http.DefaultClient
+}
+
+func init() {
+	driver.Register("azure", azureDriver{})
+}
+
+type azureDriver struct{}
+
+func (azureDriver) Open(params map[string]string) (storage.FileGetPutter, error) {
+	cfg := Config{
+		AccountURL: params["account_url"],
+		Container:  params["container"],
+		Prefix:     params["prefix"],
+		SASToken:   params["sas"],
+	}
+	if s, ok := params["block_size"]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("azure: invalid block_size: %s", err)
+		}
+		cfg.BlockSize = n
+	}
+	return New(cfg)
+}
+
+// New returns a FileGetPutter storing payloads as block blobs in an Azure
+// Blob Storage container.
+//
+// Payloads are uploaded one block per request, each with its Content-MD5 so
+// that the server rejects a corrupted block, and then committed as a block
+// list.
+func New(cfg Config) (storage.FileGetPutter, error) {
+	if cfg.AccountURL == "" || cfg.Container == "" {
+		return nil, errors.New("azure: account URL and container must be set")
+	}
+	cfg.AccountURL = strings.TrimSuffix(cfg.AccountURL, "/")
+	cfg.SASToken = strings.TrimPrefix(cfg.SASToken, "?")
+	if cfg.BlockSize == 0 {
+		cfg.BlockSize = DefaultBlockSize
+	}
+	if cfg.BlockSize < 0 {
+		return nil, errors.New("azure: block size must be positive")
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &store{cfg: cfg}, nil
+}
+
+type store struct {
+	cfg Config
+}
+
+// blobURL returns the URL of the blob for `name`, with `query` and the SAS
+// token appended.
+func (s *store) blobURL(name string, query url.Values) string {
+	segments := strings.Split(s.cfg.Prefix+strings.TrimPrefix(path.Clean("/"+name), "/"), "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	u := s.cfg.AccountURL + "/" + url.PathEscape(s.cfg.Container) + "/" + strings.Join(segments, "/")
+	q := query.Encode()
+	if s.cfg.SASToken != "" {
+		if q != "" {
+			q += "&"
+		}
+		q += s.cfg.SASToken
+	}
+	if q != "" {
+		u += "?" + q
+	}
+	return u
+}
+
+func (s *store) do(method, u string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-version", apiVersion)
+	return s.cfg.Client.Do(req)
+}
+
+func (s *store) Get(name string) (io.ReadCloser, error) {
+	u := s.blobURL(name, nil)
+	resp, err := s.do("GET", u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, storage.ErrNoSuchFile
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("azure: GET %s: %s", name, resp.Status)
+	}
+}
+
+type blockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+func (s *store) Put(name string, r io.Reader) (int64, []byte, error) {
+	pd := storage.NewPayloadDigester()
+	md := md5.New()
+	tr := io.TeeReader(r, io.MultiWriter(pd, md))
+
+	var size int64
+	list := blockList{}
+	err := driver.Chunks(tr, s.cfg.BlockSize, func(chunk []byte, last bool) error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		// block IDs of a blob must all be the same length
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%010d", len(list.Latest))))
+		sum := md5.Sum(chunk)
+		resp, err := s.do("PUT", s.blobURL(name, url.Values{"comp": {"block"}, "blockid": {id}}), bytes.NewReader(chunk), http.Header{
+			"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])},
+		})
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			return fmt.Errorf("azure: put block of %s: %s", name, resp.Status)
+		}
+		list.Latest = append(list.Latest, id)
+		size += int64(len(chunk))
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	body, err := xml.Marshal(list)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := s.do("PUT", s.blobURL(name, url.Values{"comp": {"blocklist"}}), bytes.NewReader(body), http.Header{
+		"Content-Type":          {"application/xml"},
+		"X-Ms-Blob-Content-Md5": {base64.StdEncoding.EncodeToString(md.Sum(nil))},
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return 0, nil, fmt.Errorf("azure: put block list of %s: %s", name, resp.Status)
+	}
+	return size, pd.Checksum(), nil
+}
@@ -0,0 +1,130 @@
+package azure
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+	"github.com/vbatts/tar-split/tar/storage/driver"
+)
+
+// fakeBlobs implements just enough of the Blob service REST API for block
+// uploads and downloads.
+type fakeBlobs struct {
+	mu     sync.Mutex
+	blocks map[string][]byte
+	blobs  map[string][]byte
+	puts   int
+}
+
+func (f *fakeBlobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Query().Get("sig") != "s3cr3t" {
+		http.Error(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/layers/")
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.Method == "PUT" && r.URL.Query().Get("comp") == "block":
+		sum := md5.Sum(body)
+		if r.Header.Get("Content-Md5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			http.Error(w, "Md5Mismatch", http.StatusBadRequest)
+			return
+		}
+		f.puts++
+		f.blocks[name+"/"+r.URL.Query().Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && r.URL.Query().Get("comp") == "blocklist":
+		var list blockList
+		if err := xml.Unmarshal(body, &list); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		blob := []byte{}
+		for _, id := range list.Latest {
+			blob = append(blob, f.blocks[name+"/"+id]...)
+		}
+		f.blobs[name] = blob
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "GET":
+		blob, ok := f.blobs[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(blob)
+	default:
+		http.Error(w, fmt.Sprintf("unexpected %s %s", r.Method, r.URL), http.StatusBadRequest)
+	}
+}
+
+func TestAzure(t *testing.T) {
+	fake := &fakeBlobs{blocks: map[string][]byte{}, blobs: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	fgp, err := driver.Open("azure", map[string]string{
+		"account_url": srv.URL,
+		"container":   "layers",
+		"prefix":      "sha256-abc/",
+		"sas":         "?sv=2020-04-08&sig=s3cr3t",
+		"block_size":  "1024",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"./empty":        {},
+		"./hurr.txt":     []byte("imma hurr til I derp"),
+		"./usr/bin/derp": bytes.Repeat([]byte("derp"), 1000),
+	}
+	for name, body := range files {
+		fake.puts = 0
+		i, csum, err := fgp.Put(name, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if i != int64(len(body)) {
+			t.Errorf("%s: expected size %d, got %d", name, len(body), i)
+		}
+		_, expected, _ := storage.NewDiscardFilePutter().Put(name, bytes.NewReader(body))
+		if !bytes.Equal(csum, expected) {
+			t.Errorf("%s: expected checksum %x, got %x", name, expected, csum)
+		}
+		if expectedBlocks := (len(body) + 1023) / 1024; fake.puts != expectedBlocks {
+			t.Errorf("%s: expected %d blocks, got %d", name, expectedBlocks, fake.puts)
+		}
+
+		rdr, err := fgp.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rdr.Close()
+		if !bytes.Equal(got, body) {
+			t.Errorf("%s: payload did not round trip", name)
+		}
+	}
+	if _, ok := fake.blobs["sha256-abc/usr/bin/derp"]; !ok {
+		t.Errorf("expected blobs to be prefixed")
+	}
+
+	if _, err := fgp.Get("./nope"); err != storage.ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+}
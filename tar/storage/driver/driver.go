@@ -0,0 +1,108 @@
+/*
+Package driver is a registry of remote payload stores, which are opened by name
+and parameters to provide a storage.FileGetPutter.
+
+Drivers register themselves when their package is imported, much like
+`database/sql`:
+
+	import (
+		"github.com/vbatts/tar-split/tar/storage/driver"
+		_ "github.com/vbatts/tar-split/tar/storage/driver/gcs"
+	)
+
+	fgp, err := driver.Open("gcs", map[string]string{"bucket": "layers"})
+*/
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// ErrChecksumMismatch is returned when the remote store reports a checksum
+// that does not match the payload that was sent.
+var ErrChecksumMismatch = errors.New("driver: remote checksum does not match payload")
+
+// Driver opens a payload store of one kind of backend
+type Driver interface {
+	// Open returns a FileGetPutter configured by the driver specific params
+	Open(params map[string]string) (storage.FileGetPutter, error)
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Driver{}
+)
+
+// Register makes a Driver available by `name`. If Register is called twice
+// with the same name, or the driver is nil, it panics.
+func Register(name string, d Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if d == nil {
+		panic("driver: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("driver: Register called twice for driver " + name)
+	}
+	drivers[name] = d
+}
+
+// Drivers returns the sorted names of the registered drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := []string{}
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open returns a FileGetPutter from the Driver registered as `name`
+func Open(name string, params map[string]string) (storage.FileGetPutter, error) {
+	driversMu.RLock()
+	d, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("driver: unknown driver %q (forgotten import?)", name)
+	}
+	return d.Open(params)
+}
+
+// Chunks reads `r` in chunks of `size` bytes, calling fn with each chunk and
+// whether it is the last one. Only the last chunk may be short, and it is only
+// empty when `r` has no data at all.
+//
+// The chunk passed to fn is only valid until fn returns.
+func Chunks(r io.Reader, size int, fn func(chunk []byte, last bool) error) error {
+	cur := make([]byte, size)
+	next := make([]byte, size)
+	n, err := io.ReadFull(r, cur)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	for {
+		if n < size {
+			return fn(cur[:n], true)
+		}
+		m, err := io.ReadFull(r, next)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if m == 0 {
+			return fn(cur[:n], true)
+		}
+		if err := fn(cur[:n], false); err != nil {
+			return err
+		}
+		cur, next = next, cur
+		n = m
+	}
+}
@@ -0,0 +1,66 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+type bufferDriver struct{}
+
+func (bufferDriver) Open(params map[string]string) (storage.FileGetPutter, error) {
+	return storage.NewBufferFileGetPutter(), nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register("buffer", bufferDriver{})
+	if names := Drivers(); len(names) != 1 || names[0] != "buffer" {
+		t.Errorf("expected only the buffer driver, got %v", names)
+	}
+	if _, err := Open("buffer", nil); err != nil {
+		t.Error(err)
+	}
+	if _, err := Open("nope", nil); err == nil {
+		t.Error("expected an error opening an unknown driver")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic registering a driver twice")
+		}
+	}()
+	Register("buffer", bufferDriver{})
+}
+
+func TestChunks(t *testing.T) {
+	for _, tc := range []struct {
+		input  string
+		chunks []string
+	}{
+		{"", []string{""}},
+		{"abc", []string{"abc"}},
+		{"abcd", []string{"abcd"}},
+		{"abcdefgh", []string{"abcd", "efgh"}},
+		{"abcdefghi", []string{"abcd", "efgh", "i"}},
+	} {
+		var got []string
+		var lasts int
+		err := Chunks(strings.NewReader(tc.input), 4, func(chunk []byte, last bool) error {
+			got = append(got, string(chunk))
+			if last {
+				lasts++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "|") != strings.Join(tc.chunks, "|") {
+			t.Errorf("%q: expected chunks %q, got %q", tc.input, tc.chunks, got)
+		}
+		if lasts != 1 {
+			t.Errorf("%q: expected exactly one last chunk, got %d", tc.input, lasts)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Package gcs provides a payload store backed by Google Cloud Storage, using its
+JSON API.
+
+Importing this package registers the "gcs" driver, with the params "bucket",
+"prefix", "endpoint", "token" and "chunk_size" (see Config).
+*/
+package gcs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/vbatts/tar-split/tar/storage"
+	"github.com/vbatts/tar-split/tar/storage/driver"
+)
+
+const (
+	// DefaultEndpoint is the Google Cloud Storage API endpoint
+	DefaultEndpoint = "https://storage.googleapis.com"
+	// DefaultChunkSize is the size of each request of a resumable upload
+	DefaultChunkSize = 8 << 20
+	// chunkAlign is the granularity the resumable upload chunks must have
+	chunkAlign = 256 << 10
+)
+
+// Config for the Google Cloud Storage payload store
+type Config struct {
+	Bucket    string
+	Prefix    string       // prepended to each object name
+	Endpoint  string       // defaults to DefaultEndpoint
+	Token     string       // OAuth2 bearer token, if the Client does not authorize itself
+	ChunkSize int          // defaults to DefaultChunkSize, and must be a multiple of 256KiB
+	Client    *http.Client // defaults to http.DefaultClient
+}
+
+func init() {
+	driver.Register("gcs", gcsDriver{})
+}
+
+type gcsDriver struct{}
+
+func (gcsDriver) Open(params map[string]string) (storage.FileGetPutter, error) {
+	cfg := Config{
+		Bucket:   params["bucket"],
+		Prefix:   params["prefix"],
+		Endpoint: params["endpoint"],
+		Token:    params["token"],
+	}
+	if s, ok := params["chunk_size"]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("gcs: invalid chunk_size: %s", err)
+		}
+		cfg.ChunkSize = n
+	}
+	return New(cfg)
+}
+
+// New returns a FileGetPutter storing payloads as objects in a Google Cloud
+// Storage bucket.
+//
+// Payloads are uploaded with a resumable upload, one chunk per request, and
+// the final request carries the crc32c and md5 of the payload so that the
+// upload is rejected by the server if the object does not match.
+func New(cfg Config) (storage.FileGetPutter, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("gcs: bucket must be set")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = DefaultEndpoint
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	if cfg.ChunkSize == 0 {
+		cfg.ChunkSize = DefaultChunkSize
+	}
+	if cfg.ChunkSize < 0 || cfg.ChunkSize%chunkAlign != 0 {
+		return nil, fmt.Errorf("gcs: chunk size must be a multiple of %d", chunkAlign)
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &store{cfg: cfg}, nil
+}
+
+type store struct {
+	cfg Config
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (s *store) objectName(name string) string {
+	return s.cfg.Prefix + strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (s *store) do(method, u string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+	return s.cfg.Client.Do(req)
+}
+
+func (s *store) Get(name string) (io.ReadCloser, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media",
+		s.cfg.Endpoint, url.PathEscape(s.cfg.Bucket), url.PathEscape(s.objectName(name)))
+	resp, err := s.do("GET", u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, storage.ErrNoSuchFile
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("gcs: GET %s: %s", u, resp.Status)
+	}
+}
+
+func (s *store) Put(name string, r io.Reader) (int64, []byte, error) {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable&name=%s",
+		s.cfg.Endpoint, url.PathEscape(s.cfg.Bucket), url.QueryEscape(s.objectName(name)))
+	resp, err := s.do("POST", u, nil, http.Header{"Content-Length": {"0"}})
+	if err != nil {
+		return 0, nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, nil, fmt.Errorf("gcs: POST %s: %s", u, resp.Status)
+	}
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return 0, nil, errors.New("gcs: resumable upload did not return a session")
+	}
+
+	pd := storage.NewPayloadDigester()
+	c32 := crc32.New(crc32cTable)
+	md := md5.New()
+	tr := io.TeeReader(r, io.MultiWriter(pd, c32, md))
+
+	var offset int64
+	err = driver.Chunks(tr, s.cfg.ChunkSize, func(chunk []byte, last bool) error {
+		header := http.Header{}
+		end := offset + int64(len(chunk))
+		switch {
+		case last && len(chunk) == 0:
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", end))
+		case last:
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, end))
+		default:
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, end-1))
+		}
+		var sum string
+		if last {
+			sum = base64.StdEncoding.EncodeToString(c32.Sum(nil))
+			header.Set("X-Goog-Hash", "crc32c="+sum+",md5="+base64.StdEncoding.EncodeToString(md.Sum(nil)))
+		}
+
+		resp, err := s.do("PUT", session, bytes.NewReader(chunk), header)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if !last {
+			if resp.StatusCode != http.StatusPermanentRedirect {
+				return fmt.Errorf("gcs: PUT %s: %s", session, resp.Status)
+			}
+			offset = end
+			return nil
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			return fmt.Errorf("gcs: PUT %s: %s", session, resp.Status)
+		}
+		var obj struct {
+			CRC32C string `json:"crc32c"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+			return err
+		}
+		if obj.CRC32C != sum {
+			return driver.ErrChecksumMismatch
+		}
+		offset = end
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return offset, pd.Checksum(), nil
+}
@@ -0,0 +1,125 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+	"github.com/vbatts/tar-split/tar/storage/driver"
+)
+
+// fakeGCS implements just enough of the JSON API for resumable uploads and
+// media downloads.
+type fakeGCS struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	sessions map[string]*bytes.Buffer
+	requests int
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	switch {
+	case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/layers/o"):
+		name := r.URL.Query().Get("name")
+		f.sessions[name] = bytes.NewBuffer(nil)
+		w.Header().Set("Location", "http://"+r.Host+"/session/"+name)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/session/"):
+		name := strings.TrimPrefix(r.URL.Path, "/session/")
+		buf := f.sessions[name]
+		body, _ := ioutil.ReadAll(r.Body)
+		buf.Write(body)
+		if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+			w.WriteHeader(http.StatusPermanentRedirect)
+			return
+		}
+		sum := crc32.Checksum(buf.Bytes(), crc32.MakeTable(crc32.Castagnoli))
+		b := []byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+		crc := base64.StdEncoding.EncodeToString(b)
+		if !strings.Contains(r.Header.Get("X-Goog-Hash"), "crc32c="+crc) {
+			http.Error(w, "hash mismatch", http.StatusBadRequest)
+			return
+		}
+		f.objects[name] = buf.Bytes()
+		json.NewEncoder(w).Encode(map[string]string{"name": name, "crc32c": crc})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/storage/v1/b/layers/o/"):
+		obj, ok := f.objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/layers/o/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(obj)
+	default:
+		http.Error(w, fmt.Sprintf("unexpected %s %s", r.Method, r.URL), http.StatusBadRequest)
+	}
+}
+
+func TestGCS(t *testing.T) {
+	fake := &fakeGCS{objects: map[string][]byte{}, sessions: map[string]*bytes.Buffer{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	fgp, err := driver.Open("gcs", map[string]string{
+		"bucket":     "layers",
+		"prefix":     "sha256-abc/",
+		"endpoint":   srv.URL,
+		"chunk_size": fmt.Sprintf("%d", chunkAlign),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"./empty":        {},
+		"./hurr.txt":     []byte("imma hurr til I derp"),
+		"./usr/bin/derp": bytes.Repeat([]byte("derp"), chunkAlign/2), // two chunks
+	}
+	for name, body := range files {
+		fake.requests = 0
+		i, csum, err := fgp.Put(name, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if i != int64(len(body)) {
+			t.Errorf("%s: expected size %d, got %d", name, len(body), i)
+		}
+		_, expected, _ := storage.NewDiscardFilePutter().Put(name, bytes.NewReader(body))
+		if !bytes.Equal(csum, expected) {
+			t.Errorf("%s: expected checksum %x, got %x", name, expected, csum)
+		}
+		if expectedRequests := 2 + len(body)/(chunkAlign+1); fake.requests != expectedRequests {
+			t.Errorf("%s: expected %d requests, got %d", name, expectedRequests, fake.requests)
+		}
+
+		rdr, err := fgp.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rdr.Close()
+		if !bytes.Equal(got, body) {
+			t.Errorf("%s: payload did not round trip", name)
+		}
+	}
+	if _, ok := fake.objects["sha256-abc/usr/bin/derp"]; !ok {
+		t.Errorf("expected objects to be prefixed")
+	}
+
+	if _, err := fgp.Get("./nope"); err != storage.ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+}
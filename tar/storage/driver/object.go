@@ -0,0 +1,127 @@
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// DefaultPartSize is the size of each part of a multipart upload, which is
+// over the 5MiB minimum of S3
+const DefaultPartSize = 8 << 20
+
+// ObjectStore is the minimal interface of an object store, like S3 or GCS
+// (or any of their compatible services), for NewObjectFileGetPutter. It is
+// meant to be implemented in a few lines with the client of the store.
+type ObjectStore interface {
+	// PutObject stores `size` bytes from `r` as the object `key`. The size
+	// is -1 when it is not known ahead of the upload.
+	PutObject(key string, r io.Reader, size int64) error
+	// GetObject returns the content of the object `key`, or
+	// storage.ErrNoSuchFile if there is no such object.
+	GetObject(key string) (io.ReadCloser, error)
+}
+
+// MultipartObjectStore is an ObjectStore that uploads the large objects in
+// parts, like the multipart uploads of S3. Parts are numbered from 1.
+type MultipartObjectStore interface {
+	ObjectStore
+	CreateMultipartUpload(key string) (uploadID string, err error)
+	// UploadPart returns the ETag of the part, to complete the upload with
+	UploadPart(key, uploadID string, part int, r io.Reader, size int64) (etag string, err error)
+	CompleteMultipartUpload(key, uploadID string, etags []string) error
+	AbortMultipartUpload(key, uploadID string) error
+}
+
+// ObjectConfig for NewObjectFileGetPutter
+type ObjectConfig struct {
+	Store    ObjectStore
+	Prefix   string // prepended to each object key
+	PartSize int    // defaults to DefaultPartSize
+}
+
+// NewObjectFileGetPutter returns a FileGetPutter storing payloads as objects
+// of an ObjectStore, so that disassembly archives the file payloads straight
+// to remote storage.
+//
+// A payload of up to PartSize bytes is stored with a single PutObject. A
+// larger one is uploaded a part at a time if the store is a
+// MultipartObjectStore (and the upload aborted if it fails), and streamed to
+// PutObject, of an unknown size, if not.
+func NewObjectFileGetPutter(cfg ObjectConfig) (storage.FileGetPutter, error) {
+	if cfg.Store == nil {
+		return nil, errors.New("driver: object store must be set")
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = DefaultPartSize
+	}
+	if cfg.PartSize < 0 {
+		return nil, fmt.Errorf("driver: invalid part size %d", cfg.PartSize)
+	}
+	return &objectStore{cfg: cfg}, nil
+}
+
+type objectStore struct {
+	cfg ObjectConfig
+}
+
+func (s *objectStore) key(name string) string {
+	return s.cfg.Prefix + strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (s *objectStore) Get(name string) (io.ReadCloser, error) {
+	return s.cfg.Store.GetObject(s.key(name))
+}
+
+func (s *objectStore) Put(name string, r io.Reader) (int64, []byte, error) {
+	key := s.key(name)
+	pd := storage.NewPayloadDigester()
+	tr := io.TeeReader(r, pd)
+
+	// a byte over the part size tells a payload of one part from a larger one
+	var first bytes.Buffer
+	if _, err := first.ReadFrom(io.LimitReader(tr, int64(s.cfg.PartSize)+1)); err != nil {
+		return 0, nil, err
+	}
+	var err error
+	if first.Len() <= s.cfg.PartSize {
+		err = s.cfg.Store.PutObject(key, &first, int64(first.Len()))
+	} else if mos, ok := s.cfg.Store.(MultipartObjectStore); ok {
+		err = s.putMultipart(mos, key, io.MultiReader(&first, tr))
+	} else {
+		err = s.cfg.Store.PutObject(key, io.MultiReader(&first, tr), -1)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return pd.Size(), pd.Checksum(), nil
+}
+
+func (s *objectStore) putMultipart(mos MultipartObjectStore, key string, r io.Reader) error {
+	uploadID, err := mos.CreateMultipartUpload(key)
+	if err != nil {
+		return err
+	}
+	var etags []string
+	err = Chunks(r, s.cfg.PartSize, func(chunk []byte, last bool) error {
+		etag, err := mos.UploadPart(key, uploadID, len(etags)+1, bytes.NewReader(chunk), int64(len(chunk)))
+		if err != nil {
+			return err
+		}
+		etags = append(etags, etag)
+		return nil
+	})
+	if err == nil {
+		err = mos.CompleteMultipartUpload(key, uploadID, etags)
+	}
+	if err != nil {
+		mos.AbortMultipartUpload(key, uploadID)
+		return err
+	}
+	return nil
+}
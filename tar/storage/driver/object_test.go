@@ -0,0 +1,152 @@
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// memoryStore is an ObjectStore of objects in memory
+type memoryStore struct {
+	objects map[string][]byte
+	sizes   []int64
+}
+
+func (ms *memoryStore) PutObject(key string, r io.Reader, size int64) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if size >= 0 && int64(len(b)) != size {
+		return fmt.Errorf("expected %d bytes, got %d", size, len(b))
+	}
+	ms.objects[key] = b
+	ms.sizes = append(ms.sizes, size)
+	return nil
+}
+
+func (ms *memoryStore) GetObject(key string) (io.ReadCloser, error) {
+	b, ok := ms.objects[key]
+	if !ok {
+		return nil, storage.ErrNoSuchFile
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// multipartStore is a memoryStore with multipart uploads, that fails the
+// upload of part `fail`, if set
+type multipartStore struct {
+	memoryStore
+	parts   map[string][][]byte
+	aborted int
+	fail    int
+}
+
+func (ms *multipartStore) CreateMultipartUpload(key string) (string, error) {
+	id := fmt.Sprintf("upload-%d", len(ms.parts))
+	ms.parts[id] = nil
+	return id, nil
+}
+
+func (ms *multipartStore) UploadPart(key, uploadID string, part int, r io.Reader, size int64) (string, error) {
+	if part == ms.fail {
+		return "", errors.New("part failed")
+	}
+	if part != len(ms.parts[uploadID])+1 {
+		return "", fmt.Errorf("expected part %d, got %d", len(ms.parts[uploadID])+1, part)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	ms.parts[uploadID] = append(ms.parts[uploadID], b)
+	return fmt.Sprintf("etag-%d", part), nil
+}
+
+func (ms *multipartStore) CompleteMultipartUpload(key, uploadID string, etags []string) error {
+	if len(etags) != len(ms.parts[uploadID]) {
+		return fmt.Errorf("expected %d etags, got %d", len(ms.parts[uploadID]), len(etags))
+	}
+	ms.objects[key] = bytes.Join(ms.parts[uploadID], nil)
+	delete(ms.parts, uploadID)
+	return nil
+}
+
+func (ms *multipartStore) AbortMultipartUpload(key, uploadID string) error {
+	delete(ms.parts, uploadID)
+	ms.aborted++
+	return nil
+}
+
+func TestObjectFileGetPutter(t *testing.T) {
+	payloads := map[string]string{
+		"small": "a small payload",
+		"exact": strings.Repeat("e", 16),
+		"large": strings.Repeat("a large payload ", 5),
+		"empty": "",
+	}
+	single := &memoryStore{objects: map[string][]byte{}}
+	multi := &multipartStore{memoryStore: memoryStore{objects: map[string][]byte{}}, parts: map[string][][]byte{}}
+	for _, store := range []ObjectStore{single, multi} {
+		fgp, err := NewObjectFileGetPutter(ObjectConfig{Store: store, Prefix: "layers/", PartSize: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, payload := range payloads {
+			size, sum, err := fgp.Put("./"+name, strings.NewReader(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pd := storage.NewPayloadDigester()
+			io.WriteString(pd, payload)
+			if size != int64(len(payload)) || !bytes.Equal(sum, pd.Checksum()) {
+				t.Errorf("%s: unexpected size %d and checksum %x", name, size, sum)
+			}
+			rc, err := fgp.Get(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := ioutil.ReadAll(rc)
+			rc.Close()
+			if string(got) != payload {
+				t.Errorf("%s: expected %q, got %q", name, payload, got)
+			}
+		}
+		if _, err := fgp.Get("missing"); err != storage.ErrNoSuchFile {
+			t.Errorf("expected ErrNoSuchFile, got %v", err)
+		}
+	}
+	if _, ok := single.objects["layers/large"]; !ok {
+		t.Errorf("expected the objects under the prefix, got %v", single.objects)
+	}
+	var streamed int
+	for _, size := range single.sizes {
+		if size < 0 {
+			streamed++
+		}
+	}
+	if streamed != 1 {
+		t.Errorf("expected only the large payload streamed, got %v", single.sizes)
+	}
+	if len(multi.sizes) != 3 {
+		t.Errorf("expected the large payload uploaded in parts, got %v", multi.sizes)
+	}
+
+	multi.fail = 2
+	fgp, err := NewObjectFileGetPutter(ObjectConfig{Store: multi, PartSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fgp.Put("failed", strings.NewReader(payloads["large"])); err == nil {
+		t.Error("expected the failed part to fail the upload")
+	}
+	if multi.aborted != 1 || len(multi.parts) != 0 {
+		t.Errorf("expected the upload aborted, got %d aborted and %d pending", multi.aborted, len(multi.parts))
+	}
+}
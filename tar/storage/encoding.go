@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
+)
+
+// Encoding is a content-encoding, like compression, of stored file payloads
+type Encoding interface {
+	// Name of the encoding, like "gzip"
+	Name() string
+	// NewWriter returns a writer that encodes to `w`
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	// NewReader returns a reader that decodes from `r`
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	encodingsMu sync.RWMutex
+	encodings   = map[string]Encoding{}
+)
+
+// RegisterEncoding makes an Encoding available by its name. The "identity"
+// and "gzip" encodings are built in.
+func RegisterEncoding(e Encoding) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	encodings[e.Name()] = e
+}
+
+// Encodings returns the names of the registered encodings
+func Encodings() []string {
+	encodingsMu.RLock()
+	defer encodingsMu.RUnlock()
+	var names []string
+	for name := range encodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupEncoding(name string) (Encoding, error) {
+	encodingsMu.RLock()
+	defer encodingsMu.RUnlock()
+	e, ok := encodings[name]
+	if !ok {
+		return nil, fmt.Errorf("storage: unknown content-encoding %q", name)
+	}
+	return e, nil
+}
+
+func init() {
+	RegisterEncoding(identityEncoding{})
+	RegisterEncoding(gzipEncoding{})
+}
+
+type identityEncoding struct{}
+
+func (identityEncoding) Name() string { return "identity" }
+func (identityEncoding) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+func (identityEncoding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(r), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type gzipEncoding struct{}
+
+func (gzipEncoding) Name() string { return "gzip" }
+func (gzipEncoding) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+func (gzipEncoding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// encodingMagic starts each payload stored by an EncodingFileGetPutter, and
+// is followed by the name of the encoding and a newline
+const encodingMagic = "\x00tar-split-encoding:"
+
+// ErrInvalidEncodingHeader is returned when the content-encoding recorded
+// with a stored payload can not be read.
+var ErrInvalidEncodingHeader = errors.New("storage: invalid content-encoding header")
+
+// EncodingFileGetPutter stores file payloads encoded (e.g. compressed), with
+// the content-encoding recorded in each stored object, and decodes them again
+// on Get. Payloads stored otherwise are read as they are, so it can be put in
+// front of an existing store.
+//
+// The size and checksum returned by Put are of the payload itself, rather
+// than its encoded form, so it works with all of the assembly paths.
+type EncodingFileGetPutter struct {
+	fgp      FileGetPutter
+	encoding Encoding
+}
+
+// NewEncodingFileGetPutter returns an EncodingFileGetPutter storing payloads
+// to `fgp`, with the named encoding (see RegisterEncoding).
+func NewEncodingFileGetPutter(fgp FileGetPutter, encoding string) (*EncodingFileGetPutter, error) {
+	e, err := lookupEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	return &EncodingFileGetPutter{fgp: fgp, encoding: e}, nil
+}
+
+// Put encodes the payload as it is stored
+func (efgp *EncodingFileGetPutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	pr, pw := io.Pipe()
+	type result struct {
+		size int64
+		crc  []byte
+	}
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		err := func() error {
+			if _, err := io.WriteString(pw, encodingMagic+efgp.encoding.Name()+"\n"); err != nil {
+				return err
+			}
+			ew, err := efgp.encoding.NewWriter(pw)
+			if err != nil {
+				return err
+			}
+			crcHash := crc64.New(CRCTable)
+			if res.size, err = bufpool.Copy(io.MultiWriter(ew, crcHash), r); err != nil {
+				return err
+			}
+			res.crc = crcHash.Sum(nil)
+			return ew.Close()
+		}()
+		pw.CloseWithError(err)
+		done <- res
+	}()
+
+	_, _, err := efgp.fgp.Put(name, pr)
+	pr.CloseWithError(err)
+	res := <-done
+	if err != nil {
+		return 0, nil, err
+	}
+	return res.size, res.crc, nil
+}
+
+// Get returns the decoded payload
+func (efgp *EncodingFileGetPutter) Get(name string) (io.ReadCloser, error) {
+	rc, encoding, err := efgp.GetRaw(name)
+	if err != nil {
+		return nil, err
+	}
+	e, err := lookupEncoding(encoding)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	dr, err := e.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &decodingReadCloser{ReadCloser: dr, raw: rc}, nil
+}
+
+// GetRaw returns the payload in its stored form, without decoding it, and the
+// name of its encoding. This is for passing it through as it is, e.g. to a
+// client that accepts the encoding.
+func (efgp *EncodingFileGetPutter) GetRaw(name string) (io.ReadCloser, string, error) {
+	rc, err := efgp.fgp.Get(name)
+	if err != nil {
+		return nil, "", err
+	}
+	br := bufio.NewReader(rc)
+	peek, _ := br.Peek(len(encodingMagic))
+	if !bytes.Equal(peek, []byte(encodingMagic)) {
+		return &multiReadCloser{Reader: br, c: rc}, "identity", nil
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		rc.Close()
+		return nil, "", ErrInvalidEncodingHeader
+	}
+	encoding := line[len(encodingMagic) : len(line)-1]
+	return &multiReadCloser{Reader: br, c: rc}, encoding, nil
+}
+
+type decodingReadCloser struct {
+	io.ReadCloser
+	raw io.Closer
+}
+
+func (drc *decodingReadCloser) Close() error {
+	err := drc.ReadCloser.Close()
+	if rerr := drc.raw.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
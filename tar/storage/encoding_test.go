@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type zlibEncoding struct{}
+
+func (zlibEncoding) Name() string { return "zlib" }
+func (zlibEncoding) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriter(w), nil
+}
+func (zlibEncoding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func TestEncodingFileGetPutter(t *testing.T) {
+	RegisterEncoding(zlibEncoding{})
+	payload := strings.Repeat("compress me, please. ", 1000)
+	crcHash := crc64.New(CRCTable)
+	crcHash.Write([]byte(payload))
+	expectedSum := crcHash.Sum(nil)
+
+	for _, encoding := range []string{"identity", "gzip", "zlib"} {
+		store := NewBufferFileGetPutter()
+		efgp, err := NewEncodingFileGetPutter(store, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, sum, err := efgp.Put("file", strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(payload)) || !bytes.Equal(sum, expectedSum) {
+			t.Errorf("%s: expected the size and checksum of the payload itself, got %d %x", encoding, size, sum)
+		}
+
+		stored, _ := store.Get("file")
+		storedBytes, _ := ioutil.ReadAll(stored)
+		if encoding != "identity" && len(storedBytes) >= len(payload) {
+			t.Errorf("%s: expected the stored payload to be compressed, got %d bytes", encoding, len(storedBytes))
+		}
+
+		rc, err := efgp.Get("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != payload {
+			t.Errorf("%s: expected the decoded payload, got %d bytes", encoding, len(got))
+		}
+
+		raw, rawEncoding, err := efgp.GetRaw("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rawEncoding != encoding {
+			t.Errorf("expected the encoding %q, got %q", encoding, rawEncoding)
+		}
+		if encoding == "gzip" {
+			gz, err := gzip.NewReader(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := ioutil.ReadAll(gz)
+			if string(got) != payload {
+				t.Errorf("expected the raw form to be the gzip stream")
+			}
+		}
+		raw.Close()
+	}
+}
+
+func TestEncodingFileGetPutterUnencoded(t *testing.T) {
+	store := NewBufferFileGetPutter()
+	if _, _, err := store.Put("plain", strings.NewReader("stored before")); err != nil {
+		t.Fatal(err)
+	}
+	efgp, err := NewEncodingFileGetPutter(store, "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := efgp.Get("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(rc)
+	if string(got) != "stored before" {
+		t.Errorf("expected the payload as it was stored, got %q", got)
+	}
+	if _, err := efgp.Get("missing"); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+}
+
+func TestEncodingUnknown(t *testing.T) {
+	if _, err := NewEncodingFileGetPutter(NewBufferFileGetPutter(), "brotli"); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+	store := NewBufferFileGetPutter()
+	store.Put("odd", strings.NewReader(encodingMagic+"brotli\nxxxx"))
+	efgp, _ := NewEncodingFileGetPutter(store, "gzip")
+	if _, err := efgp.Get("odd"); err == nil {
+		t.Error("expected an error for a payload of an unknown encoding")
+	}
+}
@@ -23,6 +23,14 @@ const (
 	//
 	// Its payload is to be marshalled base64 encoded.
 	SegmentType
+	// SourceType records the identity of the blob (like a compressed layer)
+	// that the archive was disassembled from, in Source. It is not part of the
+	// archive stream, so assembly skips it.
+	SourceType
+	// SummaryType is the last entry of metadata packed with a SummaryPacker,
+	// with the Summary of the entries before it. Like SourceType, assembly
+	// skips it.
+	SummaryType
 )
 
 // Entry is the structure for packing and unpacking the information read from
@@ -39,6 +47,160 @@ type Entry struct {
 	Size     int64  `json:"size,omitempty"`
 	Payload  []byte `json:"payload"` // SegmentType stores payload here; FileType stores crc64 checksum here;
 	Position int    `json:"position"`
+
+	// Partial is set on a FileType entry that is only the part of a file's
+	// payload stored in one volume of a GNU multi-volume archive. The part is
+	// Size bytes, starting at PartOffset within the file, and Payload is the
+	// checksum of just the part.
+	Partial    bool  `json:"partial,omitempty"`
+	PartOffset int64 `json:"part_offset,omitempty"`
+
+	// Sparse is set on a FileType entry of a sparse file, as the extents of
+	// its data. Only the data is in the archive; the rest of its Size is
+	// holes. SparsePacked is set when the payload was stored without the
+	// holes (see SparseFilePutter), as the data of the extents one after
+	// another, and Payload is the checksum of that.
+	Sparse       []SparseExtent `json:"sparse,omitempty"`
+	SparsePacked bool           `json:"sparse_packed,omitempty"`
+
+	// Special is set on a FileType entry for the special records of GNU
+	// incremental archives (like dumpdirs), whose payload is not a file on disk.
+	// Their payload is kept in Inline, rather than with the FilePutter.
+	Special string `json:"special,omitempty"`
+	Inline  []byte `json:"inline,omitempty"`
+
+	// Checksum is set on a FileType entry packed with a ChecksumPacker, as
+	// "<algorithm>:<hex>" of the payload, for verification stronger than the
+	// crc64 of Payload.
+	Checksum string `json:"checksum,omitempty"`
+	// Checksums are further checksums of the payload, like Checksum, in
+	// other algorithms (see ChecksumPacker.AddChecksum). Consumers that only
+	// know of Checksum still verify that one.
+	Checksums []string `json:"checksums,omitempty"`
+
+	// Link is set on a FileType entry of a link, as HardLink or SymLink, with
+	// its target in Linkname, or in LinknameRaw if it is not valid UTF-8 (like
+	// Name and NameRaw).
+	Link        string `json:"link,omitempty"`
+	Linkname    string `json:"linkname,omitempty"`
+	LinknameRaw []byte `json:"linkname_raw,omitempty"`
+
+	// Chunks is set on a FileType entry packed with a ChunkPacker, as the
+	// content-defined chunks of its payload, in order.
+	Chunks []Chunk `json:"chunks,omitempty"`
+
+	// Offset is set on the entries packed with NewOffsetPacker, as where the
+	// segment, or the payload of the file, is in the archive.
+	Offset int64 `json:"offset,omitempty"`
+
+	// Annotations are arbitrary results attached to a FileType entry, like
+	// those of a Scanner.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Extensions are opaque values attached to an entry of any type by
+	// projects built on tar-split, keyed by a name in their own namespace
+	// (like "io.containerd.snapshot"). They are packed and unpacked as they
+	// are, and as the Unpackers skip what they do not know, metadata with
+	// them is still read by versions of tar-split that predate them.
+	Extensions map[string][]byte `json:"extensions,omitempty"`
+
+	// Ref is set on a SegmentType entry packed without its payload, as it is
+	// the same padding as the entry at position Ref (see
+	// NewCompactJSONPacker). The Unpacker fills in the Payload, so it is only
+	// seen in the packed metadata.
+	Ref int `json:"ref,omitempty"`
+
+	// Encoding is set on a SegmentType entry whose payload is packed encoded
+	// (see NewSegmentEncodingPacker). Like Ref, it is only seen in the packed
+	// metadata, as the Unpacker decodes the payload.
+	Encoding string `json:"encoding,omitempty"`
+
+	// Source is set on a SourceType entry
+	Source *Source `json:"source,omitempty"`
+
+	// Summary is set on a SummaryType entry
+	Summary *Summary `json:"summary,omitempty"`
+}
+
+// Source is the identity of the blob that an archive was disassembled from,
+// which may be the archive compressed, rather than the tar stream itself.
+type Source struct {
+	// Digest of the blob, like "sha256:<hex>"
+	Digest string `json:"digest"`
+	// Size of the blob
+	Size int64 `json:"size"`
+	// Compression of the blob, like "gzip", or "" if it is the tar stream
+	Compression string `json:"compression,omitempty"`
+	// Gzip is set for a gzip blob that was fingerprinted (see
+	// asm.FingerprintGzip), to compress the archive to the same blob again
+	Gzip *GzipParams `json:"gzip,omitempty"`
+}
+
+// GzipParams are how a gzip blob was compressed, as far as it is known
+type GzipParams struct {
+	// Members of the blob, of which there is one, unless it was compressed in
+	// parallel (like by pigz) or concatenated
+	Members []GzipMember `json:"members"`
+	// Reproducible is whether compress/gzip at Level compresses each member
+	// to the bytes of the blob
+	Reproducible bool `json:"reproducible,omitempty"`
+	Level        int  `json:"level,omitempty"`
+}
+
+// GzipMember is the header of a member of a gzip blob, and the size of the
+// data in it
+type GzipMember struct {
+	Name    string `json:"name,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	Extra   []byte `json:"extra,omitempty"`
+	// ModTime is in seconds since the epoch, or 0 if it is not set
+	ModTime int64 `json:"mtime,omitempty"`
+	OS      byte  `json:"os"`
+	// Size of the member's data, uncompressed
+	Size int64 `json:"size"`
+}
+
+// Special entries of GNU incremental archives
+const (
+	// GNUDumpDir is a directory, with its payload listing the directory's
+	// contents at the time of the backup
+	GNUDumpDir = "gnu-dumpdir"
+	// GNUNames is an old GNU record of renames and symbolic links to restore
+	GNUNames = "gnu-names"
+)
+
+// Kinds of link, of Entry.Link
+const (
+	HardLink = "hardlink"
+	SymLink  = "symlink"
+)
+
+// SetLink sets the kind and target of a link, checking the target for valid
+// UTF-8 like SetName
+func (e *Entry) SetLink(kind, target string) {
+	e.Link = kind
+	if utf8.ValidString(target) {
+		e.Linkname = target
+	} else {
+		e.LinknameRaw = []byte(target)
+	}
+}
+
+// GetLinkname returns the target of a link, regardless of the field stored in
+func (e *Entry) GetLinkname() string {
+	if len(e.LinknameRaw) > 0 {
+		return string(e.LinknameRaw)
+	}
+	return e.Linkname
+}
+
+// AllChecksums returns the Checksum of the entry, if any, followed by its
+// further Checksums
+func (e *Entry) AllChecksums() []string {
+	if e.Checksum == "" {
+		return e.Checksums
+	}
+	return append([]string{e.Checksum}, e.Checksums...)
 }
 
 // SetName will check name for valid UTF-8 string, and set the appropriate
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"io"
+	"sort"
+)
+
+// FileLister is implemented by payload stores that can enumerate the names of
+// the payloads they hold.
+type FileLister interface {
+	// List returns the names of all the stored payloads
+	List() ([]string, error)
+}
+
+// FileDeleter is implemented by payload stores that can remove payloads.
+type FileDeleter interface {
+	// Delete removes the payload stored for the name
+	Delete(name string) error
+}
+
+// payloadNamer is implemented by payload stores that keep the payloads by
+// other names than those of the entries, like those of
+// NewNormalizingFileGetPutter
+type payloadNamer interface {
+	// payloadName returns the name the payload of the entry `name` is
+	// stored by
+	payloadName(name string) string
+}
+
+// RefCounts reads each of the Unpackers to the end, and returns the number of
+// entries (across all of them) that reference each payload name.
+//
+// Only FileType entries with a Size > 0 are counted, since only those have a
+// payload stored by a FilePutter.
+func RefCounts(ups ...Unpacker) (map[string]int, error) {
+	return RefCountsFunc(func(name string) string { return name }, ups...)
+}
+
+// RefCountsFunc is RefCounts with the payload names being `key` of the names
+// of the entries, for stores that put the payloads by other names, like
+// normalized ones.
+func RefCountsFunc(key func(name string) string, ups ...Unpacker) (map[string]int, error) {
+	refs := map[string]int{}
+	for _, up := range ups {
+		for {
+			entry, err := up.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, err
+			}
+			if entry.Type == FileType && entry.Size > 0 {
+				refs[key(entry.GetName())]++
+			}
+		}
+	}
+	return refs, nil
+}
+
+// Unreferenced returns the sorted names of the payloads listed by `fl` that
+// are not referenced by any of the metadata streams.
+//
+// The Unpackers must cover every metadata stream sharing the store, otherwise
+// payloads still in use will be reported. If `fl` puts the payloads by other
+// names than those of the entries, as one of NewNormalizingFileGetPutter does,
+// the references are counted by those names.
+func Unreferenced(fl FileLister, ups ...Unpacker) ([]string, error) {
+	key := func(name string) string { return name }
+	if pn, ok := fl.(payloadNamer); ok {
+		key = pn.payloadName
+	}
+	refs, err := RefCountsFunc(key, ups...)
+	if err != nil {
+		return nil, err
+	}
+	names, err := fl.List()
+	if err != nil {
+		return nil, err
+	}
+	unused := []string{}
+	for _, name := range names {
+		if refs[name] == 0 {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused, nil
+}
+
+// CollectGarbage deletes the payloads of the store that are not referenced by
+// any of the metadata streams, and returns the names deleted.
+//
+// Like Unreferenced, every metadata stream sharing the store must be provided,
+// and no disassembly may be Putting to the store meanwhile.
+func CollectGarbage(store interface {
+	FileLister
+	FileDeleter
+}, ups ...Unpacker) ([]string, error) {
+	unused, err := Unreferenced(store, ups...)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range unused {
+		if err := store.Delete(name); err != nil {
+			return unused[:i], err
+		}
+	}
+	return unused, nil
+}
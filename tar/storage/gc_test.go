@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	fgp := NewBufferFileGetPutter()
+	for _, name := range []string{"./a", "./b", "./c", "./d"} {
+		if _, _, err := fgp.Put(name, bytes.NewBufferString(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pack := func(names ...string) Unpacker {
+		buf := bytes.NewBuffer(nil)
+		jp := NewJSONPacker(buf)
+		for _, name := range names {
+			if _, err := jp.AddEntry(Entry{Type: FileType, Name: name, Size: 3}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := jp.AddEntry(Entry{Type: FileType, Name: "./dir", Size: 0}); err != nil {
+			t.Fatal(err)
+		}
+		return NewJSONUnpacker(buf)
+	}
+
+	refs, err := RefCounts(pack("./a", "./b"), pack("./a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs["./a"] != 2 || refs["./b"] != 1 || len(refs) != 2 {
+		t.Errorf("unexpected reference counts %v", refs)
+	}
+
+	store := fgp.(interface {
+		FileLister
+		FileDeleter
+	})
+	deleted, err := CollectGarbage(store, pack("./a", "./b"), pack("./a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 || deleted[0] != "./c" || deleted[1] != "./d" {
+		t.Errorf("expected ./c and ./d to be deleted, got %v", deleted)
+	}
+	names, _ := store.List()
+	if len(names) != 2 {
+		t.Errorf("expected 2 payloads left, got %v", names)
+	}
+	if _, err := fgp.Get("./c"); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+}
@@ -2,11 +2,14 @@ package storage
 
 import (
 	"bytes"
-	"errors"
 	"hash/crc64"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
+
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
 )
 
 // FileGetter is the interface for getting a stream of a file payload,
@@ -43,31 +46,169 @@ type pathFileGetter struct {
 }
 
 func (pfg pathFileGetter) Get(filename string) (io.ReadCloser, error) {
+	unlock, err := lockDir(pfg.root, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	return os.Open(filepath.Join(pfg.root, filename))
 }
 
+// NewPathFilePutter returns a FilePutter that stores payloads as files
+// relative to path relpath, creating their directories as needed. Names can
+// not escape relpath. It is safe for concurrent use.
+//
+// Each payload is written to a temporary file and renamed into place, under
+// an advisory lock of the directory, so several processes can share it
+// without torn writes, or reads of partly written payloads.
+func NewPathFilePutter(relpath string) FilePutter {
+	return &pathFilePutter{root: relpath}
+}
+
+type pathFilePutter struct {
+	root string
+}
+
+func (pfp pathFilePutter) Put(filename string, r io.Reader) (int64, []byte, error) {
+	p := filepath.Join(pfp.root, filepath.Clean(string(os.PathSeparator)+filename))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return 0, nil, err
+	}
+	fh, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".tmp-")
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		// after the rename, there is nothing left to remove
+		fh.Close()
+		os.Remove(fh.Name())
+	}()
+	pd := NewPayloadDigester()
+	if _, err := bufpool.Copy(io.MultiWriter(pd, fh), r); err != nil {
+		return 0, nil, err
+	}
+	if err := fh.Chmod(0644); err != nil {
+		return 0, nil, err
+	}
+	if err := fh.Close(); err != nil {
+		return 0, nil, err
+	}
+	unlock, err := lockDir(pfp.root, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer unlock()
+	if err := os.Rename(fh.Name(), p); err != nil {
+		return 0, nil, err
+	}
+	return pd.Size(), pd.Checksum(), nil
+}
+
+// NewPathFileGetPutter returns a FileGetPutter of the files relative to path
+// relpath, combining NewPathFileGetter and NewPathFilePutter.
+func NewPathFileGetPutter(relpath string) FileGetPutter {
+	return struct {
+		FileGetter
+		FilePutter
+	}{NewPathFileGetter(relpath), NewPathFilePutter(relpath)}
+}
+
 type bufferFileGetPutter struct {
+	mu    sync.RWMutex
 	files map[string][]byte
+	bytes int64 // size of all of the files
 }
 
-func (bfgp bufferFileGetPutter) Get(name string) (io.ReadCloser, error) {
-	if _, ok := bfgp.files[name]; !ok {
-		return nil, errors.New("no such file")
+func (bfgp *bufferFileGetPutter) Get(name string) (io.ReadCloser, error) {
+	bfgp.mu.RLock()
+	defer bfgp.mu.RUnlock()
+	b, ok := bfgp.files[name]
+	if !ok {
+		return nil, ErrNoSuchFile
 	}
-	b := bytes.NewBuffer(bfgp.files[name])
-	return &readCloserWrapper{b}, nil
+	// the payload is replaced rather than written to, so it can be read on
+	// after the lock is let go
+	return &readCloserWrapper{bytes.NewReader(b)}, nil
 }
 
 func (bfgp *bufferFileGetPutter) Put(name string, r io.Reader) (int64, []byte, error) {
-	crc := crc64.New(CRCTable)
+	pd := NewPayloadDigester()
 	buf := bytes.NewBuffer(nil)
-	cw := io.MultiWriter(crc, buf)
-	i, err := io.Copy(cw, r)
-	if err != nil {
+	if _, err := bufpool.Copy(io.MultiWriter(pd, buf), r); err != nil {
 		return 0, nil, err
 	}
+	bfgp.mu.Lock()
+	bfgp.bytes += int64(buf.Len() - len(bfgp.files[name]))
 	bfgp.files[name] = buf.Bytes()
-	return i, crc.Sum(nil), nil
+	bfgp.mu.Unlock()
+	return pd.Size(), pd.Checksum(), nil
+}
+
+func (bfgp *bufferFileGetPutter) List() ([]string, error) {
+	bfgp.mu.RLock()
+	defer bfgp.mu.RUnlock()
+	names := []string{}
+	for name := range bfgp.files {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (bfgp *bufferFileGetPutter) Delete(name string) error {
+	bfgp.mu.Lock()
+	defer bfgp.mu.Unlock()
+	if _, ok := bfgp.files[name]; !ok {
+		return ErrNoSuchFile
+	}
+	bfgp.bytes -= int64(len(bfgp.files[name]))
+	delete(bfgp.files, name)
+	return nil
+}
+
+func (bfgp *bufferFileGetPutter) Stats() Stats {
+	bfgp.mu.RLock()
+	defer bfgp.mu.RUnlock()
+	return Stats{Files: len(bfgp.files), Bytes: bfgp.bytes}
+}
+
+func (bfgp *bufferFileGetPutter) Sizes() map[string]int64 {
+	bfgp.mu.RLock()
+	defer bfgp.mu.RUnlock()
+	sizes := make(map[string]int64, len(bfgp.files))
+	for name, b := range bfgp.files {
+		sizes[name] = int64(len(b))
+	}
+	return sizes
+}
+
+// Stats is the usage of a payload store
+type Stats struct {
+	// Files is the number of payloads stored
+	Files int
+	// Bytes is the size of all the stored payloads
+	Bytes int64
+}
+
+// FileStatter is implemented by payload stores that can report their usage,
+// e.g. for the caller to enforce limits or export metrics.
+type FileStatter interface {
+	// Stats returns the current usage of the store
+	Stats() Stats
+	// Sizes returns the size of each stored payload, by name
+	Sizes() map[string]int64
+}
+
+// DigestFileGetter is a FileGetter of a content-addressed store, that can get
+// a payload by the digest of its content, rather than the name of its entry,
+// as when the payloads of many entries and layers are stored once. Assembly
+// gets the payload of an entry by the digests of its checksums first, if the
+// FileGetter is one.
+type DigestFileGetter interface {
+	FileGetter
+	// GetByDigest returns a stream of the payload whose digest in
+	// `algorithm`, like "sha256", is the hex `sum`. It is ErrNoSuchFile if
+	// the store has none, or does not address payloads by the algorithm.
+	GetByDigest(algorithm, sum string) (io.ReadCloser, error)
 }
 
 type readCloserWrapper struct {
@@ -76,10 +217,103 @@ type readCloserWrapper struct {
 
 func (w *readCloserWrapper) Close() error { return nil }
 
-// NewBufferFileGetPutter is a simple in-memory FileGetPutter
+// NewFallbackFileGetter returns a FileGetter that gets each payload from the
+// first of `getters` that has it, as when taking what payloads are at hand
+// locally, and fetching the rest from a remote store. If none has it, the
+// error is that of the last one.
+func NewFallbackFileGetter(getters ...FileGetter) FileGetter {
+	return NewMultiFileGetter(getters...)
+}
+
+// NewMultiFileGetter returns a MultiFileGetter of `getters`, tried in order
+// for each payload, like a local cache, then the root filesystem, then a
+// remote blob, for assembling from tiered storage.
+func NewMultiFileGetter(getters ...FileGetter) *MultiFileGetter {
+	return &MultiFileGetter{
+		getters: getters,
+		stats:   make([]GetterStats, len(getters)),
+	}
+}
+
+// MultiFileGetter is a FileGetter that gets each payload from the first of its
+// getters that has it, as NewFallbackFileGetter, counting the payloads that
+// each got and did not. If none has it, the error is that of the last one.
+type MultiFileGetter struct {
+	getters []FileGetter
+	mu      sync.Mutex
+	stats   []GetterStats
+}
+
+// GetterStats are the gets of a getter of a MultiFileGetter
+type GetterStats struct {
+	// Hits are the payloads the getter had
+	Hits int64 `json:"hits"`
+	// Misses are the payloads it failed to get, that were then tried in the
+	// getters after it, if any
+	Misses int64 `json:"misses"`
+}
+
+// Get the payload of `filename` from the first getter that has it
+func (mfg *MultiFileGetter) Get(filename string) (io.ReadCloser, error) {
+	err := error(ErrNoSuchFile)
+	for i, fg := range mfg.getters {
+		var rc io.ReadCloser
+		rc, err = fg.Get(filename)
+		mfg.mu.Lock()
+		if err == nil {
+			mfg.stats[i].Hits++
+		} else {
+			mfg.stats[i].Misses++
+		}
+		mfg.mu.Unlock()
+		if err == nil {
+			return rc, nil
+		}
+	}
+	return nil, err
+}
+
+// GetByDigest gets the payload of the digest from the first of the getters
+// that is a DigestFileGetter and has it, counting the gets of those only. It
+// is ErrNoSuchFile if none is one.
+func (mfg *MultiFileGetter) GetByDigest(algorithm, sum string) (io.ReadCloser, error) {
+	err := error(ErrNoSuchFile)
+	for i, fg := range mfg.getters {
+		dfg, ok := fg.(DigestFileGetter)
+		if !ok {
+			continue
+		}
+		var rc io.ReadCloser
+		rc, err = dfg.GetByDigest(algorithm, sum)
+		mfg.mu.Lock()
+		if err == nil {
+			mfg.stats[i].Hits++
+		} else {
+			mfg.stats[i].Misses++
+		}
+		mfg.mu.Unlock()
+		if err == nil {
+			return rc, nil
+		}
+	}
+	return nil, err
+}
+
+// Stats returns the gets of each of the getters so far, in their order
+func (mfg *MultiFileGetter) Stats() []GetterStats {
+	mfg.mu.Lock()
+	defer mfg.mu.Unlock()
+	stats := make([]GetterStats, len(mfg.stats))
+	copy(stats, mfg.stats)
+	return stats
+}
+
+// NewBufferFileGetPutter is a simple in-memory FileGetPutter. It is also a
+// FileLister, FileDeleter and FileStatter, and is safe for concurrent use.
 //
 // Implication is this is memory intensive...
-// Probably best for testing or light weight cases.
+// Probably best for testing or light weight cases, or see
+// NewSpillingFileGetPutter for payloads that may be large.
 func NewBufferFileGetPutter() FileGetPutter {
 	return &bufferFileGetPutter{
 		files: map[string][]byte{},
@@ -95,9 +329,9 @@ type bitBucketFilePutter struct {
 }
 
 func (bbfp *bitBucketFilePutter) Put(name string, r io.Reader) (int64, []byte, error) {
-	c := crc64.New(CRCTable)
-	i, err := io.Copy(c, r)
-	return i, c.Sum(nil), err
+	pd := NewPayloadDigester()
+	_, err := bufpool.Copy(pd, r)
+	return pd.Size(), pd.Checksum(), err
 }
 
 // CRCTable is the default table used for crc64 sum calculations
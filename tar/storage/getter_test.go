@@ -2,9 +2,14 @@ package storage
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -64,6 +69,112 @@ func TestPutter(t *testing.T) {
 	}
 }
 
+func TestBufferStats(t *testing.T) {
+	fgp := NewBufferFileGetPutter()
+	fs := fgp.(FileStatter)
+	if st := fs.Stats(); st.Files != 0 || st.Bytes != 0 {
+		t.Errorf("expected an empty store, got %+v", st)
+	}
+	fgp.Put("file1.txt", strings.NewReader("foo"))
+	fgp.Put("file2.txt", strings.NewReader("barbaz"))
+	fgp.Put("file1.txt", strings.NewReader("fooo"))
+	if st := fs.Stats(); st.Files != 2 || st.Bytes != 10 {
+		t.Errorf("expected 2 files of 10 bytes, got %+v", st)
+	}
+	sizes := fs.Sizes()
+	if len(sizes) != 2 || sizes["file1.txt"] != 4 || sizes["file2.txt"] != 6 {
+		t.Errorf("unexpected sizes %v", sizes)
+	}
+	fgp.(FileDeleter).Delete("file2.txt")
+	if st := fs.Stats(); st.Files != 1 || st.Bytes != 4 {
+		t.Errorf("expected 1 file of 4 bytes, got %+v", st)
+	}
+}
+
+func TestPathFilePutter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "path-putter.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fp := NewPathFilePutter(dir)
+	for _, name := range []string{"./etc/hostname", "../../escape.txt"} {
+		if _, csum, err := fp.Put(name, bytes.NewBufferString("foo")); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(csum, []byte{60, 60, 48, 48, 0, 0, 0, 0}) {
+			t.Errorf("checksum on %q: got %v", name, csum)
+		}
+	}
+	fg := NewPathFileGetter(dir)
+	for _, name := range []string{"etc/hostname", "escape.txt"} {
+		rdr, err := fg.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := ioutil.ReadAll(rdr)
+		rdr.Close()
+		if string(buf) != "foo" {
+			t.Errorf("%q: expected %q, got %q", name, "foo", buf)
+		}
+	}
+}
+
+func TestPathFileGetPutterShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "path-putter.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a read only directory has no lock file to wait for
+	if _, err := NewPathFileGetter(dir).Get("missing"); !os.IsNotExist(err) {
+		t.Errorf("expected the file not to exist, got %v", err)
+	}
+
+	bodies := []string{strings.Repeat("a", 1<<16), strings.Repeat("b", 1<<17)}
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := NewPathFileGetPutter(dir).Put("shared/file", strings.NewReader(bodies[i%2])); err != nil {
+				errs <- err
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			rdr, err := NewPathFileGetPutter(dir).Get("shared/file")
+			if os.IsNotExist(err) {
+				return
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer rdr.Close()
+			buf, _ := ioutil.ReadAll(rdr)
+			if string(buf) != bodies[0] && string(buf) != bodies[1] {
+				errs <- fmt.Errorf("read a partly written payload of %d bytes", len(buf))
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(dir, "shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "file" {
+		t.Errorf("expected only the payload, with no temporary files left, got %d files", len(infos))
+	}
+}
+
 func BenchmarkPutter(b *testing.B) {
 	files := []string{
 		strings.Repeat("foo", 1000),
@@ -82,3 +193,79 @@ func BenchmarkPutter(b *testing.B) {
 		}
 	}
 }
+
+func TestFallbackFileGetter(t *testing.T) {
+	local, remote := NewBufferFileGetPutter(), NewBufferFileGetPutter()
+	local.Put("here", strings.NewReader("local"))
+	remote.Put("here", strings.NewReader("remote"))
+	remote.Put("there", strings.NewReader("remote"))
+	counting := &countingFileGetter{FileGetter: remote}
+	fg := NewFallbackFileGetter(local, counting)
+	if got := readPayload(t, fg, "here"); got != "local" {
+		t.Errorf("expected the local payload, got %q", got)
+	}
+	if got := readPayload(t, fg, "there"); got != "remote" {
+		t.Errorf("expected the remote payload, got %q", got)
+	}
+	if counting.gets != 1 {
+		t.Errorf("expected 1 remote get, got %d", counting.gets)
+	}
+	if _, err := fg.Get("nowhere"); err == nil {
+		t.Error("expected an error for a payload in neither")
+	}
+}
+
+func TestMultiFileGetter(t *testing.T) {
+	cache, rootfs, remote := NewBufferFileGetPutter(), NewBufferFileGetPutter(), NewBufferFileGetPutter()
+	cache.Put("a", strings.NewReader("cached"))
+	rootfs.Put("a", strings.NewReader("rootfs"))
+	rootfs.Put("b", strings.NewReader("rootfs"))
+	remote.Put("c", strings.NewReader("remote"))
+	mfg := NewMultiFileGetter(cache, rootfs, remote)
+	for name, expected := range map[string]string{"a": "cached", "b": "rootfs", "c": "remote"} {
+		if got := readPayload(t, mfg, name); got != expected {
+			t.Errorf("%s: expected the %s payload, got %q", name, expected, got)
+		}
+	}
+	if _, err := mfg.Get("d"); err == nil {
+		t.Error("expected an error for a payload in none")
+	}
+	expected := []GetterStats{{Hits: 1, Misses: 3}, {Hits: 1, Misses: 2}, {Hits: 1, Misses: 1}}
+	stats := mfg.Stats()
+	if len(stats) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, stats)
+	}
+	for i := range expected {
+		if stats[i] != expected[i] {
+			t.Errorf("getter %d: expected %+v, got %+v", i, expected[i], stats[i])
+		}
+	}
+}
+
+func TestMultiFileGetterByDigest(t *testing.T) {
+	root, err := ioutil.TempDir("", "multi-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	cas := NewCASFileGetPutter(root)
+	cas.Put("a", strings.NewReader("content"))
+	mfg := NewMultiFileGetter(NewBufferFileGetPutter(), NewCASFileGetter(root, nil), cas)
+
+	sum := sha256.Sum256([]byte("content"))
+	rc, err := mfg.GetByDigest("sha256", hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if _, err := mfg.GetByDigest("sha256", strings.Repeat("0", 64)); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+	// the buffer is not a DigestFileGetter, so it is not counted
+	expected := []GetterStats{{}, {Hits: 1, Misses: 1}, {Misses: 1}}
+	for i, stats := range mfg.Stats() {
+		if stats != expected[i] {
+			t.Errorf("getter %d: expected %+v, got %+v", i, expected[i], stats)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNoRanges is returned by NewHTTPReaderAt when the server does not serve
+// byte ranges of the blob
+var ErrNoRanges = errors.New("storage: the server does not serve byte ranges")
+
+// DefaultHTTPReadAhead is the least number of bytes an HTTPReaderAt requests
+// at once
+const DefaultHTTPReadAhead = 1 << 20
+
+// HTTPReaderAt reads a remote blob by HTTP range requests. With
+// NewTarFileGetter, it serves the payloads of an uncompressed archive from
+// where it is published, fetching only the payloads that are read.
+//
+// Each request is of at least ReadAhead bytes, and the last response is kept,
+// as the payloads are usually read in order and in small reads. It is safe for
+// concurrent use.
+type HTTPReaderAt struct {
+	// ReadAhead is the least number of bytes requested at once
+	ReadAhead int64
+
+	client *http.Client
+	url    string
+	size   int64
+
+	mu     sync.Mutex
+	buf    []byte
+	bufOff int64
+}
+
+// NewHTTPReaderAt returns an HTTPReaderAt of the blob at `url`, requested
+// with `client`, or http.DefaultClient if it is nil. The first byte is
+// requested for the size of the blob, and to check that byte ranges are
+// served, or the error is ErrNoRanges.
+func NewHTTPReaderAt(client *http.Client, url string) (*HTTPReaderAt, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hra := &HTTPReaderAt{ReadAhead: DefaultHTTPReadAhead, client: client, url: url}
+	resp, err := hra.get(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	// like "bytes 0-0/1234"
+	cr := resp.Header.Get("Content-Range")
+	i := strings.LastIndex(cr, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("storage: GET %s: invalid Content-Range %q", url, cr)
+	}
+	if hra.size, err = strconv.ParseInt(cr[i+1:], 10, 64); err != nil {
+		return nil, fmt.Errorf("storage: GET %s: invalid Content-Range %q", url, cr)
+	}
+	return hra, nil
+}
+
+// Size returns the size of the blob
+func (hra *HTTPReaderAt) Size() int64 {
+	return hra.size
+}
+
+// ReadAt reads len(p) bytes of the blob at offset `off`
+func (hra *HTTPReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("storage: negative offset")
+	}
+	var n int
+	for n < len(p) {
+		if off >= hra.size {
+			return n, io.EOF
+		}
+		hra.mu.Lock()
+		if off < hra.bufOff || off >= hra.bufOff+int64(len(hra.buf)) {
+			if err := hra.fill(off, int64(len(p)-n)); err != nil {
+				hra.mu.Unlock()
+				return n, err
+			}
+		}
+		c := copy(p[n:], hra.buf[off-hra.bufOff:])
+		hra.mu.Unlock()
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// fill requests at least `want` bytes at `off` into buf
+func (hra *HTTPReaderAt) fill(off, want int64) error {
+	if want < hra.ReadAhead {
+		want = hra.ReadAhead
+	}
+	end := off + want - 1
+	if end >= hra.size {
+		end = hra.size - 1
+	}
+	resp, err := hra.get(off, end)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, end-off+1)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return err
+	}
+	hra.buf, hra.bufOff = buf, off
+	return nil
+}
+
+// get requests the bytes from `start` to `end`, inclusive
+func (hra *HTTPReaderAt) get(start, end int64) (*http.Response, error) {
+	return getRange(hra.client, hra.url, start, end)
+}
+
+// getRange requests the bytes of the blob at `url` from `start` to `end`,
+// inclusive
+func getRange(client *http.Client, url string, start, end int64) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp, nil
+	case http.StatusOK:
+		resp.Body.Close()
+		return nil, ErrNoRanges
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNoSuchFile
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("storage: GET %s: %s", url, resp.Status)
+	}
+}
+
+// ErrNoOffsets is returned by NewHTTPRangeFileGetter for metadata that was
+// not packed with the offsets of its entries (see NewOffsetPacker)
+var ErrNoOffsets = errors.New("storage: the metadata has no offsets of its entries")
+
+// NewHTTPRangeFileGetter returns a FileGetter of the payloads of the
+// uncompressed archive at `url`, each fetched by one range request, as the
+// offsets recorded in the metadata `up` (see NewOffsetPacker) say where it
+// is. Unlike NewTarFileGetter with an HTTPReaderAt, no more than the payload
+// is requested, nor is the blob requested before a payload is.
+//
+// `up` is read to the end, so the metadata must be read again for the
+// assembly itself. `client` is http.DefaultClient if it is nil.
+func NewHTTPRangeFileGetter(client *http.Client, url string, up Unpacker) (FileGetter, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hfg := &httpRangeFileGetter{client: client, url: url, entries: map[string]blobExtent{}}
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if entry.Type != FileType || entry.Size == 0 || entry.Special != "" {
+			continue
+		}
+		// a file is never at the start of the archive, before its header
+		if entry.Offset == 0 {
+			return nil, ErrNoOffsets
+		}
+		be := blobExtent{offset: entry.Offset, size: entry.DataSize()}
+		if !entry.SparsePacked {
+			be.sparse, be.fileSize = entry.Sparse, entry.Size
+		}
+		hfg.entries[filepath.Clean(entry.GetName())] = be
+	}
+	return hfg, nil
+}
+
+type httpRangeFileGetter struct {
+	client  *http.Client
+	url     string
+	entries map[string]blobExtent
+}
+
+func (hfg *httpRangeFileGetter) Get(filename string) (io.ReadCloser, error) {
+	ext, ok := hfg.entries[filepath.Clean(filename)]
+	if !ok {
+		return nil, ErrNoSuchFile
+	}
+	if ext.size == 0 {
+		// a sparse file of only holes
+		return ioutil.NopCloser(NewSparseFileReader(eofReader{}, ext.sparse, ext.fileSize)), nil
+	}
+	resp, err := getRange(hfg.client, hfg.url, ext.offset, ext.offset+ext.size-1)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = io.LimitReader(resp.Body, ext.size)
+	if len(ext.sparse) > 0 {
+		r = NewSparseFileReader(r, ext.sparse, ext.fileSize)
+	}
+	return &readCloser{Reader: r, Closer: resp.Body}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPReaderAt(t *testing.T) {
+	blob := []byte(strings.Repeat("0123456789", 1000))
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(blob))
+	}))
+	defer srv.Close()
+
+	hra, err := NewHTTPReaderAt(nil, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hra.Size() != int64(len(blob)) {
+		t.Fatalf("expected the size %d, got %d", len(blob), hra.Size())
+	}
+	hra.ReadAhead = 4096
+	requests = 0
+	got, err := ioutil.ReadAll(io.NewSectionReader(hra, 0, hra.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Error("expected the blob read back")
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests of the read ahead, got %d", requests)
+	}
+
+	p := make([]byte, 10)
+	if n, err := hra.ReadAt(p, hra.Size()-5); n != 5 || err != io.EOF {
+		t.Errorf("expected 5 bytes and io.EOF at the end, got %d and %v", n, err)
+	}
+}
+
+func TestHTTPReaderAtNoRanges(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "the whole blob")
+	}))
+	defer srv.Close()
+	if _, err := NewHTTPReaderAt(nil, srv.URL); err != ErrNoRanges {
+		t.Errorf("expected ErrNoRanges, got %v", err)
+	}
+}
+
+func TestHTTPRangeFileGetter(t *testing.T) {
+	blob := bytes.NewBuffer(nil)
+	meta := bytes.NewBuffer(nil)
+	p := NewOffsetPacker(NewJSONPacker(meta))
+	files := []struct {
+		name, body string
+	}{
+		{"./hurr.txt", "deadbeef"},
+		{"./empty", ""},
+		{"./foo/bar.txt", strings.Repeat("wow!", 300)},
+	}
+	for _, f := range files {
+		header := make([]byte, 512)
+		blob.Write(header)
+		if _, err := p.AddEntry(Entry{Type: SegmentType, Payload: header}); err != nil {
+			t.Fatal(err)
+		}
+		blob.WriteString(f.body)
+		if _, err := p.AddEntry(Entry{Type: FileType, Name: f.name, Size: int64(len(f.body))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(blob.Bytes()))
+	}))
+	defer srv.Close()
+
+	fg, err := NewHTTPRangeFileGetter(nil, srv.URL, NewJSONUnpacker(meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 0 {
+		t.Errorf("expected no requests before a Get, got %d", requests)
+	}
+	for _, f := range files {
+		if f.body == "" {
+			continue
+		}
+		rc, err := fg.Get(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != f.body {
+			t.Errorf("%q: expected %q, got %q", f.name, f.body, got)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expected a request per payload, got %d", requests)
+	}
+	if _, err := fg.Get("./nope"); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+
+	meta.Reset()
+	p = NewJSONPacker(meta)
+	if _, err := p.AddEntry(Entry{Type: FileType, Name: "./hurr.txt", Size: 8}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHTTPRangeFileGetter(nil, srv.URL, NewJSONUnpacker(meta)); err != ErrNoOffsets {
+		t.Errorf("expected ErrNoOffsets, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// NameIndex is a prefix tree of the FileType entries of metadata, by the
+// components of their paths, for listing the entries under a directory
+// without scanning all of them for each query.
+//
+// Directories that only appear as the parent of an entry (as in archives that
+// leave out directory entries) are in the index too, without an Entry.
+type NameIndex struct {
+	root indexNode
+	size int
+}
+
+type indexNode struct {
+	entry    *Entry
+	children map[string]*indexNode
+}
+
+// NewNameIndex reads `up` to the end to index its FileType entries. Names are
+// cleaned, so "./usr/lib/" and "usr/lib" are the same path.
+func NewNameIndex(up Unpacker) (*NameIndex, error) {
+	ni := &NameIndex{}
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				return ni, nil
+			}
+			return nil, err
+		}
+		if entry.Type != FileType {
+			continue
+		}
+		n := &ni.root
+		if p := cleanLayerPath(entry.GetName()); p != "." {
+			for _, c := range strings.Split(p, "/") {
+				if n.children == nil {
+					n.children = map[string]*indexNode{}
+				}
+				child, ok := n.children[c]
+				if !ok {
+					child = &indexNode{}
+					n.children[c] = child
+				}
+				n = child
+			}
+		}
+		if n.entry == nil {
+			ni.size++
+		}
+		n.entry = entry
+	}
+}
+
+// Len is the number of entries indexed
+func (ni *NameIndex) Len() int {
+	return ni.size
+}
+
+func (ni *NameIndex) find(name string) *indexNode {
+	n := &ni.root
+	p := cleanLayerPath(name)
+	if p == "." {
+		return n
+	}
+	for _, c := range strings.Split(p, "/") {
+		if n = n.children[c]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// Lookup returns the entry for the path. It is nil if the path is only a
+// parent directory of other entries, and not found is ErrNoSuchFile.
+func (ni *NameIndex) Lookup(name string) (*Entry, error) {
+	n := ni.find(name)
+	if n == nil {
+		return nil, ErrNoSuchFile
+	}
+	return n.entry, nil
+}
+
+// ReadDir returns the sorted names of the paths directly under the directory
+// `dir` ("" or "." for the top of the archive).
+func (ni *NameIndex) ReadDir(dir string) ([]string, error) {
+	n := ni.find(dir)
+	if n == nil {
+		return nil, ErrNoSuchFile
+	}
+	names := make([]string, 0, len(n.children))
+	for c := range n.children {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Walk calls fn for each of the entries under `prefix` (including itself), in
+// lexical order of their paths. The path given is the cleaned name of the
+// entry. Walk stops at the first error from fn, and returns it.
+func (ni *NameIndex) Walk(prefix string, fn func(name string, entry *Entry) error) error {
+	n := ni.find(prefix)
+	if n == nil {
+		return ErrNoSuchFile
+	}
+	return n.walk(cleanLayerPath(prefix), fn)
+}
+
+func (n *indexNode) walk(p string, fn func(string, *Entry) error) error {
+	if n.entry != nil {
+		if err := fn(p, n.entry); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(n.children))
+	for c := range n.children {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	for _, c := range names {
+		cp := c
+		if p != "." {
+			cp = p + "/" + c
+		}
+		if err := n.children[c].walk(cp, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
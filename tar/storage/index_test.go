@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNameIndex(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	p := NewJSONPacker(buf)
+	for _, name := range []string{"./", "./usr/", "./usr/lib/", "./usr/lib/libc.so", "./usr/lib/libm.so", "./usr/bin/env", "etc/hostname"} {
+		if _, err := p.AddEntry(Entry{Type: SegmentType, Payload: []byte("header")}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.AddEntry(Entry{Type: FileType, Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ni, err := NewNameIndex(NewJSONUnpacker(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ni.Len() != 7 {
+		t.Errorf("expected 7 entries, got %d", ni.Len())
+	}
+
+	for dir, expected := range map[string][]string{
+		"":             {"etc", "usr"},
+		"/usr/lib/":    {"libc.so", "libm.so"},
+		"usr":          {"bin", "lib"},
+		"etc":          {"hostname"},
+		"etc/hostname": {},
+	} {
+		names, err := ni.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("%q: expected %v, got %v", dir, expected, names)
+		}
+	}
+	if _, err := ni.ReadDir("var"); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+
+	// etc only exists as the parent of etc/hostname
+	if e, err := ni.Lookup("etc"); err != nil || e != nil {
+		t.Errorf("expected an implicit directory, got %v %v", e, err)
+	}
+	if e, err := ni.Lookup("usr/lib/libc.so"); err != nil || e.GetName() != "./usr/lib/libc.so" {
+		t.Errorf("expected the entry of libc.so, got %v %v", e, err)
+	}
+
+	var walked []string
+	err = ni.Walk("./usr", func(name string, e *Entry) error {
+		walked = append(walked, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"usr", "usr/bin/env", "usr/lib", "usr/lib/libc.so", "usr/lib/libm.so"}; !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected to walk %v, got %v", expected, walked)
+	}
+	errStop := errors.New("stop")
+	if err := ni.Walk("", func(string, *Entry) error { return errStop }); err != errStop {
+		t.Errorf("expected the error of the walk function, got %v", err)
+	}
+}
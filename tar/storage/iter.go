@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"io"
+)
+
+// PeekUnpacker is an Unpacker that can look at the next entry before it is
+// unpacked, and skip entries, so that consumers scanning the metadata can
+// pass over the parts of it they have no use for.
+//
+// Skipped entries are still decoded from the underlying Unpacker, as the
+// packed formats have no index to seek with, but they are not handed on.
+type PeekUnpacker struct {
+	up     Unpacker
+	peeked bool
+	entry  *Entry
+	err    error
+}
+
+// NewPeekUnpacker returns a PeekUnpacker of the entries of `up`. If `up` is
+// already a *PeekUnpacker, it is returned as is.
+func NewPeekUnpacker(up Unpacker) *PeekUnpacker {
+	if pu, ok := up.(*PeekUnpacker); ok {
+		return pu
+	}
+	return &PeekUnpacker{up: up}
+}
+
+// Next returns the next entry, or the entry last returned by Peek
+func (pu *PeekUnpacker) Next() (*Entry, error) {
+	if pu.peeked {
+		entry, err := pu.entry, pu.err
+		pu.peeked, pu.entry = false, nil
+		if err != nil {
+			// errors, like io.EOF, are returned every time
+			pu.peeked = true
+		}
+		return entry, err
+	}
+	return pu.up.Next()
+}
+
+// Peek returns the next entry, without unpacking it, so that the next call
+// to Next or Peek returns it again.
+func (pu *PeekUnpacker) Peek() (*Entry, error) {
+	if !pu.peeked {
+		pu.entry, pu.err = pu.up.Next()
+		pu.peeked = true
+	}
+	return pu.entry, pu.err
+}
+
+// Skip passes over the next `n` entries, returning how many were skipped. If
+// the metadata ends before then, io.EOF is returned along with the count.
+func (pu *PeekUnpacker) Skip(n int) (int, error) {
+	for i := 0; i < n; i++ {
+		if _, err := pu.Next(); err != nil {
+			return i, err
+		}
+	}
+	return n, nil
+}
+
+// SkipWhile passes over the entries for which `f` returns true, until the
+// next one for which it returns false, which is left to be unpacked. It
+// returns how many were skipped, and io.EOF if the metadata ends first.
+func (pu *PeekUnpacker) SkipWhile(f func(*Entry) bool) (int, error) {
+	var n int
+	for {
+		entry, err := pu.Peek()
+		if err != nil {
+			return n, err
+		}
+		if !f(entry) {
+			return n, nil
+		}
+		pu.Next()
+		n++
+	}
+}
+
+// NewEntryChannel unpacks the entries of `up` in the background, sending them
+// on the returned channel, which is closed at the end of the metadata. The
+// returned function waits for it to be closed, and returns the error that
+// ended the unpacking, if it was not io.EOF.
+//
+// If `done` is closed, the unpacking stops after the entry being sent, and
+// the error is io.ErrClosedPipe. A consumer that stops reading the channel
+// early must close `done`, or the background unpacking is left blocked.
+func NewEntryChannel(up Unpacker, done <-chan struct{}) (<-chan *Entry, func() error) {
+	c := make(chan *Entry)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(c)
+		for {
+			entry, err := up.Next()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				errc <- err
+				return
+			}
+			select {
+			case c <- entry:
+			case <-done:
+				errc <- io.ErrClosedPipe
+				return
+			}
+		}
+	}()
+	var err error
+	var waited bool
+	return c, func() error {
+		if !waited {
+			err, waited = <-errc, true
+		}
+		return err
+	}
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// packedEntries returns the JSON metadata of a segment and a file, `n` times
+func packedEntries(t testing.TB, n int) []byte {
+	var buf bytes.Buffer
+	p := NewJSONPacker(&buf)
+	for i := 0; i < n; i++ {
+		if _, err := p.AddEntry(Entry{Type: SegmentType, Payload: []byte("header")}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.AddEntry(Entry{Type: FileType, Name: string(rune('a' + i)), Size: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestPeekUnpacker(t *testing.T) {
+	pu := NewPeekUnpacker(NewJSONUnpacker(bytes.NewReader(packedEntries(t, 3))))
+	if NewPeekUnpacker(pu) != pu {
+		t.Errorf("expected a PeekUnpacker to be returned as is")
+	}
+	for i := 0; i < 2; i++ {
+		entry, err := pu.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Type != SegmentType {
+			t.Errorf("expected the segment to be peeked, got %#v", entry)
+		}
+	}
+	if n, err := pu.Skip(1); n != 1 || err != nil {
+		t.Errorf("expected 1 entry skipped, got %d, %v", n, err)
+	}
+	entry, err := pu.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.GetName() != "a" {
+		t.Errorf("expected file %q, got %#v", "a", entry)
+	}
+
+	// pass over the headers to the files
+	var names []string
+	for {
+		n, err := pu.SkipWhile(func(e *Entry) bool { return e.Type == SegmentType })
+		if err == io.EOF {
+			break
+		}
+		if err != nil || n != 1 {
+			t.Fatalf("expected 1 segment skipped, got %d, %v", n, err)
+		}
+		entry, err := pu.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, entry.GetName())
+	}
+	if len(names) != 2 || names[0] != "b" || names[1] != "c" {
+		t.Errorf("expected files b and c, got %q", names)
+	}
+
+	if n, err := pu.Skip(2); n != 0 || err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got %d, %v", n, err)
+	}
+	if _, err := pu.Peek(); err != io.EOF {
+		t.Errorf("expected io.EOF again, got %v", err)
+	}
+}
+
+func TestNewEntryChannel(t *testing.T) {
+	c, wait := NewEntryChannel(NewJSONUnpacker(bytes.NewReader(packedEntries(t, 3))), nil)
+	var count int
+	for range c {
+		count++
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 6 {
+		t.Errorf("expected 6 entries, got %d", count)
+	}
+
+	done := make(chan struct{})
+	c, wait = NewEntryChannel(NewJSONUnpacker(bytes.NewReader(packedEntries(t, 3))), done)
+	<-c
+	close(done)
+	for range c {
+	}
+	if err := wait(); err != io.ErrClosedPipe {
+		t.Errorf("expected io.ErrClosedPipe once done, got %v", err)
+	}
+
+	c, wait = NewEntryChannel(NewJSONUnpacker(bytes.NewReader([]byte("{not json"))), nil)
+	for range c {
+	}
+	if err := wait(); err == nil {
+		t.Errorf("expected the invalid metadata to fail")
+	}
+}
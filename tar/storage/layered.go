@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	// WhiteoutPrefix is the file name prefix that marks a path as deleted
+	// from the layers below, per the OCI image layer specification.
+	WhiteoutPrefix = ".wh."
+	// WhiteoutOpaqueDir is the file name that marks its directory as opaque,
+	// hiding all of the lower layers' entries in that directory.
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+// Layer pairs the metadata of one layer of a stacked image with the
+// FileGetter for its payloads.
+type Layer struct {
+	Unpacker Unpacker
+	Getter   FileGetter
+}
+
+// NewLayeredFileGetter returns a FileGetter that resolves names across an
+// ordered stack of layers, uppermost first. The payload is returned from the
+// uppermost layer that has an entry for the name, unless a layer above it
+// has a whiteout for the name (or for one of its parent directories), or has
+// marked a parent directory as opaque.
+//
+// Each layer's Unpacker is read to the end to index its entries.
+func NewLayeredFileGetter(layers ...Layer) (FileGetter, error) {
+	lfg := &layeredFileGetter{}
+	for _, l := range layers {
+		idx := layerIndex{
+			getter: l.Getter,
+			names:  map[string]string{},
+		}
+		for {
+			entry, err := l.Unpacker.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, err
+			}
+			if entry.Type != FileType {
+				continue
+			}
+			idx.names[cleanLayerPath(entry.GetName())] = entry.GetName()
+		}
+		lfg.layers = append(lfg.layers, idx)
+	}
+	return lfg, nil
+}
+
+type layerIndex struct {
+	getter FileGetter
+	names  map[string]string // cleaned path to name as recorded in the layer
+}
+
+func (li layerIndex) has(name string) bool {
+	_, ok := li.names[name]
+	return ok
+}
+
+type layeredFileGetter struct {
+	layers []layerIndex
+}
+
+func (lfg *layeredFileGetter) Get(filename string) (io.ReadCloser, error) {
+	name := cleanLayerPath(filename)
+	for _, l := range lfg.layers {
+		if recorded, ok := l.names[name]; ok {
+			return l.getter.Get(recorded)
+		}
+		opaque := false
+		for p := name; p != "."; p = path.Dir(p) {
+			dir, base := path.Split(p)
+			if l.has(path.Join(dir, WhiteoutPrefix+base)) {
+				return nil, ErrNoSuchFile
+			}
+			if p != name && l.has(path.Join(p, WhiteoutOpaqueDir)) {
+				opaque = true
+			}
+		}
+		if opaque {
+			break
+		}
+	}
+	return nil, ErrNoSuchFile
+}
+
+// cleanLayerPath returns the name relative to the root of the layer, so that
+// "./usr/bin", "/usr/bin/" and "usr/bin" are all considered the same path.
+func cleanLayerPath(name string) string {
+	p := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func packLayer(t *testing.T, files map[string]string) Layer {
+	buf := bytes.NewBuffer(nil)
+	jp := NewJSONPacker(buf)
+	fgp := NewBufferFileGetPutter()
+	for name, body := range files {
+		size, csum, err := fgp.Put(name, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jp.AddEntry(Entry{Type: FileType, Name: name, Size: size, Payload: csum}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return Layer{Unpacker: NewJSONUnpacker(buf), Getter: fgp}
+}
+
+func TestLayeredFileGetter(t *testing.T) {
+	upper := packLayer(t, map[string]string{
+		"./etc/hosts":               "upper hosts",
+		"./etc/.wh.passwd":          "",
+		"./var/.wh.cache":           "",
+		"./opt/.wh..wh..opq":        "",
+		"./opt/app/upper-only.conf": "upper",
+	})
+	lower := packLayer(t, map[string]string{
+		"./etc/hosts":          "lower hosts",
+		"./etc/passwd":         "root:x:0:0",
+		"./etc/group":          "root:x:0:",
+		"./var/cache/foo/bar":  "cached",
+		"./opt/app/lower.conf": "lower",
+	})
+
+	fg, err := NewLayeredFileGetter(upper, lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]string{
+		"./etc/hosts":              "upper hosts",
+		"etc/group":                "root:x:0:",
+		"/opt/app/upper-only.conf": "upper",
+	}
+	for name, body := range found {
+		rdr, err := fg.Get(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(rdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rdr.Close()
+		if string(got) != body {
+			t.Errorf("%s: expected %q, got %q", name, body, got)
+		}
+	}
+
+	for _, name := range []string{"./etc/passwd", "./var/cache/foo/bar", "./opt/app/lower.conf", "./nope"} {
+		if _, err := fg.Get(name); err != ErrNoSuchFile {
+			t.Errorf("%s: expected ErrNoSuchFile, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNegativeSize occurs when an entry of the metadata declares a size below
+// zero
+var ErrNegativeSize = errors.New("storage: entry has a negative size")
+
+// Limit is one of the bounds of Limits, as reported by a LimitError
+type Limit string
+
+const (
+	// LimitNameLength is the bound of Limits.MaxNameLength
+	LimitNameLength Limit = "name-length"
+	// LimitPayloadSize is the bound of Limits.MaxPayloadSize
+	LimitPayloadSize Limit = "payload-size"
+	// LimitFileSize is the bound of Limits.MaxFileSize
+	LimitFileSize Limit = "file-size"
+	// LimitEntries is the bound of Limits.MaxEntries
+	LimitEntries Limit = "entries"
+	// LimitLineSize is the bound of Limits.MaxLineSize
+	LimitLineSize Limit = "line-size"
+)
+
+// LimitError is returned by an Unpacker of NewLimitedJSONUnpacker or
+// NewLimitingUnpacker when the metadata exceeds one of its Limits
+type LimitError struct {
+	Limit Limit
+	// Index is the count of the entries unpacked before the one exceeding
+	// the limit. Unlike its Position, which is what the metadata declares,
+	// it can be trusted.
+	Index int
+	// Size is what exceeded the limit. Lines, and segments being decoded,
+	// are not read to their end, so for them it is only the bytes read.
+	Size int64
+	Max  int64
+}
+
+func (le *LimitError) Error() string {
+	if le.Limit == LimitLineSize {
+		return fmt.Sprintf("storage: line of entry %d is longer than the %s limit of %d bytes", le.Index, le.Limit, le.Max)
+	}
+	return fmt.Sprintf("storage: entry %d exceeds the %s limit (%d > %d)", le.Index, le.Limit, le.Size, le.Max)
+}
+
+// Limits bound the memory an Unpacker takes for each entry of metadata from
+// an untrusted source, so a hostile file can not make it allocate gigabytes
+// for a declared size or a single line of JSON. A zero bound is no limit.
+type Limits struct {
+	// MaxNameLength is the most bytes of the name, or the link target, of
+	// an entry
+	MaxNameLength int
+	// MaxPayloadSize is the most bytes of the payload of an entry, like that
+	// of a segment once it is decoded, or the inline payload of a file
+	MaxPayloadSize int
+	// MaxFileSize is the largest size a file entry may declare
+	MaxFileSize int64
+	// MaxEntries is the most entries, which also bounds the names kept to
+	// find duplicates by
+	MaxEntries int
+	// MaxLineSize is the most bytes of a line of JSON metadata, which is
+	// read whole before it is decoded
+	MaxLineSize int
+}
+
+// DefaultLimits are Limits for servers unpacking untrusted metadata, well
+// above what tar archives in use need. Files of any size are allowed, as
+// their payloads are streamed.
+var DefaultLimits = Limits{
+	MaxNameLength:  64 << 10,
+	MaxPayloadSize: 16 << 20,
+	MaxEntries:     1 << 24,
+	MaxLineSize:    64 << 20,
+}
+
+// check returns a LimitError if the entry at `index` exceeds the limits
+func (l *Limits) check(index int, e *Entry) error {
+	exceeds := func(limit Limit, size, max int64) error {
+		if max > 0 && size > max {
+			return &LimitError{Limit: limit, Index: index, Size: size, Max: max}
+		}
+		return nil
+	}
+	if e.Size < 0 {
+		return ErrNegativeSize
+	}
+	for _, err := range []error{
+		exceeds(LimitEntries, int64(index)+1, int64(l.MaxEntries)),
+		exceeds(LimitNameLength, int64(len(e.GetNameBytes())), int64(l.MaxNameLength)),
+		exceeds(LimitNameLength, int64(len(e.GetLinkname())), int64(l.MaxNameLength)),
+		exceeds(LimitPayloadSize, int64(len(e.Payload)), int64(l.MaxPayloadSize)),
+		exceeds(LimitPayloadSize, int64(len(e.Inline)), int64(l.MaxPayloadSize)),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	if e.Type == FileType {
+		return exceeds(LimitFileSize, e.Size, l.MaxFileSize)
+	}
+	return nil
+}
+
+// NewLimitedJSONUnpacker is NewJSONUnpacker with the entries bounded by
+// `limits`. The lines of JSON, and the segments as they are decoded, are
+// bounded as they are read, before they take the memory, and the unpacking
+// stops at the first entry exceeding a limit, with a *LimitError.
+func NewLimitedJSONUnpacker(r io.Reader, limits Limits) Unpacker {
+	return &jsonUnpacker{
+		r:       r,
+		seen:    seenNames{},
+		padding: paddings{},
+		limits:  &limits,
+	}
+}
+
+// NewLimitedAutoUnpacker is NewAutoUnpacker with the entries bounded by
+// `limits`, as by NewLimitedJSONUnpacker for JSON, and NewLimitingUnpacker
+// for the metadata of a ProtoPacker, whose entries are bounded in size anyway
+func NewLimitedAutoUnpacker(r io.Reader, limits Limits) Unpacker {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(ProtoMagic)); string(magic) == ProtoMagic {
+		return NewLimitingUnpacker(NewProtoUnpacker(br), limits)
+	}
+	return NewLimitedJSONUnpacker(br, limits)
+}
+
+// NewLimitingUnpacker returns an Unpacker of the entries of `up` that stops
+// at the first one exceeding `limits`, with a *LimitError, for Unpackers with
+// no bounds of their own. As the entries are checked once `up` has unpacked
+// them, the metadata of JSON is better read by NewLimitedJSONUnpacker.
+func NewLimitingUnpacker(up Unpacker, limits Limits) Unpacker {
+	return &limitingUnpacker{up: up, limits: limits}
+}
+
+type limitingUnpacker struct {
+	up     Unpacker
+	limits Limits
+	n      int
+	err    error
+}
+
+func (lu *limitingUnpacker) Next() (*Entry, error) {
+	if lu.err != nil {
+		return nil, lu.err
+	}
+	e, err := lu.up.Next()
+	if err != nil {
+		return nil, err
+	}
+	if lu.err = lu.limits.check(lu.n, e); lu.err != nil {
+		return nil, lu.err
+	}
+	lu.n++
+	return e, nil
+}
+
+// lineLimitReader fails with a LimitError once a line of `r` is longer than
+// `max` bytes. The bytes past the limit are not returned, so the line can not
+// be decoded from what was read before the error.
+type lineLimitReader struct {
+	r    io.Reader
+	max  int
+	line int
+	err  error
+}
+
+func (llr *lineLimitReader) Read(p []byte) (int, error) {
+	if llr.err != nil {
+		return 0, llr.err
+	}
+	n, err := llr.r.Read(p)
+	for i := 0; i < n; {
+		j := bytes.IndexByte(p[i:n], '\n')
+		if j < 0 {
+			j = n - i
+		}
+		if llr.line+j > llr.max {
+			llr.err = &LimitError{Limit: LimitLineSize, Size: int64(llr.line + j), Max: int64(llr.max)}
+			return i + llr.max - llr.line, llr.err
+		}
+		if i+j == n {
+			llr.line += j
+			break
+		}
+		llr.line, i = 0, i+j+1
+	}
+	return n, err
+}
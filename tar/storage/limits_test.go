@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func packLimitsTest(t *testing.T, p Packer, entries ...Entry) {
+	for _, e := range entries {
+		if _, err := p.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLimitedJSONUnpacker(t *testing.T) {
+	limits := Limits{MaxNameLength: 16, MaxPayloadSize: 1024, MaxFileSize: 1 << 20, MaxEntries: 4, MaxLineSize: 4096}
+	segment := func(size int) Entry { return Entry{Type: SegmentType, Payload: bytes.Repeat([]byte{'x'}, size)} }
+	file := func(name string, size int64) Entry { return Entry{Type: FileType, Name: name, Size: size} }
+
+	for _, tc := range []struct {
+		desc    string
+		entries []Entry
+		limit   Limit
+		index   int
+	}{
+		{"within", []Entry{segment(1024), file("a", 1<<20), segment(10), file("b", 0)}, "", 0},
+		{"name", []Entry{segment(10), file(strings.Repeat("a", 17), 1)}, LimitNameLength, 1},
+		{"link", []Entry{{Type: FileType, Name: "l", Linkname: strings.Repeat("a", 17)}}, LimitNameLength, 0},
+		{"payload", []Entry{segment(1025)}, LimitPayloadSize, 0},
+		{"inline", []Entry{{Type: FileType, Name: "d", Special: "dumpdir", Size: 1025, Inline: make([]byte, 1025)}}, LimitPayloadSize, 0},
+		{"size", []Entry{file("a", 1<<20+1)}, LimitFileSize, 0},
+		{"entries", []Entry{file("a", 0), file("b", 0), file("c", 0), file("d", 0), file("e", 0)}, LimitEntries, 4},
+		{"line", []Entry{segment(10), {Type: FileType, Name: "a", Inline: make([]byte, 4096)}}, LimitLineSize, 1},
+	} {
+		buf := bytes.NewBuffer(nil)
+		packLimitsTest(t, NewJSONPacker(buf), tc.entries...)
+		up := NewLimitedJSONUnpacker(buf, limits)
+		var (
+			n   int
+			err error
+		)
+		for ; err == nil; n++ {
+			_, err = up.Next()
+		}
+		if tc.limit == "" {
+			if err != io.EOF || n-1 != len(tc.entries) {
+				t.Errorf("%s: expected %d entries, got %d and %v", tc.desc, len(tc.entries), n-1, err)
+			}
+			continue
+		}
+		le, ok := err.(*LimitError)
+		if !ok {
+			t.Errorf("%s: expected a *LimitError, got %v", tc.desc, err)
+			continue
+		}
+		if le.Limit != tc.limit || le.Index != tc.index {
+			t.Errorf("%s: expected the %s limit at entry %d, got %v", tc.desc, tc.limit, tc.index, le)
+		}
+	}
+}
+
+func TestLimitedJSONUnpackerEncodedSegment(t *testing.T) {
+	// a segment that is small encoded is still bounded as it is decoded
+	buf := bytes.NewBuffer(nil)
+	p, err := NewSegmentEncodingPacker(NewJSONPacker(buf), "gzip", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packLimitsTest(t, p, Entry{Type: SegmentType, Payload: bytes.Repeat([]byte{'x'}, 1<<20)})
+	if buf.Len() > 4096 {
+		t.Fatalf("expected the segment encoded, got %d bytes of metadata", buf.Len())
+	}
+	_, err = NewLimitedJSONUnpacker(buf, Limits{MaxPayloadSize: 64 << 10}).Next()
+	if le, ok := err.(*LimitError); !ok || le.Limit != LimitPayloadSize {
+		t.Errorf("expected the %s limit, got %v", LimitPayloadSize, err)
+	}
+}
+
+func TestLimitingUnpacker(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	packLimitsTest(t, NewProtoPacker(buf), Entry{Type: FileType, Name: "a"}, Entry{Type: FileType, Name: "bb"})
+	up := NewLimitedAutoUnpacker(buf, Limits{MaxNameLength: 1})
+	if _, err := up.Next(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		// the error stays
+		if _, err := up.Next(); err == nil || !strings.Contains(err.Error(), "name-length") {
+			t.Errorf("expected the name-length limit, got %v", err)
+		}
+	}
+
+	buf.Reset()
+	packLimitsTest(t, NewJSONPacker(buf), Entry{Type: SegmentType, Size: -1})
+	if _, err := NewLimitingUnpacker(NewJSONUnpacker(buf), DefaultLimits).Next(); err != ErrNegativeSize {
+		t.Errorf("expected ErrNegativeSize, got %v", err)
+	}
+}
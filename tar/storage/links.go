@@ -0,0 +1,37 @@
+package storage
+
+import "io"
+
+// HardlinkGroups reads `up` to the end, and returns the groups of the names of
+// the FileType entries that are hard links of the same file, each starting
+// with the entry that the others link to, in the order of the archive. Names
+// are cleaned, like those of a NameIndex. A hard link to a name that is not
+// in the archive starts a group with that name.
+func HardlinkGroups(up Unpacker) ([][]string, error) {
+	var groups [][]string
+	// the group of each name in it
+	group := map[string]int{}
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				return groups, nil
+			}
+			return nil, err
+		}
+		if entry.Type != FileType || entry.Link != HardLink {
+			continue
+		}
+		name, target := cleanLayerPath(entry.GetName()), cleanLayerPath(entry.GetLinkname())
+		i, ok := group[target]
+		if !ok {
+			i = len(groups)
+			groups = append(groups, []string{target})
+			group[target] = i
+		}
+		if _, ok := group[name]; !ok {
+			groups[i] = append(groups[i], name)
+			group[name] = i
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestHardlinkGroups(t *testing.T) {
+	var meta bytes.Buffer
+	jp := NewJSONPacker(&meta)
+	entries := []Entry{
+		{Type: FileType, Name: "./a", Size: 1},
+		{Type: FileType, Name: "b", Link: HardLink, Linkname: "./a"},
+		{Type: FileType, Name: "s", Link: SymLink, Linkname: "a"},
+		{Type: FileType, Name: "c", Link: HardLink, Linkname: "b"},
+		{Type: FileType, Name: "d", Link: HardLink, Linkname: "missing"},
+	}
+	for _, e := range entries {
+		if _, err := jp.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	groups, err := HardlinkGroups(NewJSONUnpacker(&meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{{"a", "b", "c"}, {"missing", "d"}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected %q, got %q", expected, groups)
+	}
+}
+
+func TestSetLink(t *testing.T) {
+	var e Entry
+	e.SetLink(SymLink, "\xff")
+	if e.Linkname != "" || string(e.LinknameRaw) != "\xff" || e.GetLinkname() != "\xff" || e.Link != SymLink {
+		t.Errorf("expected the raw target of a symlink, got %+v", e)
+	}
+}
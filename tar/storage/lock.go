@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockFileName is of the lock file in the root of a payload directory. The
+// path FileGetter and FilePutter hold it while they open and replace
+// payloads, so that several processes can share the directory.
+const lockFileName = ".tar-split.lock"
+
+// lockDir takes the lock of the payload directory `root`, exclusive to replace
+// payloads or shared to open them, and returns the function that releases it.
+// Shared locks are only taken if the lock file exists, as the directory may
+// not be writable (like an extracted archive only read from), and then has no
+// writers to wait for.
+func lockDir(root string, exclusive bool) (func(), error) {
+	p := filepath.Join(root, lockFileName)
+	var fh *os.File
+	var err error
+	if exclusive {
+		fh, err = os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	} else {
+		fh, err = os.Open(p)
+		if os.IsNotExist(err) {
+			return func() {}, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(fh, exclusive); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(fh)
+		fh.Close()
+	}, nil
+}
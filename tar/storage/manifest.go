@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// ManifestEntry is a file of an archive, as listed in a manifest written by a
+// ManifestPacker
+type ManifestEntry struct {
+	Name string `json:"name,omitempty"`
+	// NameRaw is set instead of Name when the name is not valid UTF-8
+	NameRaw []byte `json:"name_raw,omitempty"`
+	Size    int64  `json:"size"`
+	// Digest is of the payload, like "sha256:<hex>", and is empty for
+	// entries without one
+	Digest string `json:"digest,omitempty"`
+}
+
+// GetName returns the name of the file, regardless of how it was stored
+func (me ManifestEntry) GetName() string {
+	if len(me.NameRaw) > 0 {
+		return string(me.NameRaw)
+	}
+	return me.Name
+}
+
+// ManifestPacker is both a FilePutter and a Packer, that writes a manifest of
+// the archive's files (name, size and payload digest) as they are
+// disassembled, alongside the full metadata. The manifest is for consumers
+// like caches and policy checks, that need the listing but not the raw
+// headers to reassemble the archive.
+//
+// Give it as both the Packer and the FilePutter to asm.NewInputTarStream. The
+// manifest is written as a JSON object per line, in the order of the archive.
+// The parts of files split across volumes are left out.
+type ManifestPacker struct {
+	p       Packer
+	fp      FilePutter
+	enc     *json.Encoder
+	mu      sync.Mutex
+	digests map[string]string
+}
+
+// NewManifestPacker returns a ManifestPacker that stores file payloads to `fp`
+// (or discards them, if nil), packs the entries to `p`, and writes the
+// manifest to `w`.
+func NewManifestPacker(p Packer, fp FilePutter, w io.Writer) *ManifestPacker {
+	if fp == nil {
+		fp = NewDiscardFilePutter()
+	}
+	return &ManifestPacker{
+		p:       p,
+		fp:      fp,
+		enc:     json.NewEncoder(w),
+		digests: map[string]string{},
+	}
+}
+
+// Put stores the payload, digesting it on the way. Puts may be concurrent,
+// if those of the FilePutter may be.
+func (mp *ManifestPacker) Put(name string, r io.Reader) (int64, []byte, error) {
+	h := sha256.New()
+	size, csum, err := mp.fp.Put(name, io.TeeReader(r, h))
+	if err != nil {
+		return 0, nil, err
+	}
+	mp.mu.Lock()
+	mp.digests[name] = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	mp.mu.Unlock()
+	return size, csum, nil
+}
+
+// AddEntry packs the entry, and lists it in the manifest if it is a file
+func (mp *ManifestPacker) AddEntry(e Entry) (int, error) {
+	pos, err := mp.p.AddEntry(e)
+	if err != nil || e.Type != FileType || e.Partial {
+		return pos, err
+	}
+	name := e.GetName()
+	mp.mu.Lock()
+	me := ManifestEntry{Size: e.Size, Digest: mp.digests[name]}
+	delete(mp.digests, name)
+	mp.mu.Unlock()
+	if utf8.ValidString(name) {
+		me.Name = name
+	} else {
+		me.NameRaw = []byte(name)
+	}
+	if me.Digest == "" && len(e.Inline) > 0 {
+		sum := sha256.Sum256(e.Inline)
+		me.Digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	if err := mp.enc.Encode(me); err != nil {
+		return pos, err
+	}
+	return pos, nil
+}
+
+// ReadManifest reads the entries of a manifest written by a ManifestPacker
+func ReadManifest(r io.Reader) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	dec := json.NewDecoder(r)
+	for {
+		var me ManifestEntry
+		if err := dec.Decode(&me); err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return nil, err
+		}
+		entries = append(entries, me)
+	}
+}
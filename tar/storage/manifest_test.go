@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestManifestPacker(t *testing.T) {
+	meta := bytes.NewBuffer(nil)
+	manifest := bytes.NewBuffer(nil)
+	mp := NewManifestPacker(NewJSONPacker(meta), nil, manifest)
+
+	payload := "#!/bin/sh\nexit 0\n"
+	if _, err := mp.AddEntry(Entry{Type: SegmentType, Payload: []byte("header")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := mp.Put("bin/true", strings.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mp.AddEntry(Entry{Type: FileType, Name: "bin/true", Size: int64(len(payload))}); err != nil {
+		t.Fatal(err)
+	}
+	e := Entry{Type: FileType}
+	e.SetName("etc/caf\xe9")
+	if _, err := mp.AddEntry(e); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected only the 2 files in the manifest, got %v", entries)
+	}
+	sum := sha256.Sum256([]byte(payload))
+	if entries[0].Name != "bin/true" || entries[0].Size != int64(len(payload)) || entries[0].Digest != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected manifest entry %+v", entries[0])
+	}
+	if entries[1].GetName() != "etc/caf\xe9" || entries[1].Digest != "" {
+		t.Errorf("expected the raw name without a digest, got %+v", entries[1])
+	}
+
+	// the full metadata is packed as well
+	up := NewJSONUnpacker(meta)
+	for i := 0; i < 3; i++ {
+		if _, err := up.Next(); err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrNoSuchLayer occurs when the metadata of a layer is not in a MetadataStore
+var ErrNoSuchLayer = errors.New("storage: no such layer in the metadata store")
+
+// KV is the minimal interface of an embedded key-value database with ordered
+// keys, like bbolt (a bucket of it), or a table of SQLite with a key and value
+// column, for NewMetadataStore. It is meant to be implemented in a few lines
+// with the database's package, as with bbolt:
+//
+//	func (b boltKV) Put(k, v []byte) error {
+//		return b.db.Update(func(tx *bolt.Tx) error { return tx.Bucket(b.name).Put(k, v) })
+//	}
+//
+// Keys are binary, and are to be ordered bytewise.
+type KV interface {
+	// Put stores `value` for `key`, replacing any before it
+	Put(key, value []byte) error
+	// Get returns the value of `key`, or nil if it has none
+	Get(key []byte) ([]byte, error)
+	// Scan calls `fn` for each key with `prefix`, in order of the keys, until
+	// it returns false. The key and value are only valid during the call.
+	Scan(prefix []byte, fn func(key, value []byte) bool) error
+}
+
+// NewMemoryKV returns a KV that is a map in memory, for tests, and for
+// metadata stores that need not persist
+func NewMemoryKV() KV {
+	return &memoryKV{values: map[string][]byte{}}
+}
+
+type memoryKV struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+}
+
+func (m *memoryKV) Put(key, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *memoryKV) Get(key []byte) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.values[string(key)], nil
+}
+
+func (m *memoryKV) Scan(prefix []byte, fn func(key, value []byte) bool) error {
+	m.mu.RLock()
+	var keys []string
+	for k := range m.values {
+		if bytes.HasPrefix([]byte(k), prefix) {
+			keys = append(keys, k)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		m.mu.RLock()
+		v, ok := m.values[k]
+		m.mu.RUnlock()
+		if ok && !fn([]byte(k), v) {
+			break
+		}
+	}
+	return nil
+}
+
+// MetadataStore keeps the metadata of many layers in a KV, keyed by the
+// digest of each layer and the position of its entries, for servers that
+// manage the tar-data of thousands of layers. Apart from unpacking a layer in
+// order, its file entries are looked up by name, and those of all the layers
+// by the checksums of their payloads.
+//
+// The keys are made of these parts, separated by NUL bytes, as neither names
+// nor digests have one:
+//
+//	"l", layer                          the layers stored
+//	"e", layer, position                the entries, as JSON
+//	"n", layer, cleaned name, position  the file entries by name
+//	"c", checksum, layer, position      the file entries by checksum
+//
+// where the position is 8 bytes, big endian, for the entries to be in order.
+type MetadataStore struct {
+	kv KV
+}
+
+// NewMetadataStore returns a MetadataStore of the metadata in `kv`
+func NewMetadataStore(kv KV) *MetadataStore {
+	return &MetadataStore{kv: kv}
+}
+
+func metaKey(kind string, parts ...string) []byte {
+	key := []byte(kind)
+	for _, p := range parts {
+		key = append(append(key, 0), p...)
+	}
+	return append(key, 0)
+}
+
+func positionKey(prefix []byte, position int) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(position))
+	return append(append([]byte(nil), prefix...), b[:]...)
+}
+
+// Packer returns a Packer of the metadata of `layer`, like its digest
+// "sha256:...", to the store. Its entries are stored and indexed as they are
+// added, so the layer is to be packed only once, and is not to be read until
+// it is all packed.
+func (ms *MetadataStore) Packer(layer string) Packer {
+	return &metaPacker{ms: ms, layer: layer, seen: seenNames{}}
+}
+
+type metaPacker struct {
+	ms    *MetadataStore
+	layer string
+	pos   int
+	seen  seenNames
+}
+
+func (mp *metaPacker) AddEntry(e Entry) (int, error) {
+	// as the jsonPacker does, for a name that is not valid utf8 to be kept
+	if e.Name != "" && !utf8.ValidString(e.Name) {
+		e.NameRaw = []byte(e.Name)
+		e.Name = ""
+	}
+	name := cleanLayerPath(e.GetName())
+	if e.Type == FileType {
+		if _, ok := mp.seen[name]; ok {
+			return -1, ErrDuplicatePath
+		}
+		mp.seen[name] = struct{}{}
+	}
+	if mp.pos == 0 {
+		if err := mp.ms.kv.Put(metaKey("l", mp.layer), []byte(mp.layer)); err != nil {
+			return -1, err
+		}
+	}
+
+	e.Position = mp.pos
+	value, err := json.Marshal(e)
+	if err != nil {
+		return -1, err
+	}
+	if err := mp.ms.kv.Put(positionKey(metaKey("e", mp.layer), e.Position), value); err != nil {
+		return -1, err
+	}
+	if e.Type == FileType {
+		if err := mp.ms.kv.Put(positionKey(metaKey("n", mp.layer, name), e.Position), nil); err != nil {
+			return -1, err
+		}
+		for _, checksum := range metaChecksums(&e) {
+			if err := mp.ms.kv.Put(positionKey(metaKey("c", checksum, mp.layer), e.Position), nil); err != nil {
+				return -1, err
+			}
+		}
+	}
+	mp.pos++
+	return e.Position, nil
+}
+
+// metaChecksums are the checksums that the payload of `e` is indexed by: its
+// crc64, as "crc64:<hex>", and its Checksum and Checksums, if any
+func metaChecksums(e *Entry) []string {
+	if e.Size == 0 || len(e.Payload) == 0 {
+		return nil
+	}
+	return append([]string{"crc64:" + hex.EncodeToString(e.Payload)}, e.AllChecksums()...)
+}
+
+// Unpacker returns an Unpacker of the metadata of `layer`, from the store, in
+// order. It is ErrNoSuchLayer if the store has none of it.
+func (ms *MetadataStore) Unpacker(layer string) (Unpacker, error) {
+	v, err := ms.kv.Get(metaKey("l", layer))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrNoSuchLayer
+	}
+	return &metaUnpacker{ms: ms, prefix: metaKey("e", layer)}, nil
+}
+
+type metaUnpacker struct {
+	ms     *MetadataStore
+	prefix []byte
+	pos    int
+}
+
+func (mu *metaUnpacker) Next() (*Entry, error) {
+	e, err := mu.ms.entry(mu.prefix, mu.pos)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, io.EOF
+	}
+	mu.pos++
+	return e, nil
+}
+
+// entry returns the entry at `position` under the `prefix` of its layer, or
+// nil if there is none
+func (ms *MetadataStore) entry(prefix []byte, position int) (*Entry, error) {
+	v, err := ms.kv.Get(positionKey(prefix, position))
+	if err != nil || v == nil {
+		return nil, err
+	}
+	var e Entry
+	if err := json.Unmarshal(v, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Layers returns the layers in the store, in order
+func (ms *MetadataStore) Layers() ([]string, error) {
+	var layers []string
+	prefix := []byte("l\x00")
+	err := ms.kv.Scan(prefix, func(key, _ []byte) bool {
+		layers = append(layers, string(key[len(prefix):len(key)-1]))
+		return true
+	})
+	return layers, err
+}
+
+// LookupName returns the file entry of `name` in `layer`, or nil if it has
+// none. Names are cleaned, so "./etc/passwd" and "etc/passwd" are the same.
+func (ms *MetadataStore) LookupName(layer, name string) (*Entry, error) {
+	position := -1
+	prefix := metaKey("n", layer, cleanLayerPath(name))
+	err := ms.kv.Scan(prefix, func(key, _ []byte) bool {
+		if len(key) == len(prefix)+8 {
+			position = int(binary.BigEndian.Uint64(key[len(prefix):]))
+			return false
+		}
+		return true
+	})
+	if err != nil || position < 0 {
+		return nil, err
+	}
+	return ms.entry(metaKey("e", layer), position)
+}
+
+// LayerEntry is an entry of a layer in a MetadataStore, as found by
+// LookupChecksum
+type LayerEntry struct {
+	Layer string
+	Entry *Entry
+}
+
+// LookupChecksum returns the file entries of all the layers whose payload has
+// `checksum`, like "sha256:<hex>", or "crc64:<hex>" of its Payload, by layer
+// and position
+func (ms *MetadataStore) LookupChecksum(checksum string) ([]LayerEntry, error) {
+	type ref struct {
+		layer    string
+		position int
+	}
+	var refs []ref
+	prefix := metaKey("c", checksum)
+	err := ms.kv.Scan(prefix, func(key, _ []byte) bool {
+		rest := key[len(prefix):]
+		if len(rest) < 9 {
+			return true
+		}
+		refs = append(refs, ref{
+			layer:    string(rest[:len(rest)-9]),
+			position: int(binary.BigEndian.Uint64(rest[len(rest)-8:])),
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var found []LayerEntry
+	for _, r := range refs {
+		e, err := ms.entry(metaKey("e", r.layer), r.position)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			found = append(found, LayerEntry{Layer: r.layer, Entry: e})
+		}
+	}
+	return found, nil
+}
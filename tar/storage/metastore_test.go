@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash/crc64"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMetadataStore(t *testing.T) {
+	ms := NewMetadataStore(NewMemoryKV())
+	crc := func(s string) []byte {
+		h := crc64.New(CRCTable)
+		io.WriteString(h, s)
+		return h.Sum(nil)
+	}
+	layers := map[string][]Entry{
+		"sha256:aaaa": {
+			{Type: SegmentType, Payload: []byte("header")},
+			{Type: FileType, Name: "./etc/hostname", Size: 5, Payload: crc("hello"), Checksum: "sha256:1111"},
+			{Type: FileType, Name: "etc/", Size: 0},
+		},
+		"sha256:bbbb": {
+			{Type: FileType, Name: "usr/share/hello", Size: 5, Payload: crc("hello"), Checksum: "sha256:1111"},
+			{Type: FileType, Name: "bin/\xff", Size: 5, Payload: crc("world")},
+		},
+	}
+	for layer, entries := range layers {
+		p := ms.Packer(layer)
+		for i, e := range entries {
+			pos, err := p.AddEntry(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos != i {
+				t.Errorf("expected position %d, got %d", i, pos)
+			}
+		}
+		if _, err := p.AddEntry(Entry{Type: FileType, Name: entries[len(entries)-1].Name}); err != ErrDuplicatePath {
+			t.Errorf("expected ErrDuplicatePath, got %v", err)
+		}
+	}
+
+	got, err := ms.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "sha256:aaaa,sha256:bbbb" {
+		t.Errorf("unexpected layers %q", got)
+	}
+
+	up, err := ms.Unpacker("sha256:aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range layers["sha256:aaaa"] {
+		e, err := up.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Position != i || e.GetName() != expected.GetName() || !bytes.Equal(e.Payload, expected.Payload) {
+			t.Errorf("entry %d: expected %+v, got %+v", i, expected, e)
+		}
+	}
+	if _, err := up.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if _, err := ms.Unpacker("sha256:cccc"); err != ErrNoSuchLayer {
+		t.Errorf("expected ErrNoSuchLayer, got %v", err)
+	}
+
+	for _, name := range []string{"etc/hostname", "/etc/hostname", "./etc/hostname"} {
+		e, err := ms.LookupName("sha256:aaaa", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil || e.Position != 1 {
+			t.Errorf("%q: expected the entry at 1, got %+v", name, e)
+		}
+	}
+	if e, err := ms.LookupName("sha256:bbbb", "bin/\xff"); err != nil || e == nil || e.GetName() != "bin/\xff" {
+		t.Errorf("expected the entry of a name that is not utf8, got %+v and %v", e, err)
+	}
+	if e, err := ms.LookupName("sha256:bbbb", "etc/hostname"); err != nil || e != nil {
+		t.Errorf("expected no entry in another layer, got %+v and %v", e, err)
+	}
+
+	found, err := ms.LookupChecksum("sha256:1111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].Layer != "sha256:aaaa" || found[0].Entry.Position != 1 || found[1].Layer != "sha256:bbbb" || found[1].Entry.GetName() != "usr/share/hello" {
+		t.Errorf("unexpected entries %+v", found)
+	}
+	found, err = ms.LookupChecksum("crc64:" + hex.EncodeToString(crc("world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Entry.GetName() != "bin/\xff" {
+		t.Errorf("expected the entry of the crc64, got %+v", found)
+	}
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"io"
+	"time"
+)
+
+// Metric is the name of a counter or a duration reported to Metrics
+type Metric string
+
+const (
+	// MetricEntriesPacked counts the entries packed
+	MetricEntriesPacked Metric = "entries_packed"
+	// MetricEntriesUnpacked counts the entries unpacked
+	MetricEntriesUnpacked Metric = "entries_unpacked"
+	// MetricBytesHashed counts the bytes of the payloads put, which are
+	// hashed for their checksums
+	MetricBytesHashed Metric = "bytes_hashed"
+	// MetricBytesRead counts the bytes read of the payloads got
+	MetricBytesRead Metric = "bytes_read"
+	// MetricGetterHits counts the payloads got
+	MetricGetterHits Metric = "getter_hits"
+	// MetricGetterMisses counts the payloads that failed to be got
+	MetricGetterMisses Metric = "getter_misses"
+	// MetricGetDuration is how long a payload took to be opened by a
+	// FileGetter, hit or miss
+	MetricGetDuration Metric = "get_duration"
+	// MetricPutDuration is how long a payload took to be put by a FilePutter
+	MetricPutDuration Metric = "put_duration"
+)
+
+// Metrics receives the counts and durations of the operations of the
+// wrappers of NewMetricsPacker, NewMetricsUnpacker, NewMetricsFileGetter and
+// NewMetricsFilePutter, for operators to export, as to Prometheus, by the
+// Metric names. The calls may be concurrent, as the wrapped operations may
+// be, and are made inline, so they are to be quick.
+type Metrics interface {
+	// Count adds `n` to the counter `name`
+	Count(name Metric, n int64)
+	// Observe records a duration of the operation `name`
+	Observe(name Metric, d time.Duration)
+}
+
+// NewMetricsPacker returns a Packer that packs to `p`, counting the entries
+// it packs to `m`
+func NewMetricsPacker(p Packer, m Metrics) Packer {
+	return &metricsPacker{p: p, m: m}
+}
+
+type metricsPacker struct {
+	p Packer
+	m Metrics
+}
+
+func (mp *metricsPacker) AddEntry(e Entry) (int, error) {
+	pos, err := mp.p.AddEntry(e)
+	if err == nil {
+		mp.m.Count(MetricEntriesPacked, 1)
+	}
+	return pos, err
+}
+
+// NewMetricsUnpacker returns an Unpacker of the entries of `up`, counting
+// the entries it unpacks to `m`
+func NewMetricsUnpacker(up Unpacker, m Metrics) Unpacker {
+	return &metricsUnpacker{up: up, m: m}
+}
+
+type metricsUnpacker struct {
+	up Unpacker
+	m  Metrics
+}
+
+func (mu *metricsUnpacker) Next() (*Entry, error) {
+	e, err := mu.up.Next()
+	if err == nil {
+		mu.m.Count(MetricEntriesUnpacked, 1)
+	}
+	return e, err
+}
+
+// NewMetricsFileGetter returns a FileGetter of the payloads of `fg`,
+// reporting to `m` its hits and misses, how long each get took, and the
+// bytes read of the payloads. If `fg` is a DigestFileGetter, so is the one
+// returned, with the gets by digest reported the same.
+func NewMetricsFileGetter(fg FileGetter, m Metrics) FileGetter {
+	mfg := &metricsFileGetter{fg: fg, m: m}
+	if dfg, ok := fg.(DigestFileGetter); ok {
+		return &metricsDigestFileGetter{metricsFileGetter: mfg, dfg: dfg}
+	}
+	return mfg
+}
+
+type metricsFileGetter struct {
+	fg FileGetter
+	m  Metrics
+}
+
+func (mfg *metricsFileGetter) Get(filename string) (io.ReadCloser, error) {
+	return mfg.get(func() (io.ReadCloser, error) { return mfg.fg.Get(filename) })
+}
+
+func (mfg *metricsFileGetter) get(fn func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := fn()
+	mfg.m.Observe(MetricGetDuration, time.Since(start))
+	if err != nil {
+		mfg.m.Count(MetricGetterMisses, 1)
+		return nil, err
+	}
+	mfg.m.Count(MetricGetterHits, 1)
+	return &metricsReadCloser{rc: rc, m: mfg.m}, nil
+}
+
+type metricsDigestFileGetter struct {
+	*metricsFileGetter
+	dfg DigestFileGetter
+}
+
+func (mdfg *metricsDigestFileGetter) GetByDigest(algorithm, sum string) (io.ReadCloser, error) {
+	return mdfg.get(func() (io.ReadCloser, error) { return mdfg.dfg.GetByDigest(algorithm, sum) })
+}
+
+// metricsReadCloser counts the bytes read of a payload
+type metricsReadCloser struct {
+	rc io.ReadCloser
+	m  Metrics
+}
+
+func (mrc *metricsReadCloser) Read(p []byte) (int, error) {
+	n, err := mrc.rc.Read(p)
+	if n > 0 {
+		mrc.m.Count(MetricBytesRead, int64(n))
+	}
+	return n, err
+}
+
+func (mrc *metricsReadCloser) Close() error {
+	return mrc.rc.Close()
+}
+
+// NewMetricsFilePutter returns a FilePutter that puts the payloads to `fp`,
+// reporting to `m` the bytes of each, as they are hashed, and how long it
+// took. If `fp` is a SparseFilePutter, so is the one returned.
+func NewMetricsFilePutter(fp FilePutter, m Metrics) FilePutter {
+	mfp := &metricsFilePutter{fp: fp, m: m}
+	if sfp, ok := fp.(SparseFilePutter); ok {
+		return &metricsSparseFilePutter{metricsFilePutter: mfp, sfp: sfp}
+	}
+	return mfp
+}
+
+type metricsFilePutter struct {
+	fp FilePutter
+	m  Metrics
+}
+
+func (mfp *metricsFilePutter) Put(filename string, input io.Reader) (int64, []byte, error) {
+	return mfp.put(func() (int64, []byte, error) { return mfp.fp.Put(filename, input) })
+}
+
+func (mfp *metricsFilePutter) put(fn func() (int64, []byte, error)) (int64, []byte, error) {
+	start := time.Now()
+	size, checksum, err := fn()
+	mfp.m.Observe(MetricPutDuration, time.Since(start))
+	if size > 0 {
+		mfp.m.Count(MetricBytesHashed, size)
+	}
+	return size, checksum, err
+}
+
+type metricsSparseFilePutter struct {
+	*metricsFilePutter
+	sfp SparseFilePutter
+}
+
+func (msfp *metricsSparseFilePutter) PutSparse(filename string, r io.Reader, extents []SparseExtent) (int64, []byte, error) {
+	return msfp.put(func() (int64, []byte, error) { return msfp.sfp.PutSparse(filename, r, extents) })
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedMetrics are the Metrics reported, for the tests
+type recordedMetrics struct {
+	mu        sync.Mutex
+	counts    map[Metric]int64
+	durations map[Metric]int
+}
+
+func newRecordedMetrics() *recordedMetrics {
+	return &recordedMetrics{counts: map[Metric]int64{}, durations: map[Metric]int{}}
+}
+
+func (rm *recordedMetrics) Count(name Metric, n int64) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.counts[name] += n
+}
+
+func (rm *recordedMetrics) Observe(name Metric, d time.Duration) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.durations[name]++
+}
+
+func TestMetricsPackerUnpacker(t *testing.T) {
+	m := newRecordedMetrics()
+	buf := bytes.NewBuffer(nil)
+	p := NewMetricsPacker(NewJSONPacker(buf), m)
+	for _, name := range []string{"a", "b", "a"} {
+		p.AddEntry(Entry{Type: FileType, Name: name})
+	}
+	up := NewMetricsUnpacker(NewJSONUnpacker(buf), m)
+	for {
+		if _, err := up.Next(); err != nil {
+			break
+		}
+	}
+	// the duplicate is not packed
+	if m.counts[MetricEntriesPacked] != 2 || m.counts[MetricEntriesUnpacked] != 2 {
+		t.Errorf("expected 2 entries packed and unpacked, got %v", m.counts)
+	}
+}
+
+func TestMetricsFileGetterPutter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := newRecordedMetrics()
+	fgp := NewCASFileGetPutter(dir)
+	fp := NewMetricsFilePutter(fgp, m)
+	if _, _, err := fp.Put("a", bytes.NewReader([]byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := NewMetricsFilePutter(NewHolelessFilePutter(fgp), m).(SparseFilePutter); !ok {
+		t.Error("expected a SparseFilePutter of a SparseFilePutter")
+	}
+
+	fg := NewMetricsFileGetter(fgp, m)
+	if _, ok := fg.(DigestFileGetter); !ok {
+		t.Fatal("expected a DigestFileGetter of a DigestFileGetter")
+	}
+	rc, err := fg.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(rc)
+	rc.Close()
+	if _, err := fg.Get("b"); err == nil {
+		t.Fatal("expected no payload of b")
+	}
+	if _, ok := NewMetricsFileGetter(NewBufferFileGetPutter(), m).(DigestFileGetter); ok {
+		t.Error("expected no DigestFileGetter of a store not addressed by digest")
+	}
+
+	for name, expected := range map[Metric]int64{
+		MetricBytesHashed:  5,
+		MetricBytesRead:    5,
+		MetricGetterHits:   1,
+		MetricGetterMisses: 1,
+	} {
+		if m.counts[name] != expected {
+			t.Errorf("expected %s of %d, got %d", name, expected, m.counts[name])
+		}
+	}
+	if m.durations[MetricPutDuration] != 1 || m.durations[MetricGetDuration] != 2 {
+		t.Errorf("expected a put and two gets timed, got %v", m.durations)
+	}
+}
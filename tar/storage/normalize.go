@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var normForms = map[string]norm.Form{
+	"NFC": norm.NFC,
+	"NFD": norm.NFD,
+}
+
+func lookupNormForm(form string) (norm.Form, error) {
+	f, ok := normForms[form]
+	if !ok {
+		return 0, fmt.Errorf("storage: unknown normalization form %q", form)
+	}
+	return f, nil
+}
+
+// NormalizeName returns the name in the Unicode normalization form "NFC" or
+// "NFD". Names that are not valid UTF-8 are left as they are.
+func NormalizeName(name, form string) (string, error) {
+	f, err := lookupNormForm(form)
+	if err != nil {
+		return "", err
+	}
+	return f.String(name), nil
+}
+
+// NewNormalizingFileGetter returns a FileGetter that gets payloads from `fg`
+// by their names normalized to the form "NFC" or "NFD". This is for when the
+// names in the metadata are in a different form than the store, like an
+// archive made on macOS (with NFD names) extracted to a filesystem that keeps
+// NFC names. The metadata keeps the names as they are in the archive, so it
+// is still assembled byte for byte.
+func NewNormalizingFileGetter(fg FileGetter, form string) (FileGetter, error) {
+	f, err := lookupNormForm(form)
+	if err != nil {
+		return nil, err
+	}
+	return normalizingFileGetPutter{fg: fg, form: f}, nil
+}
+
+// NewNormalizingFileGetPutter is like NewNormalizingFileGetter, and also puts
+// the payloads by their normalized names, so that names in either form are
+// the same payload.
+//
+// As the metadata keeps the names of the archive, the garbage of the store is
+// to be collected through the one returned, which is a FileLister and a
+// FileDeleter if `fgp` is both, so the references are counted by the
+// normalized names. Collected from `fgp` itself, payloads of names not in
+// the form would be deleted while in use.
+func NewNormalizingFileGetPutter(fgp FileGetPutter, form string) (FileGetPutter, error) {
+	f, err := lookupNormForm(form)
+	if err != nil {
+		return nil, err
+	}
+	nfgp := normalizingFileGetPutter{fg: fgp, fp: fgp, form: f}
+	if store, ok := fgp.(interface {
+		FileLister
+		FileDeleter
+	}); ok {
+		return normalizingStore{normalizingFileGetPutter: nfgp, store: store}, nil
+	}
+	return nfgp, nil
+}
+
+type normalizingFileGetPutter struct {
+	fg   FileGetter
+	fp   FilePutter
+	form norm.Form
+}
+
+func (n normalizingFileGetPutter) Get(name string) (io.ReadCloser, error) {
+	return n.fg.Get(n.form.String(name))
+}
+
+func (n normalizingFileGetPutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	return n.fp.Put(n.form.String(name), r)
+}
+
+// normalizingStore is a normalizingFileGetPutter whose garbage can be
+// collected
+type normalizingStore struct {
+	normalizingFileGetPutter
+	store interface {
+		FileLister
+		FileDeleter
+	}
+}
+
+func (n normalizingStore) List() ([]string, error) {
+	return n.store.List()
+}
+
+func (n normalizingStore) Delete(name string) error {
+	return n.store.Delete(name)
+}
+
+func (n normalizingStore) payloadName(name string) string {
+	return n.form.String(name)
+}
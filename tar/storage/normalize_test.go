@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const (
+	cafeNFC = "caf\u00e9"  // é as one code point
+	cafeNFD = "cafe\u0301" // e and a combining acute accent
+)
+
+func TestNormalizeName(t *testing.T) {
+	for _, tc := range []struct {
+		name, form, expected string
+	}{
+		{cafeNFD, "NFC", cafeNFC},
+		{cafeNFC, "NFD", cafeNFD},
+		{cafeNFC, "NFC", cafeNFC},
+		{"plain/ascii", "NFD", "plain/ascii"},
+	} {
+		got, err := NormalizeName(tc.name, tc.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.expected {
+			t.Errorf("%q to %s: expected %q, got %q", tc.name, tc.form, tc.expected, got)
+		}
+	}
+	if _, err := NormalizeName(cafeNFC, "NFX"); err == nil {
+		t.Error("expected an error for an unknown form")
+	}
+}
+
+func TestNormalizingFileGetPutter(t *testing.T) {
+	store := NewBufferFileGetPutter()
+	nfgp, err := NewNormalizingFileGetPutter(store, "NFC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// put by the NFD name, as in an archive made on macOS
+	if _, _, err := nfgp.Put("menu/"+cafeNFD, strings.NewReader("espresso")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("menu/" + cafeNFC); err != nil {
+		t.Errorf("expected the payload to be stored by its NFC name: %v", err)
+	}
+	for _, name := range []string{"menu/" + cafeNFD, "menu/" + cafeNFC} {
+		rdr, err := nfgp.Get(name)
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+		buf, _ := ioutil.ReadAll(rdr)
+		if string(buf) != "espresso" {
+			t.Errorf("%q: expected the payload, got %q", name, buf)
+		}
+	}
+
+	// a store keyed by NFC paths, like an extracted rootfs
+	fg, err := NewNormalizingFileGetter(store, "NFC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fg.Get("menu/" + cafeNFD); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNormalizingFileGetPutterCollectGarbage(t *testing.T) {
+	nfgp, err := NewNormalizingFileGetPutter(NewBufferFileGetPutter(), "NFC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{cafeNFD + ".txt", "stale.txt"} {
+		if _, _, err := nfgp.Put(name, strings.NewReader("espresso")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the metadata keeps the NFD name of the archive
+	buf := bytes.NewBuffer(nil)
+	if _, err := NewJSONPacker(buf).AddEntry(Entry{Type: FileType, Name: cafeNFD + ".txt", Size: 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	store, ok := nfgp.(interface {
+		FileLister
+		FileDeleter
+	})
+	if !ok {
+		t.Fatal("expected the normalizing store of a buffer to be a FileLister and a FileDeleter")
+	}
+	deleted, err := CollectGarbage(store, NewJSONUnpacker(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "stale.txt" {
+		t.Errorf("expected only stale.txt to be deleted, got %v", deleted)
+	}
+	if _, err := nfgp.Get(cafeNFD + ".txt"); err != nil {
+		t.Errorf("expected the payload in use to be kept: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package storage
+
+// NewOffsetPacker returns a Packer that sets the Offset of each entry packed
+// to `p`, as the entries are of an archive being disassembled, for mapping
+// them back to their place in the archive (or, with an index of its
+// compression, in the compressed blob) without assembling it.
+//
+// The length of a SegmentType entry is its Payload, and of a FileType entry
+// its DataSize.
+func NewOffsetPacker(p Packer) Packer {
+	return &offsetPacker{p: p}
+}
+
+type offsetPacker struct {
+	p      Packer
+	offset int64
+}
+
+func (op *offsetPacker) AddEntry(e Entry) (int, error) {
+	switch e.Type {
+	case SegmentType:
+		e.Offset = op.offset
+		op.offset += int64(len(e.Payload))
+	case FileType:
+		e.Offset = op.offset
+		op.offset += e.DataSize()
+	}
+	return op.p.AddEntry(e)
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestOffsetPacker(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	p := NewOffsetPacker(NewJSONPacker(buf))
+	entries := []Entry{
+		{Type: SourceType, Source: &Source{Digest: "sha256:beef", Size: 20}},
+		{Type: SegmentType, Payload: make([]byte, 512)},
+		{Type: FileType, Name: "./hurr.txt", Size: 20, Payload: []byte("deadbeef")},
+		{Type: SegmentType, Payload: make([]byte, 492+512)},
+		{Type: FileType, Name: "./empty"},
+		{Type: SegmentType, Payload: make([]byte, 1024)},
+	}
+	for _, e := range entries {
+		if _, err := p.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []int64{0, 0, 512, 532, 1536, 1536}
+	up := NewJSONUnpacker(buf)
+	for i := 0; ; i++ {
+		e, err := up.Next()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			if i != len(expected) {
+				t.Errorf("expected %d entries, got %d", len(expected), i)
+			}
+			break
+		}
+		if e.Offset != expected[i] {
+			t.Errorf("entry %d: expected offset %d, got %d", i, expected[i], e.Offset)
+		}
+	}
+}
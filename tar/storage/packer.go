@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
+	"io/ioutil"
 	"path/filepath"
 	"unicode/utf8"
 )
@@ -12,6 +15,10 @@ import (
 // same file path
 var ErrDuplicatePath = errors.New("duplicates of file paths not supported")
 
+// ErrInvalidRef occurs when a segment references the payload of an entry that
+// is not a padding segment before it
+var ErrInvalidRef = errors.New("segment references an unknown padding")
+
 // Packer describes the methods to pack Entries to a storage destination
 type Packer interface {
 	// AddEntry packs the Entry and returns its position
@@ -32,16 +39,66 @@ type PackUnpacker interface {
 */
 
 type jsonUnpacker struct {
-	seen seenNames
-	dec  *json.Decoder
+	r       io.Reader
+	seen    seenNames
+	dec     *json.Decoder
+	padding paddings
+
+	// limits, if not nil, bound the entries, of which n have been unpacked
+	limits *Limits
+	n      int
 }
 
 func (jup *jsonUnpacker) Next() (*Entry, error) {
+	e, err := jup.next()
+	if le, ok := err.(*LimitError); ok {
+		le.Index = jup.n
+	}
+	if err != nil {
+		return nil, err
+	}
+	jup.n++
+	return e, nil
+}
+
+func (jup *jsonUnpacker) next() (*Entry, error) {
+	if jup.dec == nil {
+		r, err := decodeMetadata(jup.r)
+		if err != nil {
+			return nil, err
+		}
+		if jup.limits != nil && jup.limits.MaxLineSize > 0 {
+			r = &lineLimitReader{r: r, max: jup.limits.MaxLineSize}
+		}
+		jup.dec = json.NewDecoder(r)
+	}
 	var e Entry
 	err := jup.dec.Decode(&e)
 	if err != nil {
 		return nil, err
 	}
+	var max int
+	if jup.limits != nil {
+		max = jup.limits.MaxPayloadSize
+	}
+
+	if e.Type == SegmentType {
+		if e.Encoding != "" {
+			if e.Payload, err = decodeSegment(e.Encoding, e.Payload, max); err != nil {
+				return nil, err
+			}
+			e.Encoding = ""
+		}
+		if e.Ref > 0 {
+			size, ok := jup.padding[e.Ref]
+			if !ok || e.Ref >= e.Position {
+				return nil, ErrInvalidRef
+			}
+			e.Payload, e.Ref = make([]byte, size), 0
+		} else if isZeros(e.Payload) {
+			jup.padding[e.Position] = len(e.Payload)
+		}
+	}
 
 	// check for dup name
 	if e.Type == FileType {
@@ -52,17 +109,24 @@ func (jup *jsonUnpacker) Next() (*Entry, error) {
 		jup.seen[cName] = struct{}{}
 	}
 
-	return &e, err
+	if jup.limits != nil {
+		if err := jup.limits.check(jup.n, &e); err != nil {
+			return nil, err
+		}
+	}
+	return &e, nil
 }
 
 // NewJSONUnpacker provides an Unpacker that reads Entries (SegmentType and
 // FileType) as a json document.
 //
-// Each Entry read are expected to be delimited by new line.
+// Each Entry read are expected to be delimited by new line. Metadata packed
+// by a CompressedJSONPacker is decompressed.
 func NewJSONUnpacker(r io.Reader) Unpacker {
 	return &jsonUnpacker{
-		dec:  json.NewDecoder(r),
-		seen: seenNames{},
+		r:       r,
+		seen:    seenNames{},
+		padding: paddings{},
 	}
 }
 
@@ -71,10 +135,26 @@ type jsonPacker struct {
 	e    *json.Encoder
 	pos  int
 	seen seenNames
+
+	// padding is the position of the first of each size of padding, when
+	// packing compactly
+	padding map[int]int
 }
 
 type seenNames map[string]struct{}
 
+// paddings is the size of the padding segments, by position
+type paddings map[int]int
+
+func isZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
 func (jp *jsonPacker) AddEntry(e Entry) (int, error) {
 	// if Name is not valid utf8, switch it to raw first.
 	if e.Name != "" {
@@ -93,7 +173,15 @@ func (jp *jsonPacker) AddEntry(e Entry) (int, error) {
 		jp.seen[cName] = struct{}{}
 	}
 
-	e.Position = jp.pos
+	e.Position, e.Ref = jp.pos, 0
+	if jp.padding != nil && e.Type == SegmentType && isZeros(e.Payload) {
+		if ref, ok := jp.padding[len(e.Payload)]; ok {
+			e.Ref, e.Payload = ref, nil
+		} else if e.Position > 0 {
+			// a Ref of 0 is none, so the first entry is never referenced
+			jp.padding[len(e.Payload)] = e.Position
+		}
+	}
 	err := jp.e.Encode(e)
 	if err != nil {
 		return -1, err
@@ -116,6 +204,149 @@ func NewJSONPacker(w io.Writer) Packer {
 	}
 }
 
+// NewCompactJSONPacker is like NewJSONPacker, but packs each padding segment
+// (zeros, like the padding of a file's payload, or the end of the archive) of
+// a size seen before as only a reference to the first, which for archives of
+// thousands of members shrinks the metadata considerably.
+//
+// The metadata can only be read by an Unpacker that resolves the references,
+// like NewJSONUnpacker since they were added.
+func NewCompactJSONPacker(w io.Writer) Packer {
+	return &jsonPacker{
+		w:       w,
+		e:       json.NewEncoder(w),
+		seen:    seenNames{},
+		padding: map[int]int{},
+	}
+}
+
+// metadataMagic starts the metadata packed by a CompressedJSONPacker, and is
+// followed by the name of the encoding and a newline
+const metadataMagic = "\x00tar-split-metadata:"
+
+// CompressedJSONPacker is a Packer like NewJSONPacker, of which the whole
+// metadata is compressed with an encoding, like "gzip" (or "zstd", once an
+// Encoding of it is registered, see RegisterEncoding). The encoding is
+// recorded at the start, so NewJSONUnpacker detects and decompresses it.
+//
+// Close must be called once the entries are packed, to flush the compressed
+// metadata.
+type CompressedJSONPacker struct {
+	Packer
+	ew io.WriteCloser
+}
+
+// NewCompressedJSONPacker returns a CompressedJSONPacker writing to `w` with
+// the named encoding. If `compact`, the padding segments are packed as with
+// NewCompactJSONPacker.
+func NewCompressedJSONPacker(w io.Writer, encoding string, compact bool) (*CompressedJSONPacker, error) {
+	enc, err := lookupEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, metadataMagic+enc.Name()+"\n"); err != nil {
+		return nil, err
+	}
+	ew, err := enc.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	p := NewJSONPacker(ew)
+	if compact {
+		p = NewCompactJSONPacker(ew)
+	}
+	return &CompressedJSONPacker{Packer: p, ew: ew}, nil
+}
+
+// Close flushes the compressed metadata. It does not close the underlying
+// writer.
+func (cjp *CompressedJSONPacker) Close() error {
+	return cjp.ew.Close()
+}
+
+// decodeMetadata returns the metadata of `r`, decompressed if it was packed by
+// a CompressedJSONPacker
+func decodeMetadata(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if peek, _ := br.Peek(len(metadataMagic)); !bytes.Equal(peek, []byte(metadataMagic)) {
+		return br, nil
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, ErrInvalidEncodingHeader
+	}
+	enc, err := lookupEncoding(line[len(metadataMagic) : len(line)-1])
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewReader(br)
+}
+
+// NewSegmentEncodingPacker returns a Packer that packs to `p` the payload of
+// each raw segment larger than `threshold` bytes (like huge PAX headers, or the
+// preamble of an embedded archive) encoded with the named encoding (see
+// RegisterEncoding), when that makes it smaller. Unlike compressing the whole
+// metadata stream, each entry stays readable on its own, e.g. for indexed
+// metadata.
+//
+// The metadata can only be read by an Unpacker that decodes the segments, like
+// NewJSONUnpacker since they were added.
+func NewSegmentEncodingPacker(p Packer, encoding string, threshold int) (Packer, error) {
+	enc, err := lookupEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentEncodingPacker{p: p, encoding: enc, threshold: threshold}, nil
+}
+
+type segmentEncodingPacker struct {
+	p         Packer
+	encoding  Encoding
+	threshold int
+}
+
+func (sep *segmentEncodingPacker) AddEntry(e Entry) (int, error) {
+	if e.Type == SegmentType && e.Encoding == "" && len(e.Payload) > sep.threshold {
+		buf := bytes.NewBuffer(nil)
+		w, err := sep.encoding.NewWriter(buf)
+		if err != nil {
+			return -1, err
+		}
+		if _, err := w.Write(e.Payload); err != nil {
+			return -1, err
+		}
+		if err := w.Close(); err != nil {
+			return -1, err
+		}
+		if buf.Len() < len(e.Payload) {
+			e.Payload, e.Encoding = buf.Bytes(), sep.encoding.Name()
+		}
+	}
+	return sep.p.AddEntry(e)
+}
+
+// decodeSegment decodes the payload of a segment, failing with a LimitError
+// once it is more than `max` bytes, if it is not 0
+func decodeSegment(encoding string, payload []byte, max int) ([]byte, error) {
+	enc, err := lookupEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	r, err := enc.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if max <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	decoded, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err == nil && len(decoded) > max {
+		return nil, &LimitError{Limit: LimitPayloadSize, Size: int64(len(decoded)), Max: int64(max)}
+	}
+	return decoded, err
+}
+
 /*
 TODO(vbatts) perhaps have a more compact packer/unpacker, maybe using msgapck
 (https://github.com/ugorji/go)
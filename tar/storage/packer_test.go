@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -216,3 +217,202 @@ func BenchmarkGetPut(b *testing.B) {
 		}
 	})
 }
+
+func TestCompactJSONPacker(t *testing.T) {
+	pad := make([]byte, 500)
+	eof := make([]byte, 1024)
+	e := []Entry{
+		{Type: SegmentType, Payload: []byte("header one")},
+		{Type: FileType, Name: "one", Size: 12, Payload: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Type: SegmentType, Payload: pad},
+		{Type: SegmentType, Payload: []byte("header two")},
+		{Type: FileType, Name: "two", Size: 12, Payload: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Type: SegmentType, Payload: pad},
+		{Type: SegmentType, Payload: eof},
+	}
+
+	compact := bytes.NewBuffer(nil)
+	plain := bytes.NewBuffer(nil)
+	cp, jp := NewCompactJSONPacker(compact), NewJSONPacker(plain)
+	for i := range e {
+		if _, err := cp.AddEntry(e[i]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jp.AddEntry(e[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if compact.Len() >= plain.Len()-600 {
+		t.Errorf("expected the second padding to be packed as a reference, got %d bytes (from %d)", compact.Len(), plain.Len())
+	}
+
+	up := NewJSONUnpacker(compact)
+	for i := range e {
+		entry, err := up.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Type != e[i].Type || !bytes.Equal(entry.Payload, e[i].Payload) || entry.Ref != 0 {
+			t.Errorf("entry %d: expected payload of %d bytes, got %d (ref %d)", i, len(e[i].Payload), len(entry.Payload), entry.Ref)
+		}
+	}
+}
+
+func TestInvalidRef(t *testing.T) {
+	for _, meta := range []string{
+		`{"type":2,"payload":"aGVhZGVy","position":0}` + "\n" + `{"type":2,"ref":0,"position":1}` + "\n" + `{"type":2,"ref":1,"position":2}`,
+		`{"type":2,"payload":"aGVhZGVy","position":0}` + "\n" + `{"type":2,"ref":5,"position":1}`,
+	} {
+		up := NewJSONUnpacker(strings.NewReader(meta))
+		var err error
+		for err == nil {
+			_, err = up.Next()
+		}
+		if err != ErrInvalidRef {
+			t.Errorf("expected ErrInvalidRef, got %v", err)
+		}
+	}
+}
+
+func TestSegmentEncodingPacker(t *testing.T) {
+	pax := []byte(strings.Repeat("30 SCHILY.xattr.user.note=abc\n", 200))
+	random := []byte("\x8f\x01\xa7 not compressible, and small enough")
+	e := []Entry{
+		{Type: SegmentType, Payload: pax},
+		{Type: FileType, Name: "one", Size: 12, Payload: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Type: SegmentType, Payload: random},
+		{Type: SegmentType, Payload: make([]byte, 1024)},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	p, err := NewSegmentEncodingPacker(NewCompactJSONPacker(buf), "gzip", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range e {
+		if _, err := p.AddEntry(e[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() >= len(pax) {
+		t.Errorf("expected the large segments encoded, got %d bytes of metadata", buf.Len())
+	}
+	if n := strings.Count(buf.String(), `"encoding":"gzip"`); n != 2 {
+		t.Errorf("expected the two segments over the threshold to be encoded, got %d", n)
+	}
+
+	up := NewJSONUnpacker(buf)
+	for i := range e {
+		entry, err := up.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(entry.Payload, e[i].Payload) || entry.Encoding != "" {
+			t.Errorf("entry %d: expected the decoded payload of %d bytes, got %d (encoding %q)", i, len(e[i].Payload), len(entry.Payload), entry.Encoding)
+		}
+	}
+
+	if _, err := NewSegmentEncodingPacker(NewJSONPacker(ioutil.Discard), "brotli", 512); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+}
+
+func TestCompressedJSONPacker(t *testing.T) {
+	RegisterEncoding(zlibEncoding{})
+	e := []Entry{
+		{Type: SegmentType, Payload: []byte(strings.Repeat("header one", 50))},
+		{Type: FileType, Name: "one", Size: 12, Payload: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Type: SegmentType, Payload: make([]byte, 500)},
+		{Type: SegmentType, Payload: make([]byte, 1024)},
+	}
+	plain := bytes.NewBuffer(nil)
+	jp := NewJSONPacker(plain)
+	for i := range e {
+		if _, err := jp.AddEntry(e[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, encoding := range []string{"gzip", "zlib"} {
+		buf := bytes.NewBuffer(nil)
+		cp, err := NewCompressedJSONPacker(buf, encoding, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range e {
+			if _, err := cp.AddEntry(e[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := cp.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(buf.String(), metadataMagic+encoding+"\n") {
+			t.Errorf("%s: expected the encoding recorded at the start", encoding)
+		}
+		if buf.Len() >= plain.Len()/2 {
+			t.Errorf("%s: expected the metadata compressed, got %d bytes (from %d)", encoding, buf.Len(), plain.Len())
+		}
+
+		up := NewJSONUnpacker(buf)
+		for i := range e {
+			entry, err := up.Next()
+			if err != nil {
+				t.Fatalf("%s: %v", encoding, err)
+			}
+			if entry.Type != e[i].Type || !bytes.Equal(entry.Payload, e[i].Payload) {
+				t.Errorf("%s: entry %d: expected payload of %d bytes, got %d", encoding, i, len(e[i].Payload), len(entry.Payload))
+			}
+		}
+		if _, err := up.Next(); err != io.EOF {
+			t.Errorf("%s: expected io.EOF, got %v", encoding, err)
+		}
+	}
+
+	if _, err := NewCompressedJSONPacker(ioutil.Discard, "brotli", false); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+	up := NewJSONUnpacker(strings.NewReader(metadataMagic + "brotli\n"))
+	if _, err := up.Next(); err == nil {
+		t.Error("expected an error for metadata of an unknown encoding")
+	}
+}
+
+func TestJSONExtensions(t *testing.T) {
+	e := []Entry{
+		{Type: SegmentType, Payload: []byte("header"), Extensions: map[string][]byte{"io.example.segment": {0, 1, 2}}},
+		{Type: FileType, Name: "one", Size: 3, Payload: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Extensions: map[string][]byte{"io.example.a": []byte("1"), "io.example.b": []byte("\x00\xff")}},
+	}
+	buf := bytes.NewBuffer(nil)
+	p := NewJSONPacker(buf)
+	for i := range e {
+		if _, err := p.AddEntry(e[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// as from a newer version, with a field that is not known
+	buf.WriteString(`{"type":2,"payload":"dHJhaWxlcg==","position":2,"from_the_future":{"a":[1,2]}}` + "\n")
+
+	up := NewJSONUnpacker(buf)
+	for i := range e {
+		entry, err := up.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entry.Extensions) != len(e[i].Extensions) {
+			t.Fatalf("entry %d: expected extensions %v, got %v", i, e[i].Extensions, entry.Extensions)
+		}
+		for k, v := range e[i].Extensions {
+			if !bytes.Equal(entry.Extensions[k], v) {
+				t.Errorf("entry %d: expected %s=%q, got %q", i, k, v, entry.Extensions[k])
+			}
+		}
+	}
+	entry, err := up.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entry.Payload) != "trailer" {
+		t.Errorf("expected the entry with an unknown field read, got %q", entry.Payload)
+	}
+}
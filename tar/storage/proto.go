@@ -0,0 +1,544 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+)
+
+// ProtoMagic starts metadata packed by a ProtoPacker, which NewAutoUnpacker
+// tells apart from JSON by it
+const ProtoMagic = "\x00tar-split-proto\x01"
+
+// maxProtoEntrySize bounds the size of an entry read by a ProtoUnpacker, so
+// corrupt metadata does not exhaust memory
+const maxProtoEntrySize = 1 << 30
+
+// ErrInvalidProto occurs when metadata is not in the format of a ProtoPacker
+var ErrInvalidProto = errors.New("storage: invalid protobuf metadata")
+
+// The field numbers of the Entry message. See entry.proto.
+const (
+	protoType         = 1
+	protoName         = 2
+	protoNameRaw      = 3
+	protoSize         = 4
+	protoPayload      = 5
+	protoZeros        = 6
+	protoPartial      = 7
+	protoPartOffset   = 8
+	protoSpecial      = 9
+	protoInline       = 10
+	protoChecksum     = 11
+	protoAnnotations  = 12
+	protoEncoding     = 13
+	protoSource       = 14
+	protoSummary      = 15
+	protoOffset       = 16
+	protoSparse       = 17
+	protoSparsePacked = 18
+	protoExtensions   = 19
+	protoChunks       = 20
+	protoLink         = 21
+	protoLinkname     = 22
+	protoLinknameRaw  = 23
+	protoChecksums    = 24
+)
+
+// protobuf wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// protoBuffer encodes the fields of a protobuf message
+type protoBuffer struct {
+	bytes.Buffer
+	tmp [binary.MaxVarintLen64]byte
+}
+
+func (pb *protoBuffer) varint(v uint64) {
+	n := binary.PutUvarint(pb.tmp[:], v)
+	pb.Write(pb.tmp[:n])
+}
+
+func (pb *protoBuffer) uintField(field int, v uint64) {
+	if v != 0 {
+		pb.varint(uint64(field)<<3 | wireVarint)
+		pb.varint(v)
+	}
+}
+
+func (pb *protoBuffer) bytesField(field int, b []byte) {
+	if len(b) > 0 {
+		pb.varint(uint64(field)<<3 | wireBytes)
+		pb.varint(uint64(len(b)))
+		pb.Write(b)
+	}
+}
+
+func (pb *protoBuffer) stringField(field int, s string) {
+	pb.bytesField(field, []byte(s))
+}
+
+// NewProtoPacker returns a Packer that writes each Entry as a length delimited
+// protobuf message (of entry.proto), after ProtoMagic. It is much smaller than
+// JSON for archives of many files, as the payloads of segments are not base64
+// encoded, and paddings are packed as only their size.
+//
+// The metadata can be read by NewProtoUnpacker, or NewAutoUnpacker.
+func NewProtoPacker(w io.Writer) Packer {
+	return &protoPacker{w: w, seen: seenNames{}}
+}
+
+type protoPacker struct {
+	w     io.Writer
+	pos   int
+	seen  seenNames
+	magic bool
+	buf   protoBuffer
+	msg   protoBuffer
+}
+
+func (pp *protoPacker) AddEntry(e Entry) (int, error) {
+	if e.Type == FileType {
+		cName := filepath.Clean(e.GetName())
+		if _, ok := pp.seen[cName]; ok {
+			return -1, ErrDuplicatePath
+		}
+		pp.seen[cName] = struct{}{}
+	}
+	if !pp.magic {
+		if _, err := io.WriteString(pp.w, ProtoMagic); err != nil {
+			return -1, err
+		}
+		pp.magic = true
+	}
+
+	m := &pp.msg
+	m.Reset()
+	m.uintField(protoType, uint64(e.Type))
+	if e.Name != "" && !utf8.ValidString(e.Name) {
+		e.NameRaw, e.Name = []byte(e.Name), ""
+	}
+	m.stringField(protoName, e.Name)
+	m.bytesField(protoNameRaw, e.NameRaw)
+	if e.Linkname != "" && !utf8.ValidString(e.Linkname) {
+		e.LinknameRaw, e.Linkname = []byte(e.Linkname), ""
+	}
+	m.stringField(protoLink, e.Link)
+	m.stringField(protoLinkname, e.Linkname)
+	m.bytesField(protoLinknameRaw, e.LinknameRaw)
+	m.uintField(protoSize, uint64(e.Size))
+	if e.Type == SegmentType && isZeros(e.Payload) && e.Encoding == "" {
+		m.uintField(protoZeros, uint64(len(e.Payload)))
+	} else {
+		m.bytesField(protoPayload, e.Payload)
+	}
+	if e.Partial {
+		m.uintField(protoPartial, 1)
+	}
+	m.uintField(protoPartOffset, uint64(e.PartOffset))
+	m.stringField(protoSpecial, e.Special)
+	m.bytesField(protoInline, e.Inline)
+	m.stringField(protoChecksum, e.Checksum)
+	for _, c := range e.Checksums {
+		m.stringField(protoChecksums, c)
+	}
+	m.uintField(protoOffset, uint64(e.Offset))
+	for _, se := range e.Sparse {
+		var a protoBuffer
+		// the offset is always there, so an extent is never an empty message
+		a.varint(1<<3 | wireVarint)
+		a.varint(uint64(se.Offset))
+		a.uintField(2, uint64(se.Length))
+		m.bytesField(protoSparse, a.Bytes())
+	}
+	if e.SparsePacked {
+		m.uintField(protoSparsePacked, 1)
+	}
+	var keys []string
+	for k := range e.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var a protoBuffer
+		a.stringField(1, k)
+		a.stringField(2, e.Annotations[k])
+		m.bytesField(protoAnnotations, a.Bytes())
+	}
+	for _, c := range e.Chunks {
+		var a protoBuffer
+		a.uintField(1, uint64(c.Offset))
+		a.uintField(2, uint64(c.Length))
+		a.stringField(3, c.Digest)
+		m.bytesField(protoChunks, a.Bytes())
+	}
+	keys = keys[:0]
+	for k := range e.Extensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var x protoBuffer
+		x.stringField(1, k)
+		x.bytesField(2, e.Extensions[k])
+		m.bytesField(protoExtensions, x.Bytes())
+	}
+	m.stringField(protoEncoding, e.Encoding)
+	if e.Source != nil {
+		var s protoBuffer
+		s.stringField(1, e.Source.Digest)
+		s.uintField(2, uint64(e.Source.Size))
+		s.stringField(3, e.Source.Compression)
+		if g := e.Source.Gzip; g != nil {
+			var gb protoBuffer
+			for _, member := range g.Members {
+				var mb protoBuffer
+				mb.stringField(1, member.Name)
+				mb.stringField(2, member.Comment)
+				mb.bytesField(3, member.Extra)
+				mb.uintField(4, uint64(member.ModTime))
+				mb.uintField(5, uint64(member.OS))
+				mb.uintField(6, uint64(member.Size))
+				// written even if empty, as the member still is one
+				gb.varint(1<<3 | wireBytes)
+				gb.varint(uint64(mb.Len()))
+				gb.Write(mb.Bytes())
+			}
+			if g.Reproducible {
+				gb.uintField(2, 1)
+			}
+			gb.uintField(3, uint64(g.Level))
+			s.bytesField(4, gb.Bytes())
+		}
+		m.bytesField(protoSource, s.Bytes())
+	}
+	if e.Summary != nil {
+		var s protoBuffer
+		s.uintField(1, uint64(e.Summary.Entries))
+		s.uintField(2, uint64(e.Summary.Size))
+		s.stringField(3, e.Summary.Digest)
+		m.bytesField(protoSummary, s.Bytes())
+	}
+
+	pp.buf.Reset()
+	pp.buf.varint(uint64(m.Len()))
+	pp.buf.Write(m.Bytes())
+	if _, err := pp.w.Write(pp.buf.Bytes()); err != nil {
+		return -1, err
+	}
+	pos := pp.pos
+	pp.pos++
+	return pos, nil
+}
+
+// NewProtoUnpacker returns an Unpacker of the metadata written by a
+// ProtoPacker
+func NewProtoUnpacker(r io.Reader) Unpacker {
+	return &protoUnpacker{r: bufio.NewReader(r), seen: seenNames{}}
+}
+
+type protoUnpacker struct {
+	r     *bufio.Reader
+	pos   int
+	seen  seenNames
+	magic bool
+	buf   []byte
+}
+
+func (pu *protoUnpacker) Next() (*Entry, error) {
+	if !pu.magic {
+		magic := make([]byte, len(ProtoMagic))
+		if _, err := io.ReadFull(pu.r, magic); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, ErrInvalidProto
+		}
+		if string(magic) != ProtoMagic {
+			return nil, ErrInvalidProto
+		}
+		pu.magic = true
+	}
+	size, err := binary.ReadUvarint(pu.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, ErrInvalidProto
+	}
+	if size > maxProtoEntrySize {
+		return nil, ErrInvalidProto
+	}
+	if uint64(cap(pu.buf)) < size {
+		pu.buf = make([]byte, size)
+	}
+	msg := pu.buf[:size]
+	if _, err := io.ReadFull(pu.r, msg); err != nil {
+		return nil, ErrInvalidProto
+	}
+	e, err := decodeProtoEntry(msg)
+	if err != nil {
+		return nil, err
+	}
+	e.Position = pu.pos
+	pu.pos++
+
+	if e.Type == SegmentType && e.Encoding != "" {
+		if e.Payload, err = decodeSegment(e.Encoding, e.Payload, 0); err != nil {
+			return nil, err
+		}
+		e.Encoding = ""
+	}
+	if e.Type == FileType {
+		cName := filepath.Clean(e.GetName())
+		if _, ok := pu.seen[cName]; ok {
+			return nil, ErrDuplicatePath
+		}
+		pu.seen[cName] = struct{}{}
+	}
+	return e, nil
+}
+
+// protoFields calls fn with each field of the message `b`, with the value of
+// a varint, or the bytes of a length delimited field
+func protoFields(b []byte, fn func(field int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrInvalidProto
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return ErrInvalidProto
+			}
+			b = b[n:]
+			if err := fn(field, v, nil); err != nil {
+				return err
+			}
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return ErrInvalidProto
+			}
+			data := b[n : n+int(l)]
+			b = b[n+int(l):]
+			if err := fn(field, 0, data); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("storage: unsupported protobuf wire type %d", key&7)
+		}
+	}
+	return nil
+}
+
+func decodeProtoEntry(msg []byte) (*Entry, error) {
+	e := &Entry{}
+	err := protoFields(msg, func(field int, v uint64, data []byte) error {
+		switch field {
+		case protoType:
+			e.Type = Type(v)
+		case protoName:
+			e.Name = string(data)
+		case protoNameRaw:
+			e.NameRaw = append([]byte(nil), data...)
+		case protoLink:
+			e.Link = string(data)
+		case protoLinkname:
+			e.Linkname = string(data)
+		case protoLinknameRaw:
+			e.LinknameRaw = append([]byte(nil), data...)
+		case protoSize:
+			e.Size = int64(v)
+		case protoPayload:
+			e.Payload = append([]byte(nil), data...)
+		case protoZeros:
+			if v > maxProtoEntrySize {
+				return ErrInvalidProto
+			}
+			e.Payload = make([]byte, v)
+		case protoPartial:
+			e.Partial = v != 0
+		case protoPartOffset:
+			e.PartOffset = int64(v)
+		case protoSpecial:
+			e.Special = string(data)
+		case protoInline:
+			e.Inline = append([]byte(nil), data...)
+		case protoChecksum:
+			e.Checksum = string(data)
+		case protoChecksums:
+			e.Checksums = append(e.Checksums, string(data))
+		case protoOffset:
+			e.Offset = int64(v)
+		case protoSparse:
+			var se SparseExtent
+			err := protoFields(data, func(field int, v uint64, _ []byte) error {
+				if field == 1 {
+					se.Offset = int64(v)
+				} else if field == 2 {
+					se.Length = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			e.Sparse = append(e.Sparse, se)
+		case protoSparsePacked:
+			e.SparsePacked = v != 0
+		case protoAnnotations:
+			var k, val string
+			err := protoFields(data, func(field int, _ uint64, data []byte) error {
+				if field == 1 {
+					k = string(data)
+				} else if field == 2 {
+					val = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if e.Annotations == nil {
+				e.Annotations = map[string]string{}
+			}
+			e.Annotations[k] = val
+		case protoChunks:
+			var c Chunk
+			err := protoFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					c.Offset = int64(v)
+				case 2:
+					c.Length = int64(v)
+				case 3:
+					c.Digest = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			e.Chunks = append(e.Chunks, c)
+		case protoExtensions:
+			var k string
+			var val []byte
+			err := protoFields(data, func(field int, _ uint64, data []byte) error {
+				if field == 1 {
+					k = string(data)
+				} else if field == 2 {
+					val = append([]byte(nil), data...)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if e.Extensions == nil {
+				e.Extensions = map[string][]byte{}
+			}
+			e.Extensions[k] = val
+		case protoEncoding:
+			e.Encoding = string(data)
+		case protoSource:
+			e.Source = &Source{}
+			return protoFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					e.Source.Digest = string(data)
+				case 2:
+					e.Source.Size = int64(v)
+				case 3:
+					e.Source.Compression = string(data)
+				case 4:
+					g, err := unmarshalGzipParams(data)
+					if err != nil {
+						return err
+					}
+					e.Source.Gzip = g
+				}
+				return nil
+			})
+		case protoSummary:
+			e.Summary = &Summary{}
+			return protoFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					e.Summary.Entries = int(v)
+				case 2:
+					e.Summary.Size = int64(v)
+				case 3:
+					e.Summary.Digest = string(data)
+				}
+				return nil
+			})
+		}
+		// unknown fields are skipped, for metadata of newer versions
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// NewAutoUnpacker returns an Unpacker of the metadata `r`, in whichever
+// format it was packed, by a ProtoPacker or as JSON
+func NewAutoUnpacker(r io.Reader) Unpacker {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(ProtoMagic)); string(magic) == ProtoMagic {
+		return NewProtoUnpacker(br)
+	}
+	return NewJSONUnpacker(br)
+}
+
+func unmarshalGzipParams(data []byte) (*GzipParams, error) {
+	g := &GzipParams{}
+	err := protoFields(data, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1:
+			var member GzipMember
+			err := protoFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					member.Name = string(data)
+				case 2:
+					member.Comment = string(data)
+				case 3:
+					member.Extra = append([]byte(nil), data...)
+				case 4:
+					member.ModTime = int64(v)
+				case 5:
+					member.OS = byte(v)
+				case 6:
+					member.Size = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			g.Members = append(g.Members, member)
+		case 2:
+			g.Reproducible = v != 0
+		case 3:
+			g.Level = int(v)
+		}
+		return nil
+	})
+	return g, err
+}
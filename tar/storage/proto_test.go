@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+var protoEntries = []Entry{
+	{Type: SourceType, Source: &Source{Digest: "sha256:abcd", Size: 1234, Compression: "gzip", Gzip: &GzipParams{Members: []GzipMember{{Name: "layer.tar", Extra: []byte("x"), ModTime: 1500000000, OS: 3, Size: 1000}, {}}, Reproducible: true, Level: 6}}},
+	{Type: SegmentType, Payload: []byte("a header"), Extensions: map[string][]byte{"io.example.segment": {0, 1, 2}}},
+	{Type: FileType, Name: "./hurr.txt", Size: 8, Payload: []byte("deadbeef"), Checksum: "sha256:beef", Checksums: []string{"blake3:cafe", "sha512:f00d"}, Offset: 512, Annotations: map[string]string{"a": "1", "b": "2"}, Extensions: map[string][]byte{"io.example.a": []byte("1"), "io.example.b": []byte("\x00\xff")}},
+	{Type: SegmentType, Payload: make([]byte, 504)},
+	{Type: FileType, Name: "\xff\xfe", Size: 3, Payload: []byte("crc"), Chunks: []Chunk{{Offset: 0, Length: 2, Digest: "sha256:ab"}, {Offset: 2, Length: 1, Digest: "sha256:cd"}}},
+	{Type: FileType, Name: "hardlink", Link: HardLink, Linkname: "./hurr.txt"},
+	{Type: FileType, Name: "symlink", Link: SymLink, LinknameRaw: []byte("\xff")},
+	{Type: FileType, Name: "part", Size: 512, Payload: []byte("crc"), Partial: true, PartOffset: 1024},
+	{Type: FileType, Name: "sparse", Size: 4096, Payload: []byte("crc"), Sparse: []SparseExtent{{Offset: 0, Length: 512}, {Offset: 4096}}, SparsePacked: true},
+	{Type: FileType, Name: "dumpdir", Size: 4, Special: GNUDumpDir, Inline: []byte("Yfoo")},
+	{Type: SegmentType, Payload: bytes.Repeat([]byte("a huge PAX header "), 100)},
+	{Type: SummaryType, Summary: &Summary{Entries: 8, Size: 4096, Digest: "sha256:1234"}},
+}
+
+func TestProtoPackerUnpacker(t *testing.T) {
+	var buf bytes.Buffer
+	// the huge segment is packed encoded, and decoded on unpacking
+	pp, err := NewSegmentEncodingPacker(NewProtoPacker(&buf), "gzip", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range protoEntries {
+		pos, err := pp.AddEntry(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pos != i {
+			t.Errorf("expected position %d, got %d", i, pos)
+		}
+	}
+	if _, err := pp.AddEntry(Entry{Type: FileType, Name: "hurr.txt"}); err != ErrDuplicatePath {
+		t.Errorf("expected ErrDuplicatePath, got %v", err)
+	}
+
+	for _, up := range []Unpacker{NewProtoUnpacker(bytes.NewReader(buf.Bytes())), NewAutoUnpacker(bytes.NewReader(buf.Bytes()))} {
+		for i, want := range protoEntries {
+			got, err := up.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want.Position = i
+			if want.Name == "\xff\xfe" {
+				want.Name, want.NameRaw = "", []byte(want.Name)
+			}
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("expected %#v, got %#v", want, *got)
+			}
+		}
+		if _, err := up.Next(); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+	}
+}
+
+func TestProtoSmallerThanJSON(t *testing.T) {
+	var pbuf, jbuf bytes.Buffer
+	pp, jp := NewProtoPacker(&pbuf), NewJSONPacker(&jbuf)
+	for _, p := range []Packer{pp, jp} {
+		for _, e := range protoEntries {
+			p.AddEntry(e)
+		}
+	}
+	if pbuf.Len() >= jbuf.Len()*2/3 {
+		t.Errorf("expected the protobuf metadata to be much smaller than JSON, got %d and %d bytes", pbuf.Len(), jbuf.Len())
+	}
+	e, err := NewAutoUnpacker(bytes.NewReader(jbuf.Bytes())).Next()
+	if err != nil || e.Type != SourceType {
+		t.Errorf("expected JSON detected, got %v and %v", e, err)
+	}
+}
+
+func TestProtoUnpackerInvalid(t *testing.T) {
+	for _, data := range []string{
+		"not protobuf at all",
+		ProtoMagic + "\x05\x08",
+		ProtoMagic + "\x03\x0a\x09a",
+	} {
+		if _, err := NewProtoUnpacker(bytes.NewReader([]byte(data))).Next(); err == nil || err == io.EOF {
+			t.Errorf("expected an error for %q, got %v", data, err)
+		}
+	}
+	if _, err := NewProtoUnpacker(bytes.NewReader(nil)).Next(); err != io.EOF {
+		t.Errorf("expected io.EOF for no metadata, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Scanner inspects the payload of a file as it is disassembled, e.g. for
+// secrets, malware or licenses. The returned results are attached to the
+// file's entry as annotations.
+//
+// Scan need not read all of `r`. It is called concurrently for each
+// scanner, and for the duration of reading `r` holds up the disassembly.
+type Scanner interface {
+	Scan(name string, r io.Reader) (map[string]string, error)
+}
+
+// ScannerFunc is a function that is a Scanner
+type ScannerFunc func(name string, r io.Reader) (map[string]string, error)
+
+// Scan calls f(name, r)
+func (f ScannerFunc) Scan(name string, r io.Reader) (map[string]string, error) {
+	return f(name, r)
+}
+
+// ScanningPacker is both a FilePutter and a Packer, that passes the file
+// payloads to scanners while they are stored. Give it as both the Packer and
+// the FilePutter to asm.NewInputTarStream, so that file payloads are scanned
+// in the stream, without a second pass over the archive.
+//
+// The results of the scanners are merged into the Annotations of the file's
+// FileType entry, in the order the scanners were given.
+type ScanningPacker struct {
+	p        Packer
+	fp       FilePutter
+	scanners []Scanner
+	results  map[string]map[string]string
+}
+
+// NewScanningPacker returns a ScanningPacker that stores file payloads to `fp`
+// (or discards them, if nil) and packs the entries to `p`.
+func NewScanningPacker(p Packer, fp FilePutter, scanners ...Scanner) *ScanningPacker {
+	if fp == nil {
+		fp = NewDiscardFilePutter()
+	}
+	return &ScanningPacker{
+		p:        p,
+		fp:       fp,
+		scanners: scanners,
+		results:  map[string]map[string]string{},
+	}
+}
+
+// Put stores the payload, while each of the scanners reads it
+func (sp *ScanningPacker) Put(name string, r io.Reader) (int64, []byte, error) {
+	if len(sp.scanners) == 0 {
+		return sp.fp.Put(name, r)
+	}
+	var (
+		wg      sync.WaitGroup
+		writers = make([]io.Writer, len(sp.scanners))
+		pipes   = make([]*io.PipeWriter, len(sp.scanners))
+		results = make([]map[string]string, len(sp.scanners))
+		errs    = make([]error, len(sp.scanners))
+	)
+	for i, s := range sp.scanners {
+		pr, pw := io.Pipe()
+		writers[i], pipes[i] = pw, pw
+		wg.Add(1)
+		go func(i int, s Scanner) {
+			defer wg.Done()
+			results[i], errs[i] = s.Scan(name, pr)
+			if errs[i] != nil {
+				pr.CloseWithError(errs[i])
+				return
+			}
+			// the scanner may not have needed all of the payload
+			_, errs[i] = io.Copy(ioutil.Discard, pr)
+		}(i, s)
+	}
+
+	size, csum, err := sp.fp.Put(name, io.TeeReader(r, io.MultiWriter(writers...)))
+	for _, pw := range pipes {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	merged := map[string]string{}
+	for i := range sp.scanners {
+		if errs[i] != nil {
+			return 0, nil, errs[i]
+		}
+		for k, v := range results[i] {
+			merged[k] = v
+		}
+	}
+	if len(merged) > 0 {
+		sp.results[name] = merged
+	}
+	return size, csum, nil
+}
+
+// AddEntry packs the entry, with the scanners' results for its payload
+func (sp *ScanningPacker) AddEntry(e Entry) (int, error) {
+	if e.Type == FileType {
+		if results, ok := sp.results[e.GetName()]; ok {
+			delete(sp.results, e.GetName())
+			if e.Annotations == nil {
+				e.Annotations = map[string]string{}
+			}
+			for k, v := range results {
+				e.Annotations[k] = v
+			}
+		}
+	}
+	return sp.p.AddEntry(e)
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestScanningPacker(t *testing.T) {
+	secrets := ScannerFunc(func(name string, r io.Reader) (map[string]string, error) {
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		n := bytes.Count(buf, []byte("password"))
+		if n == 0 {
+			return nil, nil
+		}
+		return map[string]string{"secrets": strconv.Itoa(n)}, nil
+	})
+	// only needs the start of the payload
+	magic := ScannerFunc(func(name string, r io.Reader) (map[string]string, error) {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, nil
+		}
+		return map[string]string{"magic": string(buf)}, nil
+	})
+
+	buf := bytes.NewBuffer(nil)
+	fgp := NewBufferFileGetPutter()
+	sp := NewScanningPacker(NewJSONPacker(buf), fgp, secrets, magic)
+	files := map[string]string{
+		"etc/shadow": "root:password:0\nuser:password:1\n",
+		"bin/true":   strings.Repeat("\x7fELF", 10000),
+	}
+	for _, name := range []string{"etc/shadow", "bin/true"} {
+		if _, _, err := sp.Put(name, strings.NewReader(files[name])); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sp.AddEntry(Entry{Type: FileType, Name: name, Size: int64(len(files[name]))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]map[string]string{
+		"etc/shadow": {"secrets": "2", "magic": "root"},
+		"bin/true":   {"magic": "\x7fELF"},
+	}
+	up := NewJSONUnpacker(buf)
+	for {
+		entry, err := up.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := expected[entry.GetName()]
+		if len(entry.Annotations) != len(want) {
+			t.Errorf("%s: expected annotations %v, got %v", entry.GetName(), want, entry.Annotations)
+		}
+		for k, v := range want {
+			if entry.Annotations[k] != v {
+				t.Errorf("%s: expected %s=%q, got %q", entry.GetName(), k, v, entry.Annotations[k])
+			}
+		}
+	}
+
+	// the payloads are still stored whole
+	rdr, err := fgp.Get("bin/true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(rdr)
+	if string(got) != files["bin/true"] {
+		t.Errorf("expected the stored payload to be %d bytes, got %d", len(files["bin/true"]), len(got))
+	}
+}
+
+func TestScanningPackerError(t *testing.T) {
+	errMalware := errors.New("malware found")
+	sp := NewScanningPacker(NewJSONPacker(ioutil.Discard), nil, ScannerFunc(func(name string, r io.Reader) (map[string]string, error) {
+		return nil, errMalware
+	}))
+	if _, _, err := sp.Put("evil", strings.NewReader(strings.Repeat("x", 100000))); err != errMalware {
+		t.Errorf("expected the scanner's error, got %v", err)
+	}
+}
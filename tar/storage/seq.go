@@ -0,0 +1,34 @@
+//go:build go1.23
+// +build go1.23
+
+package storage
+
+import (
+	"io"
+	"iter"
+)
+
+// EntrySeq returns an iterator over the entries of `up`, for range loops. An
+// error other than io.EOF ends the iteration, yielded with a nil entry.
+// Breaking out of the loop leaves the rest of `up` unread, so that it can be
+// picked up again, like by a PeekUnpacker.
+//
+//	for entry, err := range storage.EntrySeq(up) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func EntrySeq(up Unpacker) iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		for {
+			entry, err := up.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(entry, err) || err != nil {
+				return
+			}
+		}
+	}
+}
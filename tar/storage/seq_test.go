@@ -0,0 +1,48 @@
+//go:build go1.23
+// +build go1.23
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEntrySeq(t *testing.T) {
+	var count int
+	for _, err := range EntrySeq(NewJSONUnpacker(bytes.NewReader(packedEntries(t, 3)))) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	if count != 6 {
+		t.Errorf("expected 6 entries, got %d", count)
+	}
+
+	// the rest is left to be unpacked, after a break
+	pu := NewPeekUnpacker(NewJSONUnpacker(bytes.NewReader(packedEntries(t, 3))))
+	for entry := range EntrySeq(pu) {
+		if entry.Type == FileType {
+			break
+		}
+	}
+	entry, err := pu.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Type != SegmentType {
+		t.Errorf("expected the segment after the first file, got %#v", entry)
+	}
+
+	var errs int
+	for entry, err := range EntrySeq(NewJSONUnpacker(bytes.NewReader([]byte("{not json")))) {
+		if err == nil || entry != nil {
+			t.Errorf("expected the invalid metadata to fail, got %#v, %v", entry, err)
+		}
+		errs++
+	}
+	if errs != 1 {
+		t.Errorf("expected the error once, got %d", errs)
+	}
+}
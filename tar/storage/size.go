@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"io"
+)
+
+// TarSize returns the size of the tar stream that the metadata read from `up`
+// is assembled to, from the metadata alone, without the file payloads, e.g.
+// for the Content-Length of an archive to be assembled as it is served. The
+// padding and end of archive marker are in the segments, so it is exact.
+func TarSize(up Unpacker) (int64, error) {
+	var size int64
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				return size, nil
+			}
+			return 0, err
+		}
+		size += entrySize(entry)
+	}
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTarSize(t *testing.T) {
+	size, err := TarSize(NewJSONUnpacker(bytes.NewReader(packSummarized(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 22 {
+		t.Errorf("expected 22 bytes, got %d", size)
+	}
+
+	// only the data of a sparse file is in the archive
+	buf := bytes.NewBuffer(nil)
+	p := NewJSONPacker(buf)
+	p.AddEntry(Entry{Type: SegmentType, Payload: make([]byte, 512)})
+	p.AddEntry(Entry{Type: FileType, Name: "sparse", Size: 1 << 30, Sparse: []SparseExtent{{Offset: 0, Length: 512}, {Offset: 1<<30 - 512, Length: 512}}})
+	p.AddEntry(Entry{Type: SegmentType, Payload: make([]byte, 1024)})
+	if size, err = TarSize(NewJSONUnpacker(buf)); err != nil {
+		t.Fatal(err)
+	}
+	if size != 2560 {
+		t.Errorf("expected 2560 bytes, got %d", size)
+	}
+
+	if _, err := TarSize(NewJSONUnpacker(bytes.NewReader([]byte("{not json")))); err == nil {
+		t.Error("expected the invalid metadata to fail")
+	}
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
+)
+
+// NewSnapshotFileGetter returns a FileGetter that sources the payloads of the
+// layer whose metadata is read from `up` from the files of `dir`, falling back
+// to `fg` for those it does not have. `dir` is the mounted diff directory of
+// a snapshot of a sibling layer, like that of a containerd or BuildKit
+// snapshotter, so that the files a busy node already has need not be pulled.
+//
+// A file of `dir` is used only if its size and checksum are those recorded
+// for the payload. The file at the same path is tried first, and then the
+// other files of the same size, as siblings often have the same content under
+// other names. `dir` is walked once for the sizes of its regular files, and
+// is expected not to change while the getter is used.
+func NewSnapshotFileGetter(dir string, up Unpacker, fg FileGetter) (FileGetter, error) {
+	sfg := &snapshotFileGetter{
+		dir:      dir,
+		fg:       fg,
+		payloads: map[string]snapshotPayload{},
+		bySize:   map[int64][]string{},
+		sums:     map[string][]byte{},
+	}
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		// the parts of a split payload are not files of their own
+		if entry.Type != FileType || entry.Size == 0 || entry.Partial {
+			continue
+		}
+		sfg.payloads[entry.GetName()] = snapshotPayload{size: entry.Size, sum: entry.Payload}
+	}
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() && fi.Size() > 0 {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			sfg.bySize[fi.Size()] = append(sfg.bySize[fi.Size()], rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sfg, nil
+}
+
+type snapshotPayload struct {
+	size int64
+	sum  []byte
+}
+
+type snapshotFileGetter struct {
+	dir      string
+	fg       FileGetter
+	payloads map[string]snapshotPayload
+	bySize   map[int64][]string // relative paths of the files of dir
+
+	mu   sync.Mutex
+	sums map[string][]byte // checksums of the files of dir computed so far
+}
+
+func (sfg *snapshotFileGetter) Get(filename string) (io.ReadCloser, error) {
+	payload, ok := sfg.payloads[filename]
+	if !ok {
+		return sfg.fg.Get(filename)
+	}
+	same := filepath.FromSlash(cleanLayerPath(filename))
+	candidates := append([]string{same}, sfg.bySize[payload.size]...)
+	for i, rel := range candidates {
+		if i > 0 && rel == same {
+			continue
+		}
+		if fh := sfg.open(rel, payload); fh != nil {
+			return fh, nil
+		}
+	}
+	return sfg.fg.Get(filename)
+}
+
+// open returns the file `rel` of the snapshot, if it is the payload, or nil
+func (sfg *snapshotFileGetter) open(rel string, payload snapshotPayload) io.ReadCloser {
+	fh, err := os.Open(filepath.Join(sfg.dir, rel))
+	if err != nil {
+		return nil
+	}
+	fi, err := fh.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() != payload.size {
+		fh.Close()
+		return nil
+	}
+	sfg.mu.Lock()
+	sum, ok := sfg.sums[rel]
+	sfg.mu.Unlock()
+	if !ok {
+		pd := NewPayloadDigester()
+		if _, err := bufpool.Copy(pd, fh); err != nil {
+			fh.Close()
+			return nil
+		}
+		sum = pd.Checksum()
+		sfg.mu.Lock()
+		sfg.sums[rel] = sum
+		sfg.mu.Unlock()
+		if _, err := fh.Seek(0, 0); err != nil {
+			fh.Close()
+			return nil
+		}
+	}
+	if !bytes.Equal(sum, payload.sum) {
+		fh.Close()
+		return nil
+	}
+	return fh
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotFileGetter(t *testing.T) {
+	files := map[string]string{
+		"./etc/same":    "in both layers",
+		"usr/moved":     "under another name in the snapshot",
+		"usr/changed":   "as in the layer",
+		"usr/not-there": "only in the layer",
+	}
+	fgp := NewBufferFileGetPutter()
+	meta := bytes.NewBuffer(nil)
+	p := NewJSONPacker(meta)
+	for name, content := range files {
+		size, sum, err := fgp.Put(name, strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.AddEntry(Entry{Type: FileType, Name: name, Size: size, Payload: sum}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshot := map[string]string{
+		"etc/same":        "in both layers",
+		"opt/elsewhere":   "under another name in the snapshot",
+		"usr/changed":     "as in the snapshot!",
+		"usr/another-one": "as in the snapshot!",
+	}
+	for name, content := range snapshot {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fallback := &countingFileGetter{FileGetter: fgp}
+	sfg, err := NewSnapshotFileGetter(dir, NewJSONUnpacker(meta), fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if got := readPayload(t, sfg, name); got != content {
+			t.Errorf("expected %q for %s, got %q", content, name, got)
+		}
+	}
+	// only the payloads that are not in the snapshot are fetched
+	if fallback.gets != 2 {
+		t.Errorf("expected 2 payloads from the fallback, got %d", fallback.gets)
+	}
+}
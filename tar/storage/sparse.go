@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// SparseExtent is a data fragment of a sparse file, of Length bytes at Offset
+// in the file. The rest of the file is holes, that are not in the archive.
+type SparseExtent struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// DataSize is the size of the payload of a FileType entry in the archive,
+// which is its Size, or for a sparse file, the size of its data
+func (e *Entry) DataSize() int64 {
+	if len(e.Sparse) == 0 {
+		return e.Size
+	}
+	var n int64
+	for _, s := range e.Sparse {
+		n += s.Length
+	}
+	return n
+}
+
+// SparseFilePutter is a FilePutter that can store the payload of a sparse file
+// without its holes. Disassembly uses it for sparse files when the FilePutter
+// given is one, and marks the entries SparsePacked.
+type SparseFilePutter interface {
+	FilePutter
+	// PutSparse stores only the data of the file `r` (holes and all) in the
+	// extents, one after another, and returns their size and crc64 checksum
+	PutSparse(filename string, r io.Reader, extents []SparseExtent) (int64, []byte, error)
+}
+
+// NewHolelessFilePutter returns a SparseFilePutter that stores payloads to
+// `fp`, with the holes of sparse files left out, so a mostly empty disk
+// image takes only the space of its data.
+func NewHolelessFilePutter(fp FilePutter) SparseFilePutter {
+	return holelessFilePutter{fp}
+}
+
+type holelessFilePutter struct {
+	FilePutter
+}
+
+func (hfp holelessFilePutter) PutSparse(name string, r io.Reader, extents []SparseExtent) (int64, []byte, error) {
+	sr := NewSparseDataReader(r, extents)
+	size, csum, err := hfp.Put(name, sr)
+	if err != nil {
+		return 0, nil, err
+	}
+	// the trailing hole is read too, for the rest of the stream
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return 0, nil, err
+	}
+	return size, csum, nil
+}
+
+// NewSparseDataReader returns a reader of only the data of the sparse file
+// `r`, in the extents, one after another, as they are in the archive. The
+// holes are read from `r` and discarded.
+func NewSparseDataReader(r io.Reader, extents []SparseExtent) io.Reader {
+	return &sparseDataReader{r: r, extents: extents}
+}
+
+type sparseDataReader struct {
+	r       io.Reader
+	extents []SparseExtent
+	pos     int64
+}
+
+func (sdr *sparseDataReader) Read(p []byte) (int, error) {
+	for len(sdr.extents) > 0 && sdr.pos >= sdr.extents[0].Offset+sdr.extents[0].Length {
+		sdr.extents = sdr.extents[1:]
+	}
+	if len(sdr.extents) == 0 {
+		return 0, io.EOF
+	}
+	e := sdr.extents[0]
+	if sdr.pos < e.Offset {
+		n, err := io.CopyN(ioutil.Discard, sdr.r, e.Offset-sdr.pos)
+		sdr.pos += n
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	if rest := e.Offset + e.Length - sdr.pos; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := sdr.r.Read(p)
+	sdr.pos += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// NewSparseFileReader returns a reader of the sparse file of `size`, from
+// `data` of its extents one after another, as they are in the archive, with
+// the holes filled in with zeros
+func NewSparseFileReader(data io.Reader, extents []SparseExtent, size int64) io.Reader {
+	return &sparseFileReader{data: data, extents: extents, size: size}
+}
+
+type sparseFileReader struct {
+	data    io.Reader
+	extents []SparseExtent
+	size    int64
+	pos     int64
+}
+
+func (sfr *sparseFileReader) Read(p []byte) (int, error) {
+	for len(sfr.extents) > 0 && sfr.pos >= sfr.extents[0].Offset+sfr.extents[0].Length {
+		sfr.extents = sfr.extents[1:]
+	}
+	if sfr.pos >= sfr.size {
+		return 0, io.EOF
+	}
+	end := sfr.size
+	if len(sfr.extents) > 0 {
+		if e := sfr.extents[0]; sfr.pos >= e.Offset {
+			if rest := e.Offset + e.Length - sfr.pos; int64(len(p)) > rest {
+				p = p[:rest]
+			}
+			n, err := sfr.data.Read(p)
+			sfr.pos += int64(n)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		end = sfr.extents[0].Offset
+	}
+	if int64(len(p)) > end-sfr.pos {
+		p = p[:end-sfr.pos]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	sfr.pos += int64(len(p))
+	return len(p), nil
+}
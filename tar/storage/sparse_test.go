@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var sparseExtents = []SparseExtent{{Offset: 2, Length: 5}, {Offset: 18, Length: 3}}
+
+const (
+	sparseData = "abcdefgh"
+	sparseFile = "\x00\x00abcde\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00fgh\x00\x00\x00\x00"
+)
+
+func TestSparseReaders(t *testing.T) {
+	data, err := ioutil.ReadAll(NewSparseDataReader(strings.NewReader(sparseFile), sparseExtents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != sparseData {
+		t.Errorf("expected the data %q, got %q", sparseData, data)
+	}
+	file, err := ioutil.ReadAll(NewSparseFileReader(strings.NewReader(sparseData), sparseExtents, int64(len(sparseFile))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(file) != sparseFile {
+		t.Errorf("expected the file %q, got %q", sparseFile, file)
+	}
+	if _, err := ioutil.ReadAll(NewSparseFileReader(strings.NewReader("abc"), sparseExtents, int64(len(sparseFile)))); err == nil {
+		t.Error("expected an error for data that is short")
+	}
+
+	e := Entry{Type: FileType, Size: int64(len(sparseFile)), Sparse: sparseExtents}
+	if e.DataSize() != int64(len(sparseData)) {
+		t.Errorf("expected the data size %d, got %d", len(sparseData), e.DataSize())
+	}
+}
+
+func TestHolelessFilePutter(t *testing.T) {
+	fgp := NewBufferFileGetPutter()
+	hfp := NewHolelessFilePutter(fgp)
+	size, csum, err := hfp.PutSparse("sparse", strings.NewReader(sparseFile), sparseExtents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pd := NewPayloadDigester()
+	pd.Write([]byte(sparseData))
+	if size != int64(len(sparseData)) || !bytes.Equal(csum, pd.Checksum()) {
+		t.Errorf("expected the size and checksum of the data, got %d and %x", size, csum)
+	}
+	if got := readPayload(t, fgp, "sparse"); got != sparseData {
+		t.Errorf("expected only the data stored, got %q", got)
+	}
+}
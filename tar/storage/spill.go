@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
+)
+
+// DefaultSpillThreshold is the size of the payloads that a
+// SpillingFileGetPutter keeps in memory, if not given
+const DefaultSpillThreshold = 1 << 20
+
+// NewSpillingFileGetPutter returns a FileGetPutter that keeps the payloads of
+// up to `threshold` bytes in memory, as NewBufferFileGetPutter, and spills the
+// larger ones to temporary files, in a directory it makes under `dir` (or the
+// default directory for temporary files, if ""). The threshold is
+// DefaultSpillThreshold if 0 or less. It is to be closed to remove the files.
+//
+// It is also a FileLister, FileDeleter and FileStatter, and is safe for
+// concurrent use.
+func NewSpillingFileGetPutter(dir string, threshold int64) (*SpillingFileGetPutter, error) {
+	if threshold <= 0 {
+		threshold = DefaultSpillThreshold
+	}
+	tmp, err := ioutil.TempDir(dir, "tar-split-spill-")
+	if err != nil {
+		return nil, err
+	}
+	return &SpillingFileGetPutter{
+		dir:       tmp,
+		threshold: threshold,
+		payloads:  map[string]spilledPayload{},
+	}, nil
+}
+
+// SpillingFileGetPutter is the FileGetPutter of NewSpillingFileGetPutter
+type SpillingFileGetPutter struct {
+	dir       string
+	threshold int64
+	mu        sync.RWMutex
+	payloads  map[string]spilledPayload
+	bytes     int64
+}
+
+// spilledPayload is a payload in memory, or in the file `path` if it is set
+type spilledPayload struct {
+	b    []byte
+	path string
+	size int64
+}
+
+// Get the payload of `name`, from memory or from its file
+func (sfgp *SpillingFileGetPutter) Get(name string) (io.ReadCloser, error) {
+	sfgp.mu.RLock()
+	p, ok := sfgp.payloads[name]
+	sfgp.mu.RUnlock()
+	if !ok {
+		return nil, ErrNoSuchFile
+	}
+	if p.path == "" {
+		return &readCloserWrapper{bytes.NewReader(p.b)}, nil
+	}
+	// a file that is deleted or replaced once open is still read to its end
+	fh, err := os.Open(p.path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchFile
+	}
+	return fh, err
+}
+
+// Put the payload of `name`, spilling it to a file once it is over the
+// threshold
+func (sfgp *SpillingFileGetPutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	pd := NewPayloadDigester()
+	tr := io.TeeReader(r, pd)
+	buf := bytes.NewBuffer(nil)
+	if _, err := bufpool.Copy(buf, io.LimitReader(tr, sfgp.threshold+1)); err != nil {
+		return 0, nil, err
+	}
+	p := spilledPayload{b: buf.Bytes(), size: int64(buf.Len())}
+	if p.size > sfgp.threshold {
+		fh, err := ioutil.TempFile(sfgp.dir, "payload-")
+		if err != nil {
+			return 0, nil, err
+		}
+		_, err = buf.WriteTo(fh)
+		if err == nil {
+			_, err = bufpool.Copy(fh, tr)
+		}
+		if cerr := fh.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(fh.Name())
+			return 0, nil, err
+		}
+		p = spilledPayload{path: fh.Name(), size: pd.Size()}
+	}
+
+	sfgp.mu.Lock()
+	old, ok := sfgp.payloads[name]
+	sfgp.payloads[name] = p
+	sfgp.bytes += p.size - old.size
+	sfgp.mu.Unlock()
+	if ok && old.path != "" {
+		os.Remove(old.path)
+	}
+	return pd.Size(), pd.Checksum(), nil
+}
+
+// List the names of the payloads stored
+func (sfgp *SpillingFileGetPutter) List() ([]string, error) {
+	sfgp.mu.RLock()
+	defer sfgp.mu.RUnlock()
+	names := []string{}
+	for name := range sfgp.payloads {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Delete the payload of `name`, and its file if it was spilled
+func (sfgp *SpillingFileGetPutter) Delete(name string) error {
+	sfgp.mu.Lock()
+	p, ok := sfgp.payloads[name]
+	if ok {
+		sfgp.bytes -= p.size
+		delete(sfgp.payloads, name)
+	}
+	sfgp.mu.Unlock()
+	if !ok {
+		return ErrNoSuchFile
+	}
+	if p.path != "" {
+		return os.Remove(p.path)
+	}
+	return nil
+}
+
+// Stats of all of the payloads, in memory and spilled
+func (sfgp *SpillingFileGetPutter) Stats() Stats {
+	sfgp.mu.RLock()
+	defer sfgp.mu.RUnlock()
+	return Stats{Files: len(sfgp.payloads), Bytes: sfgp.bytes}
+}
+
+// Sizes of the payloads stored, by name
+func (sfgp *SpillingFileGetPutter) Sizes() map[string]int64 {
+	sfgp.mu.RLock()
+	defer sfgp.mu.RUnlock()
+	sizes := make(map[string]int64, len(sfgp.payloads))
+	for name, p := range sfgp.payloads {
+		sizes[name] = p.size
+	}
+	return sizes
+}
+
+// Spilled returns the number of payloads, and their bytes, that are in files
+// rather than memory
+func (sfgp *SpillingFileGetPutter) Spilled() Stats {
+	sfgp.mu.RLock()
+	defer sfgp.mu.RUnlock()
+	var s Stats
+	for _, p := range sfgp.payloads {
+		if p.path != "" {
+			s.Files++
+			s.Bytes += p.size
+		}
+	}
+	return s
+}
+
+// Close removes the spilled files, and all of the payloads with them
+func (sfgp *SpillingFileGetPutter) Close() error {
+	sfgp.mu.Lock()
+	sfgp.payloads = map[string]spilledPayload{}
+	sfgp.bytes = 0
+	sfgp.mu.Unlock()
+	return os.RemoveAll(sfgp.dir)
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSpillingFileGetPutter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tar-split-spill-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sfgp, err := NewSpillingFileGetPutter(dir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := map[string]string{
+		"small": "tiny",
+		"exact": "12345678",
+		"big":   strings.Repeat("big", 100),
+	}
+	for name, payload := range payloads {
+		size, csum, err := sfgp.Put(name, strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pd := NewPayloadDigester()
+		pd.Write([]byte(payload))
+		if size != int64(len(payload)) || !bytes.Equal(csum, pd.Checksum()) {
+			t.Errorf("%s: unexpected size %d or checksum", name, size)
+		}
+	}
+	for name, payload := range payloads {
+		if got := readPayload(t, sfgp, name); got != payload {
+			t.Errorf("%s: expected %q, got %q", name, payload, got)
+		}
+	}
+	if s := sfgp.Spilled(); s.Files != 1 || s.Bytes != 300 {
+		t.Errorf("expected only the big payload spilled, got %+v", s)
+	}
+	if s := sfgp.Stats(); s.Files != 3 || s.Bytes != 312 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	// replaced by a small payload, the spilled file is removed
+	sfgp.Put("big", strings.NewReader("small"))
+	if s := sfgp.Spilled(); s.Files != 0 {
+		t.Errorf("expected nothing spilled, got %+v", s)
+	}
+	sfgp.Put("big", strings.NewReader(payloads["big"]))
+	if err := sfgp.Delete("big"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sfgp.Get("big"); err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile once deleted, got %v", err)
+	}
+
+	spilled := sfgp.dir
+	if err := sfgp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(spilled); !os.IsNotExist(err) {
+		t.Errorf("expected the spill directory removed, got %v", err)
+	}
+}
+
+func TestBufferFileGetPutterConcurrent(t *testing.T) {
+	fgp := NewBufferFileGetPutter()
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			name := strings.Repeat("x", i+1)
+			for j := 0; j < 100; j++ {
+				fgp.Put(name, strings.NewReader(name))
+				if rc, err := fgp.Get(name); err == nil {
+					ioutil.ReadAll(rc)
+					rc.Close()
+				}
+				fgp.(FileStatter).Stats()
+				fgp.(FileLister).List()
+			}
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	if s := fgp.(FileStatter).Stats(); s.Files != 4 || s.Bytes != 10 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
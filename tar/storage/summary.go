@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+)
+
+var (
+	// ErrNoSummary is returned by a SummaryUnpacker at the end of metadata
+	// without a summary, e.g. as it was truncated.
+	ErrNoSummary = errors.New("storage: the metadata has no summary")
+	// ErrSummaryMismatch is returned when metadata does not match its summary
+	ErrSummaryMismatch = errors.New("storage: the metadata does not match its summary")
+)
+
+// Summary is of the entries of metadata, and the tar stream they assemble, to
+// detect metadata that is truncated or tampered with
+type Summary struct {
+	// Entries is the number of entries before the summary
+	Entries int `json:"entries"`
+	// Size of the tar stream
+	Size int64 `json:"size"`
+	// Digest of the tar stream, like "sha256:<hex>", if it was written to the
+	// SummaryPacker
+	Digest string `json:"digest,omitempty"`
+}
+
+// entrySize is the number of bytes of the tar stream of `e`
+func entrySize(e *Entry) int64 {
+	switch e.Type {
+	case SegmentType:
+		return int64(len(e.Payload))
+	case FileType:
+		return e.DataSize()
+	}
+	return 0
+}
+
+// SummaryPacker packs entries to a Packer, and a trailing SummaryType entry on
+// Close. The tar stream itself is not seen by a Packer, so for the summary to
+// have its digest, the stream is to be written to the SummaryPacker as well,
+// like the stream read from asm.NewInputTarStream.
+type SummaryPacker struct {
+	p       Packer
+	summary Summary
+	h       hash.Hash
+	written bool
+}
+
+// NewSummaryPacker returns a SummaryPacker packing to `p`
+func NewSummaryPacker(p Packer) *SummaryPacker {
+	return &SummaryPacker{p: p, h: sha256.New()}
+}
+
+// AddEntry packs the entry, counting it in the summary
+func (sp *SummaryPacker) AddEntry(e Entry) (int, error) {
+	pos, err := sp.p.AddEntry(e)
+	if err != nil {
+		return pos, err
+	}
+	sp.summary.Entries++
+	sp.summary.Size += entrySize(&e)
+	return pos, nil
+}
+
+// Write adds to the digest of the tar stream
+func (sp *SummaryPacker) Write(p []byte) (int, error) {
+	sp.written = true
+	return sp.h.Write(p)
+}
+
+// Close packs the summary. No entries are to be added after it.
+func (sp *SummaryPacker) Close() error {
+	summary := sp.summary
+	if sp.written {
+		summary.Digest = "sha256:" + hex.EncodeToString(sp.h.Sum(nil))
+	}
+	_, err := sp.p.AddEntry(Entry{Type: SummaryType, Summary: &summary})
+	return err
+}
+
+// SummaryUnpacker reads entries from an Unpacker, checking them against the
+// summary that ends them. It fails with ErrSummaryMismatch when they do not
+// match, and with ErrNoSummary when there is no summary.
+type SummaryUnpacker struct {
+	up      Unpacker
+	counted Summary
+	summary *Summary
+}
+
+// NewSummaryUnpacker returns a SummaryUnpacker reading from `up`
+func NewSummaryUnpacker(up Unpacker) *SummaryUnpacker {
+	return &SummaryUnpacker{up: up}
+}
+
+// Next returns the next entry, including the SummaryType entry
+func (su *SummaryUnpacker) Next() (*Entry, error) {
+	e, err := su.up.Next()
+	if err != nil {
+		if err == io.EOF && su.summary == nil {
+			return nil, ErrNoSummary
+		}
+		return nil, err
+	}
+	if su.summary != nil {
+		// entries after the summary
+		return nil, ErrSummaryMismatch
+	}
+	if e.Type == SummaryType {
+		if e.Summary == nil || e.Summary.Entries != su.counted.Entries || e.Summary.Size != su.counted.Size {
+			return nil, ErrSummaryMismatch
+		}
+		su.summary = e.Summary
+		return e, nil
+	}
+	su.counted.Entries++
+	su.counted.Size += entrySize(e)
+	return e, nil
+}
+
+// Summary returns the summary, once it has been read
+func (su *SummaryUnpacker) Summary() *Summary {
+	return su.summary
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func packSummarized(t *testing.T) []byte {
+	buf := bytes.NewBuffer(nil)
+	sp := NewSummaryPacker(NewJSONPacker(buf))
+	entries := []Entry{
+		{Type: SegmentType, Payload: []byte("header")},
+		{Type: FileType, Name: "file", Size: 10, Payload: []byte("checksum")},
+		{Type: SegmentType, Payload: make([]byte, 6)},
+	}
+	for _, e := range entries {
+		if _, err := sp.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sp.Write([]byte("the stream"))
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readAll(up Unpacker) error {
+	for {
+		if _, err := up.Next(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func TestSummaryUnpacker(t *testing.T) {
+	meta := packSummarized(t)
+	su := NewSummaryUnpacker(NewJSONUnpacker(bytes.NewReader(meta)))
+	if err := readAll(su); err != nil {
+		t.Fatal(err)
+	}
+	if s := su.Summary(); s == nil || s.Entries != 3 || s.Size != 22 || !strings.HasPrefix(s.Digest, "sha256:") {
+		t.Errorf("unexpected summary %+v", s)
+	}
+
+	// truncated before the summary
+	lines := bytes.SplitAfter(meta, []byte("\n"))
+	truncated := bytes.Join(lines[:len(lines)-2], nil)
+	if err := readAll(NewSummaryUnpacker(NewJSONUnpacker(bytes.NewReader(truncated)))); err != ErrNoSummary {
+		t.Errorf("expected ErrNoSummary, got %v", err)
+	}
+
+	// an entry dropped
+	dropped := bytes.Join(append(append([][]byte{}, lines[0]), lines[2:]...), nil)
+	if err := readAll(NewSummaryUnpacker(NewJSONUnpacker(bytes.NewReader(dropped)))); err != ErrSummaryMismatch {
+		t.Errorf("expected ErrSummaryMismatch, got %v", err)
+	}
+
+	// a segment changed in size
+	tampered := bytes.Replace(meta, []byte(`"AAAAAAAA"`), []byte(`"AAAAAAAAAAAA"`), 1)
+	if bytes.Equal(tampered, meta) {
+		t.Fatal("expected the padding segment in the metadata")
+	}
+	if err := readAll(NewSummaryUnpacker(NewJSONUnpacker(bytes.NewReader(tampered)))); err != ErrSummaryMismatch {
+		t.Errorf("expected ErrSummaryMismatch, got %v", err)
+	}
+}
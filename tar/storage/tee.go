@@ -0,0 +1,31 @@
+package storage
+
+import "fmt"
+
+// TeePacker packs each entry to all of its Packers, like the same metadata in
+// the format of existing consumers and a newer one, without disassembling the
+// archive once for each.
+type TeePacker []Packer
+
+// NewTeePacker returns a TeePacker of `packers`
+func NewTeePacker(packers ...Packer) TeePacker {
+	return TeePacker(packers)
+}
+
+// AddEntry packs the entry to each Packer in turn, and returns its position
+// from the first. The Packers are expected to agree on it.
+func (tp TeePacker) AddEntry(e Entry) (int, error) {
+	pos := -1
+	for i, p := range tp {
+		n, err := p.AddEntry(e)
+		if err != nil {
+			return -1, err
+		}
+		if i == 0 {
+			pos = n
+		} else if n != pos {
+			return -1, fmt.Errorf("storage: tee packer %d packed the entry at position %d, rather than %d", i, n, pos)
+		}
+	}
+	return pos, nil
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTeePacker(t *testing.T) {
+	pad := make([]byte, 500)
+	e := []Entry{
+		{Type: SegmentType, Payload: []byte("header one")},
+		{Type: FileType, Name: "one", Size: 12, Payload: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Type: SegmentType, Payload: pad},
+		{Type: SegmentType, Payload: []byte("header two")},
+		{Type: FileType, Name: "two", Size: 12, Payload: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Type: SegmentType, Payload: pad},
+	}
+	plain, compact := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	tp := NewTeePacker(NewJSONPacker(plain), NewCompactJSONPacker(compact))
+	for i := range e {
+		pos, err := tp.AddEntry(e[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pos != i {
+			t.Errorf("expected position %d, got %d", i, pos)
+		}
+	}
+	if compact.Len() >= plain.Len() {
+		t.Errorf("expected the compact metadata to be smaller, got %d bytes (from %d)", compact.Len(), plain.Len())
+	}
+	for _, buf := range []*bytes.Buffer{plain, compact} {
+		up := NewJSONUnpacker(buf)
+		for i := range e {
+			entry, err := up.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.GetName() != e[i].Name || !bytes.Equal(entry.Payload, e[i].Payload) {
+				t.Errorf("entry %d: expected %q of %d bytes, got %q of %d", i, e[i].Name, len(e[i].Payload), entry.GetName(), len(entry.Payload))
+			}
+		}
+	}
+
+	// a duplicate name fails in each Packer, so the first fails the tee
+	if _, err := tp.AddEntry(e[1]); err != ErrDuplicatePath {
+		t.Errorf("expected ErrDuplicatePath, got %v", err)
+	}
+}
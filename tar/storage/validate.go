@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PathIssue is what is unsafe of the path of an entry, as found by
+// ValidatePaths
+type PathIssue string
+
+const (
+	// PathTraversal is a name with a ".." component, which may extract
+	// outside the directory the archive is extracted to
+	PathTraversal PathIssue = "traversal"
+	// PathAbsolute is a name that starts with "/"
+	PathAbsolute PathIssue = "absolute"
+	// PathDuplicate is a name that is the same path as an entry before it,
+	// once cleaned (like "/etc/passwd" after "etc/passwd"), so one replaces
+	// the other on extraction
+	PathDuplicate PathIssue = "duplicate"
+	// PathLinkTraversal is a hard link whose target has a ".." component,
+	// which may link to a file outside the directory it is extracted to
+	PathLinkTraversal PathIssue = "link-traversal"
+)
+
+// PathProblem is an entry whose path is unsafe to extract
+type PathProblem struct {
+	Position int       `json:"position"`
+	Name     string    `json:"name"`
+	Issue    PathIssue `json:"issue"`
+	// Previous is the position of the entry of the same path, for a
+	// PathDuplicate (a file is never at 0, after the header of the archive)
+	Previous int `json:"previous,omitempty"`
+	// Linkname is the target of a PathLinkTraversal
+	Linkname string `json:"linkname,omitempty"`
+}
+
+func (pp PathProblem) Error() string {
+	switch pp.Issue {
+	case PathDuplicate:
+		return fmt.Sprintf("storage: entry %d %q is the same path as entry %d", pp.Position, pp.Name, pp.Previous)
+	case PathLinkTraversal:
+		return fmt.Sprintf("storage: entry %d %q is a hard link to %q, outside of the archive", pp.Position, pp.Name, pp.Linkname)
+	}
+	return fmt.Sprintf("storage: entry %d %q has an unsafe path (%s)", pp.Position, pp.Name, pp.Issue)
+}
+
+// ValidationReport is the result of ValidatePaths
+type ValidationReport struct {
+	// Entries is the number of file entries validated
+	Entries  int           `json:"entries"`
+	Problems []PathProblem `json:"problems"`
+}
+
+// OK is whether no entry has a problem
+func (vr *ValidationReport) OK() bool {
+	return len(vr.Problems) == 0
+}
+
+// hasDotDot is whether the path `name` has a ".." component
+func hasDotDot(name string) bool {
+	for _, c := range strings.Split(name, "/") {
+		if c == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// pathValidator finds the problems of each file entry, in order
+type pathValidator struct {
+	seen map[string]int
+}
+
+func (pv *pathValidator) check(e *Entry) []PathProblem {
+	if e.Type != FileType {
+		return nil
+	}
+	var problems []PathProblem
+	name := e.GetName()
+	if hasDotDot(name) {
+		problems = append(problems, PathProblem{Position: e.Position, Name: name, Issue: PathTraversal})
+	}
+	if strings.HasPrefix(name, "/") {
+		problems = append(problems, PathProblem{Position: e.Position, Name: name, Issue: PathAbsolute})
+	}
+	clean := cleanLayerPath(name)
+	if pos, ok := pv.seen[clean]; ok {
+		problems = append(problems, PathProblem{Position: e.Position, Name: name, Issue: PathDuplicate, Previous: pos})
+	} else {
+		pv.seen[clean] = e.Position
+	}
+	if e.Link == HardLink && hasDotDot(e.GetLinkname()) {
+		problems = append(problems, PathProblem{Position: e.Position, Name: name, Issue: PathLinkTraversal, Linkname: e.GetLinkname()})
+	}
+	return problems
+}
+
+// ValidatePaths reads `up` to the end, and reports the file entries whose
+// names are unsafe to hand to an extraction tool: those with a ".."
+// component, absolute ones, those of the same path as one before, and hard
+// links with a ".." in their target. Symbolic links are not checked, as their
+// targets are resolved on the filesystem, where absolute ones are common.
+//
+// Names that are the same but for cleaning, like "a/./b" and "a/b", are
+// already ErrDuplicatePath from the unpackers of this package.
+func ValidatePaths(up Unpacker) (*ValidationReport, error) {
+	pv := &pathValidator{seen: map[string]int{}}
+	report := &ValidationReport{Problems: []PathProblem{}}
+	for {
+		e, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				return report, nil
+			}
+			return nil, err
+		}
+		if e.Type == FileType {
+			report.Entries++
+		}
+		report.Problems = append(report.Problems, pv.check(e)...)
+	}
+}
+
+// NewValidatingUnpacker returns an Unpacker of `up` that fails with the
+// PathProblem of the first file entry with an unsafe path, as ValidatePaths
+// finds them, before the entry is returned
+func NewValidatingUnpacker(up Unpacker) Unpacker {
+	return &validatingUnpacker{up: up, pv: &pathValidator{seen: map[string]int{}}}
+}
+
+type validatingUnpacker struct {
+	up Unpacker
+	pv *pathValidator
+}
+
+func (vu *validatingUnpacker) Next() (*Entry, error) {
+	e, err := vu.up.Next()
+	if err != nil {
+		return nil, err
+	}
+	if problems := vu.pv.check(e); len(problems) > 0 {
+		return nil, problems[0]
+	}
+	return e, nil
+}
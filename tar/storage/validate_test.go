@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestValidatePaths(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	p := NewJSONPacker(buf)
+	entries := []Entry{
+		{Type: SegmentType, Payload: []byte("header")},
+		{Type: FileType, Name: "etc/passwd"},
+		{Type: FileType, Name: "../../etc/shadow"},
+		{Type: FileType, Name: "/etc/passwd"},
+		{Type: FileType, Name: "usr/bin/..data"},
+		{Type: FileType, Name: "usr/bin/sh", Link: SymLink, Linkname: "/bin/busybox"},
+		{Type: FileType, Name: "usr/bin/ls", Link: HardLink, Linkname: "usr/../../bin/ls"},
+	}
+	for _, e := range entries {
+		if _, err := p.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := ValidatePaths(NewJSONUnpacker(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PathProblem{
+		{Position: 2, Name: "../../etc/shadow", Issue: PathTraversal},
+		{Position: 3, Name: "/etc/passwd", Issue: PathAbsolute},
+		{Position: 3, Name: "/etc/passwd", Issue: PathDuplicate, Previous: 1},
+		{Position: 6, Name: "usr/bin/ls", Issue: PathLinkTraversal, Linkname: "usr/../../bin/ls"},
+	}
+	if report.Entries != 6 || report.OK() {
+		t.Errorf("expected 6 entries with problems, got %d", report.Entries)
+	}
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, report.Problems)
+	}
+	for i := range expected {
+		if report.Problems[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], report.Problems[i])
+		}
+	}
+
+	up := NewValidatingUnpacker(NewJSONUnpacker(bytes.NewReader(buf.Bytes())))
+	var n int
+	for {
+		if _, err = up.Next(); err != nil {
+			break
+		}
+		n++
+	}
+	if pp, ok := err.(PathProblem); !ok || pp != expected[0] || n != 2 {
+		t.Errorf("expected %v after 2 entries, got %v after %d", expected[0], err, n)
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"io"
+	"path"
+	"strings"
+)
+
+// WhiteoutKind is how an entry of a layer deletes from the layers below it
+type WhiteoutKind string
+
+const (
+	// NotWhiteout is an entry that is not a whiteout
+	NotWhiteout WhiteoutKind = ""
+	// WhiteoutFile is a whiteout of one path, like ".wh.passwd" deleting
+	// "passwd" and all under it
+	WhiteoutFile WhiteoutKind = "file"
+	// WhiteoutOpaque is an opaque directory marker, hiding all the entries
+	// of the layers below in its directory
+	WhiteoutOpaque WhiteoutKind = "opaque"
+)
+
+const (
+	// WhiteoutAnnotation is the annotation of a whiteout entry set by
+	// NewWhiteoutPacker, to its WhiteoutKind
+	WhiteoutAnnotation = "whiteout"
+	// WhiteoutTargetAnnotation is set along with WhiteoutAnnotation, to the
+	// path deleted, or for WhiteoutOpaque the directory made opaque, relative
+	// to the root of the layer (like "etc/passwd")
+	WhiteoutTargetAnnotation = "whiteout.target"
+)
+
+// ClassifyWhiteout returns the kind of whiteout that the entry `name` is, per
+// the OCI image layer specification, and the path it applies to, relative to
+// the root of the layer. Other names starting with ".wh..wh." are reserved,
+// rather than whiteouts.
+func ClassifyWhiteout(name string) (WhiteoutKind, string) {
+	p := cleanLayerPath(name)
+	dir, base := path.Split(p)
+	dir = cleanLayerPath(dir)
+	switch {
+	case base == WhiteoutOpaqueDir:
+		return WhiteoutOpaque, dir
+	case strings.HasPrefix(base, WhiteoutPrefix+WhiteoutPrefix):
+		return NotWhiteout, ""
+	case strings.HasPrefix(base, WhiteoutPrefix) && len(base) > len(WhiteoutPrefix):
+		return WhiteoutFile, path.Join(dir, base[len(WhiteoutPrefix):])
+	}
+	return NotWhiteout, ""
+}
+
+// NewWhiteoutPacker returns a Packer that annotates the whiteout entries
+// packed to `p` with their WhiteoutAnnotation and WhiteoutTargetAnnotation,
+// as a layer is disassembled, so that the deletions of a layer can be read
+// from its metadata without knowing the naming of whiteouts.
+func NewWhiteoutPacker(p Packer) Packer {
+	return &whiteoutPacker{p: p}
+}
+
+type whiteoutPacker struct {
+	p Packer
+}
+
+func (wp *whiteoutPacker) AddEntry(e Entry) (int, error) {
+	if e.Type == FileType {
+		if kind, target := ClassifyWhiteout(e.GetName()); kind != NotWhiteout {
+			annotations := map[string]string{}
+			for k, v := range e.Annotations {
+				annotations[k] = v
+			}
+			annotations[WhiteoutAnnotation] = string(kind)
+			annotations[WhiteoutTargetAnnotation] = target
+			e.Annotations = annotations
+		}
+	}
+	return wp.p.AddEntry(e)
+}
+
+// Whiteout is a whiteout entry of a layer, as found by Whiteouts
+type Whiteout struct {
+	// Name of the entry, as it is in the layer
+	Name string       `json:"name"`
+	Kind WhiteoutKind `json:"kind"`
+	// Target is the path deleted, or the directory made opaque
+	Target string `json:"target"`
+}
+
+// Whiteouts returns the whiteout entries of the layer whose metadata is read
+// from `up`, in order. They are classified by name, so the metadata need not
+// have been packed with NewWhiteoutPacker.
+func Whiteouts(up Unpacker) ([]Whiteout, error) {
+	var whiteouts []Whiteout
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			if err == io.EOF {
+				return whiteouts, nil
+			}
+			return nil, err
+		}
+		if entry.Type != FileType {
+			continue
+		}
+		if kind, target := ClassifyWhiteout(entry.GetName()); kind != NotWhiteout {
+			whiteouts = append(whiteouts, Whiteout{Name: entry.GetName(), Kind: kind, Target: target})
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestClassifyWhiteout(t *testing.T) {
+	cases := []struct {
+		name   string
+		kind   WhiteoutKind
+		target string
+	}{
+		{"./etc/.wh.passwd", WhiteoutFile, "etc/passwd"},
+		{".wh.var", WhiteoutFile, "var"},
+		{"/opt/.wh..wh..opq", WhiteoutOpaque, "opt"},
+		{".wh..wh..opq", WhiteoutOpaque, "."},
+		{"opt/.wh..wh.plnk", NotWhiteout, ""},
+		{"etc/.wh.", NotWhiteout, ""},
+		{"etc/passwd.wh.", NotWhiteout, ""},
+		{".wh.dir/file", NotWhiteout, ""},
+	}
+	for _, c := range cases {
+		kind, target := ClassifyWhiteout(c.name)
+		if kind != c.kind || target != c.target {
+			t.Errorf("%q: expected %q of %q, got %q of %q", c.name, c.kind, c.target, kind, target)
+		}
+	}
+}
+
+func TestWhiteoutPacker(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	p := NewWhiteoutPacker(NewJSONPacker(buf))
+	entries := []Entry{
+		{Type: SegmentType, Payload: []byte("header")},
+		{Type: FileType, Name: "etc/.wh.passwd", Annotations: map[string]string{"scanned": "yes"}},
+		{Type: FileType, Name: "etc/hostname", Size: 8},
+		{Type: FileType, Name: "opt/.wh..wh..opq"},
+	}
+	for _, e := range entries {
+		if _, err := p.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(entries[1].Annotations) != 1 {
+		t.Errorf("expected the annotations of the entry given to be left as they were")
+	}
+
+	up := NewJSONUnpacker(bytes.NewReader(buf.Bytes()))
+	var annotated []map[string]string
+	for {
+		entry, err := up.Next()
+		if err != nil {
+			break
+		}
+		if entry.Type == FileType {
+			annotated = append(annotated, entry.Annotations)
+		}
+	}
+	if a := annotated[0]; a[WhiteoutAnnotation] != "file" || a[WhiteoutTargetAnnotation] != "etc/passwd" || a["scanned"] != "yes" {
+		t.Errorf("unexpected annotations %v", a)
+	}
+	if a := annotated[1]; len(a) != 0 {
+		t.Errorf("expected no annotations, got %v", a)
+	}
+	if a := annotated[2]; a[WhiteoutAnnotation] != "opaque" || a[WhiteoutTargetAnnotation] != "opt" {
+		t.Errorf("unexpected annotations %v", a)
+	}
+
+	whiteouts, err := Whiteouts(NewJSONUnpacker(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Whiteout{
+		{Name: "etc/.wh.passwd", Kind: WhiteoutFile, Target: "etc/passwd"},
+		{Name: "opt/.wh..wh..opq", Kind: WhiteoutOpaque, Target: "opt"},
+	}
+	if len(whiteouts) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, whiteouts)
+	}
+	for i := range expected {
+		if whiteouts[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], whiteouts[i])
+		}
+	}
+}
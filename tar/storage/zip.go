@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"archive/zip"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/vbatts/tar-split/tar/internal/bufpool"
+)
+
+const (
+	// ZipMetadataName is the member of the zip that holds the packed metadata,
+	// when it has been stored alongside the payloads.
+	ZipMetadataName = "tar-data.json"
+	// ZipPayloadDir is the directory of the zip that file payloads are stored
+	// under.
+	ZipPayloadDir = "files"
+)
+
+// NewZipFilePutter returns a FilePutter that stores each payload as a member
+// of a zip file written to `w`. The `method` is the compression used for the
+// members, like zip.Store or zip.Deflate.
+//
+// Since a zip can not be read until its central directory is written, the
+// ZipFilePutter must be closed before the zip is handed to
+// NewZipFileGetter.
+func NewZipFilePutter(w io.Writer, method uint16) *ZipFilePutter {
+	return &ZipFilePutter{
+		zw:     zip.NewWriter(w),
+		method: method,
+	}
+}
+
+// ZipFilePutter is a FilePutter storing payloads as members of a zip file.
+type ZipFilePutter struct {
+	zw     *zip.Writer
+	method uint16
+}
+
+// Put stores the payload of `name` as a member of the zip
+func (zfp *ZipFilePutter) Put(name string, r io.Reader) (int64, []byte, error) {
+	w, err := zfp.zw.CreateHeader(&zip.FileHeader{
+		Name:   zipPayloadName(name),
+		Method: zfp.method,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	pd := NewPayloadDigester()
+	if _, err := bufpool.Copy(io.MultiWriter(w, pd), r); err != nil {
+		return 0, nil, err
+	}
+	return pd.Size(), pd.Checksum(), nil
+}
+
+// PutMetadata stores the packed metadata read from `r` in the zip as well, so
+// that the zip is a single artifact able to reassemble the tar archive.
+//
+// The metadata can not be written while payloads are being Put (only one zip
+// member is written at a time), so it is to be buffered elsewhere and stored
+// once disassembly is done.
+func (zfp *ZipFilePutter) PutMetadata(r io.Reader) error {
+	w, err := zfp.zw.CreateHeader(&zip.FileHeader{
+		Name:   ZipMetadataName,
+		Method: zfp.method,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Close finishes writing the zip file. It does not close the underlying
+// writer.
+func (zfp *ZipFilePutter) Close() error {
+	return zfp.zw.Close()
+}
+
+// NewZipFileGetter returns a FileGetter for the payloads stored in the zip
+// file `r` of `size` bytes, as written by a ZipFilePutter.
+func NewZipFileGetter(r io.ReaderAt, size int64) (*ZipFileGetter, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	zfg := &ZipFileGetter{files: map[string]*zip.File{}}
+	for _, f := range zr.File {
+		zfg.files[f.Name] = f
+	}
+	return zfg, nil
+}
+
+// ZipFileGetter is a FileGetter for payloads stored as members of a zip file.
+type ZipFileGetter struct {
+	files map[string]*zip.File
+}
+
+// Get returns the payload of `name` from the zip
+func (zfg *ZipFileGetter) Get(name string) (io.ReadCloser, error) {
+	return zfg.open(zipPayloadName(name))
+}
+
+// Metadata returns the packed metadata stored in the zip, if any.
+func (zfg *ZipFileGetter) Metadata() (io.ReadCloser, error) {
+	return zfg.open(ZipMetadataName)
+}
+
+func (zfg *ZipFileGetter) open(member string) (io.ReadCloser, error) {
+	f, ok := zfg.files[member]
+	if !ok {
+		return nil, ErrNoSuchFile
+	}
+	return f.Open()
+}
+
+// zipPayloadName maps a file name to its member of the zip. Members are
+// relative, since most zip tools refuse absolute paths.
+func zipPayloadName(name string) string {
+	return path.Join(ZipPayloadDir, strings.TrimPrefix(path.Clean("/"+name), "/"))
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestZipGetPutter(t *testing.T) {
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		files := map[string]string{
+			"./hurr.txt":     "imma hurr til I derp",
+			"./usr/bin/derp": strings.Repeat("derp", 1000),
+		}
+
+		buf := bytes.NewBuffer(nil)
+		zfp := NewZipFilePutter(buf, method)
+		sums := map[string][]byte{}
+		for name, body := range files {
+			i, csum, err := zfp.Put(name, bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i != int64(len(body)) {
+				t.Errorf("%s: expected size %d, got %d", name, len(body), i)
+			}
+			sums[name] = csum
+		}
+		if err := zfp.PutMetadata(bytes.NewBufferString("{}\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := zfp.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		zfg, err := NewZipFileGetter(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bfp := NewDiscardFilePutter()
+		for name, body := range files {
+			rdr, err := zfg.Get(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, csum, err := bfp.Put(name, rdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rdr.Close()
+			if !bytes.Equal(csum, sums[name]) {
+				t.Errorf("%s: checksum mismatch of %q", name, body)
+			}
+		}
+
+		rdr, err := zfg.Metadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		md, err := ioutil.ReadAll(rdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(md) != "{}\n" {
+			t.Errorf("expected metadata %q, got %q", "{}\n", md)
+		}
+
+		if _, err := zfg.Get("./nope"); err != ErrNoSuchFile {
+			t.Errorf("expected ErrNoSuchFile, got %v", err)
+		}
+	}
+}
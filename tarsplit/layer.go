@@ -0,0 +1,276 @@
+/*
+Package tarsplit is a facade over the `asm` and `storage` packages, for the
+common lifecycle of an image layer: ingesting its blob, then assembling it
+again byte for byte, verifying it, and reading its files.
+
+	l := tarsplit.NewLayer("/var/lib/layers/1")
+	info, err := l.Ingest(blob)
+	...
+	err = l.Assemble(w)
+
+The metadata is stored as gzipped JSON with a summary, and the file payloads
+in a content addressed store, which layers can share. For other setups, wire
+the packers, putters and streams of `asm` and `storage` directly.
+*/
+package tarsplit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/common"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+const (
+	// MediaTypeLayer, MediaTypeLayerGzip and MediaTypeLayerZstd are the OCI
+	// media types of uncompressed, gzipped and zstd compressed layers
+	MediaTypeLayer     = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeLayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	metadataName = "tar-data.json.gz"
+	manifestName = "manifest.json"
+	infoName     = "layer.json"
+)
+
+// Descriptor is of a blob, as in an OCI image manifest
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// LayerInfo is what Ingest learns of a layer
+type LayerInfo struct {
+	// Descriptor is of the blob, as it was ingested
+	Descriptor Descriptor `json:"descriptor"`
+	// DiffID is the digest of the uncompressed archive, as in the rootfs of
+	// an OCI image config
+	DiffID string `json:"diffID"`
+}
+
+// Layer is an image layer, stored as tar-split metadata and file payloads
+type Layer struct {
+	// Dir is where the metadata of the layer is stored
+	Dir string
+	// PayloadDir is the content addressed store of the file payloads, which
+	// layers can share, so that each distinct payload is stored once
+	PayloadDir string
+}
+
+// NewLayer returns the Layer stored in `dir`, with its payloads in "payloads"
+// under it. Set PayloadDir to share a payload store between layers.
+func NewLayer(dir string) *Layer {
+	return &Layer{Dir: dir, PayloadDir: filepath.Join(dir, "payloads")}
+}
+
+// Ingest disassembles the layer blob `r`, which may be gzipped, or zstd
+// compressed if a decompressor is registered for it (see
+// common.RegisterDecompressor), storing its metadata and payloads, and
+// returns its descriptor and DiffID.
+func (l *Layer) Ingest(r io.Reader) (*LayerInfo, error) {
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return nil, err
+	}
+	blobHash := sha256.New()
+	counter := &countingWriter{}
+	br := bufio.NewReader(io.TeeReader(r, io.MultiWriter(blobHash, counter)))
+	info := &LayerInfo{Descriptor: Descriptor{MediaType: MediaTypeLayer}}
+	archive, compression, err := common.DecompressReader(br)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	switch compression {
+	case common.Uncompressed:
+	case common.Gzip:
+		info.Descriptor.MediaType = MediaTypeLayerGzip
+	case common.Zstd:
+		info.Descriptor.MediaType = MediaTypeLayerZstd
+	default:
+		return nil, fmt.Errorf("tarsplit: %s compressed layers have no OCI media type", compression)
+	}
+
+	mf, err := os.Create(filepath.Join(l.Dir, metadataName))
+	if err != nil {
+		return nil, err
+	}
+	defer mf.Close()
+	mfz := gzip.NewWriter(mf)
+	manifest, err := os.Create(filepath.Join(l.Dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	defer manifest.Close()
+
+	sp := storage.NewSummaryPacker(storage.NewJSONPacker(mfz))
+	mp := storage.NewManifestPacker(sp, storage.NewCASFilePutter(l.PayloadDir), manifest)
+	its, err := asm.NewInputTarStream(archive, mp, mp)
+	if err != nil {
+		return nil, err
+	}
+	diffHash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(sp, diffHash), its); err != nil {
+		return nil, err
+	}
+	// the rest of the blob, like the gzip trailer, is in its digest too
+	if _, err := io.Copy(ioutil.Discard, br); err != nil {
+		return nil, err
+	}
+	if err := sp.Close(); err != nil {
+		return nil, err
+	}
+	for _, c := range []io.Closer{mfz, mf, manifest} {
+		if err := c.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	info.DiffID = digest(diffHash)
+	info.Descriptor.Digest = digest(blobHash)
+	info.Descriptor.Size = counter.n
+	buf, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(l.Dir, infoName), buf, 0644); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Info returns what Ingest learned of the layer
+func (l *Layer) Info() (*LayerInfo, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(l.Dir, infoName))
+	if err != nil {
+		return nil, err
+	}
+	info := &LayerInfo{}
+	if err := json.Unmarshal(buf, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Assemble writes the uncompressed archive of the layer to `w`, byte for byte
+// as it was ingested
+func (l *Layer) Assemble(w io.Writer) error {
+	return l.withMetadata(func(fg storage.FileGetter, up storage.Unpacker) error {
+		return asm.WriteOutputTarStream(fg, up, w)
+	})
+}
+
+// Verify assembles the archive of the layer, checking that the metadata and
+// payloads are intact and that it matches the DiffID
+func (l *Layer) Verify() error {
+	info, err := l.Info()
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	err = l.withMetadata(func(fg storage.FileGetter, up storage.Unpacker) error {
+		return asm.WriteVerifiedTarStream(fg, up, h)
+	})
+	if err != nil {
+		return err
+	}
+	if d := digest(h); d != info.DiffID {
+		return fmt.Errorf("tarsplit: layer assembled to %s, rather than its DiffID %s", d, info.DiffID)
+	}
+	return nil
+}
+
+// ExtractFile returns the content of the regular file `name` of the layer,
+// following hard links, or storage.ErrNoSuchFile
+func (l *Layer) ExtractFile(name string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := l.withMetadata(func(fg storage.FileGetter, up storage.Unpacker) error {
+		files := map[string]*storage.Entry{}
+		links := map[string]string{}
+		hr := asm.NewHeaderReader(up)
+		for {
+			hdr, entry, err := hr.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			switch hdr.Typeflag {
+			case tar.TypeReg, tar.TypeRegA:
+				files[hdr.Name] = entry
+			case tar.TypeLink:
+				links[hdr.Name] = hdr.Linkname
+			}
+		}
+		if target, ok := links[name]; ok {
+			name = target
+		}
+		found, ok := files[name]
+		if !ok {
+			return storage.ErrNoSuchFile
+		}
+		if found.Size == 0 {
+			rc = ioutil.NopCloser(bytes.NewReader(nil))
+			return nil
+		}
+		if len(found.Inline) > 0 {
+			rc = ioutil.NopCloser(bytes.NewReader(found.Inline))
+			return nil
+		}
+		var err error
+		rc, err = fg.Get(found.GetName())
+		return err
+	})
+	return rc, err
+}
+
+// withMetadata calls fn with the payloads and metadata of the layer
+func (l *Layer) withMetadata(fn func(storage.FileGetter, storage.Unpacker) error) error {
+	manifest, err := os.Open(filepath.Join(l.Dir, manifestName))
+	if err != nil {
+		return err
+	}
+	entries, err := storage.ReadManifest(manifest)
+	manifest.Close()
+	if err != nil {
+		return err
+	}
+	mf, err := os.Open(filepath.Join(l.Dir, metadataName))
+	if err != nil {
+		return err
+	}
+	defer mf.Close()
+	mfz, err := gzip.NewReader(mf)
+	if err != nil {
+		return err
+	}
+	defer mfz.Close()
+	return fn(storage.NewCASFileGetter(l.PayloadDir, entries), storage.NewJSONUnpacker(mfz))
+}
+
+func digest(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,109 @@
+package tarsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+func buildLayer(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	mtime := time.Unix(1500000000, 0)
+	files := []struct {
+		hdr  tar.Header
+		body string
+	}{
+		{tar.Header{Name: "etc/", Mode: 0755, Typeflag: tar.TypeDir}, ""},
+		{tar.Header{Name: "etc/hosts", Mode: 0644, Typeflag: tar.TypeReg}, "127.0.0.1 localhost\n"},
+		{tar.Header{Name: "etc/hosts.bak", Mode: 0644, Typeflag: tar.TypeReg}, "127.0.0.1 localhost\n"},
+		{tar.Header{Name: "etc/hosts.link", Typeflag: tar.TypeLink, Linkname: "etc/hosts"}, ""},
+	}
+	for _, f := range files {
+		hdr := f.hdr
+		hdr.Size, hdr.ModTime = int64(len(f.body)), mtime
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tarsplit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := buildLayer(t)
+	var blob bytes.Buffer
+	gz := gzip.NewWriter(&blob)
+	gz.Write(archive)
+	gz.Close()
+
+	l := NewLayer(dir)
+	info, err := l.Ingest(bytes.NewReader(blob.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffID, blobDigest := sha256.Sum256(archive), sha256.Sum256(blob.Bytes())
+	if info.DiffID != "sha256:"+hex.EncodeToString(diffID[:]) {
+		t.Errorf("unexpected DiffID %s", info.DiffID)
+	}
+	want := Descriptor{MediaType: MediaTypeLayerGzip, Digest: "sha256:" + hex.EncodeToString(blobDigest[:]), Size: int64(blob.Len())}
+	if info.Descriptor != want {
+		t.Errorf("expected the descriptor %+v, got %+v", want, info.Descriptor)
+	}
+	if stored, _ := ioutil.ReadDir(filepath.Join(l.PayloadDir, "sha256")); len(stored) != 1 {
+		t.Errorf("expected the one distinct payload stored, got %d", len(stored))
+	}
+
+	var out bytes.Buffer
+	if err := l.Assemble(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), archive) {
+		t.Error("expected the archive assembled as it was")
+	}
+	if err := l.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	for _, name := range []string{"etc/hosts", "etc/hosts.link"} {
+		rc, err := l.ExtractFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(content) != "127.0.0.1 localhost\n" {
+			t.Errorf("unexpected content of %s: %q", name, content)
+		}
+	}
+	if _, err := l.ExtractFile("etc/passwd"); err != storage.ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+
+	// a missing payload is noticed
+	stored, _ := ioutil.ReadDir(filepath.Join(l.PayloadDir, "sha256"))
+	os.Remove(filepath.Join(l.PayloadDir, "sha256", stored[0].Name()))
+	if err := l.Verify(); err == nil {
+		t.Error("expected Verify to fail without the payload")
+	}
+}